@@ -2,7 +2,9 @@ package application
 
 import (
 	"context"
+	"crypto/tls"
 	"database/sql"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
@@ -45,7 +47,12 @@ func (app *App) Start(ctx context.Context) error {
 		Handler: app.router,
 	}
 
-	errorChan := make(chan error, 1)
+	tlsServer, err := newTLSServer(app.router)
+	if err != nil {
+		return err
+	}
+
+	errorChan := make(chan error, 2)
 
 	// Handle server listening on port in a goroutine
 	go func() {
@@ -54,10 +61,22 @@ func (app *App) Start(ctx context.Context) error {
 			msg := "[FAIL]: unable to start server"
 
 			errorChan <- fmt.Errorf("%s: %w", msg, err)
-			close(errorChan)
 		}
 	}()
 
+	// Handle the TLS listener, for clients authenticating with certificates
+	if tlsServer != nil {
+		go func() {
+			err := tlsServer.ListenAndServeTLS(os.Getenv("TLS_CERT_FILE"), os.Getenv("TLS_KEY_FILE"))
+			if err != nil {
+				msg := "[FAIL]: unable to start tls server"
+
+				errorChan <- fmt.Errorf("%s: %w", msg, err)
+			}
+		}()
+		log.Printf("[SUCCESS]: tls server listening on port: %s\n", os.Getenv("TLS_PORT"))
+	}
+
 	var url string
 	// Use the default address in a production environment
 	if os.Getenv("ENVIRONMENT") == "production" {
@@ -75,6 +94,43 @@ func (app *App) Start(ctx context.Context) error {
 		timeout, cancel := context.WithTimeout(context.Background(), time.Second*10)
 		defer cancel()
 
-		return server.Shutdown(timeout)
+		if tlsServer != nil {
+			err = tlsServer.Shutdown(timeout)
+		}
+		return errors.Join(server.Shutdown(timeout), err)
 	}
 }
+
+/*
+Creates the TLS listener, requesting client certificates for mutual TLS client authentication and certificate bound tokens
+
+Objectives:
+  - Serve the router on TLS_PORT with the certificate of TLS_CERT_FILE and TLS_KEY_FILE
+  - Request a client certificate without verifying it in the handshake, self-signed certificates are checked against the client's keys
+
+Params:
+  - router: The application router
+
+Returns:
+  - A pointer to the server, nil when TLS_PORT isn't set
+  - An error if the certificate files are missing
+*/
+func newTLSServer(router http.Handler) (*http.Server, error) {
+	port := os.Getenv("TLS_PORT")
+	if port == "" {
+		return nil, nil
+	}
+
+	if os.Getenv("TLS_CERT_FILE") == "" || os.Getenv("TLS_KEY_FILE") == "" {
+		return nil, fmt.Errorf("[FAIL]: TLS_CERT_FILE and TLS_KEY_FILE are required when TLS_PORT is set")
+	}
+
+	return &http.Server{
+		Addr:    fmt.Sprintf(":%s", port),
+		Handler: router,
+		TLSConfig: &tls.Config{
+			MinVersion: tls.VersionTLS12,
+			ClientAuth: tls.RequestClientCert,
+		},
+	}, nil
+}
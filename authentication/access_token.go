@@ -0,0 +1,214 @@
+package authentication
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+// Audience of access tokens issued for this API, resource servers verify tokens issued for their own audience
+const APIAudience = "api"
+
+// Type of access tokens in their typ header, RFC 9068, telling them apart from the tokens this server issues to itself
+const accessTokenType = "at+jwt"
+
+/*
+Actor claim struct, the party acting for the subject of an exchanged token, RFC 8693
+
+Fields:
+  - Subject: string, the client id of the actor
+  - Actor:   The actor that acted before it, for tokens exchanged more than once
+*/
+type Actor struct {
+	Subject string `json:"sub"`
+	Actor   *Actor `json:"act,omitempty"`
+}
+
+/*
+Confirmation claim struct, the key a sender-constrained token is bound to, RFC 7800
+
+Fields:
+  - KeyThumbprint:         string, the RFC 7638 thumbprint of the client's DPoP key, RFC 9449
+  - CertificateThumbprint: string, the SHA-256 thumbprint of the client's TLS certificate, RFC 8705
+*/
+type Confirmation struct {
+	KeyThumbprint         string `json:"jkt,omitempty"`
+	CertificateThumbprint string `json:"x5t#S256,omitempty"`
+}
+
+/*
+Access token claims struct, what a token issued by the authorization server grants
+
+Fields:
+  - ClientID:         string, the client the token was issued to
+  - Scope:            string, the granted scopes, space separated
+  - FamilyID:         string, the refresh token family of the grant, revoking the family revokes the token
+  - Actor:            The delegation chain of a token issued by token exchange, nil otherwise
+  - Confirmation:     The key the token is bound to, nil for bearer tokens
+  - RegisteredClaims: The subject is the user id, or the client id for clients acting for themselves, the audience the resource servers accepting the token
+*/
+type AccessTokenClaims struct {
+	ClientID     string        `json:"client_id"`
+	Scope        string        `json:"scope,omitempty"`
+	FamilyID     string        `json:"family_id,omitempty"`
+	Actor        *Actor        `json:"act,omitempty"`
+	Confirmation *Confirmation `json:"cnf,omitempty"`
+	jwt.RegisteredClaims
+}
+
+/*
+Signs access token claims into a token
+
+Objectives:
+  - Set the token id, issue and expiry times
+  - Mark the token as an access token in its typ header
+
+Params:
+  - claims:   The access token claims, the issuer, subject and audience must be set
+  - lifetime: How long the token is valid
+
+Returns:
+  - The signed token string
+  - The claims the token was signed with
+  - An error if signing failed
+*/
+func CreateAccessToken(claims AccessTokenClaims, lifetime time.Duration) (string, AccessTokenClaims, error) {
+	secretKey, err := signingKey()
+	if err != nil {
+		return "", AccessTokenClaims{}, err
+	}
+
+	now := time.Now()
+	claims.ID = uuid.NewString()
+	claims.IssuedAt = jwt.NewNumericDate(now)
+	claims.ExpiresAt = jwt.NewNumericDate(now.Add(lifetime))
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	token.Header["typ"] = accessTokenType
+
+	tokenString, err := token.SignedString(secretKey)
+	if err != nil {
+		return "", AccessTokenClaims{}, fmt.Errorf("[FAIL]: could not sign access token: %w", err)
+	}
+
+	return tokenString, claims, nil
+}
+
+/*
+Verifies a token created by CreateAccessToken
+
+Params:
+  - tokenString: The token string
+  - audience:    The audience the token must be issued for
+
+Returns:
+  - The access token claims
+  - An error if the token is invalid, expired or issued for another audience
+*/
+func VerifyAccessToken(tokenString string, audience string) (AccessTokenClaims, error) {
+	return parseAccessToken(tokenString, jwt.WithAudience(audience))
+}
+
+/*
+Verifies a token created by CreateAccessToken for any audience, for introspection and revocation
+
+Params:
+  - tokenString: The token string
+
+Returns:
+  - The access token claims, the caller checks the audience
+  - An error if the token is invalid or expired
+*/
+func InspectAccessToken(tokenString string) (AccessTokenClaims, error) {
+	return parseAccessToken(tokenString)
+}
+
+/*
+Returns the DPoP key thumbprint the token is bound to
+
+Returns:
+  - The jkt confirmation, empty for tokens not bound to a DPoP key
+*/
+func (claims AccessTokenClaims) DPoPKeyThumbprint() string {
+	if claims.Confirmation == nil {
+		return ""
+	}
+
+	return claims.Confirmation.KeyThumbprint
+}
+
+/*
+Returns the client certificate thumbprint the token is bound to
+
+Returns:
+  - The x5t#S256 confirmation, empty for tokens not bound to a certificate
+*/
+func (claims AccessTokenClaims) CertificateThumbprint() string {
+	if claims.Confirmation == nil {
+		return ""
+	}
+
+	return claims.Confirmation.CertificateThumbprint
+}
+
+/*
+Returns what the token's revocation is keyed on, besides its id
+
+Returns:
+  - The refresh token family of the grant, uuid.Nil if it has none
+  - The user the token acts for, uuid.Nil for tokens of clients acting for themselves, or exchanged from their tokens
+  - When the token was issued, revoking the grant revokes the tokens issued before
+  - An error if the family id is malformed, such a token must be treated as revoked
+*/
+func (claims AccessTokenClaims) RevocationScope() (uuid.UUID, uuid.UUID, time.Time, error) {
+	familyID := uuid.Nil
+	if claims.FamilyID != "" {
+		parsed, err := uuid.Parse(claims.FamilyID)
+		if err != nil {
+			return uuid.Nil, uuid.Nil, time.Time{}, fmt.Errorf("[FAIL]: malformed family id: %w", err)
+		}
+		familyID = parsed
+	}
+
+	userID := uuid.Nil
+	if parsed, err := uuid.Parse(claims.Subject); err == nil && claims.Subject != claims.ClientID {
+		userID = parsed
+	}
+
+	var issuedAt time.Time
+	if claims.IssuedAt != nil {
+		issuedAt = claims.IssuedAt.Time
+	}
+
+	return familyID, userID, issuedAt, nil
+}
+
+func parseAccessToken(tokenString string, options ...jwt.ParserOption) (AccessTokenClaims, error) {
+	secretKey, err := signingKey()
+	if err != nil {
+		return AccessTokenClaims{}, err
+	}
+
+	options = append(options, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}), jwt.WithExpirationRequired())
+
+	var claims AccessTokenClaims
+	_, err = jwt.ParseWithClaims(tokenString, &claims, func(token *jwt.Token) (interface{}, error) {
+		// Session and other internal tokens share the key, only the typ header tells them apart
+		if !isAccessTokenType(token) {
+			return nil, fmt.Errorf("[FAIL]: token is not an access token")
+		}
+
+		return secretKey, nil
+	}, options...)
+	if err != nil {
+		return AccessTokenClaims{}, fmt.Errorf("[FAIL]: invalid access token: %w", err)
+	}
+
+	if claims.ClientID == "" || claims.Subject == "" || claims.ID == "" || len(claims.Audience) == 0 {
+		return AccessTokenClaims{}, fmt.Errorf("[FAIL]: invalid access token: missing client, subject, audience or id")
+	}
+
+	return claims, nil
+}
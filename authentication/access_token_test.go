@@ -0,0 +1,66 @@
+package authentication
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dev-xero/authentication-backend/internal/testutil"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+// Issues an access token acting for userID, for audience
+func newTestAccessToken(t *testing.T, userID uuid.UUID, audience string) string {
+	t.Helper()
+
+	claims := AccessTokenClaims{ClientID: "billing"}
+	claims.Subject = userID.String()
+	claims.Audience = jwt.ClaimStrings{audience}
+
+	token, _, err := CreateAccessToken(claims, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return token
+}
+
+func TestVerifyAccessTokenAcceptsAccessTokens(t *testing.T) {
+	testutil.SetSigningKey(t)
+	userID := uuid.New()
+
+	claims, err := VerifyAccessToken(newTestAccessToken(t, userID, APIAudience), APIAudience)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.Subject != userID.String() || claims.ClientID != "billing" {
+		t.Fatalf("unexpected claims %+v", claims)
+	}
+}
+
+// Access tokens share the signing key with session tokens, the audience alone mustn't tell them apart
+func TestAccessTokensAreNotSessionTokens(t *testing.T) {
+	testutil.SetSigningKey(t)
+	userID := uuid.New()
+
+	if _, err := VerifyToken(newTestAccessToken(t, userID, userAudience)); err == nil {
+		t.Error("access token for the user audience was accepted as a session token")
+	}
+	if _, err := VerifyMFAToken(newTestAccessToken(t, userID, mfaAudience)); err == nil {
+		t.Error("access token for the mfa audience was accepted as an mfa token")
+	}
+	if _, err := VerifyConsentToken(newTestAccessToken(t, userID, consentAudience)); err == nil {
+		t.Error("access token for the consent audience was accepted as a consent")
+	}
+
+	session, err := CreateJWToken(userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := InspectAccessToken(session); err == nil {
+		t.Error("session token was accepted as an access token")
+	}
+	if _, err := VerifyToken(session); err != nil {
+		t.Errorf("session token was rejected: %v", err)
+	}
+}
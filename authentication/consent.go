@@ -0,0 +1,94 @@
+package authentication
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Audience of consent tokens, they are never accepted as session or device approval tokens
+const consentAudience = "oauth_consent"
+
+// How long a user has to answer the consent screen
+const ConsentLifetime = 10 * time.Minute
+
+/*
+Consent claims struct, carries a verified authorization request through the consent screen
+
+Fields:
+  - ClientID:         string
+  - RedirectURI:      string, the verified redirect URI
+  - Scope:            string, the requested scopes, space separated
+  - State:            string, the state of the client, echoed in the redirect
+  - CodeChallenge:    string, the S256 PKCE challenge
+  - Nonce:            string, the OpenID Connect nonce
+  - RegisteredClaims: The subject is the signed-in user's id
+*/
+type Consent struct {
+	ClientID      string `json:"client_id"`
+	RedirectURI   string `json:"redirect_uri"`
+	Scope         string `json:"scope,omitempty"`
+	State         string `json:"state,omitempty"`
+	CodeChallenge string `json:"code_challenge"`
+	Nonce         string `json:"nonce,omitempty"`
+	jwt.RegisteredClaims
+}
+
+/*
+Signs consent claims into a token, sent with the consent form
+
+Params:
+  - consent: The consent claims, its subject must be set
+
+Returns:
+  - The signed token string
+  - An error if signing failed
+*/
+func CreateConsentToken(consent Consent) (string, error) {
+	secretKey, err := signingKey()
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	consent.Audience = jwt.ClaimStrings{consentAudience}
+	consent.IssuedAt = jwt.NewNumericDate(now)
+	consent.ExpiresAt = jwt.NewNumericDate(now.Add(ConsentLifetime))
+
+	tokenString, err := jwt.NewWithClaims(jwt.SigningMethodHS256, consent).SignedString(secretKey)
+	if err != nil {
+		return "", fmt.Errorf("[FAIL]: could not sign consent: %w", err)
+	}
+
+	return tokenString, nil
+}
+
+/*
+Verifies a token created by CreateConsentToken
+
+Params:
+  - tokenString: The token string
+
+Returns:
+  - The consent claims
+  - An error if the token is invalid or expired
+*/
+func VerifyConsentToken(tokenString string) (Consent, error) {
+	secretKey, err := signingKey()
+	if err != nil {
+		return Consent{}, err
+	}
+
+	var consent Consent
+	_, err = jwt.ParseWithClaims(tokenString, &consent, internalTokenKey(secretKey), jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}), jwt.WithAudience(consentAudience), jwt.WithExpirationRequired())
+	if err != nil {
+		return Consent{}, fmt.Errorf("[FAIL]: invalid consent: %w", err)
+	}
+
+	if consent.Subject == "" || consent.ClientID == "" || consent.RedirectURI == "" || consent.CodeChallenge == "" {
+		return Consent{}, fmt.Errorf("[FAIL]: invalid consent: missing subject, client, redirect uri or code challenge")
+	}
+
+	return consent, nil
+}
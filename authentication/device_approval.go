@@ -0,0 +1,84 @@
+package authentication
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Audience of device approval tokens, they are never accepted as session or mfa tokens
+const deviceApprovalAudience = "device_approval"
+
+// How long a user has to approve a device once the verification page is shown
+const DeviceApprovalLifetime = 10 * time.Minute
+
+/*
+Device approval claims struct, binds the approval form to the user it was shown to
+
+Fields:
+  - UserCode:         string, the user code the form approves
+  - RegisteredClaims: The subject is the signed-in user's id
+*/
+type DeviceApproval struct {
+	UserCode string `json:"user_code"`
+	jwt.RegisteredClaims
+}
+
+/*
+Signs device approval claims into a token, sent with the approval form
+
+Params:
+  - approval: The device approval claims, its subject must be set
+
+Returns:
+  - The signed token string
+  - An error if signing failed
+*/
+func CreateDeviceApprovalToken(approval DeviceApproval) (string, error) {
+	secretKey, err := signingKey()
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	approval.Audience = jwt.ClaimStrings{deviceApprovalAudience}
+	approval.IssuedAt = jwt.NewNumericDate(now)
+	approval.ExpiresAt = jwt.NewNumericDate(now.Add(DeviceApprovalLifetime))
+
+	tokenString, err := jwt.NewWithClaims(jwt.SigningMethodHS256, approval).SignedString(secretKey)
+	if err != nil {
+		return "", fmt.Errorf("[FAIL]: could not sign device approval: %w", err)
+	}
+
+	return tokenString, nil
+}
+
+/*
+Verifies a token created by CreateDeviceApprovalToken
+
+Params:
+  - tokenString: The token string
+
+Returns:
+  - The device approval claims
+  - An error if the token is invalid or expired
+*/
+func VerifyDeviceApprovalToken(tokenString string) (DeviceApproval, error) {
+	secretKey, err := signingKey()
+	if err != nil {
+		return DeviceApproval{}, err
+	}
+
+	var approval DeviceApproval
+	_, err = jwt.ParseWithClaims(tokenString, &approval, internalTokenKey(secretKey), jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}), jwt.WithAudience(deviceApprovalAudience), jwt.WithExpirationRequired())
+	if err != nil {
+		return DeviceApproval{}, fmt.Errorf("[FAIL]: invalid device approval: %w", err)
+	}
+
+	if approval.Subject == "" || approval.UserCode == "" {
+		return DeviceApproval{}, fmt.Errorf("[FAIL]: invalid device approval: missing subject or user code")
+	}
+
+	return approval, nil
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
@@ -11,23 +12,106 @@ import (
 	"github.com/joho/godotenv"
 )
 
-func CreateJWToken(userID uuid.UUID) (string, error) {
-	// Load environment variables from .env file in development
-	if env := os.Getenv("ENVIRONMENT"); env != "production" {
-		err := godotenv.Load()
-		if err != nil {
-			return "", fmt.Errorf("[FAIL]: could not load environment variables: %w", err)
+// Token audiences, session tokens must never be accepted where an mfa token is expected and vice versa
+const (
+	userAudience = "user"
+	mfaAudience  = "mfa"
+)
+
+// Audiences of the tokens this server issues to itself, access tokens are never issued for them
+var reservedAudiences = []string{userAudience, mfaAudience, oauthStateAudience, samlRequestAudience, consentAudience, deviceApprovalAudience}
+
+/*
+Checks whether an audience is used by the tokens this server issues to itself
+
+Params:
+  - audience: The audience a client registers or requests
+
+Returns:
+  - True if access tokens must not be issued for the audience
+*/
+func IsReservedAudience(audience string) bool {
+	for _, reserved := range reservedAudiences {
+		if audience == reserved {
+			return true
 		}
 	}
 
-	var secretKey = []byte(os.Getenv("JWT_SECRET_KEY"))
+	return false
+}
+
+func CreateJWToken(userID uuid.UUID) (string, error) {
+	return createToken(userID, userAudience, time.Hour)
+}
+
+/*
+Creates a short-lived token for a user that passed the first sign-in factor
+
+Params:
+  - userID: The user id
+
+Returns:
+  - The signed token string
+  - An error if signing failed
+*/
+func CreateMFAToken(userID uuid.UUID) (string, error) {
+	return createToken(userID, mfaAudience, 5*time.Minute)
+}
+
+func VerifyToken(tokenString string) (*jwt.Token, error) {
+	return verifyToken(tokenString, userAudience)
+}
+
+/*
+Verifies a token created by CreateMFAToken
+
+Params:
+  - tokenString: The token string
+
+Returns:
+  - A pointer to the parsed token
+  - An error if the token is invalid
+*/
+func VerifyMFAToken(tokenString string) (*jwt.Token, error) {
+	return verifyToken(tokenString, mfaAudience)
+}
+
+/*
+Returns the user id stored in a verified token's subject
+
+Params:
+  - token: A pointer to a verified token
+
+Returns:
+  - The user id
+  - An error if the subject is missing or not a uuid
+*/
+func SubjectFromToken(token *jwt.Token) (uuid.UUID, error) {
+	subject, err := token.Claims.GetSubject()
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("[FAIL]: token subject missing: %w", err)
+	}
+
+	userID, err := uuid.Parse(subject)
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("[FAIL]: token subject is not a valid id: %w", err)
+	}
+
+	return userID, nil
+}
+
+func createToken(userID uuid.UUID, audience string, lifetime time.Duration) (string, error) {
+	secretKey, err := signingKey()
+	if err != nil {
+		return "", err
+	}
 
 	claims := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
 		"sub":    userID,
 		"issuer": "go-auth-server",
-		"aud":    "user",
+		"aud":    audience,
 		"iat":    time.Now().Unix(),
-		"exp":    time.Now().Add(time.Hour).Unix(),
+		"exp":    time.Now().Add(lifetime).Unix(),
 	})
 
 	log.Printf("[SUCCESS]: token claims added: %+v\n", claims)
@@ -40,21 +124,14 @@ func CreateJWToken(userID uuid.UUID) (string, error) {
 	return tokenString, nil
 }
 
-func VerifyToken(tokenString string) (*jwt.Token, error) {
-	// Load environment variables from .env file in development
-	if env := os.Getenv("ENVIRONMENT"); env != "production" {
-		err := godotenv.Load()
-		if err != nil {
-			return nil, fmt.Errorf("[FAIL]: could not load environment variables: %w", err)
-		}
+func verifyToken(tokenString string, audience string) (*jwt.Token, error) {
+	secretKey, err := signingKey()
+	if err != nil {
+		return nil, err
 	}
 
-	var secretKey = []byte(os.Getenv("JWT_SECRET_KEY"))
-
 	// Verify token
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		return secretKey, nil
-	})
+	token, err := jwt.Parse(tokenString, internalTokenKey(secretKey), jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}), jwt.WithAudience(audience))
 
 	if err != nil {
 		return nil, err
@@ -66,3 +143,60 @@ func VerifyToken(tokenString string) (*jwt.Token, error) {
 
 	return token, nil
 }
+
+/*
+Returns the key function of the tokens this server issues to itself
+
+Objectives:
+  - Reject access tokens, they are signed with the same key and their audience and subject are set by clients
+
+Params:
+  - secretKey: The key tokens are signed with
+
+Returns:
+  - The key function
+*/
+func internalTokenKey(secretKey []byte) jwt.Keyfunc {
+	return func(token *jwt.Token) (interface{}, error) {
+		if isAccessTokenType(token) {
+			return nil, fmt.Errorf("[FAIL]: access tokens are not accepted here")
+		}
+
+		return secretKey, nil
+	}
+}
+
+/*
+Checks whether a token's typ header marks it as an access token, RFC 9068
+
+Params:
+  - token: A pointer to the parsed token
+
+Returns:
+  - True for the at+jwt type, with or without the application/ prefix
+*/
+func isAccessTokenType(token *jwt.Token) bool {
+	typ, _ := token.Header["typ"].(string)
+	typ = strings.TrimPrefix(strings.ToLower(typ), "application/")
+
+	return typ == accessTokenType
+}
+
+/*
+Returns the key tokens are signed with
+
+Returns:
+  - The JWT_SECRET_KEY bytes
+  - An error if the environment can't be loaded
+*/
+func signingKey() ([]byte, error) {
+	// Load environment variables from .env file in development
+	if env := os.Getenv("ENVIRONMENT"); env != "production" {
+		err := godotenv.Load()
+		if err != nil {
+			return nil, fmt.Errorf("[FAIL]: could not load environment variables: %w", err)
+		}
+	}
+
+	return []byte(os.Getenv("JWT_SECRET_KEY")), nil
+}
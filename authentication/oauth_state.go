@@ -0,0 +1,94 @@
+package authentication
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Audience of OAuth state tokens, they are never accepted as session or mfa tokens
+const oauthStateAudience = "oauth_state"
+
+// How long a user has to complete an OAuth flow
+const OAuthStateLifetime = 10 * time.Minute
+
+/*
+OAuth state claims struct, what a callback needs to complete the flow it was started with
+
+Fields:
+  - Provider:         string, the provider the flow was started for
+  - Verifier:         string, the PKCE code verifier
+  - Nonce:            string, the nonce the id token must contain
+  - LinkUserID:       string, the signed-in user the identity is linked to, empty for sign-in flows
+  - ReturnTo:         string, the allowlisted URL the flow redirects to once it ends, empty to respond with JSON
+  - FormPost:         bool, whether the provider posts the callback cross-site, without the session cookie
+  - RegisteredClaims: The token id is the state parameter sent to the provider
+*/
+type OAuthState struct {
+	Provider   string `json:"provider"`
+	Verifier   string `json:"verifier"`
+	Nonce      string `json:"nonce,omitempty"`
+	LinkUserID string `json:"link_user_id,omitempty"`
+	ReturnTo   string `json:"return_to,omitempty"`
+	FormPost   bool   `json:"form_post,omitempty"`
+	jwt.RegisteredClaims
+}
+
+/*
+Signs OAuth state claims into a token, so any server instance can complete the flow
+
+Params:
+  - state: The OAuth state claims, its token id must be set
+
+Returns:
+  - The signed token string
+  - An error if signing failed
+*/
+func CreateOAuthStateToken(state OAuthState) (string, error) {
+	secretKey, err := signingKey()
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	state.Audience = jwt.ClaimStrings{oauthStateAudience}
+	state.IssuedAt = jwt.NewNumericDate(now)
+	state.ExpiresAt = jwt.NewNumericDate(now.Add(OAuthStateLifetime))
+
+	tokenString, err := jwt.NewWithClaims(jwt.SigningMethodHS256, state).SignedString(secretKey)
+	if err != nil {
+		return "", fmt.Errorf("[FAIL]: could not sign oauth state: %w", err)
+	}
+
+	return tokenString, nil
+}
+
+/*
+Verifies a token created by CreateOAuthStateToken
+
+Params:
+  - tokenString: The token string
+
+Returns:
+  - The OAuth state claims
+  - An error if the token is invalid or expired
+*/
+func VerifyOAuthStateToken(tokenString string) (OAuthState, error) {
+	secretKey, err := signingKey()
+	if err != nil {
+		return OAuthState{}, err
+	}
+
+	var state OAuthState
+	_, err = jwt.ParseWithClaims(tokenString, &state, internalTokenKey(secretKey), jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}), jwt.WithAudience(oauthStateAudience), jwt.WithExpirationRequired())
+	if err != nil {
+		return OAuthState{}, fmt.Errorf("[FAIL]: invalid oauth state: %w", err)
+	}
+
+	if state.ID == "" || state.Verifier == "" {
+		return OAuthState{}, fmt.Errorf("[FAIL]: invalid oauth state: missing id or verifier")
+	}
+
+	return state, nil
+}
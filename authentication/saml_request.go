@@ -0,0 +1,86 @@
+package authentication
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Audience of SAML request tokens, they are never accepted as session, mfa or oauth state tokens
+const samlRequestAudience = "saml_request"
+
+// How long a user has to complete a SAML sign-in
+const SAMLRequestLifetime = 10 * time.Minute
+
+/*
+SAML request claims struct, what the ACS needs to verify the response to an AuthnRequest
+
+Fields:
+  - Connection:       string, the connection the AuthnRequest was sent to
+  - ReturnTo:         string, the allowlisted URL the flow redirects to once it ends, empty to respond with JSON
+  - RegisteredClaims: The token id is the AuthnRequest id the response must answer
+*/
+type SAMLRequest struct {
+	Connection string `json:"connection"`
+	ReturnTo   string `json:"return_to,omitempty"`
+	jwt.RegisteredClaims
+}
+
+/*
+Signs SAML request claims into a token, so any server instance can verify the response
+
+Params:
+  - request: The SAML request claims, its token id must be set
+
+Returns:
+  - The signed token string
+  - An error if signing failed
+*/
+func CreateSAMLRequestToken(request SAMLRequest) (string, error) {
+	secretKey, err := signingKey()
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	request.Audience = jwt.ClaimStrings{samlRequestAudience}
+	request.IssuedAt = jwt.NewNumericDate(now)
+	request.ExpiresAt = jwt.NewNumericDate(now.Add(SAMLRequestLifetime))
+
+	tokenString, err := jwt.NewWithClaims(jwt.SigningMethodHS256, request).SignedString(secretKey)
+	if err != nil {
+		return "", fmt.Errorf("[FAIL]: could not sign saml request: %w", err)
+	}
+
+	return tokenString, nil
+}
+
+/*
+Verifies a token created by CreateSAMLRequestToken
+
+Params:
+  - tokenString: The token string
+
+Returns:
+  - The SAML request claims
+  - An error if the token is invalid or expired
+*/
+func VerifySAMLRequestToken(tokenString string) (SAMLRequest, error) {
+	secretKey, err := signingKey()
+	if err != nil {
+		return SAMLRequest{}, err
+	}
+
+	var request SAMLRequest
+	_, err = jwt.ParseWithClaims(tokenString, &request, internalTokenKey(secretKey), jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}), jwt.WithAudience(samlRequestAudience), jwt.WithExpirationRequired())
+	if err != nil {
+		return SAMLRequest{}, fmt.Errorf("[FAIL]: invalid saml request: %w", err)
+	}
+
+	if request.ID == "" || request.Connection == "" {
+		return SAMLRequest{}, fmt.Errorf("[FAIL]: invalid saml request: missing id or connection")
+	}
+
+	return request, nil
+}
@@ -0,0 +1,203 @@
+package authserver
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Assertion type of RFC 7523 client authentication, sent as client_assertion_type
+const ClientAssertionTypeJWT = "urn:ietf:params:oauth:client-assertion-type:jwt-bearer"
+
+// Assertions valid for longer are rejected, their ids are kept until they expire
+const maxClientAssertionLifetime = 10 * time.Minute
+
+// Algorithms client assertions may be signed with
+var clientAssertionAlgorithms = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384"}
+
+/*
+Public key struct, a key from the JSON Web Key Set of a client
+
+Fields:
+  - ID:  The key id, may be empty
+  - Key: The RSA or ECDSA public key
+*/
+type PublicKey struct {
+	ID  string
+	Key crypto.PublicKey
+}
+
+/*
+Parses the signing keys of a JSON Web Key Set
+
+Objectives:
+  - Decode the RSA and EC keys, skipping encryption keys
+  - Reject RSA keys shorter than 2048 bits and unknown curves
+
+Params:
+  - data: The JSON Web Key Set
+
+Returns:
+  - The public keys
+  - An error if the set is malformed or has no signing key
+*/
+func ParseJWKS(data []byte) ([]PublicKey, error) {
+	var set struct {
+		Keys []struct {
+			KeyType string `json:"kty"`
+			KeyID   string `json:"kid"`
+			Use     string `json:"use"`
+			N       string `json:"n"`
+			E       string `json:"e"`
+			Curve   string `json:"crv"`
+			X       string `json:"x"`
+			Y       string `json:"y"`
+		} `json:"keys"`
+	}
+
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("[FAIL]: jwks is not valid json: %w", err)
+	}
+
+	var keys []PublicKey
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+
+		switch jwk.KeyType {
+		case "RSA":
+			n, errN := decodeBigInt(jwk.N)
+			e, errE := decodeBigInt(jwk.E)
+			if errN != nil || errE != nil || !e.IsInt64() || e.Int64() < 3 || e.Int64() > 1<<31-1 {
+				return nil, fmt.Errorf("[FAIL]: jwks has a malformed RSA key")
+			}
+			if n.BitLen() < minSigningKeyBits {
+				return nil, fmt.Errorf("[FAIL]: jwks RSA keys must be at least %d bits", minSigningKeyBits)
+			}
+			keys = append(keys, PublicKey{ID: jwk.KeyID, Key: &rsa.PublicKey{N: n, E: int(e.Int64())}})
+		case "EC":
+			var curve elliptic.Curve
+			switch jwk.Curve {
+			case "P-256":
+				curve = elliptic.P256()
+			case "P-384":
+				curve = elliptic.P384()
+			default:
+				return nil, fmt.Errorf("[FAIL]: jwks EC curve %q is not supported", jwk.Curve)
+			}
+			x, errX := decodeBigInt(jwk.X)
+			y, errY := decodeBigInt(jwk.Y)
+			if errX != nil || errY != nil || !curve.IsOnCurve(x, y) {
+				return nil, fmt.Errorf("[FAIL]: jwks has a malformed EC key")
+			}
+			keys = append(keys, PublicKey{ID: jwk.KeyID, Key: &ecdsa.PublicKey{Curve: curve, X: x, Y: y}})
+		default:
+			return nil, fmt.Errorf("[FAIL]: jwks key type %q is not supported", jwk.KeyType)
+		}
+	}
+
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("[FAIL]: jwks has no signing key")
+	}
+
+	return keys, nil
+}
+
+/*
+Verifies a RFC 7523 client assertion
+
+Objectives:
+  - Pick the key named by the assertion, or try every key of a set without key ids
+  - Check the client is the issuer and subject, and the assertion is meant for this server
+  - Require an id and an expiry at most 10 minutes ahead, so the id can be recorded until it expires
+
+Params:
+  - assertion: The client_assertion parameter
+  - clientID:  The client the assertion must be issued by
+  - keys:      The public keys of the client
+  - audiences: The audiences the assertion may be issued for, the token endpoint URL and the issuer
+
+Returns:
+  - The assertion claims
+  - An error if the assertion is invalid
+*/
+func VerifyClientAssertion(assertion string, clientID string, keys []PublicKey, audiences []string) (jwt.RegisteredClaims, error) {
+	var (
+		claims   jwt.RegisteredClaims
+		verified bool
+	)
+
+	for _, key := range keys {
+		claims = jwt.RegisteredClaims{}
+		_, err := jwt.ParseWithClaims(assertion, &claims, func(token *jwt.Token) (interface{}, error) {
+			if kid, ok := token.Header["kid"].(string); ok && key.ID != "" && kid != key.ID {
+				return nil, errors.New("key id does not match")
+			}
+			return key.Key, nil
+		}, jwt.WithValidMethods(clientAssertionAlgorithms), jwt.WithExpirationRequired(),
+			jwt.WithIssuer(clientID), jwt.WithSubject(clientID))
+		if err == nil {
+			verified = true
+			break
+		}
+		if !errors.Is(err, jwt.ErrTokenSignatureInvalid) && !errors.Is(err, jwt.ErrTokenUnverifiable) {
+			return jwt.RegisteredClaims{}, fmt.Errorf("[FAIL]: invalid client assertion: %w", err)
+		}
+	}
+
+	if !verified {
+		return jwt.RegisteredClaims{}, fmt.Errorf("[FAIL]: invalid client assertion: no key of the client verifies it")
+	}
+
+	audienceAllowed := false
+	for _, audience := range claims.Audience {
+		for _, allowed := range audiences {
+			if audience == allowed {
+				audienceAllowed = true
+			}
+		}
+	}
+	if !audienceAllowed {
+		return jwt.RegisteredClaims{}, fmt.Errorf("[FAIL]: invalid client assertion: issued for another audience")
+	}
+
+	if claims.ID == "" {
+		return jwt.RegisteredClaims{}, fmt.Errorf("[FAIL]: invalid client assertion: missing jti")
+	}
+
+	if time.Until(claims.ExpiresAt.Time) > maxClientAssertionLifetime {
+		return jwt.RegisteredClaims{}, fmt.Errorf("[FAIL]: invalid client assertion: expires more than %s ahead", maxClientAssertionLifetime)
+	}
+
+	return claims, nil
+}
+
+/*
+Returns the algorithms client assertions may be signed with, advertised in the server metadata
+
+Returns:
+  - The JWS algorithm names
+*/
+func ClientAssertionAlgorithms() []string {
+	return append([]string(nil), clientAssertionAlgorithms...)
+}
+
+// Decodes a base64url encoded big-endian integer of a JSON Web Key
+func decodeBigInt(value string) (*big.Int, error) {
+	bytes, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil || len(bytes) == 0 {
+		return nil, fmt.Errorf("[FAIL]: malformed key parameter")
+	}
+
+	return new(big.Int).SetBytes(bytes), nil
+}
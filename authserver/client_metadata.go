@@ -0,0 +1,171 @@
+package authserver
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/dev-xero/authentication-backend/authentication"
+	"github.com/dev-xero/authentication-backend/model"
+	"github.com/dev-xero/authentication-backend/util"
+)
+
+// Error codes of RFC 7591, a client metadata error carries one of them
+const (
+	MetadataErrorInvalidRedirectURI = "invalid_redirect_uri"
+	MetadataErrorInvalidMetadata    = "invalid_client_metadata"
+)
+
+/*
+Client metadata error struct, why a client can't be registered
+
+Fields:
+  - Code:        string, the RFC 7591 error code
+  - Description: string, the invalid field and why
+*/
+type ClientMetadataError struct {
+	Code        string
+	Description string
+}
+
+func (err *ClientMetadataError) Error() string {
+	return "[FAIL]: " + err.Description
+}
+
+func invalidMetadata(format string, args ...interface{}) error {
+	return &ClientMetadataError{Code: MetadataErrorInvalidMetadata, Description: fmt.Sprintf(format, args...)}
+}
+
+func invalidRedirectURI(format string, args ...interface{}) error {
+	return &ClientMetadataError{Code: MetadataErrorInvalidRedirectURI, Description: fmt.Sprintf(format, args...)}
+}
+
+/*
+Validates the metadata of a client before it is registered or updated
+
+Objectives:
+  - Check the auth method is supported, private_key_jwt and self_signed_tls_client_auth clients have a valid key set, and tls_client_auth clients name their certificate
+  - Check the grant types are supported and have what they need, audiences, subject token clients or redirect URIs
+  - Check no audience is one of the audiences of the tokens this server issues to itself
+  - Check redirect URIs are absolute, without a fragment, and use https unless they point to loopback
+  - Check the URIs shown to users use https and contacts are email addresses
+
+Params:
+  - client: The client
+
+Returns:
+  - A *ClientMetadataError describing the first invalid field
+*/
+func ValidateClient(client model.OAuthClient) error {
+	if client.Name == "" {
+		return invalidMetadata("client name is required")
+	}
+
+	switch client.TokenEndpointAuthMethod {
+	case model.ClientAuthSecretBasic, model.ClientAuthSecretPost, model.ClientAuthNone, model.ClientAuthTLS:
+		if client.JWKS != "" {
+			return invalidMetadata("jwks is only used by private_key_jwt and self_signed_tls_client_auth clients")
+		}
+	case model.ClientAuthPrivateKey, model.ClientAuthSelfSigned:
+		if _, err := ParseJWKS([]byte(client.JWKS)); err != nil {
+			return invalidMetadata("%s clients need a valid jwks: %s", client.TokenEndpointAuthMethod, strings.TrimPrefix(err.Error(), "[FAIL]: "))
+		}
+	default:
+		return invalidMetadata("unsupported auth method %q", client.TokenEndpointAuthMethod)
+	}
+
+	// The certificate of a tls_client_auth client is named by exactly one of its subject DN or DNS name
+	hasSubjectDN, hasSANDNS := client.TLSSubjectDN != "", client.TLSSANDNS != ""
+	if client.TokenEndpointAuthMethod == model.ClientAuthTLS && hasSubjectDN == hasSANDNS {
+		return invalidMetadata("tls_client_auth clients need either tls_client_auth_subject_dn or tls_client_auth_san_dns")
+	}
+	if client.TokenEndpointAuthMethod != model.ClientAuthTLS && (hasSubjectDN || hasSANDNS) {
+		return invalidMetadata("certificate names are only used by tls_client_auth clients")
+	}
+
+	for _, grantType := range client.GrantTypes {
+		switch grantType {
+		case model.GrantAuthorizationCode, model.GrantRefreshToken, model.GrantClientCredentials, model.GrantDeviceCode, model.GrantTokenExchange:
+		default:
+			return invalidMetadata("unsupported grant type %q", grantType)
+		}
+	}
+
+	if client.AllowsGrant(model.GrantClientCredentials) {
+		if client.TokenEndpointAuthMethod == model.ClientAuthNone {
+			return invalidMetadata("the client_credentials grant requires a confidential client")
+		}
+		if len(client.Audiences) == 0 {
+			return invalidMetadata("the client_credentials grant requires audiences")
+		}
+	}
+
+	if client.AllowsGrant(model.GrantTokenExchange) {
+		if client.TokenEndpointAuthMethod == model.ClientAuthNone {
+			return invalidMetadata("the token exchange grant requires a confidential client")
+		}
+		if len(client.Audiences) == 0 || len(client.SubjectTokenClients) == 0 {
+			return invalidMetadata("the token exchange grant requires audiences and subject token clients")
+		}
+	}
+
+	// Tokens for a reserved audience would be accepted as sessions or other internal tokens
+	for _, audience := range client.Audiences {
+		if authentication.IsReservedAudience(audience) {
+			return invalidMetadata("audience %q is reserved", audience)
+		}
+	}
+
+	if client.AllowsGrant(model.GrantAuthorizationCode) && len(client.RedirectURIs) == 0 {
+		return invalidRedirectURI("the authorization_code grant requires redirect URIs")
+	}
+
+	for _, redirectURI := range client.RedirectURIs {
+		parsed, err := url.Parse(redirectURI)
+		if err != nil || parsed.Scheme == "" || parsed.Fragment != "" {
+			return invalidRedirectURI("redirect URI %q must be absolute and without a fragment", redirectURI)
+		}
+		if parsed.Scheme == "http" && !isLoopback(parsed.Hostname()) {
+			return invalidRedirectURI("redirect URI %q must use https, http is only allowed for loopback", redirectURI)
+		}
+		if (parsed.Scheme == "http" || parsed.Scheme == "https") && parsed.Host == "" {
+			return invalidRedirectURI("redirect URI %q has no host", redirectURI)
+		}
+	}
+
+	userFacingURIs := []struct{ name, value string }{
+		{"client_uri", client.ClientURI},
+		{"logo_uri", client.LogoURI},
+		{"policy_uri", client.PolicyURI},
+		{"tos_uri", client.TOSURI},
+	}
+	for _, uri := range userFacingURIs {
+		if uri.value == "" {
+			continue
+		}
+		if parsed, err := url.Parse(uri.value); err != nil || parsed.Scheme != "https" || parsed.Host == "" {
+			return invalidMetadata("%s must be an absolute https URL", uri.name)
+		}
+	}
+
+	for _, contact := range client.Contacts {
+		if !util.IsValidEmail(contact) {
+			return invalidMetadata("contact %q must be an email address", contact)
+		}
+	}
+
+	return nil
+}
+
+/*
+Checks whether a redirect URI host is the loopback interface
+
+Params:
+  - host: The host name, without port
+
+Returns:
+  - True for localhost and the loopback addresses
+*/
+func isLoopback(host string) bool {
+	return host == "localhost" || host == "127.0.0.1" || host == "::1"
+}
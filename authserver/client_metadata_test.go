@@ -0,0 +1,39 @@
+package authserver
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/dev-xero/authentication-backend/model"
+)
+
+// A confidential client allowed the client credentials grant for audiences
+func newTestServiceClient(audiences ...string) model.OAuthClient {
+	return model.OAuthClient{
+		ID:                      "billing",
+		Name:                    "Billing",
+		GrantTypes:              []string{model.GrantClientCredentials},
+		TokenEndpointAuthMethod: model.ClientAuthSecretBasic,
+		Audiences:               audiences,
+	}
+}
+
+func TestValidateClientAcceptsServiceAudiences(t *testing.T) {
+	if err := ValidateClient(newTestServiceClient("invoices", "api")); err != nil {
+		t.Fatalf("client of service audiences was rejected: %v", err)
+	}
+}
+
+// Access tokens share the signing key of session and other internal tokens, so their audiences can't be registered
+func TestValidateClientRejectsReservedAudiences(t *testing.T) {
+	for _, audience := range []string{"user", "mfa", "oauth_state", "saml_request", "oauth_consent", "device_approval"} {
+		t.Run(audience, func(t *testing.T) {
+			err := ValidateClient(newTestServiceClient("invoices", audience))
+
+			var metadataErr *ClientMetadataError
+			if !errors.As(err, &metadataErr) || metadataErr.Code != MetadataErrorInvalidMetadata {
+				t.Fatalf("client with audience %q: got %v, want invalid_client_metadata", audience, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,168 @@
+package authserver
+
+import (
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/joho/godotenv"
+)
+
+/*
+Authorization server config struct
+
+Fields:
+  - Issuer:               The issuer URL, the public URL the /oauth routes are served below
+  - LoginURL:             Where users without a session are sent to sign-in, with the authorize URL as return_to
+  - CodeLifetime:         How long an authorization code can be exchanged
+  - DeviceCodeLifetime:   How long a device code waits for approval
+  - DevicePollInterval:   How long devices wait between polls of the token endpoint
+  - AccessTokenLifetime:  How long an access token is valid
+  - RefreshTokenLifetime: How long a refresh token is valid
+  - SigningKey:           The key id tokens are signed with
+  - Registration:         The dynamic client registration policy, nil when registration is disabled
+  - MTLSBaseURL:          The public URL of the TLS listener requesting client certificates, empty when mutual TLS is disabled
+  - ClientCAs:            The CAs trusted to issue the certificates of tls_client_auth clients, nil when none are trusted
+*/
+type Config struct {
+	Issuer               string
+	LoginURL             string
+	CodeLifetime         time.Duration
+	DeviceCodeLifetime   time.Duration
+	DevicePollInterval   time.Duration
+	AccessTokenLifetime  time.Duration
+	RefreshTokenLifetime time.Duration
+	SigningKey           *SigningKey
+	Registration         *RegistrationPolicy
+	MTLSBaseURL          string
+	ClientCAs            *x509.CertPool
+}
+
+/*
+Creates the authorization server config from environment variables
+
+Objectives:
+  - Enable the authorization server when OAUTH_ISSUER is set
+  - Load the token lifetimes, 15 minute access tokens and 30 day refresh tokens unless configured
+  - Load the id token signing key from OAUTH_SIGNING_KEY_FILE
+  - Load the dynamic client registration policy
+  - Load the mutual TLS endpoint and the CAs trusted for client certificates
+
+Params:
+  - No parameters
+
+Returns:
+  - A pointer to the config, nil when OAUTH_ISSUER isn't set
+  - An error if a setting is malformed
+*/
+func NewConfigFromEnvironment() (*Config, error) {
+	// Load environment variables from .env file in development
+	if env := os.Getenv("ENVIRONMENT"); env != "production" {
+		err := godotenv.Load()
+		if err != nil {
+			return nil, fmt.Errorf("[FAIL]: could not load environment variables: %w", err)
+		}
+	}
+
+	issuer := strings.TrimSuffix(os.Getenv("OAUTH_ISSUER"), "/")
+	if issuer == "" {
+		return nil, nil
+	}
+
+	if parsed, err := url.Parse(issuer); err != nil || (parsed.Scheme != "https" && parsed.Scheme != "http") || parsed.Host == "" || parsed.RawQuery != "" || parsed.Fragment != "" {
+		return nil, fmt.Errorf("[FAIL]: OAUTH_ISSUER must be an absolute http or https URL without query or fragment")
+	}
+
+	config := &Config{
+		Issuer:               issuer,
+		LoginURL:             os.Getenv("OAUTH_LOGIN_URL"),
+		CodeLifetime:         time.Minute,
+		DeviceCodeLifetime:   10 * time.Minute,
+		DevicePollInterval:   5 * time.Second,
+		AccessTokenLifetime:  15 * time.Minute,
+		RefreshTokenLifetime: 30 * 24 * time.Hour,
+	}
+
+	if value := os.Getenv("OAUTH_ACCESS_TOKEN_LIFETIME_MINUTES"); value != "" {
+		minutes, err := strconv.Atoi(value)
+		if err != nil || minutes <= 0 {
+			return nil, fmt.Errorf("[FAIL]: OAUTH_ACCESS_TOKEN_LIFETIME_MINUTES must be a positive number")
+		}
+		config.AccessTokenLifetime = time.Duration(minutes) * time.Minute
+	}
+
+	if value := os.Getenv("OAUTH_REFRESH_TOKEN_LIFETIME_DAYS"); value != "" {
+		days, err := strconv.Atoi(value)
+		if err != nil || days <= 0 {
+			return nil, fmt.Errorf("[FAIL]: OAUTH_REFRESH_TOKEN_LIFETIME_DAYS must be a positive number")
+		}
+		config.RefreshTokenLifetime = time.Duration(days) * 24 * time.Hour
+	}
+
+	keyFile := os.Getenv("OAUTH_SIGNING_KEY_FILE")
+	if keyFile == "" {
+		return nil, fmt.Errorf("[FAIL]: OAUTH_SIGNING_KEY_FILE is required when OAUTH_ISSUER is set")
+	}
+
+	keyData, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("[FAIL]: could not read signing key: %w", err)
+	}
+
+	config.SigningKey, err = ParseSigningKey(keyData)
+	if err != nil {
+		return nil, err
+	}
+
+	config.Registration, err = newRegistrationPolicyFromEnvironment()
+	if err != nil {
+		return nil, err
+	}
+
+	config.MTLSBaseURL = strings.TrimSuffix(os.Getenv("OAUTH_MTLS_BASE_URL"), "/")
+	if config.MTLSBaseURL != "" {
+		if parsed, err := url.Parse(config.MTLSBaseURL); err != nil || parsed.Scheme != "https" || parsed.Host == "" || parsed.RawQuery != "" || parsed.Fragment != "" {
+			return nil, fmt.Errorf("[FAIL]: OAUTH_MTLS_BASE_URL must be an absolute https URL without query or fragment")
+		}
+	}
+
+	if caFile := os.Getenv("OAUTH_MTLS_CA_FILE"); caFile != "" {
+		if config.MTLSBaseURL == "" {
+			return nil, fmt.Errorf("[FAIL]: OAUTH_MTLS_CA_FILE requires OAUTH_MTLS_BASE_URL")
+		}
+
+		caData, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("[FAIL]: could not read client CA file: %w", err)
+		}
+
+		config.ClientCAs = x509.NewCertPool()
+		if !config.ClientCAs.AppendCertsFromPEM(caData) {
+			return nil, fmt.Errorf("[FAIL]: OAUTH_MTLS_CA_FILE holds no PEM encoded certificate")
+		}
+	}
+
+	return config, nil
+}
+
+/*
+Returns the base URL a request was sent to, as the client sees it, e.g. for comparing with the htu claim of DPoP proofs
+
+Params:
+  - r: A pointer to a http request object
+
+Returns:
+  - The mutual TLS URL for requests received by the TLS listener, the issuer otherwise, since the server may run behind a proxy terminating TLS
+*/
+func (config *Config) BaseURL(r *http.Request) string {
+	if r.TLS != nil && config.MTLSBaseURL != "" {
+		return config.MTLSBaseURL
+	}
+
+	return config.Issuer
+}
@@ -0,0 +1,249 @@
+package authserver
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Type header of RFC 9449 proofs, set so other JWTs signed by the client can't be presented as proofs
+const DPoPProofType = "dpop+jwt"
+
+// Proofs issued longer ago, or further ahead than the clock skew, are rejected, their ids are kept until they can't be accepted anymore
+const (
+	dpopProofMaxAge    = 5 * time.Minute
+	dpopProofClockSkew = time.Minute
+)
+
+/*
+DPoP proof struct, a verified proof of possession of a client key
+
+Fields:
+  - KeyThumbprint: The RFC 7638 thumbprint of the public key the proof was signed with, the jkt tokens are bound to
+  - ID:            The proof id, recorded so the proof can't be replayed
+  - IssuedAt:      When the client created the proof
+*/
+type DPoPProof struct {
+	KeyThumbprint string
+	ID            string
+	IssuedAt      time.Time
+}
+
+/*
+Returns when a proof stops being accepted, its id must be kept until then
+
+Returns:
+  - The time the proof expires
+*/
+func (proof DPoPProof) ExpiresAt() time.Time {
+	return proof.IssuedAt.Add(dpopProofMaxAge)
+}
+
+/*
+DPoP proof claims struct
+
+Fields:
+  - Method:           string, the HTTP method of the request the proof was created for
+  - URL:              string, the URL of the request, without query and fragment
+  - AccessTokenHash:  string, the base64url encoded SHA-256 hash of the access token presented with the proof
+  - RegisteredClaims: The proof id and issue time
+*/
+type dpopClaims struct {
+	Method          string `json:"htm"`
+	URL             string `json:"htu"`
+	AccessTokenHash string `json:"ath,omitempty"`
+	jwt.RegisteredClaims
+}
+
+/*
+Reads the DPoP proof of a request
+
+Params:
+  - r: A pointer to a http request object
+
+Returns:
+  - The proof, empty if the request has none
+  - An error if the request carries more than one proof
+*/
+func DPoPProofFromRequest(r *http.Request) (string, error) {
+	values := r.Header.Values("DPoP")
+	switch {
+	case len(values) == 0:
+		return "", nil
+	case len(values) > 1:
+		return "", fmt.Errorf("[FAIL]: request carries more than one dpop proof")
+	}
+
+	return strings.TrimSpace(values[0]), nil
+}
+
+/*
+Verifies a RFC 9449 DPoP proof
+
+Objectives:
+  - Check the proof is typed dpop+jwt and signed with the public key of its jwk header, private keys are refused
+  - Check the proof was created for the method and URL of the request, recently, and has an id
+  - Check the proof covers the access token presented with it, for requests to resource servers
+
+Params:
+  - proof:       The DPoP header
+  - method:      The HTTP method of the request
+  - targetURL:   The URL the request was sent to, query and fragment are ignored
+  - accessToken: The access token presented with the proof, empty for token requests
+
+Returns:
+  - The verified proof
+  - An error if the proof is invalid
+*/
+func VerifyDPoPProof(proof string, method string, targetURL string, accessToken string) (DPoPProof, error) {
+	var (
+		claims     dpopClaims
+		thumbprint string
+	)
+
+	_, err := jwt.ParseWithClaims(proof, &claims, func(token *jwt.Token) (interface{}, error) {
+		if typ, _ := token.Header["typ"].(string); typ != DPoPProofType {
+			return nil, errors.New("typ is not " + DPoPProofType)
+		}
+
+		jwk, ok := token.Header["jwk"].(map[string]interface{})
+		if !ok {
+			return nil, errors.New("jwk header is missing")
+		}
+		if _, private := jwk["d"]; private {
+			return nil, errors.New("jwk header holds a private key")
+		}
+
+		key, err := parseJWK(jwk)
+		if err != nil {
+			return nil, err
+		}
+
+		thumbprint, err = keyThumbprint(key)
+		if err != nil {
+			return nil, err
+		}
+		return key, nil
+	}, jwt.WithValidMethods(clientAssertionAlgorithms))
+	if err != nil {
+		return DPoPProof{}, fmt.Errorf("[FAIL]: invalid dpop proof: %w", err)
+	}
+
+	if claims.ID == "" || claims.IssuedAt == nil {
+		return DPoPProof{}, fmt.Errorf("[FAIL]: invalid dpop proof: missing jti or iat")
+	}
+
+	if claims.Method != method {
+		return DPoPProof{}, fmt.Errorf("[FAIL]: invalid dpop proof: created for method %q", claims.Method)
+	}
+
+	if !sameTargetURL(claims.URL, targetURL) {
+		return DPoPProof{}, fmt.Errorf("[FAIL]: invalid dpop proof: created for url %q", claims.URL)
+	}
+
+	issuedAt := claims.IssuedAt.Time
+	if age := time.Since(issuedAt); age > dpopProofMaxAge || age < -dpopProofClockSkew {
+		return DPoPProof{}, fmt.Errorf("[FAIL]: invalid dpop proof: issued %s ago", age.Round(time.Second))
+	}
+
+	if accessToken != "" {
+		sum := sha256.Sum256([]byte(accessToken))
+		expected := base64.RawURLEncoding.EncodeToString(sum[:])
+		if subtle.ConstantTimeCompare([]byte(claims.AccessTokenHash), []byte(expected)) != 1 {
+			return DPoPProof{}, fmt.Errorf("[FAIL]: invalid dpop proof: ath does not match the access token")
+		}
+	}
+
+	return DPoPProof{KeyThumbprint: thumbprint, ID: claims.ID, IssuedAt: issuedAt}, nil
+}
+
+/*
+Returns the algorithms DPoP proofs may be signed with, advertised in the server metadata
+
+Returns:
+  - The JWS algorithm names
+*/
+func DPoPAlgorithms() []string {
+	return append([]string(nil), clientAssertionAlgorithms...)
+}
+
+// Parses the public key of a proof's jwk header with the rules of client key sets
+func parseJWK(jwk map[string]interface{}) (crypto.PublicKey, error) {
+	data, err := json.Marshal(map[string]interface{}{"keys": []interface{}{jwk}})
+	if err != nil {
+		return nil, err
+	}
+
+	keys, err := ParseJWKS(data)
+	if err != nil {
+		return nil, errors.New(strings.TrimPrefix(err.Error(), "[FAIL]: "))
+	}
+
+	return keys[0].Key, nil
+}
+
+// Computes the RFC 7638 thumbprint of a public key, the required members in lexicographic order
+func keyThumbprint(key crypto.PublicKey) (string, error) {
+	encode := func(bytes []byte) string {
+		return base64.RawURLEncoding.EncodeToString(bytes)
+	}
+
+	var members []byte
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		members, _ = json.Marshal(struct {
+			E   string `json:"e"`
+			Kty string `json:"kty"`
+			N   string `json:"n"`
+		}{encode(big.NewInt(int64(key.E)).Bytes()), "RSA", encode(key.N.Bytes())})
+	case *ecdsa.PublicKey:
+		// Coordinates are encoded at the full size of the curve, leading zeros included
+		size := (key.Curve.Params().BitSize + 7) / 8
+		members, _ = json.Marshal(struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		}{key.Curve.Params().Name, "EC", encode(key.X.FillBytes(make([]byte, size))), encode(key.Y.FillBytes(make([]byte, size)))})
+	default:
+		return "", errors.New("key type is not supported")
+	}
+
+	sum := sha256.Sum256(members)
+	return encode(sum[:]), nil
+}
+
+// Compares the htu claim of a proof to the request URL, ignoring query, fragment and the case of scheme and host
+func sameTargetURL(claimed string, target string) bool {
+	normalize := func(raw string) (string, bool) {
+		parsed, err := url.Parse(raw)
+		if err != nil || parsed.Scheme == "" || parsed.Host == "" {
+			return "", false
+		}
+		path := parsed.EscapedPath()
+		if path == "" {
+			path = "/"
+		}
+		return strings.ToLower(parsed.Scheme) + "://" + strings.ToLower(parsed.Host) + path, true
+	}
+
+	claimedURL, ok := normalize(claimed)
+	if !ok {
+		return false
+	}
+	targetURL, ok := normalize(target)
+	return ok && claimedURL == targetURL
+}
@@ -0,0 +1,81 @@
+package authserver
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Scopes of OpenID Connect, openid makes the token endpoint issue an id token
+const (
+	ScopeOpenID  = "openid"
+	ScopeProfile = "profile"
+	ScopeEmail   = "email"
+)
+
+/*
+ID token claims struct, the OpenID Connect claims about the authentication of a user
+
+Fields:
+  - Nonce:            string, the nonce of the authorization request
+  - AuthTime:         When the user signed-in
+  - AccessTokenHash:  string, binds the id token to the access token issued with it
+  - AuthorizedParty:  string, the client the token was issued to
+  - RegisteredClaims: The subject is the user id, the audience the client id
+*/
+type IDTokenClaims struct {
+	Nonce           string           `json:"nonce,omitempty"`
+	AuthTime        *jwt.NumericDate `json:"auth_time,omitempty"`
+	AccessTokenHash string           `json:"at_hash,omitempty"`
+	AuthorizedParty string           `json:"azp,omitempty"`
+	jwt.RegisteredClaims
+}
+
+/*
+Signs id token claims with the signing key
+
+Objectives:
+  - Set the issuer, issue and expiry times
+  - Sign with RS256, naming the key so clients can pick it from the JWKS
+
+Params:
+  - claims:   The id token claims, the subject and audience must be set
+  - lifetime: How long the token is valid
+
+Returns:
+  - The signed token string
+  - An error if signing failed
+*/
+func (config *Config) CreateIDToken(claims IDTokenClaims, lifetime time.Duration) (string, error) {
+	now := time.Now()
+	claims.Issuer = config.Issuer
+	claims.IssuedAt = jwt.NewNumericDate(now)
+	claims.ExpiresAt = jwt.NewNumericDate(now.Add(lifetime))
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = config.SigningKey.ID
+
+	tokenString, err := token.SignedString(config.SigningKey.Key)
+	if err != nil {
+		return "", fmt.Errorf("[FAIL]: could not sign id token: %w", err)
+	}
+
+	return tokenString, nil
+}
+
+/*
+Computes the at_hash claim of an access token
+
+Params:
+  - accessToken: The access token issued with the id token
+
+Returns:
+  - The base64url encoded left half of the SHA-256 hash, matching RS256
+*/
+func AccessTokenHash(accessToken string) string {
+	sum := sha256.Sum256([]byte(accessToken))
+	return base64.RawURLEncoding.EncodeToString(sum[:len(sum)/2])
+}
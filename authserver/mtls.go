@@ -0,0 +1,126 @@
+package authserver
+
+import (
+	"crypto"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/dev-xero/authentication-backend/model"
+)
+
+/*
+Returns the client certificate of a request received by the TLS listener
+
+Params:
+  - r: A pointer to a http request object
+
+Returns:
+  - The leaf certificate
+  - False if the request carries no client certificate
+*/
+func ClientCertificate(r *http.Request) (*x509.Certificate, bool) {
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		return nil, false
+	}
+
+	return r.TLS.PeerCertificates[0], true
+}
+
+/*
+Computes the RFC 8705 thumbprint of a certificate, the x5t#S256 tokens are bound to
+
+Params:
+  - cert: The certificate
+
+Returns:
+  - The base64url encoded SHA-256 hash of the DER encoded certificate
+*/
+func CertificateThumbprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+/*
+Authenticates a client by the certificate it presented, RFC 8705
+
+Objectives:
+  - For tls_client_auth, verify the chain up to a trusted CA and match the registered subject DN or DNS name
+  - For self_signed_tls_client_auth, match the certificate's public key to a key of the client's key set
+
+Params:
+  - certificates: The certificates the client presented, the leaf first
+  - client:       The client, registered with one of the mutual TLS auth methods
+  - roots:        The CAs trusted to issue client certificates, nil when tls_client_auth is disabled
+
+Returns:
+  - An error if the certificate doesn't authenticate the client
+*/
+func VerifyClientCertificate(certificates []*x509.Certificate, client model.OAuthClient, roots *x509.CertPool) error {
+	if len(certificates) == 0 {
+		return fmt.Errorf("[FAIL]: client %s sent no certificate", client.ID)
+	}
+	leaf := certificates[0]
+
+	switch client.TokenEndpointAuthMethod {
+	case model.ClientAuthTLS:
+		if roots == nil {
+			return fmt.Errorf("[FAIL]: tls_client_auth is disabled, OAUTH_MTLS_CA_FILE is not set")
+		}
+
+		intermediates := x509.NewCertPool()
+		for _, cert := range certificates[1:] {
+			intermediates.AddCert(cert)
+		}
+		_, err := leaf.Verify(x509.VerifyOptions{
+			Roots:         roots,
+			Intermediates: intermediates,
+			KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		})
+		if err != nil {
+			return fmt.Errorf("[FAIL]: certificate of client %s is not trusted: %w", client.ID, err)
+		}
+
+		if client.TLSSubjectDN != "" && leaf.Subject.String() == client.TLSSubjectDN {
+			return nil
+		}
+		if client.TLSSANDNS != "" {
+			for _, name := range leaf.DNSNames {
+				if strings.EqualFold(name, client.TLSSANDNS) {
+					return nil
+				}
+			}
+		}
+		return fmt.Errorf("[FAIL]: certificate %q does not name client %s", leaf.Subject.String(), client.ID)
+	case model.ClientAuthSelfSigned:
+		keys, err := ParseJWKS([]byte(client.JWKS))
+		if err != nil {
+			return err
+		}
+
+		for _, key := range keys {
+			if public, ok := key.Key.(interface{ Equal(crypto.PublicKey) bool }); ok && public.Equal(leaf.PublicKey) {
+				return nil
+			}
+		}
+		return fmt.Errorf("[FAIL]: certificate key of client %s is not in its jwks", client.ID)
+	default:
+		return fmt.Errorf("[FAIL]: client %s does not authenticate with a certificate", client.ID)
+	}
+}
+
+/*
+Checks whether an auth method authenticates clients by their certificate
+
+Params:
+  - method: The token endpoint auth method
+
+Returns:
+  - True for tls_client_auth and self_signed_tls_client_auth
+*/
+func UsesClientCertificate(method string) bool {
+	return method == model.ClientAuthTLS || method == model.ClientAuthSelfSigned
+}
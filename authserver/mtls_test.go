@@ -0,0 +1,101 @@
+package authserver
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/dev-xero/authentication-backend/internal/testutil"
+	"github.com/dev-xero/authentication-backend/model"
+)
+
+func TestVerifyClientCertificateTLSClientAuth(t *testing.T) {
+	ca := testutil.NewCA(t, "Local Development CA")
+	otherCA := testutil.NewCA(t, "Other CA")
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.Cert)
+
+	client := testutil.NewClientCertificate(t, &ca, x509.ExtKeyUsageClientAuth)
+	untrusted := testutil.NewClientCertificate(t, &otherCA, x509.ExtKeyUsageClientAuth)
+	serverOnly := testutil.NewClientCertificate(t, &ca, x509.ExtKeyUsageServerAuth)
+	selfSigned := testutil.NewClientCertificate(t, nil, x509.ExtKeyUsageClientAuth)
+
+	bySubject := model.OAuthClient{ID: "billing", TokenEndpointAuthMethod: model.ClientAuthTLS, TLSSubjectDN: client.Cert.Subject.String()}
+	byDNS := model.OAuthClient{ID: "billing", TokenEndpointAuthMethod: model.ClientAuthTLS, TLSSANDNS: "BILLING.localhost"}
+
+	tests := []struct {
+		name   string
+		certs  []*x509.Certificate
+		client model.OAuthClient
+		roots  *x509.CertPool
+		ok     bool
+	}{
+		{name: "subject dn", certs: []*x509.Certificate{client.Cert}, client: bySubject, roots: roots, ok: true},
+		{name: "san dns", certs: []*x509.Certificate{client.Cert}, client: byDNS, roots: roots, ok: true},
+		{name: "another subject dn", certs: []*x509.Certificate{client.Cert}, client: model.OAuthClient{ID: "billing", TokenEndpointAuthMethod: model.ClientAuthTLS, TLSSubjectDN: "CN=shipping"}, roots: roots},
+		{name: "another san dns", certs: []*x509.Certificate{client.Cert}, client: model.OAuthClient{ID: "billing", TokenEndpointAuthMethod: model.ClientAuthTLS, TLSSANDNS: "shipping.localhost"}, roots: roots},
+		{name: "issued by an untrusted ca", certs: []*x509.Certificate{untrusted.Cert}, client: bySubject, roots: roots},
+		{name: "self-signed", certs: []*x509.Certificate{selfSigned.Cert}, client: bySubject, roots: roots},
+		{name: "server certificate", certs: []*x509.Certificate{serverOnly.Cert}, client: bySubject, roots: roots},
+		{name: "no trusted cas", certs: []*x509.Certificate{client.Cert}, client: bySubject},
+		{name: "no certificate", client: bySubject, roots: roots},
+		{name: "client authenticating with a secret", certs: []*x509.Certificate{client.Cert}, client: model.OAuthClient{ID: "billing", TokenEndpointAuthMethod: model.ClientAuthSecretBasic}, roots: roots},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := VerifyClientCertificate(test.certs, test.client, test.roots)
+			if (err == nil) != test.ok {
+				t.Fatalf("VerifyClientCertificate = %v, want ok %v", err, test.ok)
+			}
+		})
+	}
+}
+
+func TestVerifyClientCertificateSelfSigned(t *testing.T) {
+	cert := testutil.NewClientCertificate(t, nil, x509.ExtKeyUsageClientAuth)
+	other := testutil.NewClientCertificate(t, nil, x509.ExtKeyUsageClientAuth)
+	client := model.OAuthClient{ID: "billing", TokenEndpointAuthMethod: model.ClientAuthSelfSigned, JWKS: testutil.ECJWKS(cert.Key)}
+
+	if err := VerifyClientCertificate([]*x509.Certificate{cert.Cert}, client, nil); err != nil {
+		t.Fatalf("certificate of a registered key was rejected: %v", err)
+	}
+	if err := VerifyClientCertificate([]*x509.Certificate{other.Cert}, client, nil); err == nil {
+		t.Fatal("certificate of another key was accepted")
+	}
+}
+
+func TestCertificateThumbprint(t *testing.T) {
+	cert := testutil.NewClientCertificate(t, nil, x509.ExtKeyUsageClientAuth)
+	other := testutil.NewClientCertificate(t, nil, x509.ExtKeyUsageClientAuth)
+
+	sum := sha256.Sum256(cert.Cert.Raw)
+	if got := CertificateThumbprint(cert.Cert); got != base64.RawURLEncoding.EncodeToString(sum[:]) {
+		t.Fatalf("thumbprint = %q", got)
+	}
+	if CertificateThumbprint(cert.Cert) == CertificateThumbprint(other.Cert) {
+		t.Fatal("certificates share a thumbprint")
+	}
+}
+
+func TestClientCertificate(t *testing.T) {
+	cert := testutil.NewClientCertificate(t, nil, x509.ExtKeyUsageClientAuth)
+
+	r := httptest.NewRequest("GET", "https://localhost:8443/oauth/token", nil)
+	if _, ok := ClientCertificate(r); ok {
+		t.Error("request without a certificate returned one")
+	}
+
+	r.TLS = &tls.ConnectionState{}
+	if _, ok := ClientCertificate(r); ok {
+		t.Error("tls request without a certificate returned one")
+	}
+
+	r.TLS.PeerCertificates = []*x509.Certificate{cert.Cert}
+	if got, ok := ClientCertificate(r); !ok || got != cert.Cert {
+		t.Error("leaf certificate wasn't returned")
+	}
+}
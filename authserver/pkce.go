@@ -0,0 +1,50 @@
+package authserver
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"regexp"
+)
+
+// Only S256 challenges are accepted, plain challenges would leak the verifier with the authorize request
+const CodeChallengeMethodS256 = "S256"
+
+// Verifiers are 43 to 128 unreserved characters, challenges are the 43 character base64url SHA-256 of one
+var (
+	codeVerifierPattern  = regexp.MustCompile(`^[A-Za-z0-9\-._~]{43,128}$`)
+	codeChallengePattern = regexp.MustCompile(`^[A-Za-z0-9\-_]{43}$`)
+)
+
+/*
+Checks whether a code challenge is a well-formed S256 challenge
+
+Params:
+  - challenge: The code_challenge parameter
+
+Returns:
+  - True if the challenge is well-formed
+*/
+func ValidCodeChallenge(challenge string) bool {
+	return codeChallengePattern.MatchString(challenge)
+}
+
+/*
+Verifies a PKCE code verifier against the S256 challenge of the authorize request
+
+Params:
+  - verifier:  The code_verifier parameter
+  - challenge: The stored code challenge
+
+Returns:
+  - True if the verifier is well-formed and hashes to the challenge
+*/
+func VerifyCodeVerifier(verifier string, challenge string) bool {
+	if !codeVerifierPattern.MatchString(verifier) {
+		return false
+	}
+
+	sum := sha256.Sum256([]byte(verifier))
+	computed := base64.RawURLEncoding.EncodeToString(sum[:])
+	return subtle.ConstantTimeCompare([]byte(computed), []byte(challenge)) == 1
+}
@@ -0,0 +1,47 @@
+package authserver
+
+import (
+	"strings"
+	"testing"
+)
+
+// The example of RFC 7636 appendix B
+const (
+	testVerifier  = "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	testChallenge = "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"
+)
+
+func TestVerifyCodeVerifier(t *testing.T) {
+	tests := []struct {
+		name     string
+		verifier string
+		want     bool
+	}{
+		{name: "matching verifier", verifier: testVerifier, want: true},
+		{name: "another verifier", verifier: strings.Repeat("a", 43)},
+		{name: "challenge as verifier", verifier: testChallenge},
+		{name: "empty verifier"},
+		{name: "too short", verifier: testVerifier[:42]},
+		{name: "too long", verifier: strings.Repeat("a", 129)},
+		{name: "reserved characters", verifier: testVerifier[:42] + "+"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := VerifyCodeVerifier(test.verifier, testChallenge); got != test.want {
+				t.Fatalf("VerifyCodeVerifier = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestValidCodeChallenge(t *testing.T) {
+	if !ValidCodeChallenge(testChallenge) {
+		t.Error("S256 challenge was rejected")
+	}
+	for _, challenge := range []string{"", testChallenge[:42], testChallenge + "A", testChallenge[:42] + "="} {
+		if ValidCodeChallenge(challenge) {
+			t.Errorf("challenge %q was accepted", challenge)
+		}
+	}
+}
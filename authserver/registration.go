@@ -0,0 +1,163 @@
+package authserver
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/dev-xero/authentication-backend/authentication"
+	"github.com/dev-xero/authentication-backend/model"
+	"github.com/dev-xero/authentication-backend/util"
+)
+
+/*
+Registration policy struct, what clients registering themselves may ask for
+
+Fields:
+  - InitialAccessTokenHash: Hash of the token partners register clients with
+  - GrantTypes:             The grant types self-registered clients may use
+  - Scopes:                 The scopes self-registered clients may request, also their scopes when they ask for none
+  - Audiences:              The services self-registered clients may request client credentials tokens for
+*/
+type RegistrationPolicy struct {
+	InitialAccessTokenHash string
+	GrantTypes             []string
+	Scopes                 []string
+	Audiences              []string
+}
+
+/*
+Loads the dynamic client registration policy from environment variables
+
+Objectives:
+  - Enable registration when OAUTH_REGISTRATION_INITIAL_ACCESS_TOKEN is set
+  - Allow the authorization code and refresh token grants, and the openid, profile and email scopes, unless configured
+  - Reject audiences of the tokens this server issues to itself
+
+Params:
+  - No parameters
+
+Returns:
+  - A pointer to the policy, nil when registration is disabled
+  - An error if a setting is malformed
+*/
+func newRegistrationPolicyFromEnvironment() (*RegistrationPolicy, error) {
+	token := os.Getenv("OAUTH_REGISTRATION_INITIAL_ACCESS_TOKEN")
+	if token == "" {
+		return nil, nil
+	}
+	if len(token) < 32 {
+		return nil, fmt.Errorf("[FAIL]: OAUTH_REGISTRATION_INITIAL_ACCESS_TOKEN must be at least 32 characters")
+	}
+
+	policy := &RegistrationPolicy{
+		InitialAccessTokenHash: util.HashToken(token),
+		GrantTypes:             []string{model.GrantAuthorizationCode, model.GrantRefreshToken},
+		Scopes:                 []string{ScopeOpenID, ScopeProfile, ScopeEmail},
+		Audiences:              splitList(os.Getenv("OAUTH_REGISTRATION_AUDIENCES")),
+	}
+
+	if value := os.Getenv("OAUTH_REGISTRATION_GRANT_TYPES"); value != "" {
+		policy.GrantTypes = splitList(value)
+	}
+	if value := os.Getenv("OAUTH_REGISTRATION_SCOPES"); value != "" {
+		policy.Scopes = splitList(value)
+	}
+
+	for _, audience := range policy.Audiences {
+		if authentication.IsReservedAudience(audience) {
+			return nil, fmt.Errorf("[FAIL]: OAUTH_REGISTRATION_AUDIENCES can't include the reserved audience %q", audience)
+		}
+	}
+
+	return policy, nil
+}
+
+/*
+Checks the initial access token of a registration request
+
+Params:
+  - token: The bearer token of the request
+
+Returns:
+  - True if it is the initial access token, compared in constant time
+*/
+func (policy *RegistrationPolicy) AuthorizesRegistration(token string) bool {
+	return subtle.ConstantTimeCompare([]byte(util.HashToken(token)), []byte(policy.InitialAccessTokenHash)) == 1
+}
+
+/*
+Checks a self-registered client asks for no more than the policy allows
+
+Params:
+  - client: The client, already validated
+
+Returns:
+  - A *ClientMetadataError naming the first grant type, scope or audience the policy doesn't allow
+*/
+func (policy *RegistrationPolicy) Check(client model.OAuthClient) error {
+	if client.FirstParty {
+		return invalidMetadata("self-registered clients can't be first party")
+	}
+
+	for _, grantType := range client.GrantTypes {
+		if !contains(policy.GrantTypes, grantType) {
+			return invalidMetadata("grant type %q is not allowed for self-registered clients", grantType)
+		}
+	}
+
+	for _, scope := range client.Scopes {
+		if !contains(policy.Scopes, scope) {
+			return invalidMetadata("scope %q is not allowed for self-registered clients", scope)
+		}
+	}
+
+	for _, audience := range client.Audiences {
+		if !contains(policy.Audiences, audience) {
+			return invalidMetadata("audience %q is not allowed for self-registered clients", audience)
+		}
+	}
+
+	return nil
+}
+
+/*
+Checks whether a list contains a value
+
+Params:
+  - list:  The list
+  - value: The value to look for
+
+Returns:
+  - True if the value is in the list
+*/
+func contains(list []string, value string) bool {
+	for _, entry := range list {
+		if entry == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+/*
+Splits a comma separated setting
+
+Params:
+  - value: The setting
+
+Returns:
+  - The trimmed, non-empty entries
+*/
+func splitList(value string) []string {
+	var entries []string
+	for _, entry := range strings.Split(value, ",") {
+		if entry = strings.TrimSpace(entry); entry != "" {
+			entries = append(entries, entry)
+		}
+	}
+
+	return entries
+}
@@ -0,0 +1,23 @@
+package authserver
+
+import (
+	"testing"
+)
+
+func TestRegistrationPolicyRejectsReservedAudiences(t *testing.T) {
+	t.Setenv("OAUTH_REGISTRATION_INITIAL_ACCESS_TOKEN", "0123456789abcdef0123456789abcdef")
+
+	t.Setenv("OAUTH_REGISTRATION_AUDIENCES", "invoices,user")
+	if _, err := newRegistrationPolicyFromEnvironment(); err == nil {
+		t.Error("policy allowing the user audience was loaded")
+	}
+
+	t.Setenv("OAUTH_REGISTRATION_AUDIENCES", "invoices,api")
+	policy, err := newRegistrationPolicyFromEnvironment()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(policy.Audiences) != 2 {
+		t.Fatalf("audiences = %v, want invoices and api", policy.Audiences)
+	}
+}
@@ -0,0 +1,84 @@
+package authserver
+
+import "strings"
+
+/*
+Splits a space separated scope parameter, dropping duplicates
+
+Params:
+  - scope: The scope parameter
+
+Returns:
+  - The scopes in the order they were requested
+*/
+func SplitScope(scope string) []string {
+	var scopes []string
+	seen := make(map[string]bool)
+
+	for _, entry := range strings.Fields(scope) {
+		if !seen[entry] {
+			seen[entry] = true
+			scopes = append(scopes, entry)
+		}
+	}
+
+	return scopes
+}
+
+/*
+Joins scopes into a space separated scope parameter
+
+Params:
+  - scopes: The scopes
+
+Returns:
+  - The scope parameter
+*/
+func JoinScope(scopes []string) string {
+	return strings.Join(scopes, " ")
+}
+
+/*
+Checks whether every requested scope is allowed
+
+Params:
+  - requested: The requested scopes
+  - allowed:   The allowed scopes
+
+Returns:
+  - True if every requested scope is one of the allowed scopes
+*/
+func ScopesAllowed(requested []string, allowed []string) bool {
+	set := make(map[string]bool, len(allowed))
+	for _, scope := range allowed {
+		set[scope] = true
+	}
+
+	for _, scope := range requested {
+		if !set[scope] {
+			return false
+		}
+	}
+
+	return true
+}
+
+/*
+Checks whether a scope list contains a scope
+
+Params:
+  - scopes: The scopes
+  - scope:  The scope to look for
+
+Returns:
+  - True if the scope is in the list
+*/
+func HasScope(scopes []string, scope string) bool {
+	for _, entry := range scopes {
+		if entry == scope {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,112 @@
+package authserver
+
+import (
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+)
+
+// Keys shorter than this are rejected, clients verify id tokens with the public key for as long as it is published
+const minSigningKeyBits = 2048
+
+/*
+Signing key struct, the key id tokens are signed with
+
+Fields:
+  - ID:  The key id, the RFC 7638 thumbprint of the public key
+  - Key: The RSA private key
+*/
+type SigningKey struct {
+	ID  string
+	Key *rsa.PrivateKey
+}
+
+/*
+JSON Web Key struct, the public part of a signing key
+
+Fields:
+  - KeyType:   Always RSA
+  - Use:       Always sig
+  - Algorithm: Always RS256
+  - KeyID:     The key id
+  - Modulus:   The base64url encoded modulus
+  - Exponent:  The base64url encoded public exponent
+*/
+type JSONWebKey struct {
+	KeyType   string `json:"kty"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	KeyID     string `json:"kid"`
+	Modulus   string `json:"n"`
+	Exponent  string `json:"e"`
+}
+
+/*
+Parses a PEM encoded RSA private key into a signing key
+
+Params:
+  - keyData: The PEM encoded key, PKCS #1 or PKCS #8
+
+Returns:
+  - A pointer to the signing key
+  - An error if the key isn't an RSA key of at least 2048 bits
+*/
+func ParseSigningKey(keyData []byte) (*SigningKey, error) {
+	block, _ := pem.Decode(keyData)
+	if block == nil {
+		return nil, fmt.Errorf("[FAIL]: signing key is not PEM encoded")
+	}
+
+	var key *rsa.PrivateKey
+	if parsed, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		key = parsed
+	} else if parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes); err == nil {
+		key, _ = parsed.(*rsa.PrivateKey)
+	}
+	if key == nil {
+		return nil, fmt.Errorf("[FAIL]: signing key is not an RSA private key")
+	}
+
+	if key.N.BitLen() < minSigningKeyBits {
+		return nil, fmt.Errorf("[FAIL]: signing key must be at least %d bits", minSigningKeyBits)
+	}
+
+	signingKey := &SigningKey{Key: key}
+	signingKey.ID = signingKey.thumbprint()
+	return signingKey, nil
+}
+
+/*
+Returns the public key as a JSON Web Key
+
+Returns:
+  - The JSON Web Key
+*/
+func (key *SigningKey) PublicJWK() JSONWebKey {
+	return JSONWebKey{
+		KeyType:   "RSA",
+		Use:       "sig",
+		Algorithm: "RS256",
+		KeyID:     key.ID,
+		Modulus:   base64.RawURLEncoding.EncodeToString(key.Key.N.Bytes()),
+		Exponent:  base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.Key.E)).Bytes()),
+	}
+}
+
+// Computes the RFC 7638 thumbprint, the required members in lexicographic order
+func (key *SigningKey) thumbprint() string {
+	jwk := key.PublicJWK()
+	members, _ := json.Marshal(struct {
+		E   string `json:"e"`
+		Kty string `json:"kty"`
+		N   string `json:"n"`
+	}{jwk.Exponent, jwk.KeyType, jwk.Modulus})
+
+	sum := sha256.Sum256(members)
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
@@ -0,0 +1,83 @@
+package authserver
+
+import (
+	"crypto/rand"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// User codes use consonants only, so they can't spell words and survive being typed on a TV remote
+const (
+	userCodeAlphabet = "BCDFGHJKLMNPQRSTVWXZ"
+	userCodeLength   = 8
+)
+
+/*
+Generates a user code for the device authorization grant
+
+Returns:
+  - The normalized user code, 8 characters of the alphabet
+  - An error if the random source failed
+*/
+func GenerateUserCode() (string, error) {
+	var code strings.Builder
+	max := big.NewInt(int64(len(userCodeAlphabet)))
+
+	for i := 0; i < userCodeLength; i++ {
+		index, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", fmt.Errorf("[FAIL]: could not generate user code: %w", err)
+		}
+		code.WriteByte(userCodeAlphabet[index.Int64()])
+	}
+
+	return code.String(), nil
+}
+
+/*
+Normalizes a user code typed by a user
+
+Params:
+  - input: The typed code, in any case, with or without dashes and spaces
+
+Returns:
+  - The normalized user code
+  - False if it can't be a user code
+*/
+func NormalizeUserCode(input string) (string, bool) {
+	var code strings.Builder
+	for _, char := range strings.ToUpper(input) {
+		switch {
+		case char == '-' || char == ' ':
+			continue
+		case strings.ContainsRune(userCodeAlphabet, char):
+			code.WriteRune(char)
+		default:
+			return "", false
+		}
+	}
+
+	if code.Len() != userCodeLength {
+		return "", false
+	}
+
+	return code.String(), true
+}
+
+/*
+Formats a normalized user code for display
+
+Params:
+  - code: The normalized user code
+
+Returns:
+  - The code split in two halves by a dash
+*/
+func FormatUserCode(code string) string {
+	if len(code) != userCodeLength {
+		return code
+	}
+
+	return code[:userCodeLength/2] + "-" + code[userCodeLength/2:]
+}
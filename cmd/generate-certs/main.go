@@ -0,0 +1,181 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"flag"
+	"fmt"
+	"log"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Local certificates are for development only, they expire after a year
+const certificateLifetime = 365 * 24 * time.Hour
+
+/*
+Local certificate generation entry point, for trying mutual TLS without a real CA
+
+Objectives:
+  - Generate a CA, a server certificate for the TLS listener, and a client certificate signed by the CA
+  - Write the key set of the client certificate, for registering self_signed_tls_client_auth clients
+  - Print the settings and client registration that use them
+
+Params:
+  - No parameters
+
+Returns:
+  - No return value
+*/
+func main() {
+	dir := flag.String("dir", "certs", "directory the certificates and keys are written to")
+	hosts := flag.String("hosts", "localhost,127.0.0.1", "comma separated host names and addresses of the server certificate")
+	clientName := flag.String("client", "billing", "common name of the client certificate")
+	flag.Parse()
+
+	if err := os.MkdirAll(*dir, 0o700); err != nil {
+		log.Fatal(err)
+	}
+
+	ca, caKey, err := createCertificate(&x509.Certificate{
+		Subject:               pkix.Name{CommonName: "Local Development CA"},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+	}, nil, nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	serverTemplate := &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "localhost"},
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	for _, host := range strings.Split(*hosts, ",") {
+		if host = strings.TrimSpace(host); host == "" {
+			continue
+		}
+		if ip := net.ParseIP(host); ip != nil {
+			serverTemplate.IPAddresses = append(serverTemplate.IPAddresses, ip)
+		} else {
+			serverTemplate.DNSNames = append(serverTemplate.DNSNames, host)
+		}
+	}
+	server, serverKey, err := createCertificate(serverTemplate, ca, caKey)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	client, clientKey, err := createCertificate(&x509.Certificate{
+		Subject:     pkix.Name{CommonName: *clientName, Organization: []string{"Local Development"}},
+		DNSNames:    []string{*clientName + ".localhost"},
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, ca, caKey)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	files := []struct {
+		name  string
+		write func(string) error
+	}{
+		{"ca.pem", func(path string) error { return writeCertificate(path, ca) }},
+		{"server.pem", func(path string) error { return writeCertificate(path, server) }},
+		{"server-key.pem", func(path string) error { return writeKey(path, serverKey) }},
+		{"client.pem", func(path string) error { return writeCertificate(path, client) }},
+		{"client-key.pem", func(path string) error { return writeKey(path, clientKey) }},
+		{"client.jwks.json", func(path string) error { return writeJWKS(path, clientKey) }},
+	}
+	for _, file := range files {
+		if err := file.write(filepath.Join(*dir, file.name)); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	fmt.Printf("TLS_PORT=8443\nTLS_CERT_FILE=%[1]s/server.pem\nTLS_KEY_FILE=%[1]s/server-key.pem\n", *dir)
+	fmt.Printf("OAUTH_MTLS_BASE_URL=https://localhost:8443\nOAUTH_MTLS_CA_FILE=%s/ca.pem\n\n", *dir)
+	fmt.Printf("tls_client_auth:             -auth-method tls_client_auth -tls-subject-dn %q\n", client.Subject.String())
+	fmt.Printf("self_signed_tls_client_auth: -auth-method self_signed_tls_client_auth -jwks-file %s/client.jwks.json\n", *dir)
+}
+
+/*
+Creates a certificate with a new P-256 key
+
+Params:
+  - template:  The certificate fields, the serial number and validity are set
+  - parent:    The issuing CA, nil for a self-signed certificate
+  - parentKey: The key of the issuing CA
+
+Returns:
+  - The certificate
+  - Its private key
+  - An error if the key couldn't be generated or the certificate signed
+*/
+func createCertificate(template *x509.Certificate, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	template.SerialNumber, err = rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, err
+	}
+	template.NotBefore = time.Now().Add(-time.Minute)
+	template.NotAfter = time.Now().Add(certificateLifetime)
+
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	return cert, key, err
+}
+
+// Writes a PEM encoded certificate
+func writeCertificate(path string, cert *x509.Certificate) error {
+	return os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}), 0o644)
+}
+
+// Writes a PEM encoded PKCS #8 private key, readable by the owner only
+func writeKey(path string, key *ecdsa.PrivateKey) error {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600)
+}
+
+// Writes the public key as a JSON Web Key Set
+func writeJWKS(path string, key *ecdsa.PrivateKey) error {
+	encode := func(value *big.Int) string {
+		return base64.RawURLEncoding.EncodeToString(value.FillBytes(make([]byte, 32)))
+	}
+
+	data, err := json.MarshalIndent(map[string]interface{}{
+		"keys": []map[string]string{{"kty": "EC", "crv": "P-256", "use": "sig", "x": encode(key.X), "y": encode(key.Y)}},
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, data, 0o644)
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/dev-xero/authentication-backend/authserver"
+	"github.com/dev-xero/authentication-backend/database"
+	"github.com/dev-xero/authentication-backend/model"
+	repository "github.com/dev-xero/authentication-backend/repository/user"
+	"github.com/dev-xero/authentication-backend/util"
+)
+
+/*
+OAuth client registration entry point
+
+Objectives:
+  - Validate the client metadata passed as flags
+  - Generate the client id, and a secret unless the client is public or authenticates with its keys
+  - Store the client with the secret hashed and print the credentials once
+
+Params:
+  - No parameters
+
+Returns:
+  - No return value
+*/
+func main() {
+	name := flag.String("name", "", "client name shown to users")
+	redirectURIs := flag.String("redirect-uris", "", "comma separated redirect URIs")
+	scopes := flag.String("scopes", "", "comma separated scopes the client may request")
+	grantTypes := flag.String("grant-types", model.GrantAuthorizationCode+","+model.GrantRefreshToken, "comma separated grant types")
+	authMethod := flag.String("auth-method", model.ClientAuthSecretBasic, "token endpoint auth method: client_secret_basic, client_secret_post, private_key_jwt, tls_client_auth, self_signed_tls_client_auth or none")
+	jwksFile := flag.String("jwks-file", "", "JSON Web Key Set file of a private_key_jwt or self_signed_tls_client_auth client")
+	tlsSubjectDN := flag.String("tls-subject-dn", "", "subject DN of the certificate of a tls_client_auth client, e.g. CN=billing,O=Example")
+	tlsSANDNS := flag.String("tls-san-dns", "", "DNS name of the certificate of a tls_client_auth client")
+	audiences := flag.String("audiences", "", "comma separated services the client may request client credentials tokens for")
+	subjectTokenClients := flag.String("subject-token-clients", "", "comma separated clients whose tokens the client may exchange")
+	firstParty := flag.Bool("first-party", false, "whether the client is operated by us")
+	dpopBound := flag.Bool("dpop-bound", false, "whether every token request of the client must carry a DPoP proof")
+	flag.Parse()
+
+	var jwks string
+	if *jwksFile != "" {
+		data, err := os.ReadFile(*jwksFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		jwks = string(data)
+	}
+
+	client := model.OAuthClient{
+		Name:                    strings.TrimSpace(*name),
+		RedirectURIs:            splitFlag(*redirectURIs),
+		Scopes:                  splitFlag(*scopes),
+		GrantTypes:              splitFlag(*grantTypes),
+		TokenEndpointAuthMethod: *authMethod,
+		JWKS:                    jwks,
+		Audiences:               splitFlag(*audiences),
+		SubjectTokenClients:     splitFlag(*subjectTokenClients),
+		FirstParty:              *firstParty,
+		DPoPBoundAccessTokens:   *dpopBound,
+		TLSSubjectDN:            strings.TrimSpace(*tlsSubjectDN),
+		TLSSANDNS:               strings.TrimSpace(*tlsSANDNS),
+	}
+
+	if err := authserver.ValidateClient(client); err != nil {
+		log.Fatal(err)
+	}
+
+	clientID, err := util.GenerateRandomToken(16)
+	if err != nil {
+		log.Fatal(err)
+	}
+	client.ID = clientID
+
+	var secret string
+	if client.TokenEndpointAuthMethod == model.ClientAuthSecretBasic || client.TokenEndpointAuthMethod == model.ClientAuthSecretPost {
+		secret, err = util.GenerateRandomToken(32)
+		if err != nil {
+			log.Fatal(err)
+		}
+		client.SecretHash = util.HashToken(secret)
+	}
+
+	db, err := database.ConnectDatabase()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+
+	repo := &repository.PostGreSQL{Database: db}
+	if err := repo.CreateOAuthClient(context.Background(), client); err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Println("client_id:", client.ID)
+	if secret != "" {
+		fmt.Println("client_secret:", secret)
+	}
+	log.Printf("[SUCCESS]: registered client %q\n", client.Name)
+}
+
+/*
+Splits a comma separated flag value
+
+Params:
+  - value: The flag value
+
+Returns:
+  - The trimmed, non-empty entries
+*/
+func splitFlag(value string) []string {
+	var entries []string
+	for _, entry := range strings.Split(value, ",") {
+		if entry = strings.TrimSpace(entry); entry != "" {
+			entries = append(entries, entry)
+		}
+	}
+
+	return entries
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+
+	"github.com/dev-xero/authentication-backend/database"
+	"github.com/dev-xero/authentication-backend/encryption"
+
+	// Registers the encrypted columns of the user repository
+	_ "github.com/dev-xero/authentication-backend/repository/user"
+)
+
+/*
+Master key rotation entry point
+
+Objectives:
+  - Print a new keyfile entry when -new-key is passed
+  - Otherwise re-wrap every registered encrypted column with the active master key
+
+Params:
+  - No parameters
+
+Returns:
+  - No return value
+*/
+func main() {
+	newKeyID := flag.String("new-key", "", "print a new keyfile entry with this key ID and exit")
+	flag.Parse()
+
+	if *newKeyID != "" {
+		entry, err := encryption.GenerateKeyfileEntry(*newKeyID)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(entry)
+		return
+	}
+
+	service, err := encryption.NewFromEnvironment()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	db, err := database.ConnectDatabase()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+
+	count, err := encryption.RotateColumns(context.Background(), db, service)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	log.Printf("[SUCCESS]: re-wrapped %d ciphertexts\n", count)
+}
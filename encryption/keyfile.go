@@ -0,0 +1,184 @@
+package encryption
+
+import (
+	"bufio"
+	"context"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Size in bytes of master and data keys, AES-256
+const keySize = 32
+
+/*
+Local keyfile master key provider
+
+Objectives:
+  - Hold master keys loaded from a local keyfile
+  - Wrap and unwrap data keys with AES-GCM, binding the key ID as additional data
+
+Fields:
+  - keys:        Master keys by key ID
+  - activeKeyID: The key ID new data keys are wrapped with
+*/
+type KeyfileProvider struct {
+	keys        map[string][]byte
+	activeKeyID string
+}
+
+/*
+Loads master keys from a keyfile
+
+The keyfile holds one "<key id>=<base64 key>" entry per line, blank lines and
+lines starting with # are ignored. The active key is the one named by
+activeKeyID, or the last key in the file when activeKeyID is empty.
+
+Params:
+  - path:        Path to the keyfile
+  - activeKeyID: The key ID to wrap new data keys with, optional
+
+Returns:
+  - A pointer to the keyfile provider
+  - An error if the keyfile could not be read or is malformed
+*/
+func NewKeyfileProvider(path string, activeKeyID string) (*KeyfileProvider, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("[FAIL]: could not open keyfile: %w", err)
+	}
+	defer file.Close()
+
+	provider := &KeyfileProvider{keys: map[string][]byte{}}
+	var lastKeyID string
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		id, encoded, found := strings.Cut(line, "=")
+		if !found || !isValidKeyID(id) {
+			return nil, fmt.Errorf("[FAIL]: malformed keyfile entry")
+		}
+
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil || len(key) != keySize {
+			return nil, fmt.Errorf("[FAIL]: master key %s must be %d base64 encoded bytes", id, keySize)
+		}
+
+		provider.keys[id] = key
+		lastKeyID = id
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("[FAIL]: could not read keyfile: %w", err)
+	}
+
+	if activeKeyID == "" {
+		activeKeyID = lastKeyID
+	}
+	if _, ok := provider.keys[activeKeyID]; !ok {
+		return nil, fmt.Errorf("[FAIL]: active master key %q not found in keyfile", activeKeyID)
+	}
+	provider.activeKeyID = activeKeyID
+
+	return provider, nil
+}
+
+// Implement the key provider interface for the keyfile provider
+func (provider *KeyfileProvider) ActiveKeyID() string {
+	return provider.activeKeyID
+}
+
+// Implement the key provider interface for the keyfile provider
+func (provider *KeyfileProvider) WrapKey(ctx context.Context, keyID string, dataKey []byte) ([]byte, error) {
+	aead, err := provider.masterCipher(keyID)
+	if err != nil {
+		return nil, err
+	}
+
+	return seal(aead, dataKey, []byte(keyID))
+}
+
+// Implement the key provider interface for the keyfile provider
+func (provider *KeyfileProvider) UnwrapKey(ctx context.Context, keyID string, wrappedKey []byte) ([]byte, error) {
+	aead, err := provider.masterCipher(keyID)
+	if err != nil {
+		return nil, err
+	}
+
+	return open(aead, wrappedKey, []byte(keyID))
+}
+
+/*
+Returns an AES-GCM cipher for the master key with the given ID
+
+Params:
+  - keyID: The master key ID
+
+Returns:
+  - An AEAD cipher
+  - An error if the key is unknown
+*/
+func (provider *KeyfileProvider) masterCipher(keyID string) (cipher.AEAD, error) {
+	key, ok := provider.keys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("[FAIL]: unknown master key %q", keyID)
+	}
+
+	return newGCM(key)
+}
+
+/*
+Generates a new random key entry that can be appended to a keyfile
+
+Params:
+  - keyID: The ID of the new key
+
+Returns:
+  - A "<key id>=<base64 key>" keyfile line
+  - An error if the key ID is invalid or no randomness is available
+*/
+func GenerateKeyfileEntry(keyID string) (string, error) {
+	if !isValidKeyID(keyID) {
+		return "", fmt.Errorf("[FAIL]: key IDs may only contain letters, digits, '-' and '_'")
+	}
+
+	key := make([]byte, keySize)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return "", fmt.Errorf("[FAIL]: could not generate key: %w", err)
+	}
+
+	return fmt.Sprintf("%s=%s", keyID, base64.StdEncoding.EncodeToString(key)), nil
+}
+
+/*
+Checks that a key ID is safe to embed in a ciphertext envelope
+
+Params:
+  - keyID: The key ID to check
+
+Returns:
+  - True if the key ID is non-empty and only uses [A-Za-z0-9_-]
+*/
+func isValidKeyID(keyID string) bool {
+	if keyID == "" {
+		return false
+	}
+
+	for _, c := range keyID {
+		isAlphaNumeric := (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+		if !isAlphaNumeric && c != '-' && c != '_' {
+			return false
+		}
+	}
+
+	return true
+}
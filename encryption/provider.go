@@ -0,0 +1,20 @@
+package encryption
+
+import "context"
+
+/*
+KeyProvider interface
+
+Defines KMS-style master key providers. Master keys never leave the provider,
+callers only ever hand it data keys to wrap and unwrap.
+
+Methods:
+  - ActiveKeyID: The ID of the master key new data keys are wrapped with
+  - WrapKey:     Encrypts a data key with the master key identified by keyID
+  - UnwrapKey:   Decrypts a data key previously wrapped with the master key keyID
+*/
+type KeyProvider interface {
+	ActiveKeyID() string
+	WrapKey(ctx context.Context, keyID string, dataKey []byte) ([]byte, error)
+	UnwrapKey(ctx context.Context, keyID string, wrappedKey []byte) ([]byte, error)
+}
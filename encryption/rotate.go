@@ -0,0 +1,157 @@
+package encryption
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+)
+
+/*
+Encrypted column struct
+
+Fields:
+  - Table:     The table holding the encrypted column
+  - KeyColumn: The table's primary key column
+  - Column:    The column holding ciphertext envelopes
+*/
+type Column struct {
+	Table     string
+	KeyColumn string
+	Column    string
+}
+
+var (
+	columnsMutex sync.Mutex
+	columns      []Column
+)
+
+/*
+Registers an encrypted column so master key rotation re-wraps it
+
+Params:
+  - column: The encrypted column
+
+Returns:
+  - No return value
+*/
+func RegisterColumn(column Column) {
+	columnsMutex.Lock()
+	defer columnsMutex.Unlock()
+
+	columns = append(columns, column)
+}
+
+/*
+Re-encryption job for master key rotation
+
+Objectives:
+  - Find every ciphertext in the registered columns not wrapped by the active key
+  - Re-wrap its data key with the active master key
+  - Only update rows whose ciphertext did not change in the meantime
+
+Params:
+  - ctx:     Method context
+  - db:      A pointer to the application database
+  - service: The encryption service
+
+Returns:
+  - The number of re-wrapped ciphertexts
+  - An error if any step fails
+*/
+func RotateColumns(ctx context.Context, db *sql.DB, service *Service) (int, error) {
+	columnsMutex.Lock()
+	registered := append([]Column{}, columns...)
+	columnsMutex.Unlock()
+
+	var total int
+	for _, column := range registered {
+		count, err := rotateColumn(ctx, db, service, column)
+		total += count
+		if err != nil {
+			return total, err
+		}
+
+		log.Printf("[LOG]: re-wrapped %d ciphertexts in %s.%s\n", count, column.Table, column.Column)
+	}
+
+	return total, nil
+}
+
+/*
+Re-wraps all ciphertexts of a single column
+
+Params:
+  - ctx:     Method context
+  - db:      A pointer to the application database
+  - service: The encryption service
+  - column:  The encrypted column
+
+Returns:
+  - The number of re-wrapped ciphertexts
+  - An error if any step fails
+*/
+func rotateColumn(ctx context.Context, db *sql.DB, service *Service, column Column) (int, error) {
+	// The key ID is the second field of the envelope
+	var selectQuery = fmt.Sprintf(`
+		SELECT %[1]s, %[2]s FROM %[3]s
+		WHERE %[2]s IS NOT NULL AND split_part(%[2]s, ':', 2) <> $1
+	`, column.KeyColumn, column.Column, column.Table)
+
+	rows, err := db.QueryContext(ctx, selectQuery, service.provider.ActiveKeyID())
+	if err != nil {
+		// Nothing to rotate if the table hasn't been created yet
+		if strings.Contains(err.Error(), "does not exist") {
+			return 0, nil
+		}
+		return 0, fmt.Errorf("[FAIL]: could not query %s.%s: %w", column.Table, column.Column, err)
+	}
+
+	type row struct {
+		key        string
+		ciphertext string
+	}
+
+	var pending []row
+	for rows.Next() {
+		var r row
+		if err := rows.Scan(&r.key, &r.ciphertext); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("[FAIL]: could not scan %s.%s: %w", column.Table, column.Column, err)
+		}
+		pending = append(pending, r)
+	}
+	rows.Close()
+
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("[FAIL]: could not read %s.%s: %w", column.Table, column.Column, err)
+	}
+
+	var updateQuery = fmt.Sprintf(`
+		UPDATE %[3]s SET %[2]s = $1 WHERE %[1]s = $2 AND %[2]s = $3
+	`, column.KeyColumn, column.Column, column.Table)
+
+	var count int
+	for _, r := range pending {
+		rewrapped, changed, err := service.Rewrap(ctx, r.ciphertext)
+		if err != nil {
+			return count, err
+		}
+		if !changed {
+			continue
+		}
+
+		result, err := db.ExecContext(ctx, updateQuery, rewrapped, r.key, r.ciphertext)
+		if err != nil {
+			return count, fmt.Errorf("[FAIL]: could not update %s.%s: %w", column.Table, column.Column, err)
+		}
+
+		if affected, _ := result.RowsAffected(); affected > 0 {
+			count++
+		}
+	}
+
+	return count, nil
+}
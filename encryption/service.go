@@ -0,0 +1,274 @@
+package encryption
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/joho/godotenv"
+)
+
+// Envelope format version, ciphertexts look like "v1:<key id>:<wrapped data key>:<sealed data>"
+const envelopeVersion = "v1"
+
+/*
+Envelope encryption service
+
+Objectives:
+  - Encrypt each value with a fresh AES-GCM data key
+  - Wrap the data key with a master key from the key provider
+  - Store the master key ID alongside every ciphertext
+
+Fields:
+  - provider: The master key provider
+*/
+type Service struct {
+	provider KeyProvider
+}
+
+/*
+Initializes a new encryption service
+
+Params:
+  - provider: The master key provider
+
+Returns:
+  - A pointer to the encryption service
+*/
+func New(provider KeyProvider) *Service {
+	return &Service{provider: provider}
+}
+
+/*
+Initializes an encryption service backed by the local keyfile
+
+Objectives:
+  - Load the keyfile path and active key ID from environment variables
+  - Load the keyfile provider
+
+Params:
+  - No parameters
+
+Returns:
+  - A pointer to the encryption service
+  - An error if the keyfile could not be loaded
+*/
+func NewFromEnvironment() (*Service, error) {
+	// Load environment variables from .env file in development
+	if env := os.Getenv("ENVIRONMENT"); env != "production" {
+		err := godotenv.Load()
+		if err != nil {
+			return nil, fmt.Errorf("[FAIL]: could not load environment variables: %w", err)
+		}
+	}
+
+	path := os.Getenv("ENCRYPTION_KEYFILE")
+	if path == "" {
+		return nil, fmt.Errorf("[FAIL]: ENCRYPTION_KEYFILE is not set")
+	}
+
+	provider, err := NewKeyfileProvider(path, os.Getenv("ENCRYPTION_ACTIVE_KEY_ID"))
+	if err != nil {
+		return nil, err
+	}
+
+	return New(provider), nil
+}
+
+/*
+Encrypts a value under a fresh data key
+
+Objectives:
+  - Generate a random data key and seal the plaintext with it
+  - Wrap the data key with the active master key
+  - Encode everything into a single envelope string
+
+Params:
+  - ctx:            Method context
+  - plaintext:      The value to encrypt
+  - associatedData: Context the ciphertext is bound to, e.g. the table, row and column
+
+Returns:
+  - The ciphertext envelope
+  - An error if any step fails
+*/
+func (service *Service) Encrypt(ctx context.Context, plaintext []byte, associatedData string) (string, error) {
+	dataKey := make([]byte, keySize)
+	if _, err := io.ReadFull(rand.Reader, dataKey); err != nil {
+		return "", fmt.Errorf("[FAIL]: could not generate data key: %w", err)
+	}
+
+	aead, err := newGCM(dataKey)
+	if err != nil {
+		return "", err
+	}
+
+	sealed, err := seal(aead, plaintext, []byte(associatedData))
+	if err != nil {
+		return "", err
+	}
+
+	keyID := service.provider.ActiveKeyID()
+	wrappedKey, err := service.provider.WrapKey(ctx, keyID, dataKey)
+	if err != nil {
+		return "", fmt.Errorf("[FAIL]: could not wrap data key: %w", err)
+	}
+
+	return encodeEnvelope(keyID, wrappedKey, sealed), nil
+}
+
+/*
+Decrypts a ciphertext envelope
+
+Params:
+  - ctx:            Method context
+  - ciphertext:     The ciphertext envelope
+  - associatedData: The context the value was encrypted with
+
+Returns:
+  - The plaintext
+  - An error if the envelope is malformed, the key is unknown or authentication fails
+*/
+func (service *Service) Decrypt(ctx context.Context, ciphertext string, associatedData string) ([]byte, error) {
+	keyID, wrappedKey, sealed, err := decodeEnvelope(ciphertext)
+	if err != nil {
+		return nil, err
+	}
+
+	dataKey, err := service.provider.UnwrapKey(ctx, keyID, wrappedKey)
+	if err != nil {
+		return nil, fmt.Errorf("[FAIL]: could not unwrap data key: %w", err)
+	}
+
+	aead, err := newGCM(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return open(aead, sealed, []byte(associatedData))
+}
+
+/*
+Re-wraps the data key of a ciphertext with the active master key
+
+The sealed data is left untouched, so associated data is not needed and
+plaintexts are never exposed during master key rotation.
+
+Params:
+  - ctx:        Method context
+  - ciphertext: The ciphertext envelope
+
+Returns:
+  - The re-wrapped ciphertext envelope
+  - True if the ciphertext was re-wrapped, false if it already used the active key
+  - An error if the data key could not be unwrapped or wrapped
+*/
+func (service *Service) Rewrap(ctx context.Context, ciphertext string) (string, bool, error) {
+	keyID, wrappedKey, sealed, err := decodeEnvelope(ciphertext)
+	if err != nil {
+		return "", false, err
+	}
+
+	activeKeyID := service.provider.ActiveKeyID()
+	if keyID == activeKeyID {
+		return ciphertext, false, nil
+	}
+
+	dataKey, err := service.provider.UnwrapKey(ctx, keyID, wrappedKey)
+	if err != nil {
+		return "", false, fmt.Errorf("[FAIL]: could not unwrap data key: %w", err)
+	}
+
+	rewrappedKey, err := service.provider.WrapKey(ctx, activeKeyID, dataKey)
+	if err != nil {
+		return "", false, fmt.Errorf("[FAIL]: could not wrap data key: %w", err)
+	}
+
+	return encodeEnvelope(activeKeyID, rewrappedKey, sealed), true, nil
+}
+
+/*
+Returns the ID of the master key a ciphertext's data key is wrapped with
+
+Params:
+  - ciphertext: The ciphertext envelope
+
+Returns:
+  - The master key ID
+  - An error if the envelope is malformed
+*/
+func KeyID(ciphertext string) (string, error) {
+	keyID, _, _, err := decodeEnvelope(ciphertext)
+	return keyID, err
+}
+
+// Encodes the envelope parts into a single string
+func encodeEnvelope(keyID string, wrappedKey []byte, sealed []byte) string {
+	return strings.Join([]string{
+		envelopeVersion,
+		keyID,
+		base64.RawStdEncoding.EncodeToString(wrappedKey),
+		base64.RawStdEncoding.EncodeToString(sealed),
+	}, ":")
+}
+
+// Decodes an envelope string into its parts
+func decodeEnvelope(ciphertext string) (string, []byte, []byte, error) {
+	parts := strings.Split(ciphertext, ":")
+	if len(parts) != 4 || parts[0] != envelopeVersion || !isValidKeyID(parts[1]) {
+		return "", nil, nil, fmt.Errorf("[FAIL]: malformed ciphertext envelope")
+	}
+
+	wrappedKey, err := base64.RawStdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", nil, nil, fmt.Errorf("[FAIL]: malformed wrapped data key")
+	}
+
+	sealed, err := base64.RawStdEncoding.DecodeString(parts[3])
+	if err != nil {
+		return "", nil, nil, fmt.Errorf("[FAIL]: malformed sealed data")
+	}
+
+	return parts[1], wrappedKey, sealed, nil
+}
+
+// Creates an AES-GCM cipher from a key
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("[FAIL]: could not create cipher: %w", err)
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// Seals a plaintext with a random nonce, the nonce is prefixed to the result
+func seal(aead cipher.AEAD, plaintext []byte, additionalData []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, fmt.Errorf("[FAIL]: could not generate nonce: %w", err)
+	}
+
+	return aead.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+// Opens a nonce-prefixed sealed value
+func open(aead cipher.AEAD, sealed []byte, additionalData []byte) ([]byte, error) {
+	if len(sealed) < aead.NonceSize() {
+		return nil, fmt.Errorf("[FAIL]: sealed data is too short")
+	}
+
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	plaintext, err := aead.Open(nil, nonce, ciphertext, additionalData)
+	if err != nil {
+		return nil, fmt.Errorf("[FAIL]: could not decrypt data: %w", err)
+	}
+
+	return plaintext, nil
+}
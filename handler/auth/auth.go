@@ -4,22 +4,62 @@ import (
 	"fmt"
 	"net/http"
 
+	mfaHandler "github.com/dev-xero/authentication-backend/handler/auth/mfa"
 	oauth "github.com/dev-xero/authentication-backend/handler/auth/oauth"
 	password "github.com/dev-xero/authentication-backend/handler/auth/password"
+	recovery "github.com/dev-xero/authentication-backend/handler/auth/recovery"
 	shared "github.com/dev-xero/authentication-backend/handler/auth/shared"
+	"github.com/dev-xero/authentication-backend/mfa"
+	"github.com/dev-xero/authentication-backend/providertoken"
+	"github.com/dev-xero/authentication-backend/saml"
 	"github.com/dev-xero/authentication-backend/service"
 	"github.com/dev-xero/authentication-backend/util"
 	"github.com/go-chi/chi/v5"
 )
 
 type AuthHandler struct {
-	dbService *service.DatabaseProvider
+	dbService   *service.DatabaseProvider
+	smsService  *service.SMSProvider
+	mailService *service.MailProvider
+	mfaPolicy   *mfa.Policy
+	registry    *oauth.Registry
+	returnTo    *oauth.ReturnToAllowlist
+	tokens      *providertoken.Store
+	saml        *saml.Connections
 }
 
 func (authHandler *AuthHandler) WithService(service *service.DatabaseProvider) {
 	authHandler.dbService = service
 }
 
+func (authHandler *AuthHandler) WithSMSService(service *service.SMSProvider) {
+	authHandler.smsService = service
+}
+
+func (authHandler *AuthHandler) WithMailService(service *service.MailProvider) {
+	authHandler.mailService = service
+}
+
+func (authHandler *AuthHandler) WithMFAPolicy(policy *mfa.Policy) {
+	authHandler.mfaPolicy = policy
+}
+
+func (authHandler *AuthHandler) WithOAuthProviders(registry *oauth.Registry) {
+	authHandler.registry = registry
+}
+
+func (authHandler *AuthHandler) WithReturnToAllowlist(allowlist *oauth.ReturnToAllowlist) {
+	authHandler.returnTo = allowlist
+}
+
+func (authHandler *AuthHandler) WithProviderTokens(tokens *providertoken.Store) {
+	authHandler.tokens = tokens
+}
+
+func (authHandler *AuthHandler) WithSAMLConnections(connections *saml.Connections) {
+	authHandler.saml = connections
+}
+
 /*
 Handles requests made to the base auth route
 
@@ -71,15 +111,151 @@ Returns:
   - No return value
 */
 func (auth *AuthHandler) SignIn(w http.ResponseWriter, r *http.Request) {
-	password.SignIn(auth.dbService, w, r)
+	password.SignIn(auth.dbService, auth.smsService, auth.mfaPolicy, w, r)
+}
+
+/*
+Handles requests made to the auth/mfa/sms/enroll route
+
+Objectives:
+  - Send a verification code to the phone number being enrolled
+
+Params:
+  - w: A http response writer
+  - r: A pointer to a http request object
+
+Returns:
+  - No return value
+*/
+func (auth *AuthHandler) EnrollSMS(w http.ResponseWriter, r *http.Request) {
+	mfaHandler.EnrollSMS(auth.dbService, auth.smsService, w, r)
+}
+
+/*
+Handles requests made to the auth/mfa/sms/enroll/verify route
+
+Objectives:
+  - Verify the phone number being enrolled
+
+Params:
+  - w: A http response writer
+  - r: A pointer to a http request object
+
+Returns:
+  - No return value
+*/
+func (auth *AuthHandler) VerifySMSEnrollment(w http.ResponseWriter, r *http.Request) {
+	mfaHandler.VerifySMSEnrollment(auth.dbService, w, r)
+}
+
+/*
+Handles requests made to the auth/mfa/sms/send route
+
+Objectives:
+  - Re-send the sign-in code of a pending second factor challenge
+
+Params:
+  - w: A http response writer
+  - r: A pointer to a http request object
+
+Returns:
+  - No return value
+*/
+func (auth *AuthHandler) ResendSMSChallenge(w http.ResponseWriter, r *http.Request) {
+	mfaHandler.ResendSMSChallenge(auth.dbService, auth.smsService, w, r)
+}
+
+/*
+Handles requests made to the auth/mfa/sms/verify route
+
+Objectives:
+  - Complete a sign-in with the SMS code
+  - Respond with the user as a payload
+
+Params:
+  - w: A http response writer
+  - r: A pointer to a http request object
+
+Returns:
+  - No return value
+*/
+func (auth *AuthHandler) VerifySMSChallenge(w http.ResponseWriter, r *http.Request) {
+	mfaHandler.VerifySMSChallenge(auth.dbService, w, r)
+}
+
+/*
+Handles requests made to the auth/recovery route
+
+Objectives:
+  - Email a recovery token to accounts that lost every second factor
+
+Params:
+  - w: A http response writer
+  - r: A pointer to a http request object
+
+Returns:
+  - No return value
+*/
+func (auth *AuthHandler) RequestRecovery(w http.ResponseWriter, r *http.Request) {
+	recovery.RequestRecovery(auth.dbService, auth.mailService, w, r)
+}
+
+/*
+Handles requests made to the auth/recovery/verify route
+
+Objectives:
+  - Verify the recovery email and start the waiting period
+
+Params:
+  - w: A http response writer
+  - r: A pointer to a http request object
+
+Returns:
+  - No return value
+*/
+func (auth *AuthHandler) VerifyRecovery(w http.ResponseWriter, r *http.Request) {
+	recovery.VerifyRecovery(auth.dbService, auth.mailService, auth.smsService, w, r)
+}
+
+/*
+Handles requests made to the auth/recovery/cancel route
+
+Objectives:
+  - Cancel a recovery request
+
+Params:
+  - w: A http response writer
+  - r: A pointer to a http request object
+
+Returns:
+  - No return value
+*/
+func (auth *AuthHandler) CancelRecovery(w http.ResponseWriter, r *http.Request) {
+	recovery.CancelRecovery(auth.dbService, auth.mailService, auth.smsService, w, r)
+}
+
+/*
+Handles requests made to the auth/recovery/complete route
+
+Objectives:
+  - Remove every second factor once the waiting period is over
+
+Params:
+  - w: A http response writer
+  - r: A pointer to a http request object
+
+Returns:
+  - No return value
+*/
+func (auth *AuthHandler) CompleteRecovery(w http.ResponseWriter, r *http.Request) {
+	recovery.CompleteRecovery(auth.dbService, auth.mailService, auth.smsService, w, r)
 }
 
 /*
-Handles requests made to the auth/oauth/google route
+Handles requests made to the auth/oauth/{provider} route
 
 Objectives:
-  - Handle the request
-  - Return user data model on success
+  - Redirect to any registered provider, unknown providers respond with 404
 
 Params:
   - w: A http response writer
@@ -88,15 +264,29 @@ Params:
 Returns:
   - No return value
 */
-func (auth *AuthHandler) GoogleSignIn(w http.ResponseWriter, r *http.Request) {
-	oauth.GoogleSignIn(w, r)
+func (auth *AuthHandler) OAuthSignIn(w http.ResponseWriter, r *http.Request) {
+	oauth.OAuthSignIn(auth.registry, auth.returnTo, w, r, chi.URLParam(r, "provider"))
 }
 
 /*
-Handles callbacks from Google
+Handles requests to link a registered provider account to the signed-in user
+
+Params:
+  - w: A http response writer
+  - r: A pointer to a http request object
+
+Returns:
+  - No return value
+*/
+func (auth *AuthHandler) OAuthLink(w http.ResponseWriter, r *http.Request) {
+	oauth.OAuthLink(auth.registry, auth.returnTo, w, r, chi.URLParam(r, "provider"))
+}
+
+/*
+Handles callbacks from registered providers
 
 Objectives:
-  - Handle Google sign-in callbacks
+  - Handle sign-in callbacks, challenging users with a second factor
 
 Params:
   - w: A http response writer
@@ -105,13 +295,16 @@ Params:
 Returns:
   - No return value
 */
-func (auth *AuthHandler) GoogleSignInCallback(w http.ResponseWriter, r *http.Request) {
-	oauth.GoogleSignInCallback(auth.dbService, w, r)
+func (auth *AuthHandler) OAuthCallback(w http.ResponseWriter, r *http.Request) {
+	oauth.OAuthCallback(auth.dbService, auth.smsService, auth.mfaPolicy, auth.tokens, auth.registry, w, r, chi.URLParam(r, "provider"))
 }
 
 /*
 Returns a generic failure response when oauth fails
 
+Objectives:
+  - Respond with the machine-readable error code the flow ended with
+
 Params:
   - w: A http response writer
   - r: The  http request object
@@ -120,9 +313,78 @@ Returns:
   - No return value
 */
 func (auth *AuthHandler) OAuthFailure(w http.ResponseWriter, r *http.Request) {
-	provider := chi.URLParam(r, "x")
+	provider := chi.URLParam(r, "provider")
 	msg := fmt.Sprintf("Failed to sign-in using %s OAuth", util.CapitalizeFirstLetter(provider))
-	util.JsonResponse(w, msg, http.StatusUnauthorized, nil)
+	util.JsonResponse(w, msg, http.StatusUnauthorized, map[string]string{"error": r.URL.Query().Get("error")})
+}
+
+/*
+Handles requests made to the auth/saml/{connection}/metadata route
+
+Objectives:
+  - Respond with the SP metadata to import into the IdP
+
+Params:
+  - w: A http response writer
+  - r: A pointer to a http request object
+
+Returns:
+  - No return value
+*/
+func (auth *AuthHandler) SAMLMetadata(w http.ResponseWriter, r *http.Request) {
+	oauth.SAMLMetadata(auth.saml, w, r, chi.URLParam(r, "connection"))
+}
+
+/*
+Handles requests made to the auth/saml/{connection}/login route
+
+Objectives:
+  - Redirect to the IdP with a signed AuthnRequest
+
+Params:
+  - w: A http response writer
+  - r: A pointer to a http request object
+
+Returns:
+  - No return value
+*/
+func (auth *AuthHandler) SAMLSignIn(w http.ResponseWriter, r *http.Request) {
+	oauth.SAMLSignIn(auth.saml, auth.returnTo, w, r, chi.URLParam(r, "connection"))
+}
+
+/*
+Handles responses posted to the auth/saml/{connection}/acs route
+
+Objectives:
+  - Verify the assertion and sign-in its user, challenging them with a second factor
+
+Params:
+  - w: A http response writer
+  - r: A pointer to a http request object
+
+Returns:
+  - No return value
+*/
+func (auth *AuthHandler) SAMLAssertionConsumer(w http.ResponseWriter, r *http.Request) {
+	oauth.SAMLAssertionConsumer(auth.dbService, auth.smsService, auth.mfaPolicy, auth.saml, w, r, chi.URLParam(r, "connection"))
+}
+
+/*
+Returns a generic failure response when a SAML sign-in fails
+
+Objectives:
+  - Respond with the machine-readable error code the flow ended with
+
+Params:
+  - w: A http response writer
+  - r: The  http request object
+
+Returns:
+  - No return value
+*/
+func (auth *AuthHandler) SAMLFailure(w http.ResponseWriter, r *http.Request) {
+	msg := fmt.Sprintf("Failed to sign-in using the %s SAML connection", chi.URLParam(r, "connection"))
+	util.JsonResponse(w, msg, http.StatusUnauthorized, map[string]string{"error": r.URL.Query().Get("error")})
 }
 
 /*
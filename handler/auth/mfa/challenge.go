@@ -0,0 +1,241 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/dev-xero/authentication-backend/authentication"
+	shared "github.com/dev-xero/authentication-backend/handler/auth/shared"
+	"github.com/dev-xero/authentication-backend/mfa"
+	"github.com/dev-xero/authentication-backend/model"
+	"github.com/dev-xero/authentication-backend/service"
+	"github.com/dev-xero/authentication-backend/sms"
+	"github.com/dev-xero/authentication-backend/util"
+)
+
+/*
+MFA challenge payload struct
+
+Fields:
+  - MFARequired: bool
+  - Factors:     []string, the factors the user can complete the sign-in with
+*/
+type ChallengePayload struct {
+	MFARequired bool     `json:"mfa_required"`
+	Factors     []string `json:"factors"`
+}
+
+/*
+Sign-in payload struct
+
+Fields:
+  - UserPayload: The signed-in user
+  - MFA:         *mfa.Decision, present when the user must enroll a second factor
+*/
+type SignInPayload struct {
+	util.UserPayload
+	MFA *mfa.Decision `json:"mfa,omitempty"`
+}
+
+/*
+Sign-in result struct, exactly one field is set
+
+Fields:
+  - Challenge: *ChallengePayload, set when a second factor challenge was started
+  - SignIn:    *SignInPayload, set when the token cookie was issued
+*/
+type SignInResult struct {
+	Challenge *ChallengePayload
+	SignIn    *SignInPayload
+}
+
+// Returned when a challenge is started for a user whose phone is no longer verified
+var ErrNoVerifiedPhone = errors.New("no verified phone number enrolled")
+
+/*
+Completes a sign-in for a user that passed the first factor
+
+Objectives:
+  - Start a second factor challenge if the user has a verified SMS factor
+  - Otherwise evaluate the MFA policy for the user
+  - Issue the token cookie, telling users that must enroll when their grace period ends
+
+Params:
+  - dbService:  The database service provider
+  - smsService: The SMS service provider
+  - policy:     The MFA enforcement policy
+  - w:          A http response writer
+  - r:          A pointer to a http request object
+  - user:       The user that passed the first factor
+  - msg:        The response message if the user is signed in
+
+Returns:
+  - No return value
+*/
+func CompleteSignIn(dbService *service.DatabaseProvider, smsService *service.SMSProvider, policy *mfa.Policy, w http.ResponseWriter, r *http.Request, user model.User, msg string) {
+	result, err := BeginSignIn(dbService, smsService, policy, w, r, user)
+	if err != nil {
+		respondSignInError(w, err)
+		return
+	}
+
+	if result.Challenge != nil {
+		util.JsonResponse(w, "Second factor required, a code was sent to your phone", http.StatusOK, *result.Challenge)
+		return
+	}
+
+	util.JsonResponse(w, msg, http.StatusOK, *result.SignIn)
+}
+
+/*
+Starts a sign-in for a user that passed the first factor without writing a response
+
+Objectives:
+  - Start a second factor challenge if the user has a verified SMS factor
+  - Otherwise evaluate the MFA policy and set the token cookie
+
+Params:
+  - dbService:  The database service provider
+  - smsService: The SMS service provider
+  - policy:     The MFA enforcement policy
+  - w:          A http response writer, only cookies are set
+  - r:          A pointer to a http request object
+  - user:       The user that passed the first factor
+
+Returns:
+  - The sign-in result
+  - An error if any step fails
+*/
+func BeginSignIn(dbService *service.DatabaseProvider, smsService *service.SMSProvider, policy *mfa.Policy, w http.ResponseWriter, r *http.Request, user model.User) (SignInResult, error) {
+	hasPhone, err := dbService.Repo.HasVerifiedPhone(r.Context(), user.ID)
+	if err != nil {
+		return SignInResult{}, fmt.Errorf("[FAIL]: could not check second factors: %w", err)
+	}
+
+	if hasPhone {
+		return startChallenge(dbService, smsService, w, r, user)
+	}
+
+	decision, err := policy.Evaluate(r.Context(), dbService, user)
+	if err != nil {
+		return SignInResult{}, fmt.Errorf("[FAIL]: could not evaluate mfa policy: %w", err)
+	}
+
+	payload := SignInPayload{UserPayload: shared.NewUserPayload(user)}
+	if decision.Required {
+		payload.MFA = &decision
+	}
+
+	if err := shared.SetTokenCookie(w, user.ID); err != nil {
+		return SignInResult{}, err
+	}
+
+	return SignInResult{SignIn: &payload}, nil
+}
+
+/*
+Starts a second factor challenge
+
+Objectives:
+  - Check the user still has a verified phone
+  - Set a short-lived mfa token cookie in place of the token cookie
+  - Send a sign-in code to the user's phone
+
+Params:
+  - dbService:  The database service provider
+  - smsService: The SMS service provider
+  - w:          A http response writer, only cookies are set
+  - r:          A pointer to a http request object
+  - user:       The user that passed the first factor
+
+Returns:
+  - The sign-in result holding the challenge
+  - An error if any step fails
+*/
+func startChallenge(dbService *service.DatabaseProvider, smsService *service.SMSProvider, w http.ResponseWriter, r *http.Request, user model.User) (SignInResult, error) {
+	phone, err := dbService.Repo.GetUserPhone(r.Context(), user.ID)
+	if err != nil || phone.VerifiedAt == nil {
+		return SignInResult{}, ErrNoVerifiedPhone
+	}
+
+	token, err := authentication.CreateMFAToken(user.ID)
+	if err != nil {
+		return SignInResult{}, err
+	}
+
+	// Set before sending, so a user refused by the rate limits can resend the code later
+	cookie := util.CreateMFACookie(token)
+	http.SetCookie(w, &cookie)
+
+	if err := mfa.SendSMSCode(r.Context(), dbService, smsService, user.ID, phone.Phone, mfa.PurposeSignIn); err != nil {
+		return SignInResult{}, err
+	}
+
+	return SignInResult{Challenge: &ChallengePayload{MFARequired: true, Factors: []string{"sms"}}}, nil
+}
+
+/*
+Responds with the status matching a BeginSignIn error
+
+Params:
+  - w:   A http response writer
+  - err: The error returned by BeginSignIn
+
+Returns:
+  - No return value
+*/
+func respondSignInError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, ErrNoVerifiedPhone):
+		util.JsonResponse(w, "No verified phone number enrolled", http.StatusBadRequest, nil)
+	case errors.Is(err, sms.ErrDestinationBlocked), errors.Is(err, sms.ErrRateLimited):
+		respondSMSError(w, err)
+	default:
+		log.Println(err)
+		util.JsonResponse(w, "Internal server error, could not complete sign-in", http.StatusInternalServerError, nil)
+	}
+}
+
+/*
+Responds with the status matching an SMS send error
+
+Params:
+  - w:   A http response writer
+  - err: The error returned by mfa.SendSMSCode
+
+Returns:
+  - No return value
+*/
+func respondSMSError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, sms.ErrDestinationBlocked):
+		util.JsonResponse(w, util.CapitalizeFirstLetter(err.Error()), http.StatusBadRequest, nil)
+	case errors.Is(err, sms.ErrRateLimited):
+		util.JsonResponse(w, util.CapitalizeFirstLetter(err.Error()), http.StatusTooManyRequests, nil)
+	default:
+		log.Println(err)
+		util.JsonResponse(w, "Failed to send verification code", http.StatusInternalServerError, nil)
+	}
+}
+
+/*
+Responds with the status matching an SMS code verification error
+
+Params:
+  - w:   A http response writer
+  - err: The error returned by mfa.VerifySMSCode
+
+Returns:
+  - No return value
+*/
+func respondVerifyError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, mfa.ErrCodeMismatch), errors.Is(err, mfa.ErrCodeExhausted), errors.Is(err, mfa.ErrCodeNotPending):
+		util.JsonResponse(w, util.CapitalizeFirstLetter(err.Error()), http.StatusUnauthorized, nil)
+	default:
+		log.Println(err)
+		util.JsonResponse(w, "Failed to verify code", http.StatusInternalServerError, nil)
+	}
+}
@@ -0,0 +1,268 @@
+package handler
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/dev-xero/authentication-backend/authentication"
+	shared "github.com/dev-xero/authentication-backend/handler/auth/shared"
+	"github.com/dev-xero/authentication-backend/mfa"
+	"github.com/dev-xero/authentication-backend/middleware"
+	"github.com/dev-xero/authentication-backend/service"
+	"github.com/dev-xero/authentication-backend/util"
+	"github.com/google/uuid"
+)
+
+/*
+Handles enrolling a phone number as an SMS second factor
+
+Objectives:
+  - Decode, sanitize and normalize the phone number
+  - Refuse to replace an already verified phone number
+  - Store the unverified number and send it a verification code
+
+Params:
+  - dbService:  The database service provider
+  - smsService: The SMS service provider
+  - w:          A http response writer
+  - r:          A pointer to a http request object
+
+Returns:
+  - No return value
+*/
+func EnrollSMS(dbService *service.DatabaseProvider, smsService *service.SMSProvider, w http.ResponseWriter, r *http.Request) {
+	userID, _ := middleware.UserIDFromContext(r.Context())
+
+	var body = util.PhoneRequestBody{}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		msg := "Bad request, phone not present"
+		util.JsonResponse(w, msg, http.StatusBadRequest, nil)
+		return
+	}
+
+	util.SanitizeUserInput(&body)
+
+	phone, err := util.NormalizePhoneNumber(body.Phone)
+	if err != nil {
+		util.JsonResponse(w, util.CapitalizeFirstLetter(err.Error()), http.StatusBadRequest, nil)
+		return
+	}
+
+	// A verified number must be removed through recovery before another is enrolled
+	existing, err := dbService.Repo.GetUserPhone(r.Context(), userID)
+	if err == nil && existing.VerifiedAt != nil {
+		msg := "An SMS second factor is already enrolled"
+		util.JsonResponse(w, msg, http.StatusConflict, nil)
+		return
+	}
+
+	if err := dbService.Repo.UpsertUserPhone(r.Context(), userID, phone); err != nil {
+		log.Println(err)
+		msg := "Failed to store phone number"
+		util.JsonResponse(w, msg, http.StatusInternalServerError, nil)
+		return
+	}
+
+	if err := mfa.SendSMSCode(r.Context(), dbService, smsService, userID, phone, mfa.PurposeEnroll); err != nil {
+		respondSMSError(w, err)
+		return
+	}
+
+	util.JsonResponse(w, "A verification code was sent to your phone", http.StatusOK, nil)
+}
+
+/*
+Handles verifying the phone number being enrolled
+
+Objectives:
+  - Decode and sanitize the verification code
+  - Verify the code sent during enrollment
+  - Mark the phone number as verified
+
+Params:
+  - dbService: The database service provider
+  - w:         A http response writer
+  - r:         A pointer to a http request object
+
+Returns:
+  - No return value
+*/
+func VerifySMSEnrollment(dbService *service.DatabaseProvider, w http.ResponseWriter, r *http.Request) {
+	userID, _ := middleware.UserIDFromContext(r.Context())
+
+	code, ok := decodeCode(w, r)
+	if !ok {
+		return
+	}
+
+	phone, err := mfa.VerifySMSCode(r.Context(), dbService, userID, mfa.PurposeEnroll, code)
+	if err != nil {
+		respondVerifyError(w, err)
+		return
+	}
+
+	if err := dbService.Repo.MarkUserPhoneVerified(r.Context(), userID, phone); err != nil {
+		log.Println(err)
+		msg := "Failed to verify phone number, it may have changed since the code was sent"
+		util.JsonResponse(w, msg, http.StatusConflict, nil)
+		return
+	}
+
+	util.JsonResponse(w, "Successfully enrolled SMS second factor", http.StatusOK, nil)
+}
+
+/*
+Handles re-sending the sign-in code to a user with a pending challenge
+
+Params:
+  - dbService:  The database service provider
+  - smsService: The SMS service provider
+  - w:          A http response writer
+  - r:          A pointer to a http request object
+
+Returns:
+  - No return value
+*/
+func ResendSMSChallenge(dbService *service.DatabaseProvider, smsService *service.SMSProvider, w http.ResponseWriter, r *http.Request) {
+	userID, ok := userFromMFACookie(w, r)
+	if !ok {
+		return
+	}
+
+	user, err := dbService.Repo.GetUserByID(r.Context(), userID.String())
+	if err != nil {
+		log.Println(err)
+		msg := "Internal server error, failed to get user"
+		util.JsonResponse(w, msg, http.StatusInternalServerError, nil)
+		return
+	}
+
+	result, err := startChallenge(dbService, smsService, w, r, user)
+	if err != nil {
+		respondSignInError(w, err)
+		return
+	}
+
+	util.JsonResponse(w, "Second factor required, a code was sent to your phone", http.StatusOK, *result.Challenge)
+}
+
+/*
+Handles completing a sign-in with an SMS code
+
+Objectives:
+  - Read the user from the mfa token cookie
+  - Verify the sign-in code
+  - Cancel any active account recovery, the owner still has their second factor
+  - Expire the mfa token cookie and issue the token cookie
+
+Params:
+  - dbService: The database service provider
+  - w:         A http response writer
+  - r:         A pointer to a http request object
+
+Returns:
+  - No return value
+*/
+func VerifySMSChallenge(dbService *service.DatabaseProvider, w http.ResponseWriter, r *http.Request) {
+	userID, ok := userFromMFACookie(w, r)
+	if !ok {
+		return
+	}
+
+	code, ok := decodeCode(w, r)
+	if !ok {
+		return
+	}
+
+	if _, err := mfa.VerifySMSCode(r.Context(), dbService, userID, mfa.PurposeSignIn, code); err != nil {
+		respondVerifyError(w, err)
+		return
+	}
+
+	user, err := dbService.Repo.GetUserByID(r.Context(), userID.String())
+	if err != nil {
+		log.Println(err)
+		msg := "Internal server error, failed to get user"
+		util.JsonResponse(w, msg, http.StatusInternalServerError, nil)
+		return
+	}
+
+	// Signing in with a second factor proves the account doesn't need recovering
+	if recovery, err := dbService.Repo.GetActiveRecovery(r.Context(), user.ID); err == nil {
+		event := shared.NewAuditEvent(r, user.ID, "recovery.cancelled", "signed-in with a second factor")
+		if err := dbService.Repo.CancelRecovery(r.Context(), recovery.ID, event); err != nil {
+			log.Println(err)
+		}
+	}
+
+	util.ExpireCookie(w, "mfa_token")
+	shared.IssueToken(w, user.ID, "Successfully signed-in", shared.NewUserPayload(user))
+}
+
+/*
+Reads the user id from the mfa token cookie
+
+Params:
+  - w: A http response writer
+  - r: A pointer to a http request object
+
+Returns:
+  - The user id
+  - False if the cookie is missing or invalid, the response is already written
+*/
+func userFromMFACookie(w http.ResponseWriter, r *http.Request) (uuid.UUID, bool) {
+	cookie, err := r.Cookie("mfa_token")
+	if err != nil {
+		msg := "No sign-in is pending a second factor"
+		util.JsonResponse(w, msg, http.StatusUnauthorized, nil)
+		return uuid.Nil, false
+	}
+
+	token, err := authentication.VerifyMFAToken(cookie.Value)
+	if err != nil {
+		log.Printf("[FAIL]: mfa token verification failed: %v", err)
+		msg := "The sign-in has expired, sign in again"
+		util.JsonResponse(w, msg, http.StatusUnauthorized, nil)
+		return uuid.Nil, false
+	}
+
+	userID, err := authentication.SubjectFromToken(token)
+	if err != nil {
+		log.Println(err)
+		msg := "The sign-in has expired, sign in again"
+		util.JsonResponse(w, msg, http.StatusUnauthorized, nil)
+		return uuid.Nil, false
+	}
+
+	return userID, true
+}
+
+/*
+Decodes and validates the verification code request body
+
+Params:
+  - w: A http response writer
+  - r: A pointer to a http request object
+
+Returns:
+  - The verification code
+  - False if the body is invalid, the response is already written
+*/
+func decodeCode(w http.ResponseWriter, r *http.Request) (string, bool) {
+	var body = util.CodeRequestBody{}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		msg := "Bad request, code not present"
+		util.JsonResponse(w, msg, http.StatusBadRequest, nil)
+		return "", false
+	}
+
+	util.SanitizeUserInput(&body)
+
+	if len(body.Code) != 6 {
+		util.JsonResponse(w, util.CapitalizeFirstLetter(util.ErrCodeInvalid.Error()), http.StatusBadRequest, nil)
+		return "", false
+	}
+
+	return body.Code, true
+}
@@ -0,0 +1,243 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/dev-xero/authentication-backend/authentication"
+	"github.com/dev-xero/authentication-backend/model"
+	"github.com/dev-xero/authentication-backend/oidc"
+	"github.com/joho/godotenv"
+	"golang.org/x/oauth2"
+)
+
+// Provider name of Apple identities
+const appleProvider = "apple"
+
+// Domain of the relay addresses Apple creates for users hiding their email
+const applePrivateRelayDomain = "@privaterelay.appleid.com"
+
+/*
+Apple provider struct, Sign in with Apple is OpenID Connect with a signed client secret and a posted callback
+
+Fields:
+  - provider: The OpenID Connect provider verifying Apple's id tokens
+*/
+type AppleProvider struct {
+	provider *oidc.Provider
+}
+
+/*
+Creates the Apple provider
+
+Params:
+  - config:    The OpenID Connect config, its ClientSecretFunc must sign the Apple client secret
+  - endpoints: Apple's endpoints, see oidc.AppleEndpoints
+
+Returns:
+  - A pointer to the Apple provider
+*/
+func NewAppleProvider(config oidc.Config, endpoints oidc.Endpoints) *AppleProvider {
+	config.Name = appleProvider
+	return &AppleProvider{provider: oidc.NewProviderWithEndpoints(config, endpoints)}
+}
+
+/*
+Creates the Apple provider configured through environment variables
+
+Objectives:
+  - Load the services id, team id, key id and redirect URL
+  - Load the Sign in with Apple private key the client secret is signed with
+  - Load the base URL, so a local mock can stand in for Apple
+
+Params:
+  - No parameters
+
+Returns:
+  - A pointer to the Apple provider, nil when APPLE_CLIENT_ID isn't set so Apple isn't registered
+  - An error if the environment or the private key can't be loaded
+*/
+func NewAppleProviderFromEnvironment() (*AppleProvider, error) {
+	// Load environment variables from .env file in development
+	if env := os.Getenv("ENVIRONMENT"); env != "production" {
+		err := godotenv.Load()
+		if err != nil {
+			return nil, fmt.Errorf("[FAIL]: could not load environment variables: %w", err)
+		}
+	}
+
+	clientID := os.Getenv("APPLE_CLIENT_ID")
+	if clientID == "" {
+		return nil, nil
+	}
+
+	teamID := os.Getenv("APPLE_TEAM_ID")
+	keyID := os.Getenv("APPLE_KEY_ID")
+	if teamID == "" || keyID == "" {
+		return nil, fmt.Errorf("[FAIL]: APPLE_TEAM_ID and APPLE_KEY_ID must be set")
+	}
+
+	keyData, err := os.ReadFile(os.Getenv("APPLE_PRIVATE_KEY_FILE"))
+	if err != nil {
+		return nil, fmt.Errorf("[FAIL]: could not read APPLE_PRIVATE_KEY_FILE: %w", err)
+	}
+
+	key, err := oidc.ParseApplePrivateKey(keyData)
+	if err != nil {
+		return nil, err
+	}
+
+	baseURL := strings.TrimSuffix(os.Getenv("APPLE_BASE_URL"), "/")
+	if baseURL == "" {
+		baseURL = oidc.AppleBaseURL
+	}
+
+	config := oidc.Config{
+		Issuer:           baseURL,
+		ClientID:         clientID,
+		RedirectURL:      os.Getenv("APPLE_OAUTH_REDIRECT_URL"),
+		Scopes:           []string{"name", "email"},
+		ClientSecretFunc: oidc.NewAppleClientSecret(teamID, keyID, clientID, baseURL, key).Secret,
+	}
+
+	return NewAppleProvider(config, oidc.AppleEndpoints(baseURL)), nil
+}
+
+// Returns the provider name
+func (provider *AppleProvider) Name() string {
+	return appleProvider
+}
+
+// Apple posts the callback when the name or email scope is requested
+func (provider *AppleProvider) FormPost() bool {
+	return true
+}
+
+/*
+Returns the URL to redirect the user to for authentication
+
+Params:
+  - state: The OAuth state, its nonce and PKCE challenge are sent
+
+Returns:
+  - The authorization URL, with response_mode=form_post
+*/
+func (provider *AppleProvider) AuthCodeURL(state authentication.OAuthState) string {
+	return provider.provider.AuthCodeURL(state.ID, state.Nonce,
+		oauth2.S256ChallengeOption(state.Verifier),
+		oauth2.SetAuthURLParam("response_mode", "form_post"),
+	)
+}
+
+/*
+Exchanges an authorization code for Apple's tokens, with a signed client secret
+
+Params:
+  - ctx:   Method context
+  - code:  The authorization code
+  - state: The OAuth state, its PKCE verifier is sent
+
+Returns:
+  - The Apple token, holding the id token
+  - An error if the exchange fails
+*/
+func (provider *AppleProvider) Exchange(ctx context.Context, code string, state authentication.OAuthState) (*oauth2.Token, error) {
+	return provider.provider.Exchange(ctx, code, oauth2.VerifierOption(state.Verifier))
+}
+
+/*
+Returns the profile of the user Apple authenticated
+
+Objectives:
+  - Verify the id token against Apple's keys, with the nonce of the state
+  - Flag private relay addresses, Apple verified them and forwards mail to the user
+
+Params:
+  - ctx:   Method context
+  - token: The Apple token
+  - state: The OAuth state
+
+Returns:
+  - The external profile of the user, without a name since Apple only sends it with the first callback
+  - An error if the id token is missing or invalid
+*/
+func (provider *AppleProvider) Profile(ctx context.Context, token *oauth2.Token, state authentication.OAuthState) (model.ExternalProfile, error) {
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok || rawIDToken == "" {
+		return model.ExternalProfile{}, oidc.ErrNoIDToken
+	}
+
+	claims, err := provider.provider.VerifyIDToken(ctx, rawIDToken, state.Nonce)
+	if err != nil {
+		return model.ExternalProfile{}, err
+	}
+
+	profile, err := provider.provider.Profile(claims)
+	if err != nil {
+		return model.ExternalProfile{}, err
+	}
+
+	// Apple sends the claim as a string or a boolean
+	switch value := claims["is_private_email"].(type) {
+	case bool:
+		profile.PrivateEmail = value
+	case string:
+		profile.PrivateEmail = value == "true"
+	}
+	if strings.HasSuffix(strings.ToLower(profile.Email), applePrivateRelayDomain) {
+		profile.PrivateEmail = true
+	}
+
+	if profile.PrivateEmail {
+		profile.EmailVerified = true
+	}
+
+	return profile, nil
+}
+
+/*
+Adds the name Apple posts with the first callback of a user to the profile
+
+Params:
+  - r:       A pointer to the callback request
+  - profile: The profile from the id token
+
+Returns:
+  - The profile, with the posted name when there is one
+*/
+func (provider *AppleProvider) CallbackProfile(r *http.Request, profile model.ExternalProfile) model.ExternalProfile {
+	var user struct {
+		Name struct {
+			FirstName string `json:"firstName"`
+			LastName  string `json:"lastName"`
+		} `json:"name"`
+	}
+
+	if err := json.Unmarshal([]byte(r.PostFormValue("user")), &user); err != nil {
+		return profile
+	}
+
+	if name := strings.TrimSpace(user.Name.FirstName + " " + user.Name.LastName); name != "" {
+		profile.Name = name
+	}
+
+	return profile
+}
+
+/*
+Returns a token source refreshing an Apple token, with a signed client secret
+
+Params:
+  - ctx:   Method context, used for refreshes
+  - token: The Apple token
+
+Returns:
+  - The token source
+*/
+func (provider *AppleProvider) TokenSource(ctx context.Context, token *oauth2.Token) oauth2.TokenSource {
+	return provider.provider.TokenSource(ctx, token)
+}
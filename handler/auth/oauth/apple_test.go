@@ -0,0 +1,282 @@
+package handler
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dev-xero/authentication-backend/authentication"
+	"github.com/dev-xero/authentication-backend/internal/testutil"
+	"github.com/dev-xero/authentication-backend/model"
+	"github.com/dev-xero/authentication-backend/oidc"
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/oauth2"
+)
+
+const testAppleClientID = "com.example.web"
+
+// A mock Apple publishing its signing key and answering token requests with idToken
+type testApple struct {
+	server    *httptest.Server
+	key       *rsa.PrivateKey
+	idToken   string
+	tokenForm url.Values
+}
+
+func newTestApple(t *testing.T) *testApple {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	apple := &testApple{key: key}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/auth/keys", func(w http.ResponseWriter, r *http.Request) {
+		jwk := testutil.RSAJWK("apple-key", key)
+		jwk["alg"] = "RS256"
+		testutil.WriteJSON(w, map[string]interface{}{"keys": []map[string]string{jwk}})
+	})
+	mux.HandleFunc("/auth/token", func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		apple.tokenForm = r.PostForm
+
+		testutil.WriteJSON(w, map[string]interface{}{
+			"access_token": "apple-access-token",
+			"token_type":   "Bearer",
+			"expires_in":   3600,
+			"id_token":     apple.idToken,
+		})
+	})
+
+	apple.server = httptest.NewServer(mux)
+	t.Cleanup(apple.server.Close)
+
+	return apple
+}
+
+// Creates the provider as NewAppleProviderFromEnvironment does with APPLE_BASE_URL set to the mock
+func (apple *testApple) provider(t *testing.T, key *ecdsa.PrivateKey) *AppleProvider {
+	t.Helper()
+
+	return NewAppleProvider(oidc.Config{
+		Issuer:           apple.server.URL,
+		ClientID:         testAppleClientID,
+		RedirectURL:      "https://app.example.com/auth/oauth/apple/callback",
+		Scopes:           []string{"name", "email"},
+		ClientSecretFunc: oidc.NewAppleClientSecret("TEAM123456", "KEY1234567", testAppleClientID, apple.server.URL, key).Secret,
+		HTTPClient:       apple.server.Client(),
+	}, oidc.AppleEndpoints(apple.server.URL))
+}
+
+// Claims of a valid Apple id token, tests change them to make it invalid
+func (apple *testApple) claims(nonce string) jwt.MapClaims {
+	now := time.Now()
+	return jwt.MapClaims{
+		"iss":            apple.server.URL,
+		"aud":            testAppleClientID,
+		"sub":            "001234.abcdef",
+		"iat":            now.Unix(),
+		"exp":            now.Add(10 * time.Minute).Unix(),
+		"nonce":          nonce,
+		"email":          "jane@example.com",
+		"email_verified": "true",
+	}
+}
+
+func (apple *testApple) sign(t *testing.T, claims jwt.MapClaims) string {
+	t.Helper()
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = "apple-key"
+
+	signed, err := token.SignedString(apple.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return signed
+}
+
+func newTestAppleState() authentication.OAuthState {
+	state := authentication.OAuthState{Provider: appleProvider, Verifier: oauth2.GenerateVerifier(), Nonce: "nonce-1"}
+	state.ID = "state-1"
+	return state
+}
+
+func TestAppleAuthCodeURLPostsCallback(t *testing.T) {
+	apple := newTestApple(t)
+	state := newTestAppleState()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	authURL, err := url.Parse(apple.provider(t, key).AuthCodeURL(state))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	query := authURL.Query()
+	if !strings.HasPrefix(authURL.String(), apple.server.URL+"/auth/authorize?") || query.Get("response_mode") != "form_post" {
+		t.Errorf("unexpected authorization url %s", authURL)
+	}
+	if query.Get("nonce") != state.Nonce || query.Get("code_challenge_method") != "S256" || query.Get("scope") != "openid name email" {
+		t.Errorf("unexpected authorization request %v", query)
+	}
+}
+
+func TestAppleExchangeAndProfile(t *testing.T) {
+	apple := newTestApple(t)
+	state := newTestAppleState()
+	ctx := context.Background()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	provider := apple.provider(t, key)
+	apple.idToken = apple.sign(t, apple.claims(state.Nonce))
+
+	token, err := provider.Exchange(ctx, "code-1", state)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The client secret is a fresh ES256 JWT signed with the Sign in with Apple key
+	var secretClaims jwt.RegisteredClaims
+	_, err = jwt.ParseWithClaims(apple.tokenForm.Get("client_secret"), &secretClaims, func(token *jwt.Token) (interface{}, error) {
+		return &key.PublicKey, nil
+	}, jwt.WithValidMethods([]string{"ES256"}), jwt.WithIssuer("TEAM123456"), jwt.WithSubject(testAppleClientID), jwt.WithAudience(apple.server.URL))
+	if err != nil {
+		t.Fatalf("client secret is invalid: %v", err)
+	}
+	if apple.tokenForm.Get("code_verifier") != state.Verifier || apple.tokenForm.Get("client_id") != testAppleClientID {
+		t.Errorf("unexpected token request %v", apple.tokenForm)
+	}
+
+	profile, err := provider.Profile(ctx, token, state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if profile.Subject != "001234.abcdef" || profile.Email != "jane@example.com" || !profile.EmailVerified || profile.PrivateEmail {
+		t.Errorf("unexpected profile %+v", profile)
+	}
+}
+
+func TestAppleProfileFlagsPrivateRelayAddresses(t *testing.T) {
+	tests := []struct {
+		name   string
+		claims func(claims jwt.MapClaims)
+	}{
+		{
+			name: "claim as string",
+			claims: func(claims jwt.MapClaims) {
+				claims["is_private_email"] = "true"
+				claims["email_verified"] = "false"
+			},
+		},
+		{
+			name: "claim as boolean",
+			claims: func(claims jwt.MapClaims) {
+				claims["is_private_email"] = true
+				delete(claims, "email_verified")
+			},
+		},
+		{
+			name: "relay domain without the claim",
+			claims: func(claims jwt.MapClaims) {
+				claims["email"] = "x7k2@PrivateRelay.AppleID.com"
+				delete(claims, "email_verified")
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			apple := newTestApple(t)
+			state := newTestAppleState()
+			claims := apple.claims(state.Nonce)
+			test.claims(claims)
+
+			token := (&oauth2.Token{AccessToken: "apple-access-token"}).WithExtra(map[string]interface{}{"id_token": apple.sign(t, claims)})
+			profile, err := apple.provider(t, nil).Profile(context.Background(), token, state)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !profile.PrivateEmail || !profile.EmailVerified {
+				t.Fatalf("private email = %v, verified = %v", profile.PrivateEmail, profile.EmailVerified)
+			}
+		})
+	}
+}
+
+func TestAppleProfileRejectsInvalidIDTokens(t *testing.T) {
+	tests := []struct {
+		name   string
+		claims func(claims jwt.MapClaims)
+		want   error
+	}{
+		{name: "another nonce", claims: func(claims jwt.MapClaims) { claims["nonce"] = "nonce-2" }, want: oidc.ErrNonceMismatch},
+		{name: "another audience", claims: func(claims jwt.MapClaims) { claims["aud"] = "com.example.other" }},
+		{name: "another issuer", claims: func(claims jwt.MapClaims) { claims["iss"] = oidc.AppleBaseURL }},
+		{name: "expired", claims: func(claims jwt.MapClaims) { claims["exp"] = time.Now().Add(-time.Hour).Unix() }},
+		{name: "no email", claims: func(claims jwt.MapClaims) { delete(claims, "email") }, want: oidc.ErrMissingClaims},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			apple := newTestApple(t)
+			state := newTestAppleState()
+			claims := apple.claims(state.Nonce)
+			test.claims(claims)
+
+			token := (&oauth2.Token{AccessToken: "apple-access-token"}).WithExtra(map[string]interface{}{"id_token": apple.sign(t, claims)})
+			_, err := apple.provider(t, nil).Profile(context.Background(), token, state)
+			if err == nil || (test.want != nil && !errors.Is(err, test.want)) {
+				t.Fatalf("expected %v, got %v", test.want, err)
+			}
+		})
+	}
+
+	apple := newTestApple(t)
+	if _, err := apple.provider(t, nil).Profile(context.Background(), &oauth2.Token{AccessToken: "apple-access-token"}, newTestAppleState()); !errors.Is(err, oidc.ErrNoIDToken) {
+		t.Fatalf("expected ErrNoIDToken, got %v", err)
+	}
+}
+
+// Apple posts the name with the first callback only, later callbacks keep the profile's name
+func TestAppleCallbackProfileReadsPostedName(t *testing.T) {
+	provider := newTestApple(t).provider(t, nil)
+
+	post := func(user string) *http.Request {
+		r := httptest.NewRequest(http.MethodPost, "/auth/oauth/apple/callback", strings.NewReader(url.Values{"user": {user}}.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		return r
+	}
+
+	profile := provider.CallbackProfile(post(`{"name":{"firstName":"Jane","lastName":"Doe"},"email":"jane@example.com"}`), model.ExternalProfile{Subject: "001234.abcdef", Email: "jane@example.com"})
+	if profile.Name != "Jane Doe" {
+		t.Errorf("name = %q", profile.Name)
+	}
+
+	profile = provider.CallbackProfile(post(""), model.ExternalProfile{Subject: "001234.abcdef", Email: "jane@example.com"})
+	if profile.Name != "" || profile.Email != "jane@example.com" {
+		t.Errorf("unexpected profile %+v", profile)
+	}
+}
@@ -0,0 +1,164 @@
+package handler
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/dev-xero/authentication-backend/mfa"
+	"github.com/dev-xero/authentication-backend/model"
+	"github.com/dev-xero/authentication-backend/providertoken"
+	"github.com/dev-xero/authentication-backend/service"
+	"github.com/dev-xero/authentication-backend/util"
+)
+
+/*
+Handles sign-in with a registered provider
+
+Params:
+  - registry:  The registered providers
+  - allowlist: The URLs return_to may point to
+  - w:         A http response writer
+  - r:         A pointer to a http request object
+  - name:      The provider name
+
+Returns:
+  - No return value
+*/
+func OAuthSignIn(registry *Registry, allowlist *ReturnToAllowlist, w http.ResponseWriter, r *http.Request, name string) {
+	startOAuth(registry, allowlist, w, r, name, false)
+}
+
+/*
+Handles linking a registered provider account to the signed-in user
+
+Params:
+  - registry:  The registered providers
+  - allowlist: The URLs return_to may point to
+  - w:         A http response writer
+  - r:         A pointer to a http request object
+  - name:      The provider name
+
+Returns:
+  - No return value
+*/
+func OAuthLink(registry *Registry, allowlist *ReturnToAllowlist, w http.ResponseWriter, r *http.Request, name string) {
+	startOAuth(registry, allowlist, w, r, name, true)
+}
+
+/*
+Starts an OAuth flow
+
+Objectives:
+  - Find the registered provider
+  - Start the OAuth flow, with its PKCE challenge and id token nonce
+  - Redirect to the provider
+
+Params:
+  - registry:  The registered providers
+  - allowlist: The URLs return_to may point to
+  - w:         A http response writer
+  - r:         A pointer to a http request object
+  - name:      The provider name
+  - link:      Whether the flow links an identity to the signed-in user
+
+Returns:
+  - No return value
+*/
+func startOAuth(registry *Registry, allowlist *ReturnToAllowlist, w http.ResponseWriter, r *http.Request, name string, link bool) {
+	provider, ok := registeredProvider(registry, w, r, name)
+	if !ok {
+		return
+	}
+
+	state, ok := startOAuthFlow(allowlist, w, r, provider, link)
+	if !ok {
+		return
+	}
+
+	http.Redirect(w, r, provider.AuthCodeURL(state), http.StatusTemporaryRedirect)
+}
+
+/*
+Handles callbacks from registered providers
+
+Objectives:
+  - Check the OAuth state
+  - Exchange the code with the PKCE verifier and fetch the profile
+  - Sign-in, sign-up or link the identity, storing the provider token when enabled
+
+Params:
+  - dbService:  The database service provider
+  - smsService: The SMS service provider
+  - policy:     The MFA enforcement policy
+  - tokens:     The provider token store, nil when tokens aren't stored
+  - registry:   The registered providers
+  - w:          A http response writer
+  - r:          A pointer to a http request object
+  - name:       The provider name
+
+Returns:
+  - No return value
+*/
+func OAuthCallback(dbService *service.DatabaseProvider, smsService *service.SMSProvider, policy *mfa.Policy, tokens *providertoken.Store, registry *Registry, w http.ResponseWriter, r *http.Request, name string) {
+	provider, ok := registeredProvider(registry, w, r, name)
+	if !ok {
+		return
+	}
+
+	state, ok := consumeOAuthFlow(dbService, w, r, provider.Name())
+	if !ok {
+		return
+	}
+
+	token, err := provider.Exchange(r.Context(), r.FormValue("code"), state)
+	if err != nil {
+		failOAuth(w, r, state.ReturnTo, errorExchangeFailed, err.Error())
+		return
+	}
+
+	profile, err := provider.Profile(r.Context(), token, state)
+	if err != nil {
+		failOAuth(w, r, state.ReturnTo, errorExchangeFailed, err.Error())
+		return
+	}
+
+	if callbackProfile, ok := provider.(callbackProfileProvider); ok {
+		profile = callbackProfile.CallbackProfile(r, profile)
+	}
+
+	completeExternalSignIn(dbService, smsService, policy, tokens, w, r, provider.Name(), profile, token, state)
+}
+
+// Implemented by providers that send profile fields with the callback itself, e.g. the name Apple only sends once
+type callbackProfileProvider interface {
+	CallbackProfile(r *http.Request, profile model.ExternalProfile) model.ExternalProfile
+}
+
+/*
+Finds an enabled provider
+
+Params:
+  - registry: The registered providers
+  - w:        A http response writer
+  - r:        A pointer to a http request object
+  - name:     The provider name
+
+Returns:
+  - The provider
+  - False if the provider is unknown or unavailable, the response is already written
+*/
+func registeredProvider(registry *Registry, w http.ResponseWriter, r *http.Request, name string) (Provider, bool) {
+	provider, err := registry.Get(r.Context(), name)
+	if err != nil {
+		if errors.Is(err, ErrUnknownProvider) {
+			util.JsonResponse(w, "Unknown identity provider", http.StatusNotFound, nil)
+			return nil, false
+		}
+		log.Println(err)
+		util.JsonResponse(w, "Identity provider is unavailable", http.StatusBadGateway, nil)
+		return nil, false
+	}
+
+	return provider, true
+}
@@ -0,0 +1,259 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/dev-xero/authentication-backend/authentication"
+	"github.com/dev-xero/authentication-backend/model"
+	"github.com/joho/godotenv"
+	"golang.org/x/oauth2"
+)
+
+// Provider name of GitHub identities
+const githubProvider = "github"
+
+/*
+GitHub provider struct, GitHub isn't an OpenID Connect provider so its REST API supplies the profile
+
+Fields:
+  - oauth2:     The authorization-code flow config
+  - apiBaseURL: The REST API base URL, https://api.github.com unless configured
+  - client:     The http client calling GitHub
+*/
+type GitHubProvider struct {
+	oauth2     oauth2.Config
+	apiBaseURL string
+	client     *http.Client
+}
+
+/*
+Creates the GitHub provider
+
+Params:
+  - config:     The authorization-code flow config, its endpoint must be set
+  - apiBaseURL: The REST API base URL
+  - client:     The http client calling GitHub, http.DefaultClient if nil
+
+Returns:
+  - A pointer to the GitHub provider
+*/
+func NewGitHubProvider(config oauth2.Config, apiBaseURL string, client *http.Client) *GitHubProvider {
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	return &GitHubProvider{
+		oauth2:     config,
+		apiBaseURL: strings.TrimSuffix(apiBaseURL, "/"),
+		client:     client,
+	}
+}
+
+/*
+Creates the GitHub provider configured through environment variables
+
+Objectives:
+  - Load the client credentials and redirect URL
+  - Load the web and API base URLs, so a local fake server can stand in for GitHub
+
+Params:
+  - No parameters
+
+Returns:
+  - A pointer to the GitHub provider, nil when GITHUB_CLIENT_ID isn't set so GitHub isn't registered
+  - An error if the environment can't be loaded
+*/
+func NewGitHubProviderFromEnvironment() (*GitHubProvider, error) {
+	// Load environment variables from .env file in development
+	if env := os.Getenv("ENVIRONMENT"); env != "production" {
+		err := godotenv.Load()
+		if err != nil {
+			return nil, fmt.Errorf("[FAIL]: could not load environment variables: %w", err)
+		}
+	}
+
+	clientID := os.Getenv("GITHUB_CLIENT_ID")
+	if clientID == "" {
+		return nil, nil
+	}
+
+	webBaseURL := strings.TrimSuffix(os.Getenv("GITHUB_BASE_URL"), "/")
+	if webBaseURL == "" {
+		webBaseURL = "https://github.com"
+	}
+
+	apiBaseURL := os.Getenv("GITHUB_API_BASE_URL")
+	if apiBaseURL == "" {
+		apiBaseURL = "https://api.github.com"
+	}
+
+	config := oauth2.Config{
+		ClientID:     clientID,
+		ClientSecret: os.Getenv("GITHUB_CLIENT_SECRET"),
+		RedirectURL:  os.Getenv("GITHUB_OAUTH_REDIRECT_URL"),
+		Scopes:       []string{"read:user", "user:email"},
+		Endpoint: oauth2.Endpoint{
+			AuthURL:   webBaseURL + "/login/oauth/authorize",
+			TokenURL:  webBaseURL + "/login/oauth/access_token",
+			AuthStyle: oauth2.AuthStyleInParams,
+		},
+	}
+
+	return NewGitHubProvider(config, apiBaseURL, nil), nil
+}
+
+// Returns the provider name
+func (provider *GitHubProvider) Name() string {
+	return githubProvider
+}
+
+/*
+Returns the URL to redirect the user to for authentication
+
+Params:
+  - state: The OAuth state, its PKCE challenge is sent
+
+Returns:
+  - The authorization URL
+*/
+func (provider *GitHubProvider) AuthCodeURL(state authentication.OAuthState) string {
+	return provider.oauth2.AuthCodeURL(state.ID, oauth2.S256ChallengeOption(state.Verifier))
+}
+
+/*
+Exchanges an authorization code for an access token
+
+Params:
+  - ctx:   Method context
+  - code:  The authorization code
+  - state: The OAuth state, its PKCE verifier is sent
+
+Returns:
+  - The GitHub token
+  - An error if the exchange fails
+*/
+func (provider *GitHubProvider) Exchange(ctx context.Context, code string, state authentication.OAuthState) (*oauth2.Token, error) {
+	token, err := provider.oauth2.Exchange(context.WithValue(ctx, oauth2.HTTPClient, provider.client), code, oauth2.VerifierOption(state.Verifier))
+	if err != nil {
+		return nil, fmt.Errorf("[FAIL]: could not exchange code: %w", err)
+	}
+
+	return token, nil
+}
+
+/*
+Fetches the profile of the user a token was issued for
+
+Objectives:
+  - Fetch the user from /user and their addresses from /user/emails
+  - Use the primary address when GitHub verified it
+
+Params:
+  - ctx:   Method context
+  - token: The GitHub token
+  - state: The OAuth state, unused since GitHub doesn't issue id tokens
+
+Returns:
+  - The external profile of the user, unverified when the primary address isn't verified
+  - An error if any step fails
+*/
+func (provider *GitHubProvider) Profile(ctx context.Context, token *oauth2.Token, state authentication.OAuthState) (model.ExternalProfile, error) {
+	var user struct {
+		ID    int64  `json:"id"`
+		Login string `json:"login"`
+	}
+	if err := provider.get(ctx, token, "/user", &user); err != nil {
+		return model.ExternalProfile{}, err
+	}
+
+	var emails []struct {
+		Email    string `json:"email"`
+		Primary  bool   `json:"primary"`
+		Verified bool   `json:"verified"`
+	}
+	if err := provider.get(ctx, token, "/user/emails", &emails); err != nil {
+		return model.ExternalProfile{}, err
+	}
+
+	if user.ID == 0 {
+		return model.ExternalProfile{}, fmt.Errorf("[FAIL]: github user has no id")
+	}
+
+	profile := model.ExternalProfile{
+		Subject: strconv.FormatInt(user.ID, 10),
+		Name:    user.Login,
+	}
+
+	for _, email := range emails {
+		if email.Primary {
+			profile.Email = email.Email
+			profile.EmailVerified = email.Verified
+			break
+		}
+	}
+
+	if profile.Email == "" {
+		return model.ExternalProfile{}, fmt.Errorf("[FAIL]: github user has no primary email")
+	}
+
+	return profile, nil
+}
+
+/*
+Returns a token source refreshing a GitHub token, GitHub only issues refresh tokens when token expiration is enabled
+
+Params:
+  - ctx:   Method context, used for refreshes
+  - token: The GitHub token
+
+Returns:
+  - The token source
+*/
+func (provider *GitHubProvider) TokenSource(ctx context.Context, token *oauth2.Token) oauth2.TokenSource {
+	return provider.oauth2.TokenSource(context.WithValue(ctx, oauth2.HTTPClient, provider.client), token)
+}
+
+/*
+Calls a GitHub REST API endpoint with the access token
+
+Params:
+  - ctx:    Method context
+  - token:  The access token, sent in the authorization header
+  - path:   The endpoint path
+  - target: The value the JSON response is decoded into
+
+Returns:
+  - An error if the request fails or GitHub doesn't respond with 200
+*/
+func (provider *GitHubProvider) get(ctx context.Context, token *oauth2.Token, path string, target interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, provider.apiBaseURL+path, nil)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Accept", "application/vnd.github+json")
+	req.Header.Set("X-GitHub-Api-Version", "2022-11-28")
+	token.SetAuthHeader(req)
+
+	res, err := provider.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("[FAIL]: could not call github %s: %w", path, err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("[FAIL]: github %s responded with status %d", path, res.StatusCode)
+	}
+
+	if err := json.NewDecoder(res.Body).Decode(target); err != nil {
+		return fmt.Errorf("[FAIL]: could not decode github %s response: %w", path, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,225 @@
+package handler
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/dev-xero/authentication-backend/authentication"
+	"github.com/dev-xero/authentication-backend/internal/testutil"
+	"golang.org/x/oauth2"
+)
+
+const testGitHubToken = "gho_test"
+
+// Address list entry of the fake /user/emails endpoint
+type testGitHubEmail struct {
+	Email    string `json:"email"`
+	Primary  bool   `json:"primary"`
+	Verified bool   `json:"verified"`
+}
+
+// A fake GitHub serving the token endpoint and the REST API on one URL, the API responds with status unless it is 200
+type testGitHub struct {
+	server    *httptest.Server
+	userID    int64
+	emails    []testGitHubEmail
+	status    int
+	tokenForm url.Values
+}
+
+func newTestGitHub(t *testing.T) *testGitHub {
+	t.Helper()
+
+	github := &testGitHub{userID: 583231, status: http.StatusOK}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/login/oauth/access_token", func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		github.tokenForm = r.PostForm
+
+		testutil.WriteJSON(w, map[string]string{"access_token": testGitHubToken, "token_type": "bearer", "scope": "read:user,user:email"})
+	})
+	api := func(body func() interface{}) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Authorization") != "Bearer "+testGitHubToken {
+				http.Error(w, "Requires authentication", http.StatusUnauthorized)
+				return
+			}
+			if github.status != http.StatusOK {
+				http.Error(w, "Server error", github.status)
+				return
+			}
+
+			testutil.WriteJSON(w, body())
+		}
+	}
+	mux.HandleFunc("/user", api(func() interface{} {
+		return map[string]interface{}{"id": github.userID, "login": "octocat"}
+	}))
+	mux.HandleFunc("/user/emails", api(func() interface{} {
+		return github.emails
+	}))
+
+	github.server = httptest.NewServer(mux)
+	t.Cleanup(github.server.Close)
+
+	return github
+}
+
+// Creates a provider calling the fake server, as GITHUB_BASE_URL and GITHUB_API_BASE_URL configure it
+func (github *testGitHub) provider() *GitHubProvider {
+	return NewGitHubProvider(oauth2.Config{
+		ClientID:     "github-client",
+		ClientSecret: "github-secret",
+		RedirectURL:  "https://app.example.com/auth/oauth/github/callback",
+		Scopes:       []string{"read:user", "user:email"},
+		Endpoint: oauth2.Endpoint{
+			AuthURL:   github.server.URL + "/login/oauth/authorize",
+			TokenURL:  github.server.URL + "/login/oauth/access_token",
+			AuthStyle: oauth2.AuthStyleInParams,
+		},
+	}, github.server.URL+"/", github.server.Client())
+}
+
+func TestGitHubAuthCodeURLSendsPKCEChallenge(t *testing.T) {
+	github := newTestGitHub(t)
+	state := authentication.OAuthState{Verifier: oauth2.GenerateVerifier()}
+	state.ID = "state-1"
+
+	authURL, err := url.Parse(github.provider().AuthCodeURL(state))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sum := sha256.Sum256([]byte(state.Verifier))
+	query := authURL.Query()
+	if query.Get("state") != "state-1" || query.Get("code_challenge_method") != "S256" || query.Get("code_challenge") != base64.RawURLEncoding.EncodeToString(sum[:]) {
+		t.Fatalf("unexpected authorization request %v", query)
+	}
+	if query.Get("scope") != "read:user user:email" {
+		t.Errorf("scope = %q", query.Get("scope"))
+	}
+}
+
+func TestGitHubExchangeSendsVerifier(t *testing.T) {
+	github := newTestGitHub(t)
+	state := authentication.OAuthState{Verifier: oauth2.GenerateVerifier()}
+
+	token, err := github.provider().Exchange(context.Background(), "code-1", state)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if token.AccessToken != testGitHubToken {
+		t.Errorf("access token = %q", token.AccessToken)
+	}
+	if github.tokenForm.Get("code") != "code-1" || github.tokenForm.Get("code_verifier") != state.Verifier || github.tokenForm.Get("client_secret") != "github-secret" {
+		t.Errorf("unexpected token request %v", github.tokenForm)
+	}
+}
+
+func TestGitHubProfileUsesPrimaryEmail(t *testing.T) {
+	tests := []struct {
+		name     string
+		emails   []testGitHubEmail
+		email    string
+		verified bool
+	}{
+		{
+			name: "primary verified",
+			emails: []testGitHubEmail{
+				{Email: "octocat@users.noreply.github.com", Verified: true},
+				{Email: "octocat@example.com", Primary: true, Verified: true},
+			},
+			email:    "octocat@example.com",
+			verified: true,
+		},
+		{
+			// A verified secondary address doesn't stand in, the profile is rejected as unverified
+			name: "primary unverified",
+			emails: []testGitHubEmail{
+				{Email: "octocat@example.org", Verified: true},
+				{Email: "octocat@example.com", Primary: true},
+			},
+			email: "octocat@example.com",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			github := newTestGitHub(t)
+			github.emails = test.emails
+
+			profile, err := github.provider().Profile(context.Background(), &oauth2.Token{AccessToken: testGitHubToken}, authentication.OAuthState{})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if profile.Subject != "583231" || profile.Name != "octocat" {
+				t.Errorf("subject = %q, name = %q", profile.Subject, profile.Name)
+			}
+			if profile.Email != test.email || profile.EmailVerified != test.verified {
+				t.Errorf("email = %q verified %v, want %q verified %v", profile.Email, profile.EmailVerified, test.email, test.verified)
+			}
+		})
+	}
+}
+
+func TestGitHubProfileFailures(t *testing.T) {
+	tests := []struct {
+		name   string
+		setup  func(github *testGitHub)
+		reason string
+	}{
+		{
+			name: "no primary email",
+			setup: func(github *testGitHub) {
+				github.emails = []testGitHubEmail{{Email: "octocat@example.org", Verified: true}}
+			},
+			reason: "no primary email",
+		},
+		{
+			name: "no emails",
+			setup: func(github *testGitHub) {
+				github.emails = nil
+			},
+			reason: "no primary email",
+		},
+		{
+			name: "no user id",
+			setup: func(github *testGitHub) {
+				github.userID = 0
+				github.emails = []testGitHubEmail{{Email: "octocat@example.com", Primary: true, Verified: true}}
+			},
+			reason: "no id",
+		},
+		{
+			name: "api error",
+			setup: func(github *testGitHub) {
+				github.status = http.StatusServiceUnavailable
+			},
+			reason: "status 503",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			github := newTestGitHub(t)
+			test.setup(github)
+
+			_, err := github.provider().Profile(context.Background(), &oauth2.Token{AccessToken: testGitHubToken}, authentication.OAuthState{})
+			if err == nil || !strings.Contains(err.Error(), test.reason) {
+				t.Fatalf("expected an error about %q, got %v", test.reason, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,350 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/dev-xero/authentication-backend/authentication"
+	mfaHandler "github.com/dev-xero/authentication-backend/handler/auth/mfa"
+	shared "github.com/dev-xero/authentication-backend/handler/auth/shared"
+	"github.com/dev-xero/authentication-backend/mfa"
+	"github.com/dev-xero/authentication-backend/model"
+	"github.com/dev-xero/authentication-backend/providertoken"
+	repository "github.com/dev-xero/authentication-backend/repository/user"
+	"github.com/dev-xero/authentication-backend/service"
+	"github.com/dev-xero/authentication-backend/util"
+	"github.com/google/uuid"
+	"golang.org/x/oauth2"
+)
+
+/*
+Completes the callback of an external identity provider
+
+Objectives:
+  - Link the identity to the signed-in user for link flows
+  - Sign-in the account linked to the identity
+  - Create an account for new identities with a verified email
+
+Params:
+  - dbService:  The database service provider
+  - smsService: The SMS service provider
+  - policy:     The MFA enforcement policy
+  - tokens:     The provider token store, nil when tokens aren't stored
+  - w:          A http response writer
+  - r:          A pointer to a http request object
+  - provider:   The provider name, e.g. "google"
+  - profile:    The profile the provider authenticated
+  - token:      The token the provider issued
+  - state:      The verified state of the flow, holding the link user and return_to URL
+
+Returns:
+  - No return value
+*/
+func completeExternalSignIn(dbService *service.DatabaseProvider, smsService *service.SMSProvider, policy *mfa.Policy, tokens *providertoken.Store, w http.ResponseWriter, r *http.Request, provider string, profile model.ExternalProfile, token *oauth2.Token, state authentication.OAuthState) {
+	title := providerTitle(provider)
+	returnTo := state.ReturnTo
+
+	// Link flows attach the identity to the signed-in user instead of signing-in
+	if state.LinkUserID != "" {
+		linkExternalIdentity(dbService, tokens, w, r, provider, profile, token, state)
+		return
+	}
+
+	// Returning identities sign-in to their linked account
+	user, err := dbService.Repo.GetUserByIdentity(r.Context(), provider, profile.Subject)
+	if err == nil {
+		saveProviderToken(r.Context(), tokens, provider, profile, token)
+		finishExternalSignIn(dbService, smsService, policy, w, r, user, returnTo, resultSignedIn, fmt.Sprintf("Successfully signed-in with %s", title))
+		return
+	}
+
+	if !errors.Is(err, repository.ErrIdentityNotFound) {
+		log.Println(err)
+		msg := fmt.Sprintf("Internal server error, could not check %s identity", title)
+		respondOAuthError(w, r, returnTo, errorServerError, msg, http.StatusInternalServerError)
+		return
+	}
+
+	if !profile.EmailVerified {
+		respondOAuthError(w, r, returnTo, errorEmailUnverified, fmt.Sprintf("%s account email is not verified", title), http.StatusForbidden)
+		return
+	}
+
+	identity := model.UserIdentity{
+		ID:       uuid.New(),
+		Provider: provider,
+		Subject:  profile.Subject,
+		Email:    profile.Email,
+	}
+
+	conflictMsg := fmt.Sprintf("An account already uses that email, sign-in to it to use %s sign-in", title)
+
+	// An account already using the email may predate stored identities
+	existing, err := dbService.Repo.GetUserByEmail(r.Context(), profile.Email)
+	if err == nil {
+		if !isLegacyGoogleAccount(provider, existing, profile) {
+			respondOAuthError(w, r, returnTo, errorAccountExists, conflictMsg, http.StatusConflict)
+			return
+		}
+
+		identity.UserID = existing.ID
+		event := shared.NewAuditEvent(r, existing.ID, "identity.linked", provider)
+		if err := dbService.Repo.ClaimLegacyIdentity(r.Context(), identity, event); err != nil {
+			log.Println(err)
+			respondOAuthError(w, r, returnTo, errorServerError, fmt.Sprintf("Failed to link %s identity", title), http.StatusInternalServerError)
+			return
+		}
+
+		saveProviderToken(r.Context(), tokens, provider, profile, token)
+		finishExternalSignIn(dbService, smsService, policy, w, r, existing, returnTo, resultSignedIn, fmt.Sprintf("Successfully signed-in with %s", title))
+		return
+	}
+
+	username, err := availableUsername(r.Context(), dbService, profile.Name)
+	if err != nil {
+		log.Println(err)
+		respondOAuthError(w, r, returnTo, errorServerError, "Failed to create new user", http.StatusInternalServerError)
+		return
+	}
+
+	// Accounts created with a provider have no password until the user sets one
+	newUser := model.User{
+		ID:       uuid.New(),
+		Username: username,
+		Email:    profile.Email,
+	}
+	identity.UserID = newUser.ID
+
+	event := shared.NewAuditEvent(r, newUser.ID, "identity.linked", provider)
+	if err := dbService.Repo.CreateUserWithIdentity(r.Context(), newUser, identity, event); err != nil {
+		log.Println(err)
+		if errors.Is(err, repository.ErrEmailTaken) {
+			respondOAuthError(w, r, returnTo, errorAccountExists, conflictMsg, http.StatusConflict)
+			return
+		}
+		respondOAuthError(w, r, returnTo, errorServerError, "Failed to create new user", http.StatusInternalServerError)
+		return
+	}
+
+	newUser.Role = "user"
+	saveProviderToken(r.Context(), tokens, provider, profile, token)
+	finishExternalSignIn(dbService, smsService, policy, w, r, newUser, returnTo, resultSignedUp, fmt.Sprintf("Successfully signed-up with %s", title))
+}
+
+/*
+Signs-in the user of an external identity
+
+Objectives:
+  - Respond with JSON when the flow has no return_to URL
+  - Otherwise set the token or mfa cookie and redirect with the result code
+
+Params:
+  - dbService:  The database service provider
+  - smsService: The SMS service provider
+  - policy:     The MFA enforcement policy
+  - w:          A http response writer
+  - r:          A pointer to a http request object
+  - user:       The user to sign-in
+  - returnTo:   The allowed return_to URL of the flow, may be empty
+  - result:     The result code, signed_in or signed_up
+  - msg:        The JSON response message
+
+Returns:
+  - No return value
+*/
+func finishExternalSignIn(dbService *service.DatabaseProvider, smsService *service.SMSProvider, policy *mfa.Policy, w http.ResponseWriter, r *http.Request, user model.User, returnTo string, result string, msg string) {
+	if returnTo == "" {
+		mfaHandler.CompleteSignIn(dbService, smsService, policy, w, r, user, msg)
+		return
+	}
+
+	signIn, err := mfaHandler.BeginSignIn(dbService, smsService, policy, w, r, user)
+	if err != nil {
+		log.Println(err)
+		redirectToReturnTo(w, r, returnTo, "error", errorServerError)
+		return
+	}
+
+	if signIn.Challenge != nil {
+		result = resultMFARequired
+	}
+	redirectToReturnTo(w, r, returnTo, "result", result)
+}
+
+/*
+Ends a flow with an error, redirecting with its code when the flow has a return_to URL
+
+Params:
+  - w:        A http response writer
+  - r:        A pointer to a http request object
+  - returnTo: The allowed return_to URL of the flow, may be empty
+  - code:     The machine-readable error code
+  - msg:      The JSON response message
+  - status:   The JSON response status
+
+Returns:
+  - No return value
+*/
+func respondOAuthError(w http.ResponseWriter, r *http.Request, returnTo string, code string, msg string, status int) {
+	if returnTo != "" {
+		redirectToReturnTo(w, r, returnTo, "error", code)
+		return
+	}
+	util.JsonResponse(w, msg, status, nil)
+}
+
+/*
+Links an external identity to the signed-in user
+
+Params:
+  - dbService: The database service provider
+  - tokens:    The provider token store, nil when tokens aren't stored
+  - w:         A http response writer
+  - r:         A pointer to a http request object
+  - provider:  The provider name
+  - profile:   The profile the provider authenticated
+  - token:     The token the provider issued
+  - state:     The verified state of the flow, holding the user it was started by
+
+Returns:
+  - No return value
+*/
+func linkExternalIdentity(dbService *service.DatabaseProvider, tokens *providertoken.Store, w http.ResponseWriter, r *http.Request, provider string, profile model.ExternalProfile, token *oauth2.Token, state authentication.OAuthState) {
+	title := providerTitle(provider)
+
+	// The flow must be completed by the session that started it
+	userID, signedIn := shared.UserIDFromTokenCookie(r)
+
+	// Lax session cookies aren't sent with cross-site form posts, the signed state cookie alone binds those flows to the user that started them
+	if !signedIn && state.FormPost {
+		parsed, err := uuid.Parse(state.LinkUserID)
+		userID, signedIn = parsed, err == nil
+	}
+
+	if !signedIn || userID.String() != state.LinkUserID {
+		msg := fmt.Sprintf("Sign-in before linking a %s account", title)
+		respondOAuthError(w, r, state.ReturnTo, errorUnauthorized, msg, http.StatusUnauthorized)
+		return
+	}
+
+	identity := model.UserIdentity{
+		ID:       uuid.New(),
+		UserID:   userID,
+		Provider: provider,
+		Subject:  profile.Subject,
+		Email:    profile.Email,
+	}
+
+	event := shared.NewAuditEvent(r, userID, "identity.linked", provider)
+	if err := dbService.Repo.LinkIdentity(r.Context(), identity, event); err != nil {
+		if errors.Is(err, repository.ErrIdentityLinked) {
+			msg := fmt.Sprintf("That %s account is already linked to an account", title)
+			respondOAuthError(w, r, state.ReturnTo, errorIdentityLinked, msg, http.StatusConflict)
+			return
+		}
+		log.Println(err)
+		respondOAuthError(w, r, state.ReturnTo, errorServerError, fmt.Sprintf("Failed to link %s account", title), http.StatusInternalServerError)
+		return
+	}
+
+	saveProviderToken(r.Context(), tokens, provider, profile, token)
+
+	if state.ReturnTo != "" {
+		redirectToReturnTo(w, r, state.ReturnTo, "result", resultLinked)
+		return
+	}
+
+	util.JsonResponse(w, fmt.Sprintf("Successfully linked %s account", title), http.StatusOK, identity)
+}
+
+/*
+Stores the token a provider issued for an identity, a failure doesn't fail the sign-in
+
+Params:
+  - ctx:      Method context
+  - tokens:   The provider token store, nil when tokens aren't stored
+  - provider: The provider name
+  - profile:  The profile the provider authenticated
+  - token:    The token the provider issued
+
+Returns:
+  - No return value
+*/
+func saveProviderToken(ctx context.Context, tokens *providertoken.Store, provider string, profile model.ExternalProfile, token *oauth2.Token) {
+	if err := tokens.Save(ctx, provider, profile.Subject, token); err != nil {
+		log.Println(err)
+	}
+}
+
+/*
+Returns the name of a provider shown in messages
+
+Params:
+  - provider: The provider name, e.g. "google" or "saml:acme"
+
+Returns:
+  - The display name, e.g. "Google" or "SAML (acme)"
+*/
+func providerTitle(provider string) string {
+	if connection, ok := strings.CutPrefix(provider, "saml:"); ok {
+		return fmt.Sprintf("SAML (%s)", connection)
+	}
+	return util.CapitalizeFirstLetter(provider)
+}
+
+/*
+Checks whether an account was created by Google sign-in before identities were stored, those accounts stored the hashed Google id as their password
+
+Params:
+  - provider: The provider name
+  - user:     The account using the profile email
+  - profile:  The profile the provider authenticated
+
+Returns:
+  - True if the account is the legacy account of the Google profile
+*/
+func isLegacyGoogleAccount(provider string, user model.User, profile model.ExternalProfile) bool {
+	return provider == "google" && user.Password != "" && util.CompareWithHash([]byte(user.Password), profile.Subject)
+}
+
+/*
+Returns a username not taken by another account, derived from the provider name
+
+Params:
+  - ctx:       Method context
+  - dbService: The database service provider
+  - name:      The name reported by the provider
+
+Returns:
+  - The available username
+  - An error if no username could be found
+*/
+func availableUsername(ctx context.Context, dbService *service.DatabaseProvider, name string) (string, error) {
+	base := strings.TrimSpace(name)
+	if base == "" {
+		base = "user"
+	}
+
+	candidate := base
+	for attempt := 0; attempt < 5; attempt++ {
+		taken, err := dbService.Repo.UserExists(ctx, "", candidate)
+		if err != nil {
+			return "", err
+		}
+		if !taken {
+			return candidate, nil
+		}
+
+		suffix, err := util.GenerateRandomToken(3)
+		if err != nil {
+			return "", err
+		}
+		candidate = fmt.Sprintf("%s-%s", base, strings.ToLower(suffix))
+	}
+
+	return "", fmt.Errorf("[FAIL]: no available username for %q", base)
+}
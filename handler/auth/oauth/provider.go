@@ -0,0 +1,234 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/dev-xero/authentication-backend/authentication"
+	"github.com/dev-xero/authentication-backend/model"
+	"github.com/dev-xero/authentication-backend/oidc"
+	"github.com/dev-xero/authentication-backend/providertoken"
+	"github.com/joho/godotenv"
+	"golang.org/x/oauth2"
+)
+
+// Returned when a provider name isn't registered or enabled
+var ErrUnknownProvider = errors.New("unknown identity provider")
+
+/*
+Provider interface, an external identity provider users sign-in with
+
+Methods:
+  - Name:        Returns the provider name used in routes and stored identities
+  - AuthCodeURL: Returns the URL to redirect the user to, with the PKCE challenge and nonce of the state
+  - Exchange:    Exchanges the authorization code with the PKCE verifier of the state
+  - Profile:     Fetches the profile of the user the token was issued for
+  - TokenSource: Returns a token source refreshing the token once it expires
+*/
+type Provider interface {
+	Name() string
+	AuthCodeURL(state authentication.OAuthState) string
+	Exchange(ctx context.Context, code string, state authentication.OAuthState) (*oauth2.Token, error)
+	Profile(ctx context.Context, token *oauth2.Token, state authentication.OAuthState) (model.ExternalProfile, error)
+	TokenSource(ctx context.Context, token *oauth2.Token) oauth2.TokenSource
+}
+
+/*
+Provider registry struct, the providers users can sign-in with
+
+Fields:
+  - providers: The registered providers by name
+  - oidc:      The configured OpenID Connect providers, discovered on first use
+  - enabled:   The enabled provider names, every provider is enabled when nil
+*/
+type Registry struct {
+	providers map[string]Provider
+	oidc      *oidc.Providers
+	enabled   map[string]bool
+}
+
+/*
+Creates a provider registry
+
+Params:
+  - oidcProviders: The configured OpenID Connect providers, may be nil
+  - providers:     Other providers, e.g. GitHub
+
+Returns:
+  - A pointer to the registry
+*/
+func NewRegistry(oidcProviders *oidc.Providers, providers ...Provider) *Registry {
+	registry := &Registry{
+		providers: make(map[string]Provider, len(providers)),
+		oidc:      oidcProviders,
+	}
+
+	for _, provider := range providers {
+		registry.Register(provider)
+	}
+
+	return registry
+}
+
+/*
+Creates the provider registry configured through environment variables
+
+Objectives:
+  - Register the OpenID Connect providers, GitHub and Apple when they are configured
+  - Only enable the providers named in OAUTH_PROVIDERS when it is set
+
+Params:
+  - No parameters
+
+Returns:
+  - A pointer to the registry
+  - An error if the environment can't be loaded or a provider is misconfigured
+*/
+func NewRegistryFromEnvironment() (*Registry, error) {
+	// Load environment variables from .env file in development
+	if env := os.Getenv("ENVIRONMENT"); env != "production" {
+		err := godotenv.Load()
+		if err != nil {
+			return nil, fmt.Errorf("[FAIL]: could not load environment variables: %w", err)
+		}
+	}
+
+	oidcProviders, err := oidc.NewProvidersFromEnvironment()
+	if err != nil {
+		return nil, err
+	}
+
+	registry := NewRegistry(oidcProviders)
+
+	github, err := NewGitHubProviderFromEnvironment()
+	if err != nil {
+		return nil, err
+	}
+	if github != nil {
+		registry.Register(github)
+	}
+
+	apple, err := NewAppleProviderFromEnvironment()
+	if err != nil {
+		return nil, err
+	}
+	if apple != nil {
+		registry.Register(apple)
+	}
+
+	var enabled []string
+	for _, name := range strings.Split(os.Getenv("OAUTH_PROVIDERS"), ",") {
+		if name = strings.ToLower(strings.TrimSpace(name)); name != "" {
+			enabled = append(enabled, name)
+		}
+	}
+	if len(enabled) > 0 {
+		registry.Enable(enabled...)
+	}
+
+	return registry, nil
+}
+
+/*
+Registers a provider, replacing any provider with the same name
+
+Params:
+  - provider: The provider
+
+Returns:
+  - No return value
+*/
+func (registry *Registry) Register(provider Provider) {
+	registry.providers[provider.Name()] = provider
+}
+
+/*
+Only enables the named providers, the others respond as unknown
+
+Params:
+  - names: The enabled provider names
+
+Returns:
+  - No return value
+*/
+func (registry *Registry) Enable(names ...string) {
+	registry.enabled = make(map[string]bool, len(names))
+	for _, name := range names {
+		registry.enabled[name] = true
+	}
+}
+
+/*
+Returns an enabled provider
+
+Params:
+  - ctx:  Method context
+  - name: The provider name
+
+Returns:
+  - The provider
+  - ErrUnknownProvider if no enabled provider has the name, or an error if discovery fails
+*/
+func (registry *Registry) Get(ctx context.Context, name string) (Provider, error) {
+	if registry == nil || (registry.enabled != nil && !registry.enabled[name]) {
+		return nil, ErrUnknownProvider
+	}
+
+	if provider, ok := registry.providers[name]; ok {
+		return provider, nil
+	}
+
+	if registry.oidc == nil {
+		return nil, ErrUnknownProvider
+	}
+
+	provider, err := registry.oidc.Get(ctx, name)
+	if err != nil {
+		if errors.Is(err, oidc.ErrUnknownProvider) {
+			return nil, ErrUnknownProvider
+		}
+		return nil, err
+	}
+
+	return oidcProvider{provider}, nil
+}
+
+/*
+Returns the token refresher of an enabled provider, for provider token storage
+
+Params:
+  - ctx:  Method context
+  - name: The provider name
+
+Returns:
+  - The refresher
+  - An error if the provider is unknown or unavailable
+*/
+func (registry *Registry) Refresher(ctx context.Context, name string) (providertoken.Refresher, error) {
+	provider, err := registry.Get(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	return provider, nil
+}
+
+// Adapts an OpenID Connect provider, its profile comes from the verified id token
+type oidcProvider struct {
+	*oidc.Provider
+}
+
+func (provider oidcProvider) AuthCodeURL(state authentication.OAuthState) string {
+	return provider.Provider.AuthCodeURL(state.ID, state.Nonce, oauth2.S256ChallengeOption(state.Verifier))
+}
+
+func (provider oidcProvider) Exchange(ctx context.Context, code string, state authentication.OAuthState) (*oauth2.Token, error) {
+	return provider.Provider.Exchange(ctx, code, oauth2.VerifierOption(state.Verifier))
+}
+
+func (provider oidcProvider) Profile(ctx context.Context, token *oauth2.Token, state authentication.OAuthState) (model.ExternalProfile, error) {
+	return provider.Provider.TokenProfile(ctx, token, state.Nonce)
+}
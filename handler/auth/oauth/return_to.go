@@ -0,0 +1,180 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/joho/godotenv"
+)
+
+// Machine-readable codes added to the return_to URL, or to the failure route, when a flow ends
+const (
+	resultSignedIn    = "signed_in"
+	resultSignedUp    = "signed_up"
+	resultLinked      = "linked"
+	resultMFARequired = "mfa_required"
+
+	errorAccessDenied     = "access_denied"
+	errorInvalidState     = "invalid_state"
+	errorInvalidAssertion = "invalid_assertion"
+	errorExchangeFailed   = "exchange_failed"
+	errorEmailUnverified  = "email_unverified"
+	errorAccountExists    = "account_exists"
+	errorIdentityLinked   = "identity_linked"
+	errorUnauthorized     = "unauthorized"
+	errorServerError      = "server_error"
+)
+
+/*
+Return-to allowlist struct, the URLs an OAuth flow may redirect to once it ends
+
+Fields:
+  - entries: The allowed origins, each with the path prefix it allows
+*/
+type ReturnToAllowlist struct {
+	entries []*url.URL
+}
+
+/*
+Creates a return-to allowlist
+
+Objectives:
+  - Parse every entry, an absolute http or https URL whose path is the allowed prefix
+  - A path ending with "/" allows everything below it, otherwise only that exact path
+
+Params:
+  - entries: The allowed URLs, e.g. "https://app.example.com/" or "https://app.example.com/signed-in"
+
+Returns:
+  - A pointer to the allowlist
+  - An error if an entry isn't an absolute http or https URL
+*/
+func NewReturnToAllowlist(entries ...string) (*ReturnToAllowlist, error) {
+	allowlist := &ReturnToAllowlist{}
+
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		parsed, err := url.Parse(entry)
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" || parsed.User != nil {
+			return nil, fmt.Errorf("[FAIL]: invalid return_to allowlist entry %q", entry)
+		}
+
+		if parsed.Path == "" {
+			parsed.Path = "/"
+		}
+		allowlist.entries = append(allowlist.entries, parsed)
+	}
+
+	return allowlist, nil
+}
+
+/*
+Creates the return-to allowlist configured through environment variables
+
+Params:
+  - No parameters
+
+Returns:
+  - A pointer to the allowlist, empty when OAUTH_RETURN_TO_ALLOWLIST isn't set
+  - An error if the environment can't be loaded or an entry is invalid
+*/
+func NewReturnToAllowlistFromEnvironment() (*ReturnToAllowlist, error) {
+	// Load environment variables from .env file in development
+	if env := os.Getenv("ENVIRONMENT"); env != "production" {
+		err := godotenv.Load()
+		if err != nil {
+			return nil, fmt.Errorf("[FAIL]: could not load environment variables: %w", err)
+		}
+	}
+
+	return NewReturnToAllowlist(strings.Split(os.Getenv("OAUTH_RETURN_TO_ALLOWLIST"), ",")...)
+}
+
+/*
+Checks a return_to URL against the allowlist
+
+Objectives:
+  - Reject relative URLs, user info, backslashes and control characters browsers may read differently
+  - Match the scheme and host exactly, and the path after resolving dot segments
+
+Params:
+  - raw: The return_to URL
+
+Returns:
+  - The normalized URL to redirect to, without a fragment
+  - False if the URL isn't allowed
+*/
+func (allowlist *ReturnToAllowlist) Allows(raw string) (string, bool) {
+	if allowlist == nil || strings.ContainsAny(raw, "\\") {
+		return "", false
+	}
+	for _, c := range raw {
+		if c < 0x20 || c == 0x7f {
+			return "", false
+		}
+	}
+
+	parsed, err := url.Parse(raw)
+	if err != nil || parsed.User != nil || parsed.Opaque != "" {
+		return "", false
+	}
+
+	cleaned := path.Clean("/" + parsed.Path)
+	if strings.HasSuffix(parsed.Path, "/") && cleaned != "/" {
+		cleaned += "/"
+	}
+
+	for _, entry := range allowlist.entries {
+		if parsed.Scheme != entry.Scheme || !strings.EqualFold(parsed.Host, entry.Host) {
+			continue
+		}
+
+		allowed := cleaned == entry.Path
+		if strings.HasSuffix(entry.Path, "/") {
+			allowed = strings.HasPrefix(cleaned, entry.Path) || cleaned+"/" == entry.Path
+		}
+		if !allowed {
+			continue
+		}
+
+		normalized := url.URL{Scheme: entry.Scheme, Host: entry.Host, Path: cleaned, RawQuery: parsed.RawQuery}
+		return normalized.String(), true
+	}
+
+	return "", false
+}
+
+/*
+Redirects to the return_to URL of a flow with a machine-readable code
+
+Params:
+  - w:        A http response writer
+  - r:        A pointer to a http request object
+  - returnTo: The allowed return_to URL
+  - key:      The query parameter, "result" or "error"
+  - code:     The machine-readable code
+
+Returns:
+  - No return value
+*/
+func redirectToReturnTo(w http.ResponseWriter, r *http.Request, returnTo string, key string, code string) {
+	target, err := url.Parse(returnTo)
+	if err != nil {
+		http.Redirect(w, r, "failure?error="+errorServerError, http.StatusSeeOther)
+		return
+	}
+
+	query := target.Query()
+	query.Set(key, code)
+	target.RawQuery = query.Encode()
+
+	http.Redirect(w, r, target.String(), http.StatusSeeOther)
+}
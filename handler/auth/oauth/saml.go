@@ -0,0 +1,207 @@
+package handler
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/dev-xero/authentication-backend/authentication"
+	"github.com/dev-xero/authentication-backend/mfa"
+	repository "github.com/dev-xero/authentication-backend/repository/user"
+	"github.com/dev-xero/authentication-backend/saml"
+	"github.com/dev-xero/authentication-backend/service"
+	"github.com/dev-xero/authentication-backend/util"
+)
+
+/*
+Serves the SP metadata of a SAML connection, imported into the IdP when the connection is set up
+
+Params:
+  - connections: The configured SAML connections
+  - w:           A http response writer
+  - r:           A pointer to a http request object
+  - name:        The connection name
+
+Returns:
+  - No return value
+*/
+func SAMLMetadata(connections *saml.Connections, w http.ResponseWriter, r *http.Request, name string) {
+	connection, ok := configuredConnection(connections, w, r, name)
+	if !ok {
+		return
+	}
+
+	metadata, err := connection.Metadata()
+	if err != nil {
+		log.Println(err)
+		util.JsonResponse(w, "Failed to generate SAML metadata", http.StatusInternalServerError, nil)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/samlmetadata+xml")
+	w.WriteHeader(http.StatusOK)
+	w.Write(metadata)
+}
+
+/*
+Starts a SAML sign-in
+
+Objectives:
+  - Check the return_to parameter against the allowlist
+  - Create a signed AuthnRequest and bind its id to the browser in a signed cookie
+  - Redirect to the IdP, or post the request when the IdP only supports the HTTP-POST binding
+
+Params:
+  - connections: The configured SAML connections
+  - allowlist:   The URLs return_to may point to
+  - w:           A http response writer
+  - r:           A pointer to a http request object
+  - name:        The connection name
+
+Returns:
+  - No return value
+*/
+func SAMLSignIn(connections *saml.Connections, allowlist *ReturnToAllowlist, w http.ResponseWriter, r *http.Request, name string) {
+	connection, ok := configuredConnection(connections, w, r, name)
+	if !ok {
+		return
+	}
+
+	var returnTo string
+	if raw := r.URL.Query().Get("return_to"); raw != "" {
+		allowed, ok := allowlist.Allows(raw)
+		if !ok {
+			util.JsonResponse(w, "return_to is not an allowed URL", http.StatusBadRequest, nil)
+			return
+		}
+		returnTo = allowed
+	}
+
+	request, err := connection.AuthnRequest("")
+	if err != nil {
+		log.Println(err)
+		util.JsonResponse(w, "Failed to start sign-in", http.StatusInternalServerError, nil)
+		return
+	}
+
+	claims := authentication.SAMLRequest{Connection: connection.Name(), ReturnTo: returnTo}
+	claims.ID = request.ID
+
+	token, err := authentication.CreateSAMLRequestToken(claims)
+	if err != nil {
+		log.Println(err)
+		util.JsonResponse(w, "Failed to start sign-in", http.StatusInternalServerError, nil)
+		return
+	}
+
+	cookie := util.CreateSAMLRequestCookie(token)
+	http.SetCookie(w, &cookie)
+
+	if request.RedirectURL != "" {
+		http.Redirect(w, r, request.RedirectURL, http.StatusFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	w.Write(request.PostForm)
+}
+
+/*
+Handles the response an IdP posts to the assertion consumer service
+
+Objectives:
+  - Expire the request cookie and verify the response answers the request it holds
+  - Verify the assertion signature, conditions and audience
+  - Record the assertion as used, so a replayed response fails on every server instance
+  - Sign-in the account linked to the NameID, or create one for emails the IdP is authoritative for
+
+Params:
+  - dbService:   The database service provider
+  - smsService:  The SMS service provider
+  - policy:      The MFA enforcement policy
+  - connections: The configured SAML connections
+  - w:           A http response writer
+  - r:           A pointer to a http request object
+  - name:        The connection name
+
+Returns:
+  - No return value
+*/
+func SAMLAssertionConsumer(dbService *service.DatabaseProvider, smsService *service.SMSProvider, policy *mfa.Policy, connections *saml.Connections, w http.ResponseWriter, r *http.Request, name string) {
+	connection, ok := configuredConnection(connections, w, r, name)
+	if !ok {
+		return
+	}
+
+	util.ExpireCookie(w, "samlrequest")
+
+	cookie, err := r.Cookie("samlrequest")
+	if err != nil {
+		failOAuth(w, r, "", errorInvalidState, "saml request cookie missing")
+		return
+	}
+
+	request, err := authentication.VerifySAMLRequestToken(cookie.Value)
+	if err != nil {
+		failOAuth(w, r, "", errorInvalidState, err.Error())
+		return
+	}
+
+	// The return_to URL is only trusted once the request it was signed into is verified
+	if request.Connection != connection.Name() {
+		failOAuth(w, r, "", errorInvalidState, "saml request was started for another connection")
+		return
+	}
+
+	assertion, err := connection.ParseResponse(r, request.ID)
+	if err != nil {
+		if errors.Is(err, saml.ErrIdPDenied) {
+			failOAuth(w, r, request.ReturnTo, errorAccessDenied, err.Error())
+			return
+		}
+		failOAuth(w, r, request.ReturnTo, errorInvalidAssertion, err.Error())
+		return
+	}
+
+	if err := dbService.Repo.ConsumeSAMLAssertion(r.Context(), connection.Name(), assertion.ID, assertion.ExpiresAt); err != nil {
+		if errors.Is(err, repository.ErrSAMLAssertionUsed) {
+			failOAuth(w, r, request.ReturnTo, errorInvalidAssertion, err.Error())
+			return
+		}
+		failOAuth(w, r, request.ReturnTo, errorServerError, err.Error())
+		return
+	}
+
+	state := authentication.OAuthState{Provider: connection.Provider(), ReturnTo: request.ReturnTo}
+	completeExternalSignIn(dbService, smsService, policy, nil, w, r, connection.Provider(), assertion.Profile, nil, state)
+}
+
+/*
+Finds a configured SAML connection, responding when it can't be used
+
+Params:
+  - connections: The configured SAML connections
+  - w:           A http response writer
+  - r:           A pointer to a http request object
+  - name:        The connection name
+
+Returns:
+  - The connection
+  - False if the connection is unknown or its IdP metadata can't be loaded, the response is already written
+*/
+func configuredConnection(connections *saml.Connections, w http.ResponseWriter, r *http.Request, name string) (*saml.Connection, bool) {
+	connection, err := connections.Get(r.Context(), name)
+	if err != nil {
+		if errors.Is(err, saml.ErrUnknownConnection) {
+			util.JsonResponse(w, "Unknown SAML connection", http.StatusNotFound, nil)
+			return nil, false
+		}
+		log.Println(err)
+		util.JsonResponse(w, "SAML connection is unavailable", http.StatusBadGateway, nil)
+		return nil, false
+	}
+
+	return connection, true
+}
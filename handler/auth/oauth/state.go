@@ -0,0 +1,181 @@
+package handler
+
+import (
+	"crypto/subtle"
+	"log"
+	"net/http"
+
+	"github.com/dev-xero/authentication-backend/authentication"
+	"github.com/dev-xero/authentication-backend/middleware"
+	"github.com/dev-xero/authentication-backend/service"
+	"github.com/dev-xero/authentication-backend/util"
+	"golang.org/x/oauth2"
+)
+
+/*
+Starts an OAuth flow
+
+Objectives:
+  - Generate the state id, the PKCE verifier and the id token nonce
+  - Bind link flows to the signed-in user
+  - Check the return_to parameter against the allowlist
+  - Store them in a short-lived, signed, HttpOnly cookie so any server instance can complete the flow
+
+Params:
+  - allowlist: The URLs return_to may point to
+  - w:         A http response writer
+  - r:         A pointer to a http request object
+  - provider:  The provider
+  - link:      Whether the flow links an identity to the signed-in user
+
+Returns:
+  - The OAuth state claims
+  - False if the flow couldn't be started, the response is already written
+*/
+func startOAuthFlow(allowlist *ReturnToAllowlist, w http.ResponseWriter, r *http.Request, provider Provider, link bool) (authentication.OAuthState, bool) {
+	var returnTo string
+	if raw := r.URL.Query().Get("return_to"); raw != "" {
+		allowed, ok := allowlist.Allows(raw)
+		if !ok {
+			util.JsonResponse(w, "return_to is not an allowed URL", http.StatusBadRequest, nil)
+			return authentication.OAuthState{}, false
+		}
+		returnTo = allowed
+	}
+
+	id, idErr := util.GenerateRandomToken(32)
+	nonce, nonceErr := util.GenerateRandomToken(32)
+	if idErr != nil || nonceErr != nil {
+		log.Println("[FAIL]: could not generate oauth state")
+		util.JsonResponse(w, "Failed to start sign-in", http.StatusInternalServerError, nil)
+		return authentication.OAuthState{}, false
+	}
+
+	state := authentication.OAuthState{
+		Provider: provider.Name(),
+		Verifier: oauth2.GenerateVerifier(),
+		Nonce:    nonce,
+		ReturnTo: returnTo,
+		FormPost: usesFormPost(provider),
+	}
+	state.ID = id
+
+	if link {
+		userID, ok := middleware.UserIDFromContext(r.Context())
+		if !ok {
+			util.JsonResponse(w, "Unauthorized request to a protected endpoint", http.StatusUnauthorized, nil)
+			return authentication.OAuthState{}, false
+		}
+		state.LinkUserID = userID.String()
+	}
+
+	token, err := authentication.CreateOAuthStateToken(state)
+	if err != nil {
+		log.Println(err)
+		util.JsonResponse(w, "Failed to start sign-in", http.StatusInternalServerError, nil)
+		return authentication.OAuthState{}, false
+	}
+
+	cookie := util.CreateOAuthStateCookie(token, state.FormPost)
+	http.SetCookie(w, &cookie)
+
+	return state, true
+}
+
+/*
+Completes the state checks of an OAuth callback
+
+Objectives:
+  - Expire the state cookie, a state is never checked twice by the same browser
+  - Verify the signed state was started for this provider and matches the state parameter
+  - Record the state as used, so a replayed callback fails on every server instance
+
+Params:
+  - dbService: The database service provider
+  - w:         A http response writer
+  - r:         A pointer to a http request object
+  - provider:  The provider name
+
+Returns:
+  - The OAuth state claims
+  - False if any check fails, the user is already redirected with an error code
+*/
+func consumeOAuthFlow(dbService *service.DatabaseProvider, w http.ResponseWriter, r *http.Request, provider string) (authentication.OAuthState, bool) {
+	util.ExpireCookie(w, "oauthstate")
+
+	cookie, err := r.Cookie("oauthstate")
+	if err != nil {
+		failOAuth(w, r, "", errorInvalidState, "oauth state cookie missing")
+		return authentication.OAuthState{}, false
+	}
+
+	state, err := authentication.VerifyOAuthStateToken(cookie.Value)
+	if err != nil {
+		failOAuth(w, r, "", errorInvalidState, err.Error())
+		return authentication.OAuthState{}, false
+	}
+
+	// The return_to URL is only trusted once the state it was signed into is verified
+	if state.Provider != provider || subtle.ConstantTimeCompare([]byte(r.FormValue("state")), []byte(state.ID)) != 1 {
+		failOAuth(w, r, "", errorInvalidState, "Oauth states do not match")
+		return authentication.OAuthState{}, false
+	}
+
+	if providerError := r.FormValue("error"); providerError != "" {
+		failOAuth(w, r, state.ReturnTo, errorAccessDenied, "provider responded with error "+providerError)
+		return authentication.OAuthState{}, false
+	}
+
+	if err := dbService.Repo.ConsumeOAuthState(r.Context(), state.ID, state.ExpiresAt.Time); err != nil {
+		failOAuth(w, r, state.ReturnTo, errorInvalidState, err.Error())
+		return authentication.OAuthState{}, false
+	}
+
+	return state, true
+}
+
+/*
+Ends an OAuth flow with a machine-readable error code
+
+Objectives:
+  - Redirect to the return_to URL of the flow with the error code
+  - Otherwise redirect to the failure route with the error code
+
+Params:
+  - w:        A http response writer
+  - r:        A pointer to a http request object
+  - returnTo: The allowed return_to URL of the flow, empty if there is none or the state isn't verified
+  - code:     The machine-readable error code
+  - reason:   Why the flow failed, only logged
+
+Returns:
+  - No return value
+*/
+func failOAuth(w http.ResponseWriter, r *http.Request, returnTo string, code string, reason string) {
+	log.Println("[AUTH]:", reason)
+	if returnTo != "" {
+		redirectToReturnTo(w, r, returnTo, "error", code)
+		return
+	}
+	// See other, so callbacks posted by the provider continue with a GET
+	http.Redirect(w, r, "failure?error="+code, http.StatusSeeOther)
+}
+
+// Implemented by providers that post the callback with response_mode=form_post
+type formPostProvider interface {
+	FormPost() bool
+}
+
+/*
+Checks whether a provider posts its callback cross-site
+
+Params:
+  - provider: The provider
+
+Returns:
+  - True if the provider uses response_mode=form_post
+*/
+func usesFormPost(provider Provider) bool {
+	formPost, ok := provider.(formPostProvider)
+	return ok && formPost.FormPost()
+}
@@ -2,10 +2,11 @@ package handler
 
 import (
 	"encoding/json"
-	"log"
 	"net/http"
+	"strings"
 
-	"github.com/dev-xero/authentication-backend/authentication"
+	mfaHandler "github.com/dev-xero/authentication-backend/handler/auth/mfa"
+	"github.com/dev-xero/authentication-backend/mfa"
 	"github.com/dev-xero/authentication-backend/service"
 	"github.com/dev-xero/authentication-backend/util"
 )
@@ -20,18 +21,20 @@ Objectives:
   - Check that the user  exists
   - If the use does not exist, respond with an error
   - Compare the request body password with the user password hash
-  - Generate a new JSON Web token
-  - Send the token cookie and the user payload response
+  - Start a second factor challenge if the user has one enrolled
+  - Otherwise send the token cookie and the user payload response
 
 Params:
-  - dbService: The database service provider
-  - w:         A http response writer
-  - r:         A pointer to a http request object
+  - dbService:  The database service provider
+  - smsService: The SMS service provider
+  - mfaPolicy:  The MFA enforcement policy
+  - w:          A http response writer
+  - r:          A pointer to a http request object
 
 Returns:
   - No return value
 */
-func SignIn(dbService *service.DatabaseProvider, w http.ResponseWriter, r *http.Request) {
+func SignIn(dbService *service.DatabaseProvider, smsService *service.SMSProvider, mfaPolicy *mfa.Policy, w http.ResponseWriter, r *http.Request) {
 	// Store the auth request body
 	var body = util.SignInRequestBody{}
 
@@ -66,6 +69,12 @@ func SignIn(dbService *service.DatabaseProvider, w http.ResponseWriter, r *http.
 	// Query the database and obtain the user the the provided email
 	user, err := dbService.Repo.GetUserByEmail(r.Context(), body.Email)
 	if err != nil {
+		// Addresses still waiting for verification can't be used to sign in
+		if strings.Contains(err.Error(), "not found") {
+			msg := "A user with those credentials does not exist"
+			util.JsonResponse(w, msg, http.StatusBadRequest, nil)
+			return
+		}
 		msg := "Internal server error, could not check if a user with that email exists"
 		util.JsonResponse(w, msg, http.StatusInternalServerError, nil)
 		return
@@ -78,24 +87,6 @@ func SignIn(dbService *service.DatabaseProvider, w http.ResponseWriter, r *http.
 		return
 	}
 
-	// Generate a new token and send response
-	token, err := authentication.CreateJWToken(user.ID)
-	if err != nil {
-		log.Println(err)
-		msg := "Failed to create token"
-		util.JsonResponse(w, msg, http.StatusInternalServerError, nil)
-		return
-	}
-
-	// Create the user payload
-	var userPayload = util.UserPayload{
-		ID:       user.ID,
-		Username: user.Username,
-		Email:    user.Email,
-	}
-
-	// Set the token cookie and send the response
-	cookie := util.CreateTokenCookie(token)
-	http.SetCookie(w, &cookie)
-	util.JsonResponse(w, "Successfully signed-in", http.StatusOK, userPayload)
+	// Sign the user in, or challenge users with a second factor enrolled
+	mfaHandler.CompleteSignIn(dbService, smsService, mfaPolicy, w, r, user, "Successfully signed-in")
 }
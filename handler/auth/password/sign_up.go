@@ -2,11 +2,13 @@ package handler
 
 import (
 	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
 
 	"github.com/dev-xero/authentication-backend/authentication"
 	"github.com/dev-xero/authentication-backend/model"
+	repository "github.com/dev-xero/authentication-backend/repository/user"
 	"github.com/dev-xero/authentication-backend/service"
 	"github.com/dev-xero/authentication-backend/util"
 	validators "github.com/dev-xero/authentication-backend/validator"
@@ -92,6 +94,11 @@ func SignUp(dbService *service.DatabaseProvider, w http.ResponseWriter, r *http.
 	err = dbService.Repo.InsertUser(r.Context(), user)
 	if err != nil {
 		log.Println(err)
+		if errors.Is(err, repository.ErrEmailTaken) {
+			msg := "A user with those credentials already exists"
+			util.JsonResponse(w, msg, http.StatusBadRequest, nil)
+			return
+		}
 		msg := "Could not insert user into database"
 		util.JsonResponse(w, msg, http.StatusInternalServerError, nil)
 		return
@@ -0,0 +1,419 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	shared "github.com/dev-xero/authentication-backend/handler/auth/shared"
+	"github.com/dev-xero/authentication-backend/mfa"
+	"github.com/dev-xero/authentication-backend/model"
+	"github.com/dev-xero/authentication-backend/service"
+	"github.com/dev-xero/authentication-backend/util"
+	"github.com/google/uuid"
+)
+
+// How long the emailed recovery token can be used to verify the request
+const recoveryTokenLifetime = time.Hour
+
+/*
+Recovery status payload struct
+
+Fields:
+  - Status:     string
+  - EligibleAt: *time.Time, when the second factors can be removed
+*/
+type RecoveryPayload struct {
+	Status     string     `json:"status"`
+	EligibleAt *time.Time `json:"eligible_at,omitempty"`
+}
+
+/*
+Handles requests to recover an account that lost every second factor
+
+Objectives:
+  - Decode and sanitize the account email
+  - Create a recovery request for accounts with a second factor
+  - Email a verification token to the account address
+  - Respond the same way whether or not the account exists
+
+Params:
+  - dbService:   The database service provider
+  - mailService: The mail service provider
+  - w:           A http response writer
+  - r:           A pointer to a http request object
+
+Returns:
+  - No return value
+*/
+func RequestRecovery(dbService *service.DatabaseProvider, mailService *service.MailProvider, w http.ResponseWriter, r *http.Request) {
+	var body = util.EmailRequestBody{}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		msg := "Bad request, email not present"
+		util.JsonResponse(w, msg, http.StatusBadRequest, nil)
+		return
+	}
+
+	util.SanitizeUserInput(&body)
+
+	// Never reveal whether an account exists or has second factors
+	msg := "If an account with a second factor uses that email, a recovery email was sent to it"
+
+	user, err := dbService.Repo.GetUserByEmail(r.Context(), body.Email)
+	if err != nil {
+		log.Println(err)
+		util.JsonResponse(w, msg, http.StatusOK, nil)
+		return
+	}
+
+	hasPhone, err := dbService.Repo.HasVerifiedPhone(r.Context(), user.ID)
+	if err != nil || !hasPhone {
+		log.Println("[LOG]: recovery requested for an account without second factors")
+		util.JsonResponse(w, msg, http.StatusOK, nil)
+		return
+	}
+
+	// A request already in its waiting period is never restarted
+	active, err := dbService.Repo.GetActiveRecovery(r.Context(), user.ID)
+	if err == nil && active.Status == model.RecoveryWaiting {
+		log.Println("[LOG]: recovery already waiting for user", user.ID)
+		util.JsonResponse(w, msg, http.StatusOK, nil)
+		return
+	}
+
+	token, err := util.GenerateRandomToken(32)
+	if err != nil {
+		log.Println(err)
+		util.JsonResponse(w, "Failed to create recovery request", http.StatusInternalServerError, nil)
+		return
+	}
+
+	recovery := model.AccountRecovery{
+		ID:        uuid.New(),
+		UserID:    user.ID,
+		TokenHash: util.HashToken(token),
+		ExpiresAt: time.Now().Add(recoveryTokenLifetime),
+	}
+
+	event := shared.NewAuditEvent(r, user.ID, "recovery.requested", recovery.ID.String())
+	if err := dbService.Repo.CreateRecovery(r.Context(), recovery, event); err != nil {
+		log.Println(err)
+		util.JsonResponse(w, "Failed to create recovery request", http.StatusInternalServerError, nil)
+		return
+	}
+
+	emailBody := fmt.Sprintf(
+		"Someone asked to remove every second factor from your account.\n\n"+
+			"If this was you, verify the request within %d minutes with this token:\n\n%s\n\n"+
+			"The second factors are only removed after a waiting period of %d hours, the request can be cancelled with the same token until then.\n"+
+			"If this wasn't you, cancel the request or ignore this email.",
+		int(recoveryTokenLifetime.Minutes()), token, int(recoveryWaitingPeriod().Hours()),
+	)
+	if err := mailService.Mailer.Send(r.Context(), user.Email, "Account recovery requested", emailBody); err != nil {
+		log.Println(err)
+	}
+
+	util.JsonResponse(w, msg, http.StatusOK, nil)
+}
+
+/*
+Handles verifying the email ownership of a recovery request
+
+Objectives:
+  - Find the recovery request matching the emailed token
+  - Start its waiting period
+  - Notify the owner through email and their enrolled phone
+
+Params:
+  - dbService:   The database service provider
+  - mailService: The mail service provider
+  - smsService:  The SMS service provider
+  - w:           A http response writer
+  - r:           A pointer to a http request object
+
+Returns:
+  - No return value
+*/
+func VerifyRecovery(dbService *service.DatabaseProvider, mailService *service.MailProvider, smsService *service.SMSProvider, w http.ResponseWriter, r *http.Request) {
+	recovery, user, ok := recoveryFromToken(dbService, w, r)
+	if !ok {
+		return
+	}
+
+	if recovery.Status != model.RecoveryPendingVerification || time.Now().After(recovery.ExpiresAt) {
+		msg := "The recovery request can no longer be verified, request a new one"
+		util.JsonResponse(w, msg, http.StatusBadRequest, nil)
+		return
+	}
+
+	eligibleAt := time.Now().Add(recoveryWaitingPeriod())
+	event := shared.NewAuditEvent(r, user.ID, "recovery.verified", recovery.ID.String())
+	if err := dbService.Repo.StartRecoveryWaitingPeriod(r.Context(), recovery.ID, eligibleAt, event); err != nil {
+		log.Println(err)
+		msg := "The recovery request can no longer be verified, request a new one"
+		util.JsonResponse(w, msg, http.StatusBadRequest, nil)
+		return
+	}
+
+	notifyOwner(r.Context(), dbService, mailService, smsService, user, ownerPhone(r.Context(), dbService, user),
+		"Your second factors will be removed",
+		fmt.Sprintf("An account recovery was verified. Every second factor will be removed from your account after %s unless the request is cancelled.", eligibleAt.UTC().Format(time.RFC1123)),
+	)
+
+	payload := RecoveryPayload{Status: model.RecoveryWaiting, EligibleAt: &eligibleAt}
+	util.JsonResponse(w, "Recovery verified, second factors can be removed after the waiting period", http.StatusOK, payload)
+}
+
+/*
+Handles cancelling a recovery request
+
+Objectives:
+  - Cancel the request matching the emailed token
+  - Or cancel every active request of the signed-in user when no token is sent
+  - Notify the owner
+
+Params:
+  - dbService:   The database service provider
+  - mailService: The mail service provider
+  - smsService:  The SMS service provider
+  - w:           A http response writer
+  - r:           A pointer to a http request object
+
+Returns:
+  - No return value
+*/
+func CancelRecovery(dbService *service.DatabaseProvider, mailService *service.MailProvider, smsService *service.SMSProvider, w http.ResponseWriter, r *http.Request) {
+	var (
+		recovery model.AccountRecovery
+		user     model.User
+		ok       bool
+	)
+
+	if userID, signedIn := shared.UserIDFromTokenCookie(r); signedIn {
+		recovery, user, ok = activeRecoveryForUser(dbService, w, r, userID)
+	} else {
+		recovery, user, ok = recoveryFromToken(dbService, w, r)
+	}
+	if !ok {
+		return
+	}
+
+	event := shared.NewAuditEvent(r, user.ID, "recovery.cancelled", recovery.ID.String())
+	if err := dbService.Repo.CancelRecovery(r.Context(), recovery.ID, event); err != nil {
+		log.Println(err)
+		msg := "The recovery request is no longer active"
+		util.JsonResponse(w, msg, http.StatusBadRequest, nil)
+		return
+	}
+
+	notifyOwner(r.Context(), dbService, mailService, smsService, user, ownerPhone(r.Context(), dbService, user),
+		"Account recovery cancelled",
+		"The account recovery request for your account was cancelled, your second factors were not changed.",
+	)
+
+	util.JsonResponse(w, "Successfully cancelled recovery", http.StatusOK, RecoveryPayload{Status: model.RecoveryCancelled})
+}
+
+/*
+Handles completing a recovery request whose waiting period is over
+
+Objectives:
+  - Find the recovery request matching the emailed token
+  - Refuse requests still in their waiting period
+  - Remove every second factor, then notify the owner
+
+Params:
+  - dbService:   The database service provider
+  - mailService: The mail service provider
+  - smsService:  The SMS service provider
+  - w:           A http response writer
+  - r:           A pointer to a http request object
+
+Returns:
+  - No return value
+*/
+func CompleteRecovery(dbService *service.DatabaseProvider, mailService *service.MailProvider, smsService *service.SMSProvider, w http.ResponseWriter, r *http.Request) {
+	recovery, user, ok := recoveryFromToken(dbService, w, r)
+	if !ok {
+		return
+	}
+
+	if recovery.Status != model.RecoveryWaiting || recovery.EligibleAt == nil {
+		msg := "The recovery request is not waiting to be completed"
+		util.JsonResponse(w, msg, http.StatusBadRequest, RecoveryPayload{Status: recovery.Status})
+		return
+	}
+
+	if time.Now().Before(*recovery.EligibleAt) {
+		msg := "The recovery waiting period is not over yet"
+		util.JsonResponse(w, msg, http.StatusForbidden, RecoveryPayload{Status: recovery.Status, EligibleAt: recovery.EligibleAt})
+		return
+	}
+
+	// Look up the phone before it is removed, the owner is only notified once the removal committed
+	phone := ownerPhone(r.Context(), dbService, user)
+
+	event := shared.NewAuditEvent(r, user.ID, "recovery.completed", recovery.ID.String())
+	if err := dbService.Repo.CompleteRecovery(r.Context(), recovery.ID, event); err != nil {
+		log.Println(err)
+		msg := "Failed to complete recovery"
+		util.JsonResponse(w, msg, http.StatusInternalServerError, nil)
+		return
+	}
+
+	notifyOwner(r.Context(), dbService, mailService, smsService, user, phone,
+		"Your second factors were removed",
+		"An account recovery was completed and every second factor was removed from your account. Sign in and enroll a new second factor.",
+	)
+
+	util.JsonResponse(w, "Successfully recovered account, second factors were removed", http.StatusOK, RecoveryPayload{Status: model.RecoveryCompleted})
+}
+
+/*
+Finds the recovery request and user matching the token in the request body
+
+Params:
+  - dbService: The database service provider
+  - w:         A http response writer
+  - r:         A pointer to a http request object
+
+Returns:
+  - The account recovery model
+  - The user the recovery is for
+  - False if no request matches, the response is already written
+*/
+func recoveryFromToken(dbService *service.DatabaseProvider, w http.ResponseWriter, r *http.Request) (model.AccountRecovery, model.User, bool) {
+	var body = util.TokenRequestBody{}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Token == "" {
+		msg := "Bad request, token not present"
+		util.JsonResponse(w, msg, http.StatusBadRequest, nil)
+		return model.AccountRecovery{}, model.User{}, false
+	}
+
+	util.SanitizeUserInput(&body)
+
+	recovery, err := dbService.Repo.GetRecoveryByTokenHash(r.Context(), util.HashToken(body.Token))
+	if err != nil {
+		log.Println(err)
+		msg := "Invalid recovery token"
+		util.JsonResponse(w, msg, http.StatusBadRequest, nil)
+		return model.AccountRecovery{}, model.User{}, false
+	}
+
+	user, err := dbService.Repo.GetUserByID(r.Context(), recovery.UserID.String())
+	if err != nil {
+		log.Println(err)
+		msg := "Internal server error, failed to get user"
+		util.JsonResponse(w, msg, http.StatusInternalServerError, nil)
+		return model.AccountRecovery{}, model.User{}, false
+	}
+
+	return recovery, user, true
+}
+
+/*
+Finds the active recovery request of a signed-in user
+
+Params:
+  - dbService: The database service provider
+  - w:         A http response writer
+  - r:         A pointer to a http request object
+  - userID:    The signed-in user's id
+
+Returns:
+  - The account recovery model
+  - The signed-in user
+  - False if the user has no active request, the response is already written
+*/
+func activeRecoveryForUser(dbService *service.DatabaseProvider, w http.ResponseWriter, r *http.Request, userID uuid.UUID) (model.AccountRecovery, model.User, bool) {
+	recovery, err := dbService.Repo.GetActiveRecovery(r.Context(), userID)
+	if err != nil {
+		log.Println(err)
+		msg := "No recovery request is active for your account"
+		util.JsonResponse(w, msg, http.StatusBadRequest, nil)
+		return model.AccountRecovery{}, model.User{}, false
+	}
+
+	user, err := dbService.Repo.GetUserByID(r.Context(), userID.String())
+	if err != nil {
+		log.Println(err)
+		msg := "Internal server error, failed to get user"
+		util.JsonResponse(w, msg, http.StatusInternalServerError, nil)
+		return model.AccountRecovery{}, model.User{}, false
+	}
+
+	return recovery, user, true
+}
+
+/*
+Notifies the account owner by email and, when a phone is given, by SMS through the SMS guard
+
+Params:
+  - ctx:         Method context
+  - dbService:   The database service provider
+  - mailService: The mail service provider
+  - smsService:  The SMS service provider
+  - user:        The account owner
+  - phone:       The owner's verified phone number, empty to notify by email only
+  - subject:     The notification subject
+  - message:     The notification message
+
+Returns:
+  - No return value, failures are logged
+*/
+func notifyOwner(ctx context.Context, dbService *service.DatabaseProvider, mailService *service.MailProvider, smsService *service.SMSProvider, user model.User, phone string, subject string, message string) {
+	if err := mailService.Mailer.Send(ctx, user.Email, subject, message); err != nil {
+		log.Println(err)
+	}
+
+	if phone == "" {
+		return
+	}
+
+	if err := mfa.SendSMSNotice(ctx, dbService, smsService, user.ID, phone, message); err != nil {
+		log.Println(err)
+	}
+}
+
+/*
+Returns the verified phone number of the account owner
+
+Params:
+  - ctx:       Method context
+  - dbService: The database service provider
+  - user:      The account owner
+
+Returns:
+  - The phone number, empty if the owner has no verified phone
+*/
+func ownerPhone(ctx context.Context, dbService *service.DatabaseProvider, user model.User) string {
+	phone, err := dbService.Repo.GetUserPhone(ctx, user.ID)
+	if err != nil || phone.VerifiedAt == nil {
+		return ""
+	}
+
+	return phone.Phone
+}
+
+/*
+Returns the recovery waiting period, 72 hours unless configured
+
+Params:
+  - No parameters
+
+Returns:
+  - The waiting period
+*/
+func recoveryWaitingPeriod() time.Duration {
+	hours, err := strconv.Atoi(os.Getenv("RECOVERY_WAITING_PERIOD_HOURS"))
+	if err != nil || hours <= 0 {
+		return 72 * time.Hour
+	}
+
+	return time.Duration(hours) * time.Hour
+}
@@ -0,0 +1,31 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/dev-xero/authentication-backend/model"
+	"github.com/google/uuid"
+)
+
+/*
+Creates an audit event for a change made by a request
+
+Params:
+  - r:      A pointer to the http request making the change
+  - userID: The user whose account changed
+  - event:  The event name, e.g. "recovery.requested"
+  - detail: Optional context for the event
+
+Returns:
+  - The audit event model
+*/
+func NewAuditEvent(r *http.Request, userID uuid.UUID, event string, detail string) model.AuditEvent {
+	return model.AuditEvent{
+		ID:        uuid.New(),
+		UserID:    userID,
+		Event:     event,
+		Detail:    detail,
+		IPAddress: r.RemoteAddr,
+		UserAgent: r.UserAgent(),
+	}
+}
@@ -0,0 +1,129 @@
+package handler
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/dev-xero/authentication-backend/authentication"
+	"github.com/dev-xero/authentication-backend/model"
+	"github.com/dev-xero/authentication-backend/util"
+	"github.com/google/uuid"
+)
+
+/*
+Signs the user in by issuing a token cookie
+
+Objectives:
+  - Generate a new JSON Web token
+  - Send the token cookie and the payload response
+
+Params:
+  - w:       A http response writer
+  - userID:  The signed-in user's id
+  - msg:     The response message
+  - payload: The response payload, usually built from the user
+
+Returns:
+  - No return value
+*/
+func IssueToken(w http.ResponseWriter, userID uuid.UUID, msg string, payload interface{}) {
+	// Set the token cookie and send the response
+	if err := SetTokenCookie(w, userID); err != nil {
+		log.Println(err)
+		msg := "Failed to create token"
+		util.JsonResponse(w, msg, http.StatusInternalServerError, nil)
+		return
+	}
+
+	util.JsonResponse(w, msg, http.StatusOK, payload)
+}
+
+/*
+Signs the user in by setting the token cookie, without writing a response
+
+Params:
+  - w:      A http response writer
+  - userID: The signed-in user's id
+
+Returns:
+  - An error if the token couldn't be created
+*/
+func SetTokenCookie(w http.ResponseWriter, userID uuid.UUID) error {
+	// Generate a new token
+	token, err := authentication.CreateJWToken(userID)
+	if err != nil {
+		return err
+	}
+
+	cookie := util.CreateTokenCookie(token)
+	http.SetCookie(w, &cookie)
+	return nil
+}
+
+/*
+Creates the user payload sent to signed-in users
+
+Params:
+  - user: The signed-in user
+
+Returns:
+  - The user payload
+*/
+func NewUserPayload(user model.User) util.UserPayload {
+	return util.UserPayload{
+		ID:       user.ID,
+		Username: user.Username,
+		Email:    user.Email,
+	}
+}
+
+/*
+Reads the user id from a valid token cookie, if the request has one
+
+Params:
+  - r: A pointer to a http request object
+
+Returns:
+  - The user id
+  - False if the request is not signed-in
+*/
+func UserIDFromTokenCookie(r *http.Request) (uuid.UUID, bool) {
+	userID, _, ok := SessionFromTokenCookie(r)
+	return userID, ok
+}
+
+/*
+Reads the session from a valid token cookie, if the request has one
+
+Params:
+  - r: A pointer to a http request object
+
+Returns:
+  - The user id
+  - When the user signed-in, the time the token was issued
+  - False if the request is not signed-in
+*/
+func SessionFromTokenCookie(r *http.Request) (uuid.UUID, time.Time, bool) {
+	cookie, err := r.Cookie("token")
+	if err != nil {
+		return uuid.Nil, time.Time{}, false
+	}
+
+	token, err := authentication.VerifyToken(cookie.Value)
+	if err != nil {
+		return uuid.Nil, time.Time{}, false
+	}
+
+	userID, err := authentication.SubjectFromToken(token)
+	if err != nil {
+		return uuid.Nil, time.Time{}, false
+	}
+
+	issuedAt, err := token.Claims.GetIssuedAt()
+	if err != nil || issuedAt == nil {
+		return uuid.Nil, time.Time{}, false
+	}
+
+	return userID, issuedAt.Time, true
+}
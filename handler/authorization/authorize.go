@@ -0,0 +1,285 @@
+package handler
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dev-xero/authentication-backend/authentication"
+	"github.com/dev-xero/authentication-backend/authserver"
+	shared "github.com/dev-xero/authentication-backend/handler/auth/shared"
+	"github.com/dev-xero/authentication-backend/model"
+	repository "github.com/dev-xero/authentication-backend/repository/user"
+	"github.com/dev-xero/authentication-backend/util"
+)
+
+// Parameters of the authorize request that must not be sent more than once
+var singleValuedAuthorizeParams = []string{"client_id", "redirect_uri", "response_type", "scope", "state", "code_challenge", "code_challenge_method", "prompt", "nonce", "max_age"}
+
+// Nonces are echoed in id tokens, longer values are rejected instead of stored
+const maxNonceLength = 255
+
+/*
+Handles authorization requests, the browser step of the authorization code flow
+
+Objectives:
+  - Verify the client and redirect URI, errors are shown to the user instead of being redirected to an unverified URI
+  - Require a S256 PKCE challenge and check the requested scopes are allowed for the client
+  - Send users without a session, or whose sign-in is older than max_age, to sign-in, returning here afterwards
+  - Ask the user to consent when they haven't granted the client the requested scopes yet
+  - Issue a single use authorization code to the redirect URI, holding the nonce and sign-in time for the id token
+
+Params:
+  - w: A http response writer
+  - r: A pointer to a http request object
+
+Returns:
+  - No return value
+*/
+func (handler *AuthorizationHandler) Authorize(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	for _, name := range singleValuedAuthorizeParams {
+		if len(query[name]) > 1 {
+			util.JsonResponse(w, "Authorization request parameters must not be repeated", http.StatusBadRequest, nil)
+			return
+		}
+	}
+
+	client, err := handler.dbService.Repo.GetOAuthClient(r.Context(), query.Get("client_id"))
+	if err != nil {
+		if errors.Is(err, repository.ErrOAuthClientNotFound) {
+			util.JsonResponse(w, "Unknown client", http.StatusBadRequest, nil)
+			return
+		}
+		log.Println(err)
+		util.JsonResponse(w, "Internal server error, failed to get client", http.StatusInternalServerError, nil)
+		return
+	}
+
+	redirectURI, ok := registeredRedirectURI(client, query.Get("redirect_uri"))
+	if !ok {
+		util.JsonResponse(w, "redirect_uri is not registered for the client", http.StatusBadRequest, nil)
+		return
+	}
+
+	// The redirect URI is verified, errors are sent to the client from here on
+	state := query.Get("state")
+	fail := func(code string, description string) {
+		redirectToClient(w, r, redirectURI, url.Values{
+			"error":             {code},
+			"error_description": {description},
+			"state":             {state},
+			"iss":               {handler.config.Issuer},
+		})
+	}
+
+	if query.Get("response_type") != "code" {
+		fail(errorUnsupportedResponseType, "response_type must be code")
+		return
+	}
+
+	if !client.AllowsGrant(model.GrantAuthorizationCode) {
+		fail(errorUnauthorizedClient, "client may not use the authorization code grant")
+		return
+	}
+
+	if query.Get("code_challenge_method") != authserver.CodeChallengeMethodS256 {
+		fail(errorInvalidRequest, "code_challenge_method must be S256")
+		return
+	}
+
+	challenge := query.Get("code_challenge")
+	if !authserver.ValidCodeChallenge(challenge) {
+		fail(errorInvalidRequest, "code_challenge is missing or malformed")
+		return
+	}
+
+	scopes := authserver.SplitScope(query.Get("scope"))
+	if len(scopes) == 0 {
+		scopes = client.Scopes
+	}
+	if !authserver.ScopesAllowed(scopes, client.Scopes) {
+		fail(errorInvalidScope, "requested scope is not allowed for the client")
+		return
+	}
+
+	nonce := query.Get("nonce")
+	if len(nonce) > maxNonceLength {
+		fail(errorInvalidRequest, "nonce is too long")
+		return
+	}
+
+	maxAge := -1
+	if value := query.Get("max_age"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 0 {
+			fail(errorInvalidRequest, "max_age must be a non-negative number of seconds")
+			return
+		}
+		maxAge = parsed
+	}
+
+	prompts := strings.Fields(query.Get("prompt"))
+
+	userID, authTime, ok := shared.SessionFromTokenCookie(r)
+	if ok && maxAge >= 0 && time.Since(authTime) > time.Duration(maxAge)*time.Second {
+		ok = false
+	}
+	if !ok {
+		if hasPrompt(prompts, "none") || handler.config.LoginURL == "" {
+			fail(errorLoginRequired, "user is not signed-in")
+			return
+		}
+		handler.redirectToLogin(w, r)
+		return
+	}
+
+	user, err := handler.dbService.Repo.GetUserByID(r.Context(), userID.String())
+	if err != nil {
+		log.Println(err)
+		fail(errorServerError, "failed to get user")
+		return
+	}
+
+	decision, err := handler.mfaPolicy.Evaluate(r.Context(), handler.dbService, user)
+	if err != nil {
+		log.Println(err)
+		fail(errorServerError, "could not evaluate mfa policy")
+		return
+	}
+	if decision.Blocked {
+		fail(errorAccessDenied, "a second factor must be enrolled")
+		return
+	}
+
+	consentRequired, err := handler.consentRequired(r, client, userID, scopes, prompts)
+	if err != nil {
+		log.Println(err)
+		fail(errorServerError, "failed to check consent")
+		return
+	}
+	if consentRequired {
+		if hasPrompt(prompts, "none") {
+			fail(errorConsentRequired, "user has not granted the requested scopes")
+			return
+		}
+
+		consent := authentication.Consent{
+			ClientID:      client.ID,
+			RedirectURI:   redirectURI,
+			Scope:         authserver.JoinScope(scopes),
+			State:         state,
+			CodeChallenge: challenge,
+			Nonce:         nonce,
+		}
+		consent.Subject = userID.String()
+
+		handler.renderConsent(w, client, consent)
+		return
+	}
+
+	code, err := handler.issueAuthorizationCode(r, model.AuthorizationCode{
+		ClientID:      client.ID,
+		UserID:        userID,
+		RedirectURI:   redirectURI,
+		Scope:         authserver.JoinScope(scopes),
+		CodeChallenge: challenge,
+		Nonce:         nonce,
+		AuthTime:      authTime,
+	})
+	if err != nil {
+		log.Println(err)
+		fail(errorServerError, "failed to issue authorization code")
+		return
+	}
+
+	log.Printf("[SUCCESS]: issued authorization code to client %s for user %s\n", client.ID, userID)
+	redirectToClient(w, r, redirectURI, url.Values{
+		"code":  {code},
+		"state": {state},
+		"iss":   {handler.config.Issuer},
+	})
+}
+
+/*
+Stores a new authorization code, only its hash is kept
+
+Params:
+  - r:       A pointer to a http request object
+  - details: What the code grants, its hash and expiry are set here
+
+Returns:
+  - The authorization code
+  - An error if it couldn't be stored
+*/
+func (handler *AuthorizationHandler) issueAuthorizationCode(r *http.Request, details model.AuthorizationCode) (string, error) {
+	code, err := util.GenerateRandomToken(32)
+	if err != nil {
+		return "", err
+	}
+
+	details.CodeHash = util.HashToken(code)
+	details.ExpiresAt = time.Now().Add(handler.config.CodeLifetime)
+
+	if err := handler.dbService.Repo.InsertAuthorizationCode(r.Context(), details); err != nil {
+		return "", err
+	}
+
+	return code, nil
+}
+
+/*
+Sends a user without a session to sign-in, with the authorize request as the URL to return to
+
+Params:
+  - w: A http response writer
+  - r: A pointer to a http request object
+
+Returns:
+  - No return value
+*/
+func (handler *AuthorizationHandler) redirectToLogin(w http.ResponseWriter, r *http.Request) {
+	target, err := url.Parse(handler.config.LoginURL)
+	if err != nil {
+		log.Println("[FAIL]: OAUTH_LOGIN_URL is malformed:", err)
+		util.JsonResponse(w, "Internal server error, sign-in is unavailable", http.StatusInternalServerError, nil)
+		return
+	}
+
+	query := target.Query()
+	query.Set("return_to", handler.config.Issuer+r.URL.RequestURI())
+	target.RawQuery = query.Encode()
+
+	http.Redirect(w, r, target.String(), http.StatusFound)
+}
+
+/*
+Finds the redirect URI of an authorize request among the URIs registered for the client
+
+Params:
+  - client:    The client
+  - requested: The redirect_uri parameter, may be empty when the client registered a single URI
+
+Returns:
+  - The redirect URI
+  - False if it is not registered, URIs are compared exactly
+*/
+func registeredRedirectURI(client model.OAuthClient, requested string) (string, bool) {
+	if requested == "" {
+		if len(client.RedirectURIs) == 1 {
+			return client.RedirectURIs[0], true
+		}
+		return "", false
+	}
+
+	if client.AllowsRedirectURI(requested) {
+		return requested, true
+	}
+
+	return "", false
+}
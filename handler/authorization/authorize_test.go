@@ -0,0 +1,93 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/dev-xero/authentication-backend/authserver"
+	"github.com/dev-xero/authentication-backend/model"
+)
+
+func TestRegisteredRedirectURI(t *testing.T) {
+	single := model.OAuthClient{RedirectURIs: []string{testRedirectURI}}
+	multiple := model.OAuthClient{RedirectURIs: []string{testRedirectURI, "https://app.example.com/other"}}
+
+	tests := []struct {
+		name      string
+		client    model.OAuthClient
+		requested string
+		want      string
+		ok        bool
+	}{
+		{name: "registered", client: multiple, requested: "https://app.example.com/other", want: "https://app.example.com/other", ok: true},
+		{name: "omitted with a single registered uri", client: single, want: testRedirectURI, ok: true},
+		{name: "omitted with several registered uris", client: multiple},
+		{name: "not registered", client: single, requested: "https://evil.example.com/callback"},
+		{name: "extra path", client: single, requested: testRedirectURI + "/extra"},
+		{name: "extra query", client: single, requested: testRedirectURI + "?next=/"},
+		{name: "other case", client: single, requested: "https://APP.example.com/callback"},
+		{name: "trailing slash", client: single, requested: testRedirectURI + "/"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, ok := registeredRedirectURI(test.client, test.requested)
+			if got != test.want || ok != test.ok {
+				t.Fatalf("registeredRedirectURI = %q, %v, want %q, %v", got, ok, test.want, test.ok)
+			}
+		})
+	}
+}
+
+// Errors about the redirect URI are shown to the user, never redirected to the unverified URI
+func TestAuthorizeRejectsUnregisteredRedirectURI(t *testing.T) {
+	flow := newTestFlow(t)
+
+	query := url.Values{
+		"client_id":             {flow.client.ID},
+		"redirect_uri":          {"https://evil.example.com/callback"},
+		"response_type":         {"code"},
+		"scope":                 {"openid"},
+		"code_challenge":        {codeChallenge(testVerifier)},
+		"code_challenge_method": {authserver.CodeChallengeMethodS256},
+	}
+	r := httptest.NewRequest(http.MethodGet, testIssuer+"/oauth/authorize?"+query.Encode(), nil)
+	r.AddCookie(flow.session)
+	w := httptest.NewRecorder()
+
+	flow.handler.Authorize(w, r)
+
+	if w.Code != http.StatusBadRequest || w.Header().Get("Location") != "" {
+		t.Fatalf("authorize responded %d, location %q", w.Code, w.Header().Get("Location"))
+	}
+}
+
+// Without a S256 challenge the error is redirected to the verified redirect URI
+func TestAuthorizeRequiresS256Challenge(t *testing.T) {
+	flow := newTestFlow(t)
+
+	for _, method := range []string{"", "plain"} {
+		query := url.Values{
+			"client_id":             {flow.client.ID},
+			"redirect_uri":          {testRedirectURI},
+			"response_type":         {"code"},
+			"code_challenge":        {testVerifier},
+			"code_challenge_method": {method},
+		}
+		r := httptest.NewRequest(http.MethodGet, testIssuer+"/oauth/authorize?"+query.Encode(), nil)
+		r.AddCookie(flow.session)
+		w := httptest.NewRecorder()
+
+		flow.handler.Authorize(w, r)
+
+		location, err := url.Parse(w.Header().Get("Location"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if w.Code != http.StatusFound || location.Query().Get("error") != errorInvalidRequest || location.Query().Get("code") != "" {
+			t.Fatalf("method %q: authorize responded %d, location %s", method, w.Code, location)
+		}
+	}
+}
@@ -0,0 +1,239 @@
+package handler
+
+import (
+	"crypto/subtle"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+
+	"github.com/dev-xero/authentication-backend/authserver"
+	"github.com/dev-xero/authentication-backend/model"
+	repository "github.com/dev-xero/authentication-backend/repository/user"
+	"github.com/dev-xero/authentication-backend/util"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Requests of clients are small forms
+const maxClientRequestSize = 16 << 10
+
+/*
+Parses the form a client posted to the token, introspection or revocation endpoint
+
+Params:
+  - w: A http response writer
+  - r: A pointer to a http request object
+
+Returns:
+  - False if the form is malformed or repeats a parameter, the error response is already written
+*/
+func parseClientForm(w http.ResponseWriter, r *http.Request) bool {
+	r.Body = http.MaxBytesReader(w, r.Body, maxClientRequestSize)
+	if err := r.ParseForm(); err != nil {
+		writeOAuthError(w, http.StatusBadRequest, errorInvalidRequest, "request body must be a form")
+		return false
+	}
+
+	for key, values := range r.PostForm {
+		if len(values) > 1 {
+			writeOAuthError(w, http.StatusBadRequest, errorInvalidRequest, key+" must not be repeated")
+			return false
+		}
+	}
+
+	return true
+}
+
+/*
+Authenticates the client of a token endpoint request
+
+Objectives:
+  - Read the credentials from the Authorization header, a client assertion or the form, never more than one
+  - Check the client authenticates the way it registered, clients registered for mutual TLS only send their client_id
+  - Compare the secret hash in constant time, verify the assertion with the client's keys and record its id, or verify the client certificate
+
+Params:
+  - w: A http response writer
+  - r: A pointer to a http request object, its form already parsed
+
+Returns:
+  - The authenticated client
+  - False if authentication failed, the error response is already written
+*/
+func (handler *AuthorizationHandler) authenticateClient(w http.ResponseWriter, r *http.Request) (model.OAuthClient, bool) {
+	var (
+		clientID string
+		secret   string
+		method   string
+	)
+
+	basicID, basicSecret, hasBasic := r.BasicAuth()
+	formID, formSecret := r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	assertion, assertionType := r.PostForm.Get("client_assertion"), r.PostForm.Get("client_assertion_type")
+
+	switch {
+	case assertion != "" || assertionType != "":
+		if hasBasic || formSecret != "" {
+			writeOAuthError(w, http.StatusBadRequest, errorInvalidRequest, "client credentials must be sent one way only")
+			return model.OAuthClient{}, false
+		}
+		if assertionType != authserver.ClientAssertionTypeJWT || assertion == "" {
+			writeOAuthError(w, http.StatusBadRequest, errorInvalidRequest, "client_assertion_type must be "+authserver.ClientAssertionTypeJWT)
+			return model.OAuthClient{}, false
+		}
+
+		// The client is named by the assertion, the form may repeat it
+		issuer, ok := unverifiedIssuer(assertion)
+		if !ok || (formID != "" && formID != issuer) {
+			writeInvalidClient(w, false)
+			return model.OAuthClient{}, false
+		}
+		clientID, method = issuer, model.ClientAuthPrivateKey
+	case hasBasic:
+		if formSecret != "" {
+			writeOAuthError(w, http.StatusBadRequest, errorInvalidRequest, "client credentials must be sent one way only")
+			return model.OAuthClient{}, false
+		}
+
+		// Basic credentials are form encoded before they are joined
+		var errID, errSecret error
+		clientID, errID = url.QueryUnescape(basicID)
+		secret, errSecret = url.QueryUnescape(basicSecret)
+		if errID != nil || errSecret != nil || (formID != "" && formID != clientID) {
+			writeInvalidClient(w, true)
+			return model.OAuthClient{}, false
+		}
+		method = model.ClientAuthSecretBasic
+	case formSecret != "":
+		clientID, secret, method = formID, formSecret, model.ClientAuthSecretPost
+	default:
+		clientID, method = formID, model.ClientAuthNone
+	}
+
+	if clientID == "" {
+		writeInvalidClient(w, false)
+		return model.OAuthClient{}, false
+	}
+
+	client, err := handler.dbService.Repo.GetOAuthClient(r.Context(), clientID)
+	if err != nil {
+		if errors.Is(err, repository.ErrOAuthClientNotFound) {
+			writeInvalidClient(w, hasBasic)
+			return model.OAuthClient{}, false
+		}
+		log.Println(err)
+		writeOAuthError(w, http.StatusInternalServerError, errorServerError, "failed to get client")
+		return model.OAuthClient{}, false
+	}
+
+	// Without other credentials the client is public, unless it authenticates with its TLS certificate
+	if method == model.ClientAuthNone && authserver.UsesClientCertificate(client.TokenEndpointAuthMethod) {
+		method = client.TokenEndpointAuthMethod
+	}
+
+	if client.TokenEndpointAuthMethod != method {
+		log.Printf("[FAIL]: client %s authenticated with %s, registered %s\n", client.ID, method, client.TokenEndpointAuthMethod)
+		writeInvalidClient(w, hasBasic)
+		return model.OAuthClient{}, false
+	}
+
+	switch method {
+	case model.ClientAuthSecretBasic, model.ClientAuthSecretPost:
+		if subtle.ConstantTimeCompare([]byte(util.HashToken(secret)), []byte(client.SecretHash)) != 1 {
+			log.Printf("[FAIL]: client %s sent a wrong secret\n", client.ID)
+			writeInvalidClient(w, hasBasic)
+			return model.OAuthClient{}, false
+		}
+	case model.ClientAuthPrivateKey:
+		if !handler.verifyClientAssertion(w, r, client, assertion) {
+			return model.OAuthClient{}, false
+		}
+	case model.ClientAuthTLS, model.ClientAuthSelfSigned:
+		if !handler.verifyClientCertificate(w, r, client) {
+			return model.OAuthClient{}, false
+		}
+	}
+
+	return client, true
+}
+
+/*
+Verifies the client assertion of a private_key_jwt client
+
+Params:
+  - w:         A http response writer
+  - r:         A pointer to a http request object
+  - client:    The client named by the assertion
+  - assertion: The client_assertion parameter
+
+Returns:
+  - False if the assertion is invalid or replayed, the error response is already written
+*/
+func (handler *AuthorizationHandler) verifyClientAssertion(w http.ResponseWriter, r *http.Request, client model.OAuthClient, assertion string) bool {
+	keys, err := authserver.ParseJWKS([]byte(client.JWKS))
+	if err != nil {
+		log.Printf("[FAIL]: client %s has unusable keys: %v\n", client.ID, err)
+		writeInvalidClient(w, false)
+		return false
+	}
+
+	audiences := []string{handler.config.Issuer + "/oauth/token", handler.config.Issuer}
+	if handler.config.MTLSBaseURL != "" {
+		audiences = append(audiences, handler.config.MTLSBaseURL+"/oauth/token")
+	}
+	claims, err := authserver.VerifyClientAssertion(assertion, client.ID, keys, audiences)
+	if err != nil {
+		log.Printf("[FAIL]: client %s sent an invalid assertion: %v\n", client.ID, err)
+		writeInvalidClient(w, false)
+		return false
+	}
+
+	if err := handler.dbService.Repo.ConsumeClientAssertion(r.Context(), client.ID, claims.ID, claims.ExpiresAt.Time); err != nil {
+		if errors.Is(err, repository.ErrClientAssertionUsed) {
+			log.Printf("[FAIL]: client %s replayed assertion %s\n", client.ID, claims.ID)
+			writeInvalidClient(w, false)
+			return false
+		}
+		log.Println(err)
+		writeOAuthError(w, http.StatusInternalServerError, errorServerError, "failed to record client assertion")
+		return false
+	}
+
+	return true
+}
+
+/*
+Reads the issuer of a client assertion before it is verified, to find the client's keys
+
+Params:
+  - assertion: The client_assertion parameter
+
+Returns:
+  - The issuer
+  - False if the assertion can't be decoded
+*/
+func unverifiedIssuer(assertion string) (string, bool) {
+	var claims jwt.RegisteredClaims
+	if _, _, err := jwt.NewParser().ParseUnverified(assertion, &claims); err != nil || claims.Issuer == "" {
+		return "", false
+	}
+
+	return claims.Issuer, true
+}
+
+/*
+Writes the invalid_client error, challenging for basic credentials when they were used
+
+Params:
+  - w:         A http response writer
+  - usedBasic: Whether the client sent basic credentials
+
+Returns:
+  - No return value
+*/
+func writeInvalidClient(w http.ResponseWriter, usedBasic bool) {
+	if usedBasic {
+		w.Header().Set("WWW-Authenticate", `Basic realm="oauth"`)
+	}
+	writeOAuthError(w, http.StatusUnauthorized, errorInvalidClient, "client authentication failed")
+}
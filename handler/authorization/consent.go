@@ -0,0 +1,256 @@
+package handler
+
+import (
+	"errors"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+
+	"github.com/dev-xero/authentication-backend/authentication"
+	"github.com/dev-xero/authentication-backend/authserver"
+	shared "github.com/dev-xero/authentication-backend/handler/auth/shared"
+	"github.com/dev-xero/authentication-backend/model"
+	repository "github.com/dev-xero/authentication-backend/repository/user"
+	"github.com/google/uuid"
+)
+
+// What each scope shares, shown to users deciding whether to grant it
+var scopeDescriptions = map[string]string{
+	authserver.ScopeOpenID:  "Sign you in with your account",
+	authserver.ScopeProfile: "See your username",
+	authserver.ScopeEmail:   "See your email address",
+}
+
+/*
+Consent page struct, what the consent screen shows
+
+Fields:
+  - ClientName: string, the client requesting access
+  - Scopes:     []string, descriptions of the requested scopes
+  - Token:      string, the consent token posted with the decision
+  - Message:    string, the error shown instead of the form
+*/
+type consentPage struct {
+	ClientName string
+	Scopes     []string
+	Token      string
+	Message    string
+}
+
+var consentTemplate = template.Must(template.New("consent").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Authorize access</title></head>
+<body>
+<h1>Authorize access</h1>
+{{if .Message}}<p>{{.Message}}</p>{{end}}
+{{if .Token}}
+<p><strong>{{.ClientName}}</strong> would like to:</p>
+{{if .Scopes}}<ul>{{range .Scopes}}<li>{{.}}</li>{{end}}</ul>{{end}}
+<p>You can revoke its access at any time from your account.</p>
+<form method="post">
+<input type="hidden" name="token" value="{{.Token}}">
+<button type="submit" name="decision" value="approve">Allow</button>
+<button type="submit" name="decision" value="deny">Deny</button>
+</form>
+{{end}}
+</body>
+</html>
+`))
+
+/*
+Checks whether the user must consent before a client gets the requested scopes
+
+Objectives:
+  - Skip consent when the user already granted the client every requested scope
+  - Skip consent for first party clients, recording the grant so the user can still revoke it
+
+Params:
+  - r:       A pointer to a http request object
+  - client:  The client requesting access
+  - userID:  The signed-in user
+  - scopes:  The requested scopes
+  - prompts: The prompt values of the authorization request, consent forces the consent screen
+
+Returns:
+  - True if the consent screen must be shown
+  - An error if the grant couldn't be read or saved
+*/
+func (handler *AuthorizationHandler) consentRequired(r *http.Request, client model.OAuthClient, userID uuid.UUID, scopes []string, prompts []string) (bool, error) {
+	if hasPrompt(prompts, "consent") {
+		return true, nil
+	}
+
+	grant, err := handler.dbService.Repo.GetOAuthGrant(r.Context(), userID, client.ID)
+	if err != nil && !errors.Is(err, repository.ErrOAuthGrantNotFound) {
+		return false, err
+	}
+	if err == nil && authserver.ScopesAllowed(scopes, grant.Scopes) {
+		return false, nil
+	}
+
+	if !client.FirstParty {
+		return true, nil
+	}
+
+	return false, handler.dbService.Repo.SaveOAuthGrant(r.Context(), userID, client.ID, scopes)
+}
+
+/*
+Shows the consent screen for a verified authorization request
+
+Params:
+  - w:       A http response writer
+  - client:  The client requesting access
+  - consent: The verified authorization request, its subject the signed-in user
+
+Returns:
+  - No return value
+*/
+func (handler *AuthorizationHandler) renderConsent(w http.ResponseWriter, client model.OAuthClient, consent authentication.Consent) {
+	token, err := authentication.CreateConsentToken(consent)
+	if err != nil {
+		log.Println(err)
+		renderHTML(w, http.StatusInternalServerError, consentTemplate, consentPage{Message: "Something went wrong, try again."})
+		return
+	}
+
+	renderHTML(w, http.StatusOK, consentTemplate, consentPage{
+		ClientName: client.Name,
+		Scopes:     describeScopes(authserver.SplitScope(consent.Scope)),
+		Token:      token,
+	})
+}
+
+/*
+Handles a user answering the consent screen
+
+Objectives:
+  - Verify the consent token was issued to the signed-in user, a form posted from another site has none
+  - Check the client still has the redirect URI, errors are shown to the user until it is verified
+  - Record the grant and issue an authorization code when the user allowed access, or send access_denied
+
+Params:
+  - w: A http response writer
+  - r: A pointer to a http request object
+
+Returns:
+  - No return value
+*/
+func (handler *AuthorizationHandler) Consent(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxClientRequestSize)
+	if err := r.ParseForm(); err != nil {
+		renderHTML(w, http.StatusBadRequest, consentTemplate, consentPage{Message: "The form is malformed."})
+		return
+	}
+
+	userID, authTime, ok := shared.SessionFromTokenCookie(r)
+	if !ok {
+		renderHTML(w, http.StatusUnauthorized, consentTemplate, consentPage{Message: "Your session ended, start again from the application."})
+		return
+	}
+
+	consent, err := authentication.VerifyConsentToken(r.PostForm.Get("token"))
+	if err != nil || consent.Subject != userID.String() {
+		log.Printf("[FAIL]: rejected consent of user %s: %v\n", userID, err)
+		renderHTML(w, http.StatusForbidden, consentTemplate, consentPage{Message: "This form expired, start again from the application."})
+		return
+	}
+
+	client, err := handler.dbService.Repo.GetOAuthClient(r.Context(), consent.ClientID)
+	if err != nil || !client.AllowsRedirectURI(consent.RedirectURI) {
+		log.Printf("[FAIL]: consent for client %s can't be completed: %v\n", consent.ClientID, err)
+		renderHTML(w, http.StatusBadRequest, consentTemplate, consentPage{Message: "This application is no longer available."})
+		return
+	}
+
+	fail := func(code string, description string) {
+		redirectToClient(w, r, consent.RedirectURI, url.Values{
+			"error":             {code},
+			"error_description": {description},
+			"state":             {consent.State},
+			"iss":               {handler.config.Issuer},
+		})
+	}
+
+	switch r.PostForm.Get("decision") {
+	case "approve":
+	case "deny":
+		log.Printf("[LOG]: user %s denied client %s\n", userID, client.ID)
+		fail(errorAccessDenied, "user denied access")
+		return
+	default:
+		renderHTML(w, http.StatusBadRequest, consentTemplate, consentPage{Message: "Allow or deny access."})
+		return
+	}
+
+	if err := handler.dbService.Repo.SaveOAuthGrant(r.Context(), userID, client.ID, authserver.SplitScope(consent.Scope)); err != nil {
+		log.Println(err)
+		fail(errorServerError, "failed to save grant")
+		return
+	}
+
+	code, err := handler.issueAuthorizationCode(r, model.AuthorizationCode{
+		ClientID:      client.ID,
+		UserID:        userID,
+		RedirectURI:   consent.RedirectURI,
+		Scope:         consent.Scope,
+		CodeChallenge: consent.CodeChallenge,
+		Nonce:         consent.Nonce,
+		AuthTime:      authTime,
+	})
+	if err != nil {
+		log.Println(err)
+		fail(errorServerError, "failed to issue authorization code")
+		return
+	}
+
+	log.Printf("[SUCCESS]: user %s granted client %s access\n", userID, client.ID)
+	redirectToClient(w, r, consent.RedirectURI, url.Values{
+		"code":  {code},
+		"state": {consent.State},
+		"iss":   {handler.config.Issuer},
+	})
+}
+
+/*
+Describes scopes for users, scopes without a description are shown as they are
+
+Params:
+  - scopes: The scopes
+
+Returns:
+  - The descriptions
+*/
+func describeScopes(scopes []string) []string {
+	descriptions := make([]string, 0, len(scopes))
+	for _, scope := range scopes {
+		if description, ok := scopeDescriptions[scope]; ok {
+			descriptions = append(descriptions, description)
+			continue
+		}
+		descriptions = append(descriptions, scope)
+	}
+
+	return descriptions
+}
+
+/*
+Checks whether an authorization request carries a prompt value
+
+Params:
+  - prompts: The space separated values of the prompt parameter
+  - value:   The prompt value, e.g. none or consent
+
+Returns:
+  - True if the value was requested
+*/
+func hasPrompt(prompts []string, value string) bool {
+	for _, prompt := range prompts {
+		if prompt == value {
+			return true
+		}
+	}
+
+	return false
+}
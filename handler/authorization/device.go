@@ -0,0 +1,357 @@
+package handler
+
+import (
+	"errors"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/dev-xero/authentication-backend/authentication"
+	"github.com/dev-xero/authentication-backend/authserver"
+	shared "github.com/dev-xero/authentication-backend/handler/auth/shared"
+	"github.com/dev-xero/authentication-backend/model"
+	repository "github.com/dev-xero/authentication-backend/repository/user"
+	"github.com/dev-xero/authentication-backend/util"
+	"github.com/google/uuid"
+)
+
+// Attempts at drawing a user code no pending device holds
+const maxUserCodeAttempts = 5
+
+/*
+Device authorization response struct
+
+Fields:
+  - The device code, user code and verification URIs defined by RFC 8628
+*/
+type deviceAuthorizationResponse struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationURI         string `json:"verification_uri"`
+	VerificationURIComplete string `json:"verification_uri_complete"`
+	ExpiresIn               int64  `json:"expires_in"`
+	Interval                int    `json:"interval"`
+}
+
+/*
+Verification page struct, what the device verification page shows
+
+Fields:
+  - UserCode:   string, the formatted user code, empty on the entry form
+  - ClientName: string, the client the device runs
+  - Scopes:     []string, descriptions of the scopes the device requests
+  - Token:      string, the device approval token posted with the decision
+  - Message:    string, the outcome or error shown above the form
+*/
+type verificationPage struct {
+	UserCode   string
+	ClientName string
+	Scopes     []string
+	Token      string
+	Message    string
+}
+
+var verificationTemplate = template.Must(template.New("device").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Connect a device</title></head>
+<body>
+<h1>Connect a device</h1>
+{{if .Message}}<p>{{.Message}}</p>{{end}}
+{{if .Token}}
+<p><strong>{{.ClientName}}</strong> is requesting access to your account with the code <strong>{{.UserCode}}</strong>.</p>
+{{if .Scopes}}<ul>{{range .Scopes}}<li>{{.}}</li>{{end}}</ul>{{end}}
+<p>Only approve if the code matches the one shown on your device.</p>
+<form method="post">
+<input type="hidden" name="token" value="{{.Token}}">
+<button type="submit" name="decision" value="approve">Approve</button>
+<button type="submit" name="decision" value="deny">Deny</button>
+</form>
+{{else}}
+<form method="get">
+<label for="user_code">Enter the code shown on your device</label>
+<input id="user_code" name="user_code" autocomplete="off" autofocus>
+<button type="submit">Continue</button>
+</form>
+{{end}}
+</body>
+</html>
+`))
+
+/*
+Handles device authorization requests, the first step of the device authorization grant
+
+Objectives:
+  - Authenticate the client, public clients are allowed since devices can't keep secrets
+  - Check the client may use the device grant and the requested scopes are allowed for it
+  - Issue a device code, stored hashed, and a user code no other pending device holds
+
+Params:
+  - w: A http response writer
+  - r: A pointer to a http request object
+
+Returns:
+  - No return value
+*/
+func (handler *AuthorizationHandler) DeviceAuthorization(w http.ResponseWriter, r *http.Request) {
+	if !parseClientForm(w, r) {
+		return
+	}
+
+	client, ok := handler.authenticateClient(w, r)
+	if !ok {
+		return
+	}
+
+	if !client.AllowsGrant(model.GrantDeviceCode) {
+		writeOAuthError(w, http.StatusBadRequest, errorUnauthorizedClient, "client may not use the device authorization grant")
+		return
+	}
+
+	scopes := authserver.SplitScope(r.PostForm.Get("scope"))
+	if len(scopes) == 0 {
+		scopes = client.Scopes
+	}
+	if !authserver.ScopesAllowed(scopes, client.Scopes) {
+		writeOAuthError(w, http.StatusBadRequest, errorInvalidScope, "requested scope is not allowed for the client")
+		return
+	}
+
+	deviceCode, err := util.GenerateRandomToken(32)
+	if err != nil {
+		log.Println(err)
+		writeOAuthError(w, http.StatusInternalServerError, errorServerError, "failed to issue device code")
+		return
+	}
+
+	interval := int(handler.config.DevicePollInterval / time.Second)
+	authorization := model.DeviceAuthorization{
+		DeviceCodeHash: util.HashToken(deviceCode),
+		ClientID:       client.ID,
+		Scope:          authserver.JoinScope(scopes),
+		PollInterval:   interval,
+		ExpiresAt:      time.Now().Add(handler.config.DeviceCodeLifetime),
+	}
+
+	// User codes are short, a collision with a pending device draws another one
+	for attempt := 0; ; attempt++ {
+		authorization.UserCode, err = authserver.GenerateUserCode()
+		if err == nil {
+			err = handler.dbService.Repo.InsertDeviceAuthorization(r.Context(), authorization)
+		}
+		if !errors.Is(err, repository.ErrUserCodeTaken) || attempt == maxUserCodeAttempts-1 {
+			break
+		}
+	}
+	if err != nil {
+		log.Println(err)
+		writeOAuthError(w, http.StatusInternalServerError, errorServerError, "failed to issue device code")
+		return
+	}
+
+	userCode := authserver.FormatUserCode(authorization.UserCode)
+	verificationURI := handler.config.Issuer + "/oauth/device"
+
+	log.Printf("[SUCCESS]: issued device code to client %s\n", client.ID)
+	writeOAuthJSON(w, http.StatusOK, deviceAuthorizationResponse{
+		DeviceCode:              deviceCode,
+		UserCode:                userCode,
+		VerificationURI:         verificationURI,
+		VerificationURIComplete: verificationURI + "?" + url.Values{"user_code": {userCode}}.Encode(),
+		ExpiresIn:               int64(handler.config.DeviceCodeLifetime / time.Second),
+		Interval:                interval,
+	})
+}
+
+/*
+Serves the device verification page, where a signed-in user enters the code shown on a device
+
+Objectives:
+  - Send users without a session to sign-in, returning here with the code they followed
+  - Show the entry form, or the client and scopes of the pending device holding the entered code
+  - Bind the approval form to the user with a signed token
+
+Params:
+  - w: A http response writer
+  - r: A pointer to a http request object
+
+Returns:
+  - No return value
+*/
+func (handler *AuthorizationHandler) DeviceVerification(w http.ResponseWriter, r *http.Request) {
+	userID, _, ok := shared.SessionFromTokenCookie(r)
+	if !ok {
+		if handler.config.LoginURL != "" {
+			handler.redirectToLogin(w, r)
+			return
+		}
+		renderVerificationPage(w, http.StatusUnauthorized, verificationPage{Message: "Sign-in to connect a device."})
+		return
+	}
+
+	input := r.URL.Query().Get("user_code")
+	if input == "" {
+		renderVerificationPage(w, http.StatusOK, verificationPage{})
+		return
+	}
+
+	userCode, ok := authserver.NormalizeUserCode(input)
+	if !ok {
+		renderVerificationPage(w, http.StatusBadRequest, verificationPage{Message: "That code is not valid, check the code shown on your device."})
+		return
+	}
+
+	authorization, err := handler.dbService.Repo.GetPendingDeviceAuthorization(r.Context(), userCode)
+	if err != nil {
+		if errors.Is(err, repository.ErrDeviceCodeInvalid) {
+			renderVerificationPage(w, http.StatusNotFound, verificationPage{Message: "That code is invalid or expired, check the code shown on your device."})
+			return
+		}
+		log.Println(err)
+		renderVerificationPage(w, http.StatusInternalServerError, verificationPage{Message: "Something went wrong, try again."})
+		return
+	}
+
+	client, err := handler.dbService.Repo.GetOAuthClient(r.Context(), authorization.ClientID)
+	if err != nil {
+		log.Println(err)
+		renderVerificationPage(w, http.StatusInternalServerError, verificationPage{Message: "Something went wrong, try again."})
+		return
+	}
+
+	approval := authentication.DeviceApproval{UserCode: userCode}
+	approval.Subject = userID.String()
+
+	token, err := authentication.CreateDeviceApprovalToken(approval)
+	if err != nil {
+		log.Println(err)
+		renderVerificationPage(w, http.StatusInternalServerError, verificationPage{Message: "Something went wrong, try again."})
+		return
+	}
+
+	renderVerificationPage(w, http.StatusOK, verificationPage{
+		UserCode:   authserver.FormatUserCode(userCode),
+		ClientName: client.Name,
+		Scopes:     describeScopes(authserver.SplitScope(authorization.Scope)),
+		Token:      token,
+	})
+}
+
+/*
+Handles a user approving or denying a device on the verification page
+
+Objectives:
+  - Verify the approval token was issued to the signed-in user, a form posted from another site has none
+  - Evaluate the MFA policy, users who must enroll a second factor can't approve devices
+  - Record the decision, the device receives its tokens on its next poll
+
+Params:
+  - w: A http response writer
+  - r: A pointer to a http request object
+
+Returns:
+  - No return value
+*/
+func (handler *AuthorizationHandler) DeviceDecision(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxClientRequestSize)
+	if err := r.ParseForm(); err != nil {
+		renderVerificationPage(w, http.StatusBadRequest, verificationPage{Message: "The form is malformed."})
+		return
+	}
+
+	userID, authTime, ok := shared.SessionFromTokenCookie(r)
+	if !ok {
+		renderVerificationPage(w, http.StatusUnauthorized, verificationPage{Message: "Sign-in to connect a device."})
+		return
+	}
+
+	approval, err := authentication.VerifyDeviceApprovalToken(r.PostForm.Get("token"))
+	if err != nil || approval.Subject != userID.String() {
+		log.Printf("[FAIL]: rejected device decision of user %s: %v\n", userID, err)
+		renderVerificationPage(w, http.StatusForbidden, verificationPage{Message: "This form expired, enter the code again."})
+		return
+	}
+
+	var approve bool
+	switch r.PostForm.Get("decision") {
+	case "approve":
+		approve = true
+	case "deny":
+	default:
+		renderVerificationPage(w, http.StatusBadRequest, verificationPage{Message: "Approve or deny the device."})
+		return
+	}
+
+	if approve && !handler.mayApproveDevice(w, r, userID) {
+		return
+	}
+
+	err = handler.dbService.Repo.DecideDeviceAuthorization(r.Context(), approval.UserCode, userID, authTime, approve)
+	if err != nil {
+		if errors.Is(err, repository.ErrDeviceCodeInvalid) {
+			renderVerificationPage(w, http.StatusNotFound, verificationPage{Message: "That code is invalid or expired, check the code shown on your device."})
+			return
+		}
+		log.Println(err)
+		renderVerificationPage(w, http.StatusInternalServerError, verificationPage{Message: "Something went wrong, try again."})
+		return
+	}
+
+	if !approve {
+		log.Printf("[SUCCESS]: user %s denied a device\n", userID)
+		renderVerificationPage(w, http.StatusOK, verificationPage{Message: "The device was denied."})
+		return
+	}
+
+	log.Printf("[SUCCESS]: user %s approved a device\n", userID)
+	renderVerificationPage(w, http.StatusOK, verificationPage{Message: "The device is connected, you can return to it."})
+}
+
+/*
+Evaluates the MFA policy for a user approving a device
+
+Params:
+  - w:      A http response writer
+  - r:      A pointer to a http request object
+  - userID: The signed-in user
+
+Returns:
+  - False if the user may not approve devices, the page is already written
+*/
+func (handler *AuthorizationHandler) mayApproveDevice(w http.ResponseWriter, r *http.Request, userID uuid.UUID) bool {
+	user, err := handler.dbService.Repo.GetUserByID(r.Context(), userID.String())
+	if err != nil {
+		log.Println(err)
+		renderVerificationPage(w, http.StatusInternalServerError, verificationPage{Message: "Something went wrong, try again."})
+		return false
+	}
+
+	decision, err := handler.mfaPolicy.Evaluate(r.Context(), handler.dbService, user)
+	if err != nil {
+		log.Println(err)
+		renderVerificationPage(w, http.StatusInternalServerError, verificationPage{Message: "Something went wrong, try again."})
+		return false
+	}
+	if decision.Blocked {
+		renderVerificationPage(w, http.StatusForbidden, verificationPage{Message: "Enroll a second factor before connecting devices."})
+		return false
+	}
+
+	return true
+}
+
+/*
+Renders the device verification page
+
+Params:
+  - w:      A http response writer
+  - status: The response status
+  - page:   What the page shows
+
+Returns:
+  - No return value
+*/
+func renderVerificationPage(w http.ResponseWriter, status int, page verificationPage) {
+	renderHTML(w, status, verificationTemplate, page)
+}
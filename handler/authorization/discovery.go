@@ -0,0 +1,143 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/dev-xero/authentication-backend/authserver"
+	"github.com/dev-xero/authentication-backend/model"
+)
+
+/*
+OpenID provider metadata struct, served at the discovery endpoint
+
+Fields:
+  - The endpoints and capabilities defined by OpenID Connect Discovery and RFC 8414
+*/
+type providerMetadata struct {
+	Issuer                            string               `json:"issuer"`
+	AuthorizationEndpoint             string               `json:"authorization_endpoint"`
+	TokenEndpoint                     string               `json:"token_endpoint"`
+	UserInfoEndpoint                  string               `json:"userinfo_endpoint"`
+	IntrospectionEndpoint             string               `json:"introspection_endpoint"`
+	RevocationEndpoint                string               `json:"revocation_endpoint"`
+	DeviceAuthorizationEndpoint       string               `json:"device_authorization_endpoint"`
+	JWKSURI                           string               `json:"jwks_uri"`
+	RegistrationEndpoint              string               `json:"registration_endpoint,omitempty"`
+	ScopesSupported                   []string             `json:"scopes_supported"`
+	ResponseTypesSupported            []string             `json:"response_types_supported"`
+	ResponseModesSupported            []string             `json:"response_modes_supported"`
+	GrantTypesSupported               []string             `json:"grant_types_supported"`
+	SubjectTypesSupported             []string             `json:"subject_types_supported"`
+	IDTokenSigningAlgValuesSupported  []string             `json:"id_token_signing_alg_values_supported"`
+	TokenEndpointAuthMethodsSupported []string             `json:"token_endpoint_auth_methods_supported"`
+	TokenEndpointAuthSigningAlgs      []string             `json:"token_endpoint_auth_signing_alg_values_supported"`
+	CodeChallengeMethodsSupported     []string             `json:"code_challenge_methods_supported"`
+	ClaimsSupported                   []string             `json:"claims_supported"`
+	DPoPSigningAlgValuesSupported     []string             `json:"dpop_signing_alg_values_supported"`
+	TLSCertificateBoundAccessTokens   bool                 `json:"tls_client_certificate_bound_access_tokens"`
+	MTLSEndpointAliases               *mtlsEndpointAliases `json:"mtls_endpoint_aliases,omitempty"`
+	AuthorizationResponseISSSupported bool                 `json:"authorization_response_iss_parameter_supported"`
+}
+
+/*
+Mutual TLS endpoint aliases struct, the endpoints of the TLS listener requesting client certificates, RFC 8705
+
+Fields:
+  - The endpoints clients authenticating with a certificate, or binding tokens to one, send requests to
+*/
+type mtlsEndpointAliases struct {
+	TokenEndpoint               string `json:"token_endpoint"`
+	UserInfoEndpoint            string `json:"userinfo_endpoint"`
+	IntrospectionEndpoint       string `json:"introspection_endpoint"`
+	RevocationEndpoint          string `json:"revocation_endpoint"`
+	DeviceAuthorizationEndpoint string `json:"device_authorization_endpoint"`
+}
+
+/*
+Key set struct, the public keys id tokens are verified with
+
+Fields:
+  - Keys: The JSON Web Keys
+*/
+type keySet struct {
+	Keys []authserver.JSONWebKey `json:"keys"`
+}
+
+/*
+Serves the OpenID provider metadata
+
+Params:
+  - w: A http response writer
+  - r: A pointer to a http request object
+
+Returns:
+  - No return value
+*/
+func (handler *AuthorizationHandler) Discovery(w http.ResponseWriter, r *http.Request) {
+	issuer := handler.config.Issuer
+
+	var registrationEndpoint string
+	if handler.config.Registration != nil {
+		registrationEndpoint = issuer + "/oauth/register"
+	}
+
+	authMethods := []string{model.ClientAuthSecretBasic, model.ClientAuthSecretPost, model.ClientAuthPrivateKey, model.ClientAuthNone}
+
+	// Certificates are only seen by the TLS listener, tls_client_auth also needs CAs to verify them with
+	var aliases *mtlsEndpointAliases
+	if base := handler.config.MTLSBaseURL; base != "" {
+		aliases = &mtlsEndpointAliases{
+			TokenEndpoint:               base + "/oauth/token",
+			UserInfoEndpoint:            base + "/oauth/userinfo",
+			IntrospectionEndpoint:       base + "/oauth/introspect",
+			RevocationEndpoint:          base + "/oauth/revoke",
+			DeviceAuthorizationEndpoint: base + "/oauth/device_authorization",
+		}
+		if handler.config.ClientCAs != nil {
+			authMethods = append(authMethods, model.ClientAuthTLS)
+		}
+		authMethods = append(authMethods, model.ClientAuthSelfSigned)
+	}
+
+	writeJSON(w, http.StatusOK, "public, max-age=3600", providerMetadata{
+		Issuer:                            issuer,
+		AuthorizationEndpoint:             issuer + "/oauth/authorize",
+		TokenEndpoint:                     issuer + "/oauth/token",
+		UserInfoEndpoint:                  issuer + "/oauth/userinfo",
+		IntrospectionEndpoint:             issuer + "/oauth/introspect",
+		RevocationEndpoint:                issuer + "/oauth/revoke",
+		DeviceAuthorizationEndpoint:       issuer + "/oauth/device_authorization",
+		JWKSURI:                           issuer + "/oauth/jwks",
+		RegistrationEndpoint:              registrationEndpoint,
+		ScopesSupported:                   []string{authserver.ScopeOpenID, authserver.ScopeProfile, authserver.ScopeEmail},
+		ResponseTypesSupported:            []string{"code"},
+		ResponseModesSupported:            []string{"query"},
+		GrantTypesSupported:               []string{model.GrantAuthorizationCode, model.GrantRefreshToken, model.GrantClientCredentials, model.GrantDeviceCode, model.GrantTokenExchange},
+		SubjectTypesSupported:             []string{"public"},
+		IDTokenSigningAlgValuesSupported:  []string{"RS256"},
+		TokenEndpointAuthMethodsSupported: authMethods,
+		TokenEndpointAuthSigningAlgs:      authserver.ClientAssertionAlgorithms(),
+		CodeChallengeMethodsSupported:     []string{authserver.CodeChallengeMethodS256},
+		ClaimsSupported:                   []string{"iss", "sub", "aud", "exp", "iat", "auth_time", "nonce", "at_hash", "azp", "preferred_username", "email", "email_verified"},
+		DPoPSigningAlgValuesSupported:     authserver.DPoPAlgorithms(),
+		TLSCertificateBoundAccessTokens:   aliases != nil,
+		MTLSEndpointAliases:               aliases,
+		AuthorizationResponseISSSupported: true,
+	})
+}
+
+/*
+Serves the key set id tokens are verified with
+
+Params:
+  - w: A http response writer
+  - r: A pointer to a http request object
+
+Returns:
+  - No return value
+*/
+func (handler *AuthorizationHandler) JWKS(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, "public, max-age=3600", keySet{
+		Keys: []authserver.JSONWebKey{handler.config.SigningKey.PublicJWK()},
+	})
+}
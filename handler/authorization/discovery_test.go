@@ -0,0 +1,123 @@
+package handler
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/dev-xero/authentication-backend/authserver"
+	"github.com/dev-xero/authentication-backend/model"
+)
+
+// Reports whether values holds value
+func containsValue(values []string, value string) bool {
+	for _, entry := range values {
+		if entry == value {
+			return true
+		}
+	}
+	return false
+}
+
+func TestDiscoveryDescribesTheIssuer(t *testing.T) {
+	handler := newTestHandler(t)
+
+	w := httptest.NewRecorder()
+	handler.Discovery(w, httptest.NewRequest(http.MethodGet, testIssuer+"/.well-known/openid-configuration", nil))
+
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("discovery responded %d with %q", w.Code, w.Header().Get("Content-Type"))
+	}
+	if w.Header().Get("Cache-Control") == "" {
+		t.Error("discovery document isn't cacheable")
+	}
+
+	var metadata providerMetadata
+	if err := json.NewDecoder(w.Body).Decode(&metadata); err != nil {
+		t.Fatal(err)
+	}
+
+	// Clients compare the issuer exactly with the iss of id tokens
+	if metadata.Issuer != testIssuer {
+		t.Errorf("issuer = %q, want %s", metadata.Issuer, testIssuer)
+	}
+
+	endpoints := map[string]string{
+		"authorization_endpoint": metadata.AuthorizationEndpoint,
+		"token_endpoint":         metadata.TokenEndpoint,
+		"userinfo_endpoint":      metadata.UserInfoEndpoint,
+		"jwks_uri":               metadata.JWKSURI,
+	}
+	paths := map[string]string{
+		"authorization_endpoint": "/oauth/authorize",
+		"token_endpoint":         "/oauth/token",
+		"userinfo_endpoint":      "/oauth/userinfo",
+		"jwks_uri":               "/oauth/jwks",
+	}
+	for name, endpoint := range endpoints {
+		if endpoint != testIssuer+paths[name] {
+			t.Errorf("%s = %q, want %s", name, endpoint, testIssuer+paths[name])
+		}
+	}
+
+	// PKCE is required with S256 only, and id tokens are signed with the RSA key
+	if len(metadata.CodeChallengeMethodsSupported) != 1 || metadata.CodeChallengeMethodsSupported[0] != authserver.CodeChallengeMethodS256 {
+		t.Errorf("code_challenge_methods_supported = %v", metadata.CodeChallengeMethodsSupported)
+	}
+	if len(metadata.IDTokenSigningAlgValuesSupported) != 1 || metadata.IDTokenSigningAlgValuesSupported[0] != "RS256" {
+		t.Errorf("id_token_signing_alg_values_supported = %v", metadata.IDTokenSigningAlgValuesSupported)
+	}
+	if len(metadata.ResponseTypesSupported) != 1 || metadata.ResponseTypesSupported[0] != "code" {
+		t.Errorf("response_types_supported = %v", metadata.ResponseTypesSupported)
+	}
+
+	for _, grant := range []string{model.GrantAuthorizationCode, model.GrantRefreshToken} {
+		if !containsValue(metadata.GrantTypesSupported, grant) {
+			t.Errorf("grant_types_supported %v is missing %s", metadata.GrantTypesSupported, grant)
+		}
+	}
+	for _, scope := range []string{authserver.ScopeOpenID, authserver.ScopeProfile, authserver.ScopeEmail} {
+		if !containsValue(metadata.ScopesSupported, scope) {
+			t.Errorf("scopes_supported %v is missing %s", metadata.ScopesSupported, scope)
+		}
+	}
+	for _, claim := range []string{"sub", "nonce", "at_hash", "email", "email_verified"} {
+		if !containsValue(metadata.ClaimsSupported, claim) {
+			t.Errorf("claims_supported %v is missing %s", metadata.ClaimsSupported, claim)
+		}
+	}
+	if !metadata.AuthorizationResponseISSSupported {
+		t.Error("authorization_response_iss_parameter_supported is false")
+	}
+}
+
+func TestJWKSPublishesTheSigningKey(t *testing.T) {
+	handler := newTestHandler(t)
+
+	w := httptest.NewRecorder()
+	handler.JWKS(w, httptest.NewRequest(http.MethodGet, testIssuer+"/oauth/jwks", nil))
+
+	var keys keySet
+	if err := json.NewDecoder(w.Body).Decode(&keys); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusOK || len(keys.Keys) != 1 {
+		t.Fatalf("jwks responded %d with %d keys", w.Code, len(keys.Keys))
+	}
+
+	key := keys.Keys[0]
+	if key.KeyID != handler.config.SigningKey.ID || key.KeyType != "RSA" || key.Algorithm != "RS256" || key.Use != "sig" {
+		t.Errorf("unexpected key %+v", key)
+	}
+
+	modulus, err := base64.RawURLEncoding.DecodeString(key.Modulus)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if new(big.Int).SetBytes(modulus).Cmp(handler.config.SigningKey.Key.N) != 0 {
+		t.Error("published modulus isn't the signing key's")
+	}
+}
@@ -0,0 +1,211 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/dev-xero/authentication-backend/authentication"
+	"github.com/dev-xero/authentication-backend/authserver"
+	"github.com/dev-xero/authentication-backend/model"
+	repository "github.com/dev-xero/authentication-backend/repository/user"
+)
+
+// Authorization scheme and token type of access tokens bound to a DPoP key
+const tokenTypeDPoP = "DPoP"
+
+/*
+Verifies the DPoP proof of a token request, tokens issued for the request are bound to its key
+
+Objectives:
+  - Require a proof from clients registered with dpop_bound_access_tokens, for others it is optional
+  - Check the proof was created for the token endpoint, and record its id so it can't be replayed
+
+Params:
+  - w:      A http response writer
+  - r:      A pointer to a http request object
+  - client: The authenticated client
+
+Returns:
+  - The thumbprint of the proof key, empty when the request carries no proof
+  - False if the proof is missing or invalid, the error response is already written
+*/
+func (handler *AuthorizationHandler) tokenRequestProof(w http.ResponseWriter, r *http.Request, client model.OAuthClient) (string, bool) {
+	proof, err := authserver.DPoPProofFromRequest(r)
+	if err != nil {
+		writeOAuthError(w, http.StatusBadRequest, errorInvalidDPoPProof, "send a single DPoP proof")
+		return "", false
+	}
+
+	if proof == "" {
+		if client.DPoPBoundAccessTokens {
+			writeOAuthError(w, http.StatusBadRequest, errorInvalidDPoPProof, "client must send a DPoP proof")
+			return "", false
+		}
+		return "", true
+	}
+
+	verified, err := authserver.VerifyDPoPProof(proof, http.MethodPost, handler.endpointURL(r, "/oauth/token"), "")
+	if err != nil {
+		log.Printf("[FAIL]: client %s sent an invalid dpop proof: %v\n", client.ID, err)
+		writeOAuthError(w, http.StatusBadRequest, errorInvalidDPoPProof, "DPoP proof is invalid")
+		return "", false
+	}
+
+	if err := handler.dbService.Repo.ConsumeDPoPProof(r.Context(), verified.KeyThumbprint, verified.ID, verified.ExpiresAt()); err != nil {
+		if errors.Is(err, repository.ErrDPoPProofUsed) {
+			log.Printf("[FAIL]: client %s replayed a dpop proof\n", client.ID)
+			writeOAuthError(w, http.StatusBadRequest, errorInvalidDPoPProof, err.Error())
+			return "", false
+		}
+		log.Println(err)
+		writeOAuthError(w, http.StatusInternalServerError, errorServerError, "failed to record DPoP proof")
+		return "", false
+	}
+
+	return verified.KeyThumbprint, true
+}
+
+/*
+Checks an access token presented to an endpoint of the authorization server is presented by its holder
+
+Objectives:
+  - Accept bearer tokens with the Bearer scheme only, and DPoP bound tokens with the DPoP scheme only
+  - Verify the proof of a bound token was created for this request and token, with the key the token is bound to
+  - Record the proof id so it can't be replayed
+
+Params:
+  - w:           A http response writer
+  - r:           A pointer to a http request object
+  - claims:      The verified access token claims
+  - scheme:      The authorization scheme the token was sent with
+  - accessToken: The access token
+  - targetURL:   The URL of the endpoint, as the client sees it
+
+Returns:
+  - False if the token may not be used, the error response is already written
+*/
+func (handler *AuthorizationHandler) verifyTokenPresentation(w http.ResponseWriter, r *http.Request, claims authentication.AccessTokenClaims, scheme string, accessToken string, targetURL string) bool {
+	thumbprint := claims.DPoPKeyThumbprint()
+	if thumbprint == "" {
+		if scheme == tokenTypeDPoP {
+			writeDPoPError(w, http.StatusUnauthorized, errorInvalidToken, "access token is not bound to a DPoP key")
+			return false
+		}
+		return true
+	}
+
+	if scheme != tokenTypeDPoP {
+		writeDPoPError(w, http.StatusUnauthorized, errorInvalidToken, "DPoP bound access tokens must be sent with the DPoP scheme")
+		return false
+	}
+
+	proof, err := authserver.DPoPProofFromRequest(r)
+	if err != nil || proof == "" {
+		writeDPoPError(w, http.StatusUnauthorized, errorInvalidDPoPProof, "send a single DPoP proof")
+		return false
+	}
+
+	verified, err := authserver.VerifyDPoPProof(proof, r.Method, targetURL, accessToken)
+	if err != nil {
+		log.Printf("[FAIL]: client %s sent an invalid dpop proof: %v\n", claims.ClientID, err)
+		writeDPoPError(w, http.StatusUnauthorized, errorInvalidDPoPProof, "DPoP proof is invalid")
+		return false
+	}
+
+	if verified.KeyThumbprint != thumbprint {
+		log.Printf("[FAIL]: dpop proof for a token of client %s was signed with another key\n", claims.ClientID)
+		writeDPoPError(w, http.StatusUnauthorized, errorInvalidDPoPProof, "DPoP proof was signed with another key than the token is bound to")
+		return false
+	}
+
+	if err := handler.dbService.Repo.ConsumeDPoPProof(r.Context(), verified.KeyThumbprint, verified.ID, verified.ExpiresAt()); err != nil {
+		if errors.Is(err, repository.ErrDPoPProofUsed) {
+			writeDPoPError(w, http.StatusUnauthorized, errorInvalidDPoPProof, err.Error())
+			return false
+		}
+		log.Println(err)
+		writeOAuthError(w, http.StatusInternalServerError, errorServerError, "failed to record DPoP proof")
+		return false
+	}
+
+	return true
+}
+
+/*
+Reads the access token of the Authorization header, sent with the Bearer or DPoP scheme
+
+Params:
+  - r: A pointer to a http request object
+
+Returns:
+  - The token
+  - The scheme, Bearer or DPoP
+  - False if the request has no access token
+*/
+func accessTokenFromRequest(r *http.Request) (string, string, bool) {
+	if token, ok := bearerToken(r); ok {
+		return token, "Bearer", true
+	}
+
+	scheme, token, found := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !found || !strings.EqualFold(scheme, tokenTypeDPoP) {
+		return "", "", false
+	}
+
+	token = strings.TrimSpace(token)
+	return token, tokenTypeDPoP, token != ""
+}
+
+/*
+Returns the token type of an access token, reported in token and introspection responses
+
+Params:
+  - claims: The access token claims
+
+Returns:
+  - DPoP for tokens bound to a DPoP key, Bearer otherwise
+*/
+func accessTokenType(claims authentication.AccessTokenClaims) string {
+	if claims.DPoPKeyThumbprint() != "" {
+		return tokenTypeDPoP
+	}
+
+	return "Bearer"
+}
+
+/*
+Binds access token claims to a DPoP key or client certificate
+
+Params:
+  - claims:  A pointer to the access token claims
+  - binding: The DPoP key and client certificate thumbprints, empty for bearer tokens
+
+Returns:
+  - No return value
+*/
+func bindConfirmation(claims *authentication.AccessTokenClaims, binding authentication.Confirmation) {
+	if binding != (authentication.Confirmation{}) {
+		claims.Confirmation = &binding
+	}
+}
+
+/*
+Writes a RFC 9449 error, challenging for a DPoP bound token
+
+Params:
+  - w:           A http response writer
+  - status:      The response status
+  - code:        The error code
+  - description: A description for the client developer
+
+Returns:
+  - No return value
+*/
+func writeDPoPError(w http.ResponseWriter, status int, code string, description string) {
+	algorithms := strings.Join(authserver.DPoPAlgorithms(), " ")
+	w.Header().Set("WWW-Authenticate", fmt.Sprintf(`DPoP realm="oauth", error=%q, error_description=%q, algs=%q`, code, description, algorithms))
+	writeOAuthError(w, status, code, description)
+}
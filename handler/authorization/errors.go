@@ -0,0 +1,170 @@
+package handler
+
+import (
+	"encoding/json"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+)
+
+// Error codes of RFC 6749, sent to clients instead of the API response format
+const (
+	errorInvalidRequest          = "invalid_request"
+	errorInvalidClient           = "invalid_client"
+	errorInvalidGrant            = "invalid_grant"
+	errorInvalidScope            = "invalid_scope"
+	errorInvalidTarget           = "invalid_target"
+	errorUnauthorizedClient      = "unauthorized_client"
+	errorUnsupportedGrantType    = "unsupported_grant_type"
+	errorUnsupportedResponseType = "unsupported_response_type"
+	errorUnsupportedTokenType    = "unsupported_token_type"
+	errorAccessDenied            = "access_denied"
+	errorLoginRequired           = "login_required"
+	errorConsentRequired         = "consent_required"
+	errorInvalidToken            = "invalid_token"
+	errorInsufficientScope       = "insufficient_scope"
+	errorServerError             = "server_error"
+)
+
+// Error codes of RFC 8628, the token endpoint answers device polls with them
+const (
+	errorAuthorizationPending = "authorization_pending"
+	errorSlowDown             = "slow_down"
+	errorExpiredToken         = "expired_token"
+)
+
+// Error code of RFC 9449, for DPoP proofs that are missing, invalid or replayed
+const errorInvalidDPoPProof = "invalid_dpop_proof"
+
+/*
+OAuth error response struct
+
+Fields:
+  - Error:            string, the error code
+  - ErrorDescription: string, a description for the client developer
+*/
+type oauthError struct {
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description,omitempty"`
+}
+
+/*
+Writes a token endpoint response, responses carry tokens and must not be cached
+
+Params:
+  - w:       A http response writer
+  - status:  The response status
+  - payload: The response body
+
+Returns:
+  - No return value
+*/
+func writeOAuthJSON(w http.ResponseWriter, status int, payload interface{}) {
+	w.Header().Set("Pragma", "no-cache")
+	writeJSON(w, status, "no-store", payload)
+}
+
+/*
+Writes a JSON response in the format of the OAuth and OpenID Connect specifications
+
+Params:
+  - w:            A http response writer
+  - status:       The response status
+  - cacheControl: The Cache-Control header
+  - payload:      The response body
+
+Returns:
+  - No return value
+*/
+func writeJSON(w http.ResponseWriter, status int, cacheControl string, payload interface{}) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		log.Println("[FAIL]: failed to encode oauth response:", err)
+		http.Error(w, "server_error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", cacheControl)
+	w.WriteHeader(status)
+	w.Write(body)
+}
+
+/*
+Writes an OAuth error response
+
+Params:
+  - w:           A http response writer
+  - status:      The response status
+  - code:        The error code
+  - description: A description for the client developer
+
+Returns:
+  - No return value
+*/
+func writeOAuthError(w http.ResponseWriter, status int, code string, description string) {
+	writeOAuthJSON(w, status, oauthError{Error: code, ErrorDescription: description})
+}
+
+/*
+Redirects an authorization response to the client
+
+Objectives:
+  - Keep the query the redirect URI was registered with
+  - Add the response parameters, the state and the issuer, so clients can tell authorization servers apart
+
+Params:
+  - w:           A http response writer
+  - r:           A pointer to a http request object
+  - redirectURI: The verified redirect URI
+  - params:      The response parameters
+
+Returns:
+  - No return value
+*/
+func redirectToClient(w http.ResponseWriter, r *http.Request, redirectURI string, params url.Values) {
+	target, err := url.Parse(redirectURI)
+	if err != nil {
+		log.Println("[FAIL]: registered redirect uri is malformed:", err)
+		writeOAuthError(w, http.StatusInternalServerError, errorServerError, "redirect uri is malformed")
+		return
+	}
+
+	query := target.Query()
+	for key, values := range params {
+		for _, value := range values {
+			if value != "" {
+				query.Add(key, value)
+			}
+		}
+	}
+	target.RawQuery = query.Encode()
+
+	w.Header().Set("Cache-Control", "no-store")
+	http.Redirect(w, r, target.String(), http.StatusFound)
+}
+
+/*
+Renders a page shown to users, pages with forms must not be framed or cached
+
+Params:
+  - w:      A http response writer
+  - status: The response status
+  - page:   The page template
+  - data:   What the page shows
+
+Returns:
+  - No return value
+*/
+func renderHTML(w http.ResponseWriter, status int, page *template.Template, data interface{}) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Frame-Options", "DENY")
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; form-action 'self'; frame-ancestors 'none'")
+	w.WriteHeader(status)
+
+	if err := page.Execute(w, data); err != nil {
+		log.Println("[FAIL]: failed to render page:", err)
+	}
+}
@@ -0,0 +1,25 @@
+package handler
+
+import (
+	"github.com/dev-xero/authentication-backend/authserver"
+	"github.com/dev-xero/authentication-backend/mfa"
+	"github.com/dev-xero/authentication-backend/service"
+)
+
+type AuthorizationHandler struct {
+	dbService *service.DatabaseProvider
+	mfaPolicy *mfa.Policy
+	config    *authserver.Config
+}
+
+func (handler *AuthorizationHandler) WithService(service *service.DatabaseProvider) {
+	handler.dbService = service
+}
+
+func (handler *AuthorizationHandler) WithMFAPolicy(policy *mfa.Policy) {
+	handler.mfaPolicy = policy
+}
+
+func (handler *AuthorizationHandler) WithConfig(config *authserver.Config) {
+	handler.config = config
+}
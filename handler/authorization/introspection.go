@@ -0,0 +1,215 @@
+package handler
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/dev-xero/authentication-backend/authentication"
+	"github.com/dev-xero/authentication-backend/model"
+	repository "github.com/dev-xero/authentication-backend/repository/user"
+	"github.com/dev-xero/authentication-backend/util"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Token type hints of RFC 7009, also reported by introspection
+const (
+	tokenTypeAccessToken  = "access_token"
+	tokenTypeRefreshToken = "refresh_token"
+)
+
+/*
+Introspection response struct, RFC 7662
+
+Fields:
+  - Active:       bool, whether the token can be used, the other fields are only set for active tokens
+  - Scope:        string, the granted scopes, space separated
+  - ClientID:     string, the client the token was issued to
+  - Subject:      string, the user id, or the client id for clients acting for themselves
+  - Audience:     The audiences of an access token
+  - Issuer:       string
+  - ExpiresAt:    int64, seconds since the epoch
+  - IssuedAt:     int64, seconds since the epoch
+  - TokenID:      string, the jti of an access token
+  - TokenType:    string, Bearer, DPoP or refresh_token
+  - Actor:        The delegation chain of an exchanged access token
+  - Confirmation: The key a sender-constrained access token is bound to
+*/
+type introspectionResponse struct {
+	Active       bool                         `json:"active"`
+	Scope        string                       `json:"scope,omitempty"`
+	ClientID     string                       `json:"client_id,omitempty"`
+	Subject      string                       `json:"sub,omitempty"`
+	Audience     jwt.ClaimStrings             `json:"aud,omitempty"`
+	Issuer       string                       `json:"iss,omitempty"`
+	ExpiresAt    int64                        `json:"exp,omitempty"`
+	IssuedAt     int64                        `json:"iat,omitempty"`
+	TokenID      string                       `json:"jti,omitempty"`
+	TokenType    string                       `json:"token_type,omitempty"`
+	Actor        *authentication.Actor        `json:"act,omitempty"`
+	Confirmation *authentication.Confirmation `json:"cnf,omitempty"`
+}
+
+/*
+Handles introspection requests, for services that ask whether a token is still good instead of verifying it themselves
+
+Objectives:
+  - Authenticate the caller, only confidential clients may introspect
+  - Verify an access token like the API does, then check it wasn't revoked on its own or with its grant
+  - Look refresh tokens up, they are only disclosed to the client they were issued to
+  - Respond with active false for anything else, without saying why
+
+Params:
+  - w: A http response writer
+  - r: A pointer to a http request object
+
+Returns:
+  - No return value
+*/
+func (handler *AuthorizationHandler) Introspect(w http.ResponseWriter, r *http.Request) {
+	if !parseClientForm(w, r) {
+		return
+	}
+
+	client, ok := handler.authenticateClient(w, r)
+	if !ok {
+		return
+	}
+
+	if client.TokenEndpointAuthMethod == model.ClientAuthNone {
+		writeOAuthError(w, http.StatusUnauthorized, errorInvalidClient, "public clients may not introspect tokens")
+		return
+	}
+
+	token := r.PostForm.Get("token")
+	if token == "" {
+		writeOAuthError(w, http.StatusBadRequest, errorInvalidRequest, "token is missing")
+		return
+	}
+
+	var (
+		response introspectionResponse
+		err      error
+	)
+
+	if looksLikeJWT(token) {
+		response, err = handler.introspectAccessToken(r, token)
+	} else {
+		response, err = handler.introspectRefreshToken(r, client, token)
+	}
+	if err != nil {
+		log.Println(err)
+		writeOAuthError(w, http.StatusInternalServerError, errorServerError, "failed to introspect token")
+		return
+	}
+
+	writeOAuthJSON(w, http.StatusOK, response)
+}
+
+/*
+Introspects an access token
+
+Params:
+  - r:     A pointer to a http request object
+  - token: The access token
+
+Returns:
+  - The introspection response, inactive if the token is invalid, expired, from another issuer or revoked
+  - An error if the revocation state couldn't be read
+*/
+func (handler *AuthorizationHandler) introspectAccessToken(r *http.Request, token string) (introspectionResponse, error) {
+	claims, err := authentication.InspectAccessToken(token)
+	if err != nil || claims.Issuer != handler.config.Issuer {
+		return introspectionResponse{}, nil
+	}
+
+	revoked, err := handler.accessTokenRevoked(r, claims)
+	if err != nil || revoked {
+		return introspectionResponse{}, err
+	}
+
+	return introspectionResponse{
+		Active:       true,
+		Scope:        claims.Scope,
+		ClientID:     claims.ClientID,
+		Subject:      claims.Subject,
+		Audience:     claims.Audience,
+		Issuer:       claims.Issuer,
+		ExpiresAt:    claims.ExpiresAt.Unix(),
+		IssuedAt:     claims.IssuedAt.Unix(),
+		TokenID:      claims.ID,
+		TokenType:    accessTokenType(claims),
+		Actor:        claims.Actor,
+		Confirmation: claims.Confirmation,
+	}, nil
+}
+
+/*
+Introspects a refresh token
+
+Params:
+  - r:      A pointer to a http request object
+  - client: The authenticated caller
+  - token:  The refresh token
+
+Returns:
+  - The introspection response, inactive if the token is unknown, used, revoked, expired or issued to another client
+  - An error if the token couldn't be looked up
+*/
+func (handler *AuthorizationHandler) introspectRefreshToken(r *http.Request, client model.OAuthClient, token string) (introspectionResponse, error) {
+	refreshToken, active, err := handler.dbService.Repo.GetRefreshToken(r.Context(), util.HashToken(token))
+	if err != nil {
+		if errors.Is(err, repository.ErrRefreshTokenInvalid) {
+			return introspectionResponse{}, nil
+		}
+		return introspectionResponse{}, err
+	}
+
+	if !active || refreshToken.ClientID != client.ID {
+		return introspectionResponse{}, nil
+	}
+
+	return introspectionResponse{
+		Active:    true,
+		Scope:     refreshToken.Scope,
+		ClientID:  refreshToken.ClientID,
+		Subject:   refreshToken.UserID.String(),
+		Issuer:    handler.config.Issuer,
+		ExpiresAt: refreshToken.ExpiresAt.Unix(),
+		TokenType: tokenTypeRefreshToken,
+	}, nil
+}
+
+/*
+Checks whether an access token was revoked, on its own, with the refresh token family of its grant, or with the grant itself
+
+Params:
+  - r:      A pointer to a http request object
+  - claims: The verified access token claims
+
+Returns:
+  - True if the token is revoked
+  - An error if the revocation state couldn't be read
+*/
+func (handler *AuthorizationHandler) accessTokenRevoked(r *http.Request, claims authentication.AccessTokenClaims) (bool, error) {
+	familyID, userID, issuedAt, err := claims.RevocationScope()
+	if err != nil {
+		return true, nil
+	}
+
+	return handler.dbService.Repo.AccessTokenRevoked(r.Context(), claims.ID, familyID, userID, claims.ClientID, issuedAt)
+}
+
+/*
+Tells access tokens apart from refresh tokens, access tokens are JWTs and refresh tokens random base64url strings
+
+Params:
+  - token: The token
+
+Returns:
+  - True if the token has the three parts of a JWT
+*/
+func looksLikeJWT(token string) bool {
+	return strings.Count(token, ".") == 2
+}
@@ -0,0 +1,78 @@
+package handler
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/dev-xero/authentication-backend/authentication"
+	"github.com/dev-xero/authentication-backend/authserver"
+	"github.com/dev-xero/authentication-backend/model"
+)
+
+/*
+Authenticates a tls_client_auth or self_signed_tls_client_auth client by the certificate it presented to the TLS listener
+
+Params:
+  - w:      A http response writer
+  - r:      A pointer to a http request object
+  - client: The client named by the client_id parameter
+
+Returns:
+  - False if the certificate is missing or doesn't authenticate the client, the error response is already written
+*/
+func (handler *AuthorizationHandler) verifyClientCertificate(w http.ResponseWriter, r *http.Request, client model.OAuthClient) bool {
+	if r.TLS == nil {
+		log.Printf("[FAIL]: client %s authenticates with a certificate, but didn't connect to the TLS listener\n", client.ID)
+		writeInvalidClient(w, false)
+		return false
+	}
+
+	if err := authserver.VerifyClientCertificate(r.TLS.PeerCertificates, client, handler.config.ClientCAs); err != nil {
+		log.Println(err)
+		writeInvalidClient(w, false)
+		return false
+	}
+
+	return true
+}
+
+/*
+Checks a certificate bound access token is presented over a connection authenticated with the same certificate
+
+Params:
+  - w:      A http response writer
+  - r:      A pointer to a http request object
+  - claims: The verified access token claims
+
+Returns:
+  - False if the token is bound to another certificate, the error response is already written
+*/
+func verifyCertificateBinding(w http.ResponseWriter, r *http.Request, claims authentication.AccessTokenClaims) bool {
+	thumbprint := claims.CertificateThumbprint()
+	if thumbprint == "" {
+		return true
+	}
+
+	cert, ok := authserver.ClientCertificate(r)
+	if !ok || authserver.CertificateThumbprint(cert) != thumbprint {
+		log.Printf("[FAIL]: certificate bound token of client %s presented without its certificate\n", claims.ClientID)
+		writeBearerError(w, http.StatusUnauthorized, errorInvalidToken, "access token is bound to another client certificate")
+		return false
+	}
+
+	return true
+}
+
+/*
+Returns the URL of an authorization server endpoint, as the client that sent a request sees it
+
+Params:
+  - r:    A pointer to a http request object
+  - path: The path of the endpoint below the issuer, e.g. /oauth/token
+
+Returns:
+  - The endpoint below the mutual TLS URL for requests received by the TLS listener, below the issuer otherwise
+*/
+func (handler *AuthorizationHandler) endpointURL(r *http.Request, path string) string {
+	return handler.config.BaseURL(r) + path
+}
@@ -0,0 +1,136 @@
+package handler
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dev-xero/authentication-backend/authentication"
+	"github.com/dev-xero/authentication-backend/authserver"
+	"github.com/dev-xero/authentication-backend/internal/testutil"
+	"github.com/dev-xero/authentication-backend/model"
+	"github.com/google/uuid"
+)
+
+// A request received by the TLS listener, with certs as the client certificates
+func newTLSRequest(method string, target string, certs ...*x509.Certificate) *http.Request {
+	r := httptest.NewRequest(method, target, nil)
+	r.TLS = &tls.ConnectionState{PeerCertificates: certs}
+	return r
+}
+
+func TestCreateTokensBindsClientCertificate(t *testing.T) {
+	handler := newTestHandler(t)
+	ca := testutil.NewCA(t, "Local Development CA")
+	cert := testutil.NewClientCertificate(t, &ca, x509.ExtKeyUsageClientAuth).Cert
+	thumbprint := authserver.CertificateThumbprint(cert)
+
+	response, err := handler.createTokens(model.OAuthClient{ID: "billing"}, uuid.New(), uuid.New(), "", time.Now(), "", authentication.Confirmation{CertificateThumbprint: thumbprint})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Certificate bound tokens keep the Bearer type, only DPoP changes it
+	if response.TokenType != "Bearer" {
+		t.Errorf("token type = %q", response.TokenType)
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(strings.Split(response.AccessToken, ".")[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var claims struct {
+		Confirmation map[string]string `json:"cnf"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		t.Fatal(err)
+	}
+	if claims.Confirmation["x5t#S256"] != thumbprint || len(claims.Confirmation) != 1 {
+		t.Fatalf("cnf = %v, want x5t#S256 %s", claims.Confirmation, thumbprint)
+	}
+}
+
+func TestVerifyCertificateBinding(t *testing.T) {
+	ca := testutil.NewCA(t, "Local Development CA")
+	cert := testutil.NewClientCertificate(t, &ca, x509.ExtKeyUsageClientAuth).Cert
+	other := testutil.NewClientCertificate(t, &ca, x509.ExtKeyUsageClientAuth).Cert
+
+	bound := authentication.AccessTokenClaims{ClientID: "billing", Confirmation: &authentication.Confirmation{CertificateThumbprint: authserver.CertificateThumbprint(cert)}}
+	unbound := authentication.AccessTokenClaims{ClientID: "billing"}
+
+	tests := []struct {
+		name   string
+		claims authentication.AccessTokenClaims
+		r      *http.Request
+		ok     bool
+	}{
+		{name: "bound token with its certificate", claims: bound, r: newTLSRequest(http.MethodGet, "https://localhost:8443/oauth/userinfo", cert), ok: true},
+		{name: "bound token with another certificate", claims: bound, r: newTLSRequest(http.MethodGet, "https://localhost:8443/oauth/userinfo", other)},
+		{name: "bound token without a certificate", claims: bound, r: newTLSRequest(http.MethodGet, "https://localhost:8443/oauth/userinfo")},
+		{name: "bound token without tls", claims: bound, r: httptest.NewRequest(http.MethodGet, testIssuer+"/oauth/userinfo", nil)},
+		{name: "unbound token without tls", claims: unbound, r: httptest.NewRequest(http.MethodGet, testIssuer+"/oauth/userinfo", nil), ok: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			if ok := verifyCertificateBinding(w, test.r, test.claims); ok != test.ok {
+				t.Fatalf("verifyCertificateBinding = %v, want %v", ok, test.ok)
+			}
+
+			if !test.ok && (w.Code != http.StatusUnauthorized || !strings.Contains(w.Header().Get("WWW-Authenticate"), `error="invalid_token"`)) {
+				t.Fatalf("responded %d, challenge %q", w.Code, w.Header().Get("WWW-Authenticate"))
+			}
+		})
+	}
+}
+
+func TestVerifyClientCertificateRejectsMismatchedCertificate(t *testing.T) {
+	handler := newTestHandler(t)
+	ca := testutil.NewCA(t, "Local Development CA")
+	cert := testutil.NewClientCertificate(t, &ca, x509.ExtKeyUsageClientAuth).Cert
+	otherCA := testutil.NewCA(t, "Other CA")
+	untrusted := testutil.NewClientCertificate(t, &otherCA, x509.ExtKeyUsageClientAuth).Cert
+
+	handler.config.MTLSBaseURL = "https://localhost:8443"
+	handler.config.ClientCAs = x509.NewCertPool()
+	handler.config.ClientCAs.AddCert(ca.Cert)
+
+	client := model.OAuthClient{ID: "billing", TokenEndpointAuthMethod: model.ClientAuthTLS, TLSSubjectDN: cert.Subject.String()}
+
+	if !handler.verifyClientCertificate(httptest.NewRecorder(), newTLSRequest(http.MethodPost, "https://localhost:8443/oauth/token", cert), client) {
+		t.Fatal("trusted certificate of the client was rejected")
+	}
+
+	tests := []struct {
+		name string
+		r    *http.Request
+	}{
+		{name: "untrusted certificate", r: newTLSRequest(http.MethodPost, "https://localhost:8443/oauth/token", untrusted)},
+		{name: "no certificate", r: newTLSRequest(http.MethodPost, "https://localhost:8443/oauth/token")},
+		{name: "without tls", r: httptest.NewRequest(http.MethodPost, testIssuer+"/oauth/token", nil)},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			if handler.verifyClientCertificate(w, test.r, client) {
+				t.Fatal("client was authenticated")
+			}
+
+			var failure oauthError
+			if err := json.NewDecoder(w.Body).Decode(&failure); err != nil {
+				t.Fatal(err)
+			}
+			if w.Code != http.StatusUnauthorized || failure.Error != errorInvalidClient {
+				t.Fatalf("responded %d %+v, want invalid_client", w.Code, failure)
+			}
+		})
+	}
+}
@@ -0,0 +1,496 @@
+package handler
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/dev-xero/authentication-backend/authserver"
+	"github.com/dev-xero/authentication-backend/model"
+	repository "github.com/dev-xero/authentication-backend/repository/user"
+	"github.com/dev-xero/authentication-backend/util"
+	"github.com/go-chi/chi/v5"
+)
+
+// Registration requests carry a key set at most
+const maxRegistrationRequestSize = 64 << 10
+
+/*
+Client metadata struct, the registration request and response of RFC 7591 and RFC 7592
+
+Fields:
+  - The client metadata defined by RFC 7591, and the credentials and registration details of the response
+*/
+type clientMetadata struct {
+	ClientID                string          `json:"client_id,omitempty"`
+	ClientSecret            string          `json:"client_secret,omitempty"`
+	ClientIDIssuedAt        int64           `json:"client_id_issued_at,omitempty"`
+	ClientSecretExpiresAt   *int64          `json:"client_secret_expires_at,omitempty"`
+	RegistrationAccessToken string          `json:"registration_access_token,omitempty"`
+	RegistrationClientURI   string          `json:"registration_client_uri,omitempty"`
+	RedirectURIs            []string        `json:"redirect_uris,omitempty"`
+	TokenEndpointAuthMethod string          `json:"token_endpoint_auth_method,omitempty"`
+	GrantTypes              []string        `json:"grant_types,omitempty"`
+	ResponseTypes           []string        `json:"response_types,omitempty"`
+	ClientName              string          `json:"client_name,omitempty"`
+	ClientURI               string          `json:"client_uri,omitempty"`
+	LogoURI                 string          `json:"logo_uri,omitempty"`
+	Scope                   string          `json:"scope,omitempty"`
+	Contacts                []string        `json:"contacts,omitempty"`
+	TOSURI                  string          `json:"tos_uri,omitempty"`
+	PolicyURI               string          `json:"policy_uri,omitempty"`
+	JWKS                    json.RawMessage `json:"jwks,omitempty"`
+	JWKSURI                 string          `json:"jwks_uri,omitempty"`
+	Audiences               []string        `json:"audiences,omitempty"`
+	DPoPBoundAccessTokens   bool            `json:"dpop_bound_access_tokens,omitempty"`
+	TLSSubjectDN            string          `json:"tls_client_auth_subject_dn,omitempty"`
+	TLSSANDNS               string          `json:"tls_client_auth_san_dns,omitempty"`
+}
+
+/*
+Handles client registration requests
+
+Objectives:
+  - Require the initial access token as a bearer token
+  - Validate the client metadata, and check it against the registration policy
+  - Generate the client id, a secret for clients authenticating with one, and a registration access token
+  - Store the client with the secrets hashed and respond with the credentials once
+
+Params:
+  - w: A http response writer
+  - r: A pointer to a http request object
+
+Returns:
+  - No return value
+*/
+func (handler *AuthorizationHandler) Register(w http.ResponseWriter, r *http.Request) {
+	policy := handler.config.Registration
+	if policy == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	token, ok := bearerToken(r)
+	if !ok || !policy.AuthorizesRegistration(token) {
+		writeBearerError(w, http.StatusUnauthorized, errorInvalidToken, "initial access token is missing or invalid")
+		return
+	}
+
+	metadata, ok := decodeClientMetadata(w, r)
+	if !ok {
+		return
+	}
+	if metadata.ClientID != "" || metadata.ClientSecret != "" {
+		writeOAuthError(w, http.StatusBadRequest, authserver.MetadataErrorInvalidMetadata, "client_id and client_secret are issued by the server")
+		return
+	}
+
+	client, ok := handler.clientFromMetadata(w, metadata)
+	if !ok {
+		return
+	}
+
+	clientID, err := util.GenerateRandomToken(16)
+	if err != nil {
+		log.Println(err)
+		writeOAuthError(w, http.StatusInternalServerError, errorServerError, "failed to register client")
+		return
+	}
+	client.ID = clientID
+
+	secret, err := issueClientSecret(&client)
+	if err != nil {
+		log.Println(err)
+		writeOAuthError(w, http.StatusInternalServerError, errorServerError, "failed to register client")
+		return
+	}
+
+	registrationToken, err := util.GenerateRandomToken(32)
+	if err != nil {
+		log.Println(err)
+		writeOAuthError(w, http.StatusInternalServerError, errorServerError, "failed to register client")
+		return
+	}
+	client.RegistrationTokenHash = util.HashToken(registrationToken)
+
+	if err := handler.dbService.Repo.CreateOAuthClient(r.Context(), client); err != nil {
+		log.Println(err)
+		writeOAuthError(w, http.StatusInternalServerError, errorServerError, "failed to register client")
+		return
+	}
+
+	// The stored client has its creation time, the response is built from it
+	client, err = handler.dbService.Repo.GetOAuthClient(r.Context(), client.ID)
+	if err != nil {
+		log.Println(err)
+		writeOAuthError(w, http.StatusInternalServerError, errorServerError, "failed to register client")
+		return
+	}
+
+	response := handler.metadataFromClient(client)
+	response.ClientSecret = secret
+	response.RegistrationAccessToken = registrationToken
+
+	log.Printf("[SUCCESS]: registered client %s (%q)\n", client.ID, client.Name)
+	writeOAuthJSON(w, http.StatusCreated, response)
+}
+
+/*
+Handles reading the registration of a client, with its registration access token
+
+Params:
+  - w: A http response writer
+  - r: A pointer to a http request object
+
+Returns:
+  - No return value
+*/
+func (handler *AuthorizationHandler) GetRegistration(w http.ResponseWriter, r *http.Request) {
+	client, ok := handler.registeredClient(w, r)
+	if !ok {
+		return
+	}
+
+	writeOAuthJSON(w, http.StatusOK, handler.metadataFromClient(client))
+}
+
+/*
+Handles replacing the metadata of a client, with its registration access token
+
+Objectives:
+  - Validate the new metadata and check it against the registration policy, like a registration
+  - Keep the secret, issue one when the client switches to a secret auth method, drop it when it stops using one
+  - Store the client and respond with its metadata
+
+Params:
+  - w: A http response writer
+  - r: A pointer to a http request object
+
+Returns:
+  - No return value
+*/
+func (handler *AuthorizationHandler) UpdateRegistration(w http.ResponseWriter, r *http.Request) {
+	current, ok := handler.registeredClient(w, r)
+	if !ok {
+		return
+	}
+
+	metadata, ok := decodeClientMetadata(w, r)
+	if !ok {
+		return
+	}
+	if metadata.ClientID != current.ID {
+		writeOAuthError(w, http.StatusBadRequest, errorInvalidRequest, "client_id must match the registered client")
+		return
+	}
+	if metadata.ClientSecret != "" && util.HashToken(metadata.ClientSecret) != current.SecretHash {
+		writeOAuthError(w, http.StatusBadRequest, errorInvalidRequest, "client_secret must match the registered client")
+		return
+	}
+	if metadata.RegistrationAccessToken != "" || metadata.RegistrationClientURI != "" || metadata.ClientIDIssuedAt != 0 || metadata.ClientSecretExpiresAt != nil {
+		writeOAuthError(w, http.StatusBadRequest, errorInvalidRequest, "registration details are issued by the server")
+		return
+	}
+
+	client, ok := handler.clientFromMetadata(w, metadata)
+	if !ok {
+		return
+	}
+	client.ID = current.ID
+	client.SecretHash = current.SecretHash
+	client.CreatedAt = current.CreatedAt
+
+	var secret string
+	if client.SecretHash == "" {
+		var err error
+		secret, err = issueClientSecret(&client)
+		if err != nil {
+			log.Println(err)
+			writeOAuthError(w, http.StatusInternalServerError, errorServerError, "failed to update client")
+			return
+		}
+	} else if !usesClientSecret(client) {
+		client.SecretHash = ""
+	}
+
+	if err := handler.dbService.Repo.UpdateOAuthClient(r.Context(), client); err != nil {
+		if errors.Is(err, repository.ErrOAuthClientNotFound) {
+			writeBearerError(w, http.StatusUnauthorized, errorInvalidToken, "registration access token is invalid")
+			return
+		}
+		log.Println(err)
+		writeOAuthError(w, http.StatusInternalServerError, errorServerError, "failed to update client")
+		return
+	}
+
+	response := handler.metadataFromClient(client)
+	response.ClientSecret = secret
+
+	log.Printf("[SUCCESS]: updated client %s\n", client.ID)
+	writeOAuthJSON(w, http.StatusOK, response)
+}
+
+/*
+Handles deleting a client, with its registration access token, every token issued to it stops working
+
+Params:
+  - w: A http response writer
+  - r: A pointer to a http request object
+
+Returns:
+  - No return value
+*/
+func (handler *AuthorizationHandler) DeleteRegistration(w http.ResponseWriter, r *http.Request) {
+	client, ok := handler.registeredClient(w, r)
+	if !ok {
+		return
+	}
+
+	if err := handler.dbService.Repo.DeleteOAuthClient(r.Context(), client.ID); err != nil && !errors.Is(err, repository.ErrOAuthClientNotFound) {
+		log.Println(err)
+		writeOAuthError(w, http.StatusInternalServerError, errorServerError, "failed to delete client")
+		return
+	}
+
+	log.Printf("[SUCCESS]: deleted client %s\n", client.ID)
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+/*
+Authenticates a client management request with the registration access token of the client in the URL
+
+Params:
+  - w: A http response writer
+  - r: A pointer to a http request object
+
+Returns:
+  - The client
+  - False if the token doesn't belong to the client, the error response is already written
+*/
+func (handler *AuthorizationHandler) registeredClient(w http.ResponseWriter, r *http.Request) (model.OAuthClient, bool) {
+	if handler.config.Registration == nil {
+		http.NotFound(w, r)
+		return model.OAuthClient{}, false
+	}
+
+	token, ok := bearerToken(r)
+	if !ok {
+		writeBearerError(w, http.StatusUnauthorized, errorInvalidToken, "registration access token is missing")
+		return model.OAuthClient{}, false
+	}
+
+	// Unknown clients and wrong tokens get the same response, so client ids can't be probed
+	client, err := handler.dbService.Repo.GetOAuthClient(r.Context(), chi.URLParam(r, "clientID"))
+	if err != nil && !errors.Is(err, repository.ErrOAuthClientNotFound) {
+		log.Println(err)
+		writeOAuthError(w, http.StatusInternalServerError, errorServerError, "failed to get client")
+		return model.OAuthClient{}, false
+	}
+	if err != nil || client.RegistrationTokenHash == "" ||
+		subtle.ConstantTimeCompare([]byte(util.HashToken(token)), []byte(client.RegistrationTokenHash)) != 1 {
+		writeBearerError(w, http.StatusUnauthorized, errorInvalidToken, "registration access token is invalid")
+		return model.OAuthClient{}, false
+	}
+
+	return client, true
+}
+
+/*
+Decodes the client metadata of a registration or update request
+
+Params:
+  - w: A http response writer
+  - r: A pointer to a http request object
+
+Returns:
+  - The client metadata
+  - False if the body isn't a JSON object, the error response is already written
+*/
+func decodeClientMetadata(w http.ResponseWriter, r *http.Request) (clientMetadata, bool) {
+	var metadata clientMetadata
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxRegistrationRequestSize)
+	if err := json.NewDecoder(r.Body).Decode(&metadata); err != nil {
+		writeOAuthError(w, http.StatusBadRequest, authserver.MetadataErrorInvalidMetadata, "request body must be a JSON object of client metadata")
+		return clientMetadata{}, false
+	}
+
+	return metadata, true
+}
+
+/*
+Builds a client from registered metadata, applying the RFC 7591 defaults
+
+Objectives:
+  - Default to the authorization code grant, the code response type and client_secret_basic
+  - Default to the scopes of the registration policy when none are requested
+  - Check response types match the grant types, and reject key sets by reference
+  - Validate the client and check it against the registration policy
+
+Params:
+  - w:        A http response writer
+  - metadata: The client metadata
+
+Returns:
+  - The client, without id or secret
+  - False if the metadata is invalid, the error response is already written
+*/
+func (handler *AuthorizationHandler) clientFromMetadata(w http.ResponseWriter, metadata clientMetadata) (model.OAuthClient, bool) {
+	policy := handler.config.Registration
+
+	client := model.OAuthClient{
+		Name:                    strings.TrimSpace(metadata.ClientName),
+		RedirectURIs:            metadata.RedirectURIs,
+		Scopes:                  authserver.SplitScope(metadata.Scope),
+		GrantTypes:              metadata.GrantTypes,
+		TokenEndpointAuthMethod: metadata.TokenEndpointAuthMethod,
+		Audiences:               metadata.Audiences,
+		ClientURI:               metadata.ClientURI,
+		LogoURI:                 metadata.LogoURI,
+		PolicyURI:               metadata.PolicyURI,
+		TOSURI:                  metadata.TOSURI,
+		Contacts:                metadata.Contacts,
+		DPoPBoundAccessTokens:   metadata.DPoPBoundAccessTokens,
+		TLSSubjectDN:            metadata.TLSSubjectDN,
+		TLSSANDNS:               metadata.TLSSANDNS,
+	}
+
+	if len(client.GrantTypes) == 0 {
+		client.GrantTypes = []string{model.GrantAuthorizationCode}
+	}
+	if client.TokenEndpointAuthMethod == "" {
+		client.TokenEndpointAuthMethod = model.ClientAuthSecretBasic
+	}
+	if len(client.Scopes) == 0 {
+		client.Scopes = policy.Scopes
+	}
+
+	responseTypes := metadata.ResponseTypes
+	if len(responseTypes) == 0 {
+		responseTypes = []string{"code"}
+	}
+	for _, responseType := range responseTypes {
+		if responseType != "code" {
+			writeOAuthError(w, http.StatusBadRequest, authserver.MetadataErrorInvalidMetadata, "response_types may only contain code")
+			return model.OAuthClient{}, false
+		}
+	}
+
+	if metadata.JWKSURI != "" {
+		writeOAuthError(w, http.StatusBadRequest, authserver.MetadataErrorInvalidMetadata, "jwks_uri is not supported, send the key set as jwks")
+		return model.OAuthClient{}, false
+	}
+	if len(metadata.JWKS) > 0 {
+		var compact bytes.Buffer
+		if err := json.Compact(&compact, metadata.JWKS); err != nil {
+			writeOAuthError(w, http.StatusBadRequest, authserver.MetadataErrorInvalidMetadata, "jwks must be a JSON Web Key Set")
+			return model.OAuthClient{}, false
+		}
+		client.JWKS = compact.String()
+	}
+
+	err := authserver.ValidateClient(client)
+	if err == nil {
+		err = policy.Check(client)
+	}
+	if err != nil {
+		var metadataError *authserver.ClientMetadataError
+		if errors.As(err, &metadataError) {
+			writeOAuthError(w, http.StatusBadRequest, metadataError.Code, metadataError.Description)
+			return model.OAuthClient{}, false
+		}
+		log.Println(err)
+		writeOAuthError(w, http.StatusInternalServerError, errorServerError, "failed to validate client")
+		return model.OAuthClient{}, false
+	}
+
+	return client, true
+}
+
+/*
+Builds the metadata response of a registered client
+
+Params:
+  - client: The client
+
+Returns:
+  - The client metadata, without secrets
+*/
+func (handler *AuthorizationHandler) metadataFromClient(client model.OAuthClient) clientMetadata {
+	metadata := clientMetadata{
+		ClientID:                client.ID,
+		ClientIDIssuedAt:        client.CreatedAt.Unix(),
+		RegistrationClientURI:   handler.config.Issuer + "/oauth/register/" + client.ID,
+		RedirectURIs:            client.RedirectURIs,
+		TokenEndpointAuthMethod: client.TokenEndpointAuthMethod,
+		GrantTypes:              client.GrantTypes,
+		ClientName:              client.Name,
+		ClientURI:               client.ClientURI,
+		LogoURI:                 client.LogoURI,
+		Scope:                   authserver.JoinScope(client.Scopes),
+		Contacts:                client.Contacts,
+		TOSURI:                  client.TOSURI,
+		PolicyURI:               client.PolicyURI,
+		Audiences:               client.Audiences,
+		DPoPBoundAccessTokens:   client.DPoPBoundAccessTokens,
+		TLSSubjectDN:            client.TLSSubjectDN,
+		TLSSANDNS:               client.TLSSANDNS,
+	}
+
+	if client.AllowsGrant(model.GrantAuthorizationCode) {
+		metadata.ResponseTypes = []string{"code"}
+	}
+	if client.JWKS != "" {
+		metadata.JWKS = json.RawMessage(client.JWKS)
+	}
+
+	// Secrets don't expire, RFC 7591 reports that as 0
+	if client.SecretHash != "" {
+		var never int64
+		metadata.ClientSecretExpiresAt = &never
+	}
+
+	return metadata
+}
+
+/*
+Generates a secret for a client authenticating with one
+
+Params:
+  - client: A pointer to the client, its secret hash is set
+
+Returns:
+  - The secret, empty if the client doesn't authenticate with one
+  - An error if the random source failed
+*/
+func issueClientSecret(client *model.OAuthClient) (string, error) {
+	if !usesClientSecret(*client) {
+		return "", nil
+	}
+
+	secret, err := util.GenerateRandomToken(32)
+	if err != nil {
+		return "", err
+	}
+	client.SecretHash = util.HashToken(secret)
+
+	return secret, nil
+}
+
+/*
+Checks whether a client authenticates with a secret
+
+Params:
+  - client: The client
+
+Returns:
+  - True for client_secret_basic and client_secret_post clients
+*/
+func usesClientSecret(client model.OAuthClient) bool {
+	return client.TokenEndpointAuthMethod == model.ClientAuthSecretBasic || client.TokenEndpointAuthMethod == model.ClientAuthSecretPost
+}
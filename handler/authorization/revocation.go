@@ -0,0 +1,86 @@
+package handler
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/dev-xero/authentication-backend/authentication"
+	"github.com/dev-xero/authentication-backend/util"
+)
+
+/*
+Handles revocation requests, RFC 7009
+
+Objectives:
+  - Authenticate the client, public clients revoke with their client id
+  - Revoke an access token until it expires
+  - Revoke the whole family of a refresh token, with the access tokens issued from it
+  - Respond with success for tokens that are invalid or already revoked, the client has nothing left to do
+
+Params:
+  - w: A http response writer
+  - r: A pointer to a http request object
+
+Returns:
+  - No return value
+*/
+func (handler *AuthorizationHandler) Revoke(w http.ResponseWriter, r *http.Request) {
+	if !parseClientForm(w, r) {
+		return
+	}
+
+	client, ok := handler.authenticateClient(w, r)
+	if !ok {
+		return
+	}
+
+	token := r.PostForm.Get("token")
+	if token == "" {
+		writeOAuthError(w, http.StatusBadRequest, errorInvalidRequest, "token is missing")
+		return
+	}
+
+	switch hint := r.PostForm.Get("token_type_hint"); hint {
+	case "", tokenTypeAccessToken, tokenTypeRefreshToken:
+	default:
+		writeOAuthError(w, http.StatusBadRequest, errorUnsupportedTokenType, "token_type_hint is not supported")
+		return
+	}
+
+	// Tokens are told apart by their format, the hint is only a hint
+	if looksLikeJWT(token) {
+		claims, err := authentication.InspectAccessToken(token)
+		if err != nil || claims.Issuer != handler.config.Issuer {
+			writeOAuthJSON(w, http.StatusOK, struct{}{})
+			return
+		}
+
+		if claims.ClientID != client.ID {
+			log.Printf("[FAIL]: client %s tried to revoke an access token of %s\n", client.ID, claims.ClientID)
+			writeOAuthError(w, http.StatusBadRequest, errorUnauthorizedClient, "token was issued to another client")
+			return
+		}
+
+		if err := handler.dbService.Repo.RevokeAccessToken(r.Context(), claims.ID, client.ID, claims.ExpiresAt.Time); err != nil {
+			log.Println(err)
+			writeOAuthError(w, http.StatusServiceUnavailable, errorServerError, "failed to revoke token")
+			return
+		}
+
+		log.Printf("[SUCCESS]: client %s revoked access token %s\n", client.ID, claims.ID)
+		writeOAuthJSON(w, http.StatusOK, struct{}{})
+		return
+	}
+
+	found, err := handler.dbService.Repo.RevokeRefreshTokenFamily(r.Context(), util.HashToken(token), client.ID)
+	if err != nil {
+		log.Println(err)
+		writeOAuthError(w, http.StatusServiceUnavailable, errorServerError, "failed to revoke token")
+		return
+	}
+
+	if found {
+		log.Printf("[SUCCESS]: client %s revoked a refresh token family\n", client.ID)
+	}
+	writeOAuthJSON(w, http.StatusOK, struct{}{})
+}
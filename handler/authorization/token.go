@@ -0,0 +1,524 @@
+package handler
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/dev-xero/authentication-backend/authentication"
+	"github.com/dev-xero/authentication-backend/authserver"
+	"github.com/dev-xero/authentication-backend/model"
+	repository "github.com/dev-xero/authentication-backend/repository/user"
+	"github.com/dev-xero/authentication-backend/util"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+// Aborts a refresh token rotation, the presented token stays active
+var (
+	errRefreshClientMismatch = errors.New("refresh token was issued to another client")
+	errRefreshScopeExceeded  = errors.New("requested scope exceeds the granted scope")
+	errRefreshKeyMismatch    = errors.New("refresh token is bound to another DPoP key or client certificate")
+)
+
+/*
+Token response struct
+
+Fields:
+  - AccessToken:     string
+  - IssuedTokenType: string, the RFC 8693 type of the issued token, only set by token exchange
+  - TokenType:       string, DPoP for tokens bound to a DPoP key, Bearer otherwise
+  - ExpiresIn:       int64, seconds until the access token expires
+  - RefreshToken:    string, only issued to clients allowed the refresh_token grant
+  - Scope:           string, the scopes of the access token
+  - IDToken:         string, only issued for the openid scope
+*/
+type tokenResponse struct {
+	AccessToken     string `json:"access_token"`
+	IssuedTokenType string `json:"issued_token_type,omitempty"`
+	TokenType       string `json:"token_type"`
+	ExpiresIn       int64  `json:"expires_in"`
+	RefreshToken    string `json:"refresh_token,omitempty"`
+	Scope           string `json:"scope,omitempty"`
+	IDToken         string `json:"id_token,omitempty"`
+}
+
+/*
+Handles token requests
+
+Objectives:
+  - Authenticate the client
+  - Verify the DPoP proof, the issued access tokens are bound to its key and to the client certificate
+  - Dispatch to the grant type, checking the client may use it
+
+Params:
+  - w: A http response writer
+  - r: A pointer to a http request object
+
+Returns:
+  - No return value
+*/
+func (handler *AuthorizationHandler) Token(w http.ResponseWriter, r *http.Request) {
+	if !parseClientForm(w, r) {
+		return
+	}
+
+	client, ok := handler.authenticateClient(w, r)
+	if !ok {
+		return
+	}
+
+	grantType := r.PostForm.Get("grant_type")
+	switch grantType {
+	case model.GrantAuthorizationCode, model.GrantRefreshToken, model.GrantClientCredentials, model.GrantDeviceCode, model.GrantTokenExchange:
+	case "":
+		writeOAuthError(w, http.StatusBadRequest, errorInvalidRequest, "grant_type is missing")
+		return
+	default:
+		writeOAuthError(w, http.StatusBadRequest, errorUnsupportedGrantType, "grant_type is not supported")
+		return
+	}
+
+	if !client.AllowsGrant(grantType) {
+		writeOAuthError(w, http.StatusBadRequest, errorUnauthorizedClient, "client may not use this grant type")
+		return
+	}
+
+	thumbprint, ok := handler.tokenRequestProof(w, r, client)
+	if !ok {
+		return
+	}
+
+	// Tokens are bound to the proof key, and to the certificate the client presented to the TLS listener
+	binding := authentication.Confirmation{KeyThumbprint: thumbprint}
+	if cert, ok := authserver.ClientCertificate(r); ok {
+		binding.CertificateThumbprint = authserver.CertificateThumbprint(cert)
+	}
+
+	switch grantType {
+	case model.GrantAuthorizationCode:
+		handler.exchangeAuthorizationCode(w, r, client, binding)
+	case model.GrantRefreshToken:
+		handler.refreshAccessToken(w, r, client, binding)
+	case model.GrantClientCredentials:
+		handler.issueClientCredentialsToken(w, r, client, binding)
+	case model.GrantDeviceCode:
+		handler.exchangeDeviceCode(w, r, client, binding)
+	case model.GrantTokenExchange:
+		handler.exchangeToken(w, r, client, binding)
+	}
+}
+
+/*
+Exchanges an authorization code for tokens
+
+Objectives:
+  - Consume the code, a code exchanged twice revokes the tokens it was exchanged for
+  - Check the code was issued to the client for the same redirect URI
+  - Verify the PKCE code verifier
+  - Issue an access token, an id token for the openid scope, and a refresh token that starts a new family
+
+Params:
+  - w:          A http response writer
+  - r:          A pointer to a http request object
+  - client:     The authenticated client
+  - binding:    The DPoP key and client certificate the tokens are bound to, empty for bearer tokens
+
+Returns:
+  - No return value
+*/
+func (handler *AuthorizationHandler) exchangeAuthorizationCode(w http.ResponseWriter, r *http.Request, client model.OAuthClient, binding authentication.Confirmation) {
+	presented := r.PostForm.Get("code")
+	if presented == "" {
+		writeOAuthError(w, http.StatusBadRequest, errorInvalidRequest, "code is missing")
+		return
+	}
+
+	code, err := handler.dbService.Repo.ConsumeAuthorizationCode(r.Context(), util.HashToken(presented))
+	if err != nil {
+		if errors.Is(err, repository.ErrAuthorizationCodeInvalid) || errors.Is(err, repository.ErrAuthorizationCodeUsed) {
+			log.Printf("[FAIL]: client %s presented an unusable code: %v\n", client.ID, err)
+			writeOAuthError(w, http.StatusBadRequest, errorInvalidGrant, err.Error())
+			return
+		}
+		log.Println(err)
+		writeOAuthError(w, http.StatusInternalServerError, errorServerError, "failed to exchange code")
+		return
+	}
+
+	if code.ClientID != client.ID {
+		log.Printf("[FAIL]: client %s presented a code issued to %s\n", client.ID, code.ClientID)
+		writeOAuthError(w, http.StatusBadRequest, errorInvalidGrant, "code was issued to another client")
+		return
+	}
+
+	if r.PostForm.Get("redirect_uri") != code.RedirectURI {
+		writeOAuthError(w, http.StatusBadRequest, errorInvalidGrant, "redirect_uri does not match the authorization request")
+		return
+	}
+
+	if !authserver.VerifyCodeVerifier(r.PostForm.Get("code_verifier"), code.CodeChallenge) {
+		writeOAuthError(w, http.StatusBadRequest, errorInvalidGrant, "code_verifier does not match the code challenge")
+		return
+	}
+
+	// Access tokens name the family of the grant, so revoking the refresh token revokes them too
+	familyID := uuid.New()
+
+	response, err := handler.createTokens(client, code.UserID, familyID, code.Scope, code.AuthTime, code.Nonce, binding)
+	if err != nil {
+		log.Println(err)
+		writeOAuthError(w, http.StatusInternalServerError, errorServerError, "failed to issue access token")
+		return
+	}
+
+	if client.AllowsGrant(model.GrantRefreshToken) {
+		refreshToken, err := util.GenerateRandomToken(32)
+		if err != nil {
+			log.Println(err)
+			writeOAuthError(w, http.StatusInternalServerError, errorServerError, "failed to issue refresh token")
+			return
+		}
+
+		err = handler.dbService.Repo.InsertRefreshToken(r.Context(), model.RefreshToken{
+			TokenHash:         util.HashToken(refreshToken),
+			FamilyID:          familyID,
+			ClientID:          client.ID,
+			UserID:            code.UserID,
+			Scope:             code.Scope,
+			CodeHash:          code.CodeHash,
+			AuthTime:          code.AuthTime,
+			ExpiresAt:         time.Now().Add(handler.config.RefreshTokenLifetime),
+			DPoPKeyThumbprint: publicClientBinding(client, binding.KeyThumbprint),
+			CertThumbprint:    publicClientBinding(client, binding.CertificateThumbprint),
+		})
+		if err != nil {
+			log.Println(err)
+			writeOAuthError(w, http.StatusInternalServerError, errorServerError, "failed to issue refresh token")
+			return
+		}
+		response.RefreshToken = refreshToken
+	}
+
+	log.Printf("[SUCCESS]: exchanged authorization code of client %s for user %s\n", client.ID, code.UserID)
+	writeOAuthJSON(w, http.StatusOK, response)
+}
+
+/*
+Answers a device polling for the tokens of its device code
+
+Objectives:
+  - Tell the device to keep waiting, slow down, or give up when the user denied it or the code expired
+  - Exchange an approved device code once for an access token, an id token for the openid scope, and a refresh token that starts a new family
+
+Params:
+  - w:          A http response writer
+  - r:          A pointer to a http request object
+  - client:     The authenticated client
+  - binding:    The DPoP key and client certificate the tokens are bound to, empty for bearer tokens
+
+Returns:
+  - No return value
+*/
+func (handler *AuthorizationHandler) exchangeDeviceCode(w http.ResponseWriter, r *http.Request, client model.OAuthClient, binding authentication.Confirmation) {
+	presented := r.PostForm.Get("device_code")
+	if presented == "" {
+		writeOAuthError(w, http.StatusBadRequest, errorInvalidRequest, "device_code is missing")
+		return
+	}
+
+	authorization, err := handler.dbService.Repo.PollDeviceAuthorization(r.Context(), util.HashToken(presented), client.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, repository.ErrDeviceAuthorizationPending):
+			writeOAuthError(w, http.StatusBadRequest, errorAuthorizationPending, err.Error())
+		case errors.Is(err, repository.ErrDeviceSlowDown):
+			writeOAuthError(w, http.StatusBadRequest, errorSlowDown, err.Error())
+		case errors.Is(err, repository.ErrDeviceCodeExpired):
+			writeOAuthError(w, http.StatusBadRequest, errorExpiredToken, err.Error())
+		case errors.Is(err, repository.ErrDeviceAccessDenied):
+			writeOAuthError(w, http.StatusBadRequest, errorAccessDenied, err.Error())
+		case errors.Is(err, repository.ErrDeviceCodeInvalid):
+			log.Printf("[FAIL]: client %s presented an unusable device code\n", client.ID)
+			writeOAuthError(w, http.StatusBadRequest, errorInvalidGrant, err.Error())
+		default:
+			log.Println(err)
+			writeOAuthError(w, http.StatusInternalServerError, errorServerError, "failed to exchange device code")
+		}
+		return
+	}
+
+	familyID := uuid.New()
+
+	response, err := handler.createTokens(client, authorization.UserID, familyID, authorization.Scope, authorization.AuthTime, "", binding)
+	if err != nil {
+		log.Println(err)
+		writeOAuthError(w, http.StatusInternalServerError, errorServerError, "failed to issue access token")
+		return
+	}
+
+	if client.AllowsGrant(model.GrantRefreshToken) {
+		refreshToken, err := util.GenerateRandomToken(32)
+		if err != nil {
+			log.Println(err)
+			writeOAuthError(w, http.StatusInternalServerError, errorServerError, "failed to issue refresh token")
+			return
+		}
+
+		err = handler.dbService.Repo.InsertRefreshToken(r.Context(), model.RefreshToken{
+			TokenHash:         util.HashToken(refreshToken),
+			FamilyID:          familyID,
+			ClientID:          client.ID,
+			UserID:            authorization.UserID,
+			Scope:             authorization.Scope,
+			AuthTime:          authorization.AuthTime,
+			ExpiresAt:         time.Now().Add(handler.config.RefreshTokenLifetime),
+			DPoPKeyThumbprint: publicClientBinding(client, binding.KeyThumbprint),
+			CertThumbprint:    publicClientBinding(client, binding.CertificateThumbprint),
+		})
+		if err != nil {
+			log.Println(err)
+			writeOAuthError(w, http.StatusInternalServerError, errorServerError, "failed to issue refresh token")
+			return
+		}
+		response.RefreshToken = refreshToken
+	}
+
+	log.Printf("[SUCCESS]: exchanged device code of client %s for user %s\n", client.ID, authorization.UserID)
+	writeOAuthJSON(w, http.StatusOK, response)
+}
+
+/*
+Exchanges a refresh token for a new access token
+
+Objectives:
+  - Rotate the refresh token, a rotated token presented again revokes its family
+  - Check the token was issued to the client, the proof key and certificate match a bound token, and the requested scope doesn't exceed the grant
+  - Issue an access token with the requested scope, an id token for the openid scope, and the next refresh token of the family
+
+Params:
+  - w:          A http response writer
+  - r:          A pointer to a http request object
+  - client:     The authenticated client
+  - binding:    The DPoP key and client certificate the tokens are bound to, empty for bearer tokens
+
+Returns:
+  - No return value
+*/
+func (handler *AuthorizationHandler) refreshAccessToken(w http.ResponseWriter, r *http.Request, client model.OAuthClient, binding authentication.Confirmation) {
+	presented := r.PostForm.Get("refresh_token")
+	if presented == "" {
+		writeOAuthError(w, http.StatusBadRequest, errorInvalidRequest, "refresh_token is missing")
+		return
+	}
+
+	replacement, err := util.GenerateRandomToken(32)
+	if err != nil {
+		log.Println(err)
+		writeOAuthError(w, http.StatusInternalServerError, errorServerError, "failed to issue refresh token")
+		return
+	}
+
+	requested := authserver.SplitScope(r.PostForm.Get("scope"))
+
+	current, err := handler.dbService.Repo.RotateRefreshToken(r.Context(), util.HashToken(presented), func(current model.RefreshToken) (model.RefreshToken, error) {
+		if current.ClientID != client.ID {
+			return model.RefreshToken{}, errRefreshClientMismatch
+		}
+		if (current.DPoPKeyThumbprint != "" && current.DPoPKeyThumbprint != binding.KeyThumbprint) ||
+			(current.CertThumbprint != "" && current.CertThumbprint != binding.CertificateThumbprint) {
+			return model.RefreshToken{}, errRefreshKeyMismatch
+		}
+		if !authserver.ScopesAllowed(requested, authserver.SplitScope(current.Scope)) {
+			return model.RefreshToken{}, errRefreshScopeExceeded
+		}
+
+		// The next token keeps the scope of the grant, narrower scopes only apply to the access token
+		return model.RefreshToken{
+			TokenHash:         util.HashToken(replacement),
+			ClientID:          current.ClientID,
+			UserID:            current.UserID,
+			Scope:             current.Scope,
+			CodeHash:          current.CodeHash,
+			AuthTime:          current.AuthTime,
+			ExpiresAt:         time.Now().Add(handler.config.RefreshTokenLifetime),
+			DPoPKeyThumbprint: current.DPoPKeyThumbprint,
+			CertThumbprint:    current.CertThumbprint,
+		}, nil
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, errRefreshScopeExceeded):
+			writeOAuthError(w, http.StatusBadRequest, errorInvalidScope, err.Error())
+		case errors.Is(err, errRefreshClientMismatch), errors.Is(err, errRefreshKeyMismatch), errors.Is(err, repository.ErrRefreshTokenInvalid), errors.Is(err, repository.ErrRefreshTokenReused):
+			log.Printf("[FAIL]: client %s presented an unusable refresh token: %v\n", client.ID, err)
+			writeOAuthError(w, http.StatusBadRequest, errorInvalidGrant, err.Error())
+		default:
+			log.Println(err)
+			writeOAuthError(w, http.StatusInternalServerError, errorServerError, "failed to refresh token")
+		}
+		return
+	}
+
+	scope := current.Scope
+	if len(requested) > 0 {
+		scope = authserver.JoinScope(requested)
+	}
+
+	response, err := handler.createTokens(client, current.UserID, current.FamilyID, scope, current.AuthTime, "", binding)
+	if err != nil {
+		log.Println(err)
+		writeOAuthError(w, http.StatusInternalServerError, errorServerError, "failed to issue access token")
+		return
+	}
+	response.RefreshToken = replacement
+
+	log.Printf("[SUCCESS]: refreshed access token of client %s for user %s\n", client.ID, current.UserID)
+	writeOAuthJSON(w, http.StatusOK, response)
+}
+
+/*
+Issues an access token to a client acting for itself, for calls between services
+
+Objectives:
+  - Require a confidential client, public clients can't keep credentials
+  - Restrict the token to one audience registered for the client, and to scopes allowed for it
+  - Issue an access token whose subject is the client, without a refresh token
+
+Params:
+  - w:          A http response writer
+  - r:          A pointer to a http request object
+  - client:     The authenticated client
+  - binding:    The DPoP key and client certificate the tokens are bound to, empty for bearer tokens
+
+Returns:
+  - No return value
+*/
+func (handler *AuthorizationHandler) issueClientCredentialsToken(w http.ResponseWriter, r *http.Request, client model.OAuthClient, binding authentication.Confirmation) {
+	if client.TokenEndpointAuthMethod == model.ClientAuthNone {
+		writeOAuthError(w, http.StatusBadRequest, errorUnauthorizedClient, "public clients may not use the client credentials grant")
+		return
+	}
+
+	audience := r.PostForm.Get("audience")
+	if audience == "" {
+		if len(client.Audiences) != 1 {
+			writeOAuthError(w, http.StatusBadRequest, errorInvalidRequest, "audience is required")
+			return
+		}
+		audience = client.Audiences[0]
+	}
+	// Clients registered before reserved audiences were rejected may still list one
+	if !client.AllowsAudience(audience) || authentication.IsReservedAudience(audience) {
+		writeOAuthError(w, http.StatusBadRequest, errorInvalidTarget, "audience is not registered for the client")
+		return
+	}
+
+	scopes := authserver.SplitScope(r.PostForm.Get("scope"))
+	if len(scopes) == 0 {
+		scopes = client.Scopes
+	}
+	if !authserver.ScopesAllowed(scopes, client.Scopes) || authserver.HasScope(scopes, authserver.ScopeOpenID) {
+		writeOAuthError(w, http.StatusBadRequest, errorInvalidScope, "requested scope is not allowed for the client")
+		return
+	}
+	scope := authserver.JoinScope(scopes)
+
+	claims := authentication.AccessTokenClaims{ClientID: client.ID, Scope: scope}
+	claims.Issuer = handler.config.Issuer
+	claims.Subject = client.ID
+	claims.Audience = jwt.ClaimStrings{audience}
+	bindConfirmation(&claims, binding)
+
+	accessToken, _, err := authentication.CreateAccessToken(claims, handler.config.AccessTokenLifetime)
+	if err != nil {
+		log.Println(err)
+		writeOAuthError(w, http.StatusInternalServerError, errorServerError, "failed to issue access token")
+		return
+	}
+
+	log.Printf("[SUCCESS]: issued client credentials token to client %s for %s\n", client.ID, audience)
+	writeOAuthJSON(w, http.StatusOK, tokenResponse{
+		AccessToken: accessToken,
+		TokenType:   accessTokenType(claims),
+		ExpiresIn:   int64(handler.config.AccessTokenLifetime / time.Second),
+		Scope:       scope,
+	})
+}
+
+/*
+Creates the access token of a token response, and the id token when the openid scope is granted
+
+Params:
+  - client:     The client the tokens are issued to
+  - userID:     The user the tokens act for
+  - familyID:   The refresh token family of the grant
+  - scope:      The granted scopes, space separated
+  - authTime:   When the user signed-in, zero if unknown
+  - nonce:      The nonce of the authorization request, empty on refresh
+  - binding:    The DPoP key and client certificate the access token is bound to, empty for a bearer token
+
+Returns:
+  - The token response, without a refresh token
+  - An error if a token couldn't be signed
+*/
+func (handler *AuthorizationHandler) createTokens(client model.OAuthClient, userID uuid.UUID, familyID uuid.UUID, scope string, authTime time.Time, nonce string, binding authentication.Confirmation) (tokenResponse, error) {
+	claims := authentication.AccessTokenClaims{ClientID: client.ID, Scope: scope, FamilyID: familyID.String()}
+	claims.Issuer = handler.config.Issuer
+	claims.Subject = userID.String()
+	claims.Audience = jwt.ClaimStrings{authentication.APIAudience}
+	bindConfirmation(&claims, binding)
+
+	accessToken, _, err := authentication.CreateAccessToken(claims, handler.config.AccessTokenLifetime)
+	if err != nil {
+		return tokenResponse{}, err
+	}
+
+	response := tokenResponse{
+		AccessToken: accessToken,
+		TokenType:   accessTokenType(claims),
+		ExpiresIn:   int64(handler.config.AccessTokenLifetime / time.Second),
+		Scope:       scope,
+	}
+
+	if !authserver.HasScope(authserver.SplitScope(scope), authserver.ScopeOpenID) {
+		return response, nil
+	}
+
+	idClaims := authserver.IDTokenClaims{
+		Nonce:           nonce,
+		AccessTokenHash: authserver.AccessTokenHash(accessToken),
+		AuthorizedParty: client.ID,
+	}
+	idClaims.Subject = userID.String()
+	idClaims.Audience = jwt.ClaimStrings{client.ID}
+	if !authTime.IsZero() {
+		idClaims.AuthTime = jwt.NewNumericDate(authTime)
+	}
+
+	response.IDToken, err = handler.config.CreateIDToken(idClaims, handler.config.AccessTokenLifetime)
+	if err != nil {
+		return tokenResponse{}, err
+	}
+
+	return response, nil
+}
+
+/*
+Returns the key a refresh token is bound to, RFC 9449 and RFC 8705 bind the refresh tokens of public clients since they have no other credential
+
+Params:
+  - client:     The client the token is issued to
+  - thumbprint: The thumbprint of the DPoP key or client certificate of the token request, empty without one
+
+Returns:
+  - The thumbprint, empty for confidential clients
+*/
+func publicClientBinding(client model.OAuthClient, thumbprint string) string {
+	if client.TokenEndpointAuthMethod != model.ClientAuthNone {
+		return ""
+	}
+
+	return thumbprint
+}
@@ -0,0 +1,170 @@
+package handler
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/dev-xero/authentication-backend/authentication"
+	"github.com/dev-xero/authentication-backend/authserver"
+	"github.com/dev-xero/authentication-backend/model"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Token type identifier of RFC 8693, access tokens are the only tokens exchanged and issued
+const tokenTypeIdentifierAccessToken = "urn:ietf:params:oauth:token-type:access_token"
+
+// Actors nested in an exchanged token, longer delegation chains are refused
+const maxDelegationDepth = 4
+
+/*
+Exchanges an access token for a token aimed at another service, RFC 8693
+
+Objectives:
+  - Require a confidential client, it becomes the actor of the issued token
+  - Verify the subject token was issued by us, isn't revoked, and was issued to a client the caller may exchange tokens of
+  - Restrict the new token to one audience registered for the caller, and to scopes the subject token and the caller both hold
+  - Issue a token for the same subject, never outliving the subject token, with the caller added to the act chain
+
+Params:
+  - w:          A http response writer
+  - r:          A pointer to a http request object
+  - client:     The authenticated client
+  - binding:    The DPoP key and client certificate the issued token is bound to, empty for a bearer token
+
+Returns:
+  - No return value
+*/
+func (handler *AuthorizationHandler) exchangeToken(w http.ResponseWriter, r *http.Request, client model.OAuthClient, binding authentication.Confirmation) {
+	if client.TokenEndpointAuthMethod == model.ClientAuthNone {
+		writeOAuthError(w, http.StatusBadRequest, errorUnauthorizedClient, "public clients may not exchange tokens")
+		return
+	}
+
+	form := r.PostForm
+	if form.Get("subject_token") == "" || form.Get("subject_token_type") == "" {
+		writeOAuthError(w, http.StatusBadRequest, errorInvalidRequest, "subject_token and subject_token_type are required")
+		return
+	}
+	if form.Get("subject_token_type") != tokenTypeIdentifierAccessToken {
+		writeOAuthError(w, http.StatusBadRequest, errorInvalidRequest, "subject_token_type must be an access token")
+		return
+	}
+	if requested := form.Get("requested_token_type"); requested != "" && requested != tokenTypeIdentifierAccessToken {
+		writeOAuthError(w, http.StatusBadRequest, errorInvalidRequest, "only access tokens can be requested")
+		return
+	}
+	if form.Get("actor_token") != "" || form.Get("actor_token_type") != "" {
+		writeOAuthError(w, http.StatusBadRequest, errorInvalidRequest, "actor_token is not supported, the authenticated client is the actor")
+		return
+	}
+	if form.Get("resource") != "" {
+		writeOAuthError(w, http.StatusBadRequest, errorInvalidTarget, "resource is not supported, use audience")
+		return
+	}
+
+	subject, err := authentication.InspectAccessToken(form.Get("subject_token"))
+	if err != nil || subject.Issuer != handler.config.Issuer {
+		writeOAuthError(w, http.StatusBadRequest, errorInvalidGrant, "subject_token is invalid or expired")
+		return
+	}
+
+	revoked, err := handler.accessTokenRevoked(r, subject)
+	if err != nil {
+		log.Println(err)
+		writeOAuthError(w, http.StatusInternalServerError, errorServerError, "failed to exchange token")
+		return
+	}
+	if revoked {
+		writeOAuthError(w, http.StatusBadRequest, errorInvalidGrant, "subject_token is revoked")
+		return
+	}
+
+	if !client.AllowsSubjectTokenClient(subject.ClientID) {
+		log.Printf("[FAIL]: client %s may not exchange tokens of client %s\n", client.ID, subject.ClientID)
+		writeOAuthError(w, http.StatusBadRequest, errorUnauthorizedClient, "client may not exchange tokens issued to that client")
+		return
+	}
+
+	audience := form.Get("audience")
+	if audience == "" {
+		if len(client.Audiences) != 1 {
+			writeOAuthError(w, http.StatusBadRequest, errorInvalidRequest, "audience is required")
+			return
+		}
+		audience = client.Audiences[0]
+	}
+	// The subject is copied, so the token must never be issued for an audience of the tokens this server issues to itself
+	if !client.AllowsAudience(audience) || authentication.IsReservedAudience(audience) {
+		writeOAuthError(w, http.StatusBadRequest, errorInvalidTarget, "audience is not registered for the client")
+		return
+	}
+
+	// Without a requested scope the token keeps the subject's scopes the caller holds too
+	subjectScopes := authserver.SplitScope(subject.Scope)
+	scopes := authserver.SplitScope(form.Get("scope"))
+	if len(scopes) == 0 {
+		for _, scope := range subjectScopes {
+			if authserver.HasScope(client.Scopes, scope) {
+				scopes = append(scopes, scope)
+			}
+		}
+	}
+	if !authserver.ScopesAllowed(scopes, subjectScopes) || !authserver.ScopesAllowed(scopes, client.Scopes) {
+		writeOAuthError(w, http.StatusBadRequest, errorInvalidScope, "requested scope exceeds the subject token or the client")
+		return
+	}
+	scope := authserver.JoinScope(scopes)
+
+	actor := &authentication.Actor{Subject: client.ID, Actor: subject.Actor}
+	if delegationDepth(actor) > maxDelegationDepth {
+		writeOAuthError(w, http.StatusBadRequest, errorInvalidGrant, "delegation chain is too long")
+		return
+	}
+
+	lifetime := handler.config.AccessTokenLifetime
+	if remaining := time.Until(subject.ExpiresAt.Time); remaining < lifetime {
+		lifetime = remaining
+	}
+
+	// The family is kept, so revoking the subject's grant revokes the exchanged token too
+	claims := authentication.AccessTokenClaims{ClientID: client.ID, Scope: scope, FamilyID: subject.FamilyID, Actor: actor}
+	claims.Issuer = handler.config.Issuer
+	claims.Subject = subject.Subject
+	claims.Audience = jwt.ClaimStrings{audience}
+	bindConfirmation(&claims, binding)
+
+	accessToken, _, err := authentication.CreateAccessToken(claims, lifetime)
+	if err != nil {
+		log.Println(err)
+		writeOAuthError(w, http.StatusInternalServerError, errorServerError, "failed to issue access token")
+		return
+	}
+
+	log.Printf("[SUCCESS]: client %s exchanged a token of %s for %s\n", client.ID, subject.Subject, audience)
+	writeOAuthJSON(w, http.StatusOK, tokenResponse{
+		AccessToken:     accessToken,
+		IssuedTokenType: tokenTypeIdentifierAccessToken,
+		TokenType:       accessTokenType(claims),
+		ExpiresIn:       int64(lifetime / time.Second),
+		Scope:           scope,
+	})
+}
+
+/*
+Counts the actors of a delegation chain
+
+Params:
+  - actor: The outermost actor
+
+Returns:
+  - The number of nested actors
+*/
+func delegationDepth(actor *authentication.Actor) int {
+	depth := 0
+	for ; actor != nil; actor = actor.Actor {
+		depth++
+	}
+
+	return depth
+}
@@ -0,0 +1,402 @@
+package handler
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dev-xero/authentication-backend/authentication"
+	"github.com/dev-xero/authentication-backend/authserver"
+	"github.com/dev-xero/authentication-backend/internal/testutil"
+	"github.com/dev-xero/authentication-backend/mfa"
+	"github.com/dev-xero/authentication-backend/model"
+	repository "github.com/dev-xero/authentication-backend/repository/user"
+	"github.com/dev-xero/authentication-backend/service"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+const (
+	testIssuer      = "https://auth.example.com"
+	testRedirectURI = "https://app.example.com/callback"
+	testVerifier    = "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+)
+
+// A handler signing id tokens with a new key, its repository is nil unless the test connects one
+func newTestHandler(t *testing.T) *AuthorizationHandler {
+	t.Helper()
+
+	// Access tokens are signed with JWT_SECRET_KEY
+	testutil.SetSigningKey(t)
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handler := &AuthorizationHandler{}
+	handler.WithMFAPolicy(&mfa.Policy{})
+	handler.WithConfig(&authserver.Config{
+		Issuer:               testIssuer,
+		CodeLifetime:         time.Minute,
+		AccessTokenLifetime:  15 * time.Minute,
+		RefreshTokenLifetime: time.Hour,
+		SigningKey:           &authserver.SigningKey{ID: "test-key", Key: key},
+	})
+
+	return handler
+}
+
+// The S256 challenge of a code verifier
+func codeChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// Verifies an id token with the handler's signing key
+func parseIDToken(t *testing.T, handler *AuthorizationHandler, idToken string) authserver.IDTokenClaims {
+	t.Helper()
+
+	var claims authserver.IDTokenClaims
+	_, err := jwt.ParseWithClaims(idToken, &claims, func(token *jwt.Token) (interface{}, error) {
+		if token.Header["kid"] != handler.config.SigningKey.ID {
+			t.Errorf("id token names key %v", token.Header["kid"])
+		}
+		return &handler.config.SigningKey.Key.PublicKey, nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodRS256.Alg()}), jwt.WithIssuer(testIssuer))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return claims
+}
+
+func TestCreateTokensIssuesIDTokenForOpenIDScope(t *testing.T) {
+	handler := newTestHandler(t)
+	client := model.OAuthClient{ID: "billing"}
+	userID, familyID := uuid.New(), uuid.New()
+	authTime := time.Now().Add(-time.Minute).Truncate(time.Second)
+
+	response, err := handler.createTokens(client, userID, familyID, "openid email", authTime, "n-0S6_WzA2Mj", authentication.Confirmation{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if response.TokenType != "Bearer" || response.Scope != "openid email" || response.IDToken == "" {
+		t.Fatalf("unexpected token response %+v", response)
+	}
+
+	claims := parseIDToken(t, handler, response.IDToken)
+	if claims.Nonce != "n-0S6_WzA2Mj" {
+		t.Errorf("nonce = %q", claims.Nonce)
+	}
+	if len(claims.Audience) != 1 || claims.Audience[0] != client.ID || claims.AuthorizedParty != client.ID {
+		t.Errorf("aud = %v, azp = %q, want %s", claims.Audience, claims.AuthorizedParty, client.ID)
+	}
+	if claims.Subject != userID.String() {
+		t.Errorf("sub = %q, want %s", claims.Subject, userID)
+	}
+	if claims.AccessTokenHash != authserver.AccessTokenHash(response.AccessToken) {
+		t.Errorf("at_hash %q doesn't match the access token", claims.AccessTokenHash)
+	}
+	if claims.AuthTime == nil || !claims.AuthTime.Time.Equal(authTime) {
+		t.Errorf("auth_time = %v, want %v", claims.AuthTime, authTime)
+	}
+
+	access, err := authentication.VerifyAccessToken(response.AccessToken, authentication.APIAudience)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if access.ClientID != client.ID || access.FamilyID != familyID.String() || access.Issuer != testIssuer {
+		t.Errorf("unexpected access token claims %+v", access)
+	}
+}
+
+func TestCreateTokensOmitsIDTokenWithoutOpenIDScope(t *testing.T) {
+	handler := newTestHandler(t)
+
+	response, err := handler.createTokens(model.OAuthClient{ID: "billing"}, uuid.New(), uuid.New(), "profile", time.Now(), "nonce", authentication.Confirmation{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if response.IDToken != "" {
+		t.Fatal("id token issued without the openid scope")
+	}
+}
+
+// Runs the authorization code flow of a public client through the authorize, token and userinfo handlers
+type testFlow struct {
+	handler *AuthorizationHandler
+	client  model.OAuthClient
+	user    model.User
+	session *http.Cookie
+}
+
+func newTestFlow(t *testing.T) *testFlow {
+	t.Helper()
+
+	handler := newTestHandler(t)
+	repo := testutil.Repository(t)
+	handler.WithService(&service.DatabaseProvider{Repo: repo})
+	ctx := context.Background()
+
+	suffix := strings.ReplaceAll(uuid.NewString(), "-", "")[:12]
+	user := model.User{ID: uuid.New(), Username: "flow" + suffix, Email: "flow" + suffix + "@example.com", Password: "correct horse battery staple"}
+	if err := repo.InsertUser(ctx, user); err != nil {
+		t.Fatal(err)
+	}
+
+	// First party clients are granted the scopes they request without a consent screen
+	client := model.OAuthClient{
+		ID:                      "flow-" + suffix,
+		Name:                    "Flow Test",
+		RedirectURIs:            []string{testRedirectURI},
+		Scopes:                  []string{authserver.ScopeOpenID, authserver.ScopeProfile, authserver.ScopeEmail},
+		GrantTypes:              []string{model.GrantAuthorizationCode, model.GrantRefreshToken},
+		TokenEndpointAuthMethod: model.ClientAuthNone,
+		FirstParty:              true,
+	}
+	if err := repo.CreateOAuthClient(ctx, client); err != nil {
+		t.Fatal(err)
+	}
+
+	token, err := authentication.CreateJWToken(user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &testFlow{handler: handler, client: client, user: user, session: &http.Cookie{Name: "token", Value: token}}
+}
+
+// Signs-in and returns the code the authorize endpoint redirects with
+func (flow *testFlow) authorize(t *testing.T, scope string, nonce string) string {
+	t.Helper()
+
+	query := url.Values{
+		"client_id":             {flow.client.ID},
+		"redirect_uri":          {testRedirectURI},
+		"response_type":         {"code"},
+		"scope":                 {scope},
+		"state":                 {"af0ifjsldkj"},
+		"nonce":                 {nonce},
+		"code_challenge":        {codeChallenge(testVerifier)},
+		"code_challenge_method": {authserver.CodeChallengeMethodS256},
+	}
+	r := httptest.NewRequest(http.MethodGet, testIssuer+"/oauth/authorize?"+query.Encode(), nil)
+	r.AddCookie(flow.session)
+	w := httptest.NewRecorder()
+
+	flow.handler.Authorize(w, r)
+
+	if w.Code != http.StatusFound {
+		t.Fatalf("authorize responded %d: %s", w.Code, w.Body)
+	}
+	location, err := url.Parse(w.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(location.String(), testRedirectURI+"?") || location.Query().Get("state") != "af0ifjsldkj" {
+		t.Fatalf("authorize redirected to %s", location)
+	}
+
+	code := location.Query().Get("code")
+	if code == "" {
+		t.Fatalf("authorize redirected without a code: %s", location)
+	}
+
+	return code
+}
+
+// Posts a token request of the public client, decoding the response into a token response or an error
+func (flow *testFlow) token(t *testing.T, form url.Values) (int, tokenResponse, oauthError) {
+	t.Helper()
+
+	form.Set("client_id", flow.client.ID)
+	r := httptest.NewRequest(http.MethodPost, testIssuer+"/oauth/token", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+
+	flow.handler.Token(w, r)
+
+	var (
+		response tokenResponse
+		failure  oauthError
+	)
+	if w.Code == http.StatusOK {
+		if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+			t.Fatal(err)
+		}
+	} else if err := json.NewDecoder(w.Body).Decode(&failure); err != nil {
+		t.Fatal(err)
+	}
+
+	return w.Code, response, failure
+}
+
+func (flow *testFlow) exchange(t *testing.T, code string, redirectURI string, verifier string) (int, tokenResponse, oauthError) {
+	t.Helper()
+
+	return flow.token(t, url.Values{
+		"grant_type":    {model.GrantAuthorizationCode},
+		"code":          {code},
+		"redirect_uri":  {redirectURI},
+		"code_verifier": {verifier},
+	})
+}
+
+func (flow *testFlow) refresh(t *testing.T, refreshToken string) (int, tokenResponse, oauthError) {
+	t.Helper()
+
+	return flow.token(t, url.Values{
+		"grant_type":    {model.GrantRefreshToken},
+		"refresh_token": {refreshToken},
+	})
+}
+
+func TestAuthorizationCodeRequiresMatchingVerifierAndRedirectURI(t *testing.T) {
+	flow := newTestFlow(t)
+
+	tests := []struct {
+		name        string
+		redirectURI string
+		verifier    string
+	}{
+		{name: "verifier of another challenge", redirectURI: testRedirectURI, verifier: strings.Repeat("a", 43)},
+		{name: "no verifier", redirectURI: testRedirectURI},
+		{name: "challenge sent as verifier", redirectURI: testRedirectURI, verifier: codeChallenge(testVerifier)},
+		{name: "another redirect uri", redirectURI: "https://app.example.com/other", verifier: testVerifier},
+		{name: "no redirect uri", verifier: testVerifier},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			code := flow.authorize(t, "openid", "")
+
+			status, _, failure := flow.exchange(t, code, test.redirectURI, test.verifier)
+			if status != http.StatusBadRequest || failure.Error != errorInvalidGrant {
+				t.Fatalf("exchange responded %d %+v, want invalid_grant", status, failure)
+			}
+		})
+	}
+}
+
+func TestAuthorizationCodeExchange(t *testing.T) {
+	flow := newTestFlow(t)
+	code := flow.authorize(t, "openid profile", "n-0S6_WzA2Mj")
+
+	status, response, failure := flow.exchange(t, code, testRedirectURI, testVerifier)
+	if status != http.StatusOK {
+		t.Fatalf("exchange responded %d %+v", status, failure)
+	}
+	if response.RefreshToken == "" || response.Scope != "openid profile" {
+		t.Fatalf("unexpected token response %+v", response)
+	}
+
+	claims := parseIDToken(t, flow.handler, response.IDToken)
+	if claims.Nonce != "n-0S6_WzA2Mj" || claims.Subject != flow.user.ID.String() || claims.AccessTokenHash != authserver.AccessTokenHash(response.AccessToken) {
+		t.Errorf("unexpected id token claims %+v", claims)
+	}
+	if len(claims.Audience) != 1 || claims.Audience[0] != flow.client.ID {
+		t.Errorf("aud = %v, want %s", claims.Audience, flow.client.ID)
+	}
+	if claims.AuthTime == nil {
+		t.Error("auth_time is missing")
+	}
+}
+
+// A code exchanged twice was intercepted, the tokens of the first exchange are revoked
+func TestAuthorizationCodeReuseRevokesTokens(t *testing.T) {
+	flow := newTestFlow(t)
+	code := flow.authorize(t, "openid", "")
+
+	status, response, failure := flow.exchange(t, code, testRedirectURI, testVerifier)
+	if status != http.StatusOK {
+		t.Fatalf("exchange responded %d %+v", status, failure)
+	}
+
+	status, _, failure = flow.exchange(t, code, testRedirectURI, testVerifier)
+	if status != http.StatusBadRequest || failure.Error != errorInvalidGrant {
+		t.Fatalf("reused code responded %d %+v, want invalid_grant", status, failure)
+	}
+
+	status, _, failure = flow.refresh(t, response.RefreshToken)
+	if status != http.StatusBadRequest || failure.Error != errorInvalidGrant {
+		t.Fatalf("refresh token of a reused code responded %d %+v, want invalid_grant", status, failure)
+	}
+}
+
+// Each refresh rotates the token, presenting a rotated token revokes the family
+func TestRefreshTokenRotationDetectsReuse(t *testing.T) {
+	flow := newTestFlow(t)
+	code := flow.authorize(t, "openid profile", "n-1")
+
+	status, first, failure := flow.exchange(t, code, testRedirectURI, testVerifier)
+	if status != http.StatusOK {
+		t.Fatalf("exchange responded %d %+v", status, failure)
+	}
+
+	status, second, failure := flow.refresh(t, first.RefreshToken)
+	if status != http.StatusOK {
+		t.Fatalf("refresh responded %d %+v", status, failure)
+	}
+	if second.RefreshToken == "" || second.RefreshToken == first.RefreshToken {
+		t.Fatal("refresh token wasn't rotated")
+	}
+	if second.Scope != "openid profile" {
+		t.Errorf("refreshed scope = %q", second.Scope)
+	}
+
+	// Refreshed id tokens are bound to the new access token and carry no nonce
+	claims := parseIDToken(t, flow.handler, second.IDToken)
+	if claims.Nonce != "" || claims.AccessTokenHash != authserver.AccessTokenHash(second.AccessToken) {
+		t.Errorf("unexpected refreshed id token claims %+v", claims)
+	}
+
+	status, _, failure = flow.refresh(t, first.RefreshToken)
+	if status != http.StatusBadRequest || failure.Error != errorInvalidGrant || failure.ErrorDescription != repository.ErrRefreshTokenReused.Error() {
+		t.Fatalf("rotated token responded %d %+v, want reuse detected", status, failure)
+	}
+
+	status, _, failure = flow.refresh(t, second.RefreshToken)
+	if status != http.StatusBadRequest || failure.Error != errorInvalidGrant {
+		t.Fatalf("token of a revoked family responded %d %+v, want invalid_grant", status, failure)
+	}
+}
+
+// A client stored before reserved audiences were rejected can't mint session tokens with them
+func TestClientCredentialsRejectsReservedAudience(t *testing.T) {
+	handler := newTestHandler(t)
+	client := model.OAuthClient{
+		ID:                      "billing",
+		GrantTypes:              []string{model.GrantClientCredentials},
+		TokenEndpointAuthMethod: model.ClientAuthSecretBasic,
+		Audiences:               []string{"invoices", "user"},
+	}
+
+	r := httptest.NewRequest(http.MethodPost, testIssuer+"/oauth/token", strings.NewReader(url.Values{"audience": {"user"}}.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if err := r.ParseForm(); err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+
+	handler.issueClientCredentialsToken(w, r, client, authentication.Confirmation{})
+
+	var failure oauthError
+	if err := json.NewDecoder(w.Body).Decode(&failure); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusBadRequest || failure.Error != errorInvalidTarget {
+		t.Fatalf("reserved audience responded %d %+v, want invalid_target", w.Code, failure)
+	}
+}
@@ -0,0 +1,191 @@
+package handler
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/dev-xero/authentication-backend/authentication"
+	"github.com/dev-xero/authentication-backend/authserver"
+	"github.com/dev-xero/authentication-backend/model"
+	"github.com/google/uuid"
+)
+
+/*
+UserInfo response struct, the claims about the user the access token grants
+
+Fields:
+  - Subject:           string, the user id
+  - PreferredUsername: string, the username, for the profile scope
+  - Email:             string, the primary email, for the email scope
+  - EmailVerified:     *bool, whether the primary email is verified, for the email scope
+*/
+type userInfoResponse struct {
+	Subject           string `json:"sub"`
+	PreferredUsername string `json:"preferred_username,omitempty"`
+	Email             string `json:"email,omitempty"`
+	EmailVerified     *bool  `json:"email_verified,omitempty"`
+}
+
+/*
+Handles UserInfo requests
+
+Objectives:
+  - Verify the access token was issued by this server with the openid scope, and wasn't revoked
+  - Require a DPoP proof of the key, or the client certificate, a bound token is bound to
+  - Respond with the claims of the user that the granted scopes cover
+
+Params:
+  - w: A http response writer
+  - r: A pointer to a http request object
+
+Returns:
+  - No return value
+*/
+func (handler *AuthorizationHandler) UserInfo(w http.ResponseWriter, r *http.Request) {
+	tokenString, scheme, ok := accessTokenFromRequest(r)
+	if !ok {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="oauth"`)
+		w.Header().Add("WWW-Authenticate", fmt.Sprintf(`DPoP realm="oauth", algs=%q`, strings.Join(authserver.DPoPAlgorithms(), " ")))
+		writeOAuthError(w, http.StatusUnauthorized, errorInvalidRequest, "access token is missing")
+		return
+	}
+
+	claims, err := authentication.VerifyAccessToken(tokenString, authentication.APIAudience)
+	if err != nil {
+		log.Println(err)
+		writeBearerError(w, http.StatusUnauthorized, errorInvalidToken, "access token is invalid or expired")
+		return
+	}
+
+	if claims.Issuer != handler.config.Issuer {
+		log.Printf("[FAIL]: userinfo access token issued by %q\n", claims.Issuer)
+		writeBearerError(w, http.StatusUnauthorized, errorInvalidToken, "access token was issued by another server")
+		return
+	}
+
+	if !verifyCertificateBinding(w, r, claims) {
+		return
+	}
+
+	if !handler.verifyTokenPresentation(w, r, claims, scheme, tokenString, handler.endpointURL(r, "/oauth/userinfo")) {
+		return
+	}
+
+	revoked, err := handler.accessTokenRevoked(r, claims)
+	if err != nil {
+		log.Println(err)
+		writeOAuthError(w, http.StatusInternalServerError, errorServerError, "failed to check token revocation")
+		return
+	}
+	if revoked {
+		writeBearerError(w, http.StatusUnauthorized, errorInvalidToken, "access token was revoked")
+		return
+	}
+
+	scopes := authserver.SplitScope(claims.Scope)
+	if !authserver.HasScope(scopes, authserver.ScopeOpenID) {
+		writeBearerError(w, http.StatusForbidden, errorInsufficientScope, "access token lacks the openid scope")
+		return
+	}
+
+	userID, err := uuid.Parse(claims.Subject)
+	if err != nil {
+		writeBearerError(w, http.StatusUnauthorized, errorInvalidToken, "access token subject is not a user")
+		return
+	}
+
+	user, err := handler.dbService.Repo.GetUserByID(r.Context(), userID.String())
+	if err != nil {
+		if strings.Contains(err.Error(), "not found") {
+			writeBearerError(w, http.StatusUnauthorized, errorInvalidToken, "user no longer exists")
+			return
+		}
+		log.Println(err)
+		writeOAuthError(w, http.StatusInternalServerError, errorServerError, "failed to get user")
+		return
+	}
+
+	response := userInfoResponse{Subject: user.ID.String()}
+
+	if authserver.HasScope(scopes, authserver.ScopeProfile) {
+		response.PreferredUsername = user.Username
+	}
+
+	if authserver.HasScope(scopes, authserver.ScopeEmail) {
+		verified, err := handler.primaryEmailVerified(r, user)
+		if err != nil {
+			log.Println(err)
+			writeOAuthError(w, http.StatusInternalServerError, errorServerError, "failed to get emails")
+			return
+		}
+		response.Email = user.Email
+		response.EmailVerified = &verified
+	}
+
+	writeOAuthJSON(w, http.StatusOK, response)
+}
+
+/*
+Checks whether the primary email of a user is verified
+
+Params:
+  - r:    A pointer to a http request object
+  - user: The user
+
+Returns:
+  - True if the address is verified
+  - An error if the emails couldn't be listed
+*/
+func (handler *AuthorizationHandler) primaryEmailVerified(r *http.Request, user model.User) (bool, error) {
+	emails, err := handler.dbService.Repo.ListUserEmails(r.Context(), user.ID)
+	if err != nil {
+		return false, err
+	}
+
+	for _, email := range emails {
+		if strings.EqualFold(email.Email, user.Email) {
+			return email.VerifiedAt != nil, nil
+		}
+	}
+
+	return false, nil
+}
+
+/*
+Reads the bearer token of the Authorization header
+
+Params:
+  - r: A pointer to a http request object
+
+Returns:
+  - The token
+  - False if the request has no bearer token
+*/
+func bearerToken(r *http.Request) (string, bool) {
+	scheme, token, found := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !found || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+
+	token = strings.TrimSpace(token)
+	return token, token != ""
+}
+
+/*
+Writes a RFC 6750 error, challenging for a bearer token
+
+Params:
+  - w:           A http response writer
+  - status:      The response status
+  - code:        The error code
+  - description: A description for the client developer
+
+Returns:
+  - No return value
+*/
+func writeBearerError(w http.ResponseWriter, status int, code string, description string) {
+	w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="oauth", error=%q, error_description=%q`, code, description))
+	writeOAuthError(w, status, code, description)
+}
@@ -0,0 +1,101 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestUserInfoRequiresAccessToken(t *testing.T) {
+	handler := newTestHandler(t)
+
+	r := httptest.NewRequest(http.MethodGet, testIssuer+"/oauth/userinfo", nil)
+	w := httptest.NewRecorder()
+	handler.UserInfo(w, r)
+
+	if w.Code != http.StatusUnauthorized || !strings.HasPrefix(w.Header().Get("WWW-Authenticate"), "Bearer") {
+		t.Fatalf("userinfo responded %d, challenge %q", w.Code, w.Header().Get("WWW-Authenticate"))
+	}
+}
+
+// Claims are only returned for the scopes the access token was granted
+func TestUserInfoFiltersClaimsByScope(t *testing.T) {
+	flow := newTestFlow(t)
+
+	tests := []struct {
+		scope    string
+		username bool
+		email    bool
+	}{
+		{scope: "openid"},
+		{scope: "openid profile", username: true},
+		{scope: "openid email", email: true},
+		{scope: "openid profile email", username: true, email: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.scope, func(t *testing.T) {
+			status, tokens, failure := flow.exchange(t, flow.authorize(t, test.scope, ""), testRedirectURI, testVerifier)
+			if status != http.StatusOK {
+				t.Fatalf("exchange responded %d %+v", status, failure)
+			}
+
+			r := httptest.NewRequest(http.MethodGet, testIssuer+"/oauth/userinfo", nil)
+			r.Header.Set("Authorization", "Bearer "+tokens.AccessToken)
+			w := httptest.NewRecorder()
+			flow.handler.UserInfo(w, r)
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("userinfo responded %d: %s", w.Code, w.Body)
+			}
+
+			var claims map[string]interface{}
+			if err := json.NewDecoder(w.Body).Decode(&claims); err != nil {
+				t.Fatal(err)
+			}
+
+			if claims["sub"] != flow.user.ID.String() {
+				t.Errorf("sub = %v, want %s", claims["sub"], flow.user.ID)
+			}
+			if _, ok := claims["preferred_username"]; ok != test.username {
+				t.Errorf("preferred_username returned = %v, want %v", ok, test.username)
+			}
+			if _, ok := claims["email"]; ok != test.email {
+				t.Errorf("email returned = %v, want %v", ok, test.email)
+			}
+			if test.email && (claims["email"] != flow.user.Email || claims["email_verified"] != true) {
+				t.Errorf("email = %v, email_verified = %v", claims["email"], claims["email_verified"])
+			}
+			if test.username && claims["preferred_username"] != flow.user.Username {
+				t.Errorf("preferred_username = %v, want %s", claims["preferred_username"], flow.user.Username)
+			}
+		})
+	}
+}
+
+// Access tokens of a family revoked by refresh token reuse are rejected
+func TestUserInfoRejectsRevokedAccessToken(t *testing.T) {
+	flow := newTestFlow(t)
+
+	status, first, failure := flow.exchange(t, flow.authorize(t, "openid", ""), testRedirectURI, testVerifier)
+	if status != http.StatusOK {
+		t.Fatalf("exchange responded %d %+v", status, failure)
+	}
+	if status, _, failure := flow.refresh(t, first.RefreshToken); status != http.StatusOK {
+		t.Fatalf("refresh responded %d %+v", status, failure)
+	}
+	if status, _, _ := flow.refresh(t, first.RefreshToken); status != http.StatusBadRequest {
+		t.Fatalf("reused refresh token responded %d", status)
+	}
+
+	r := httptest.NewRequest(http.MethodGet, testIssuer+"/oauth/userinfo", nil)
+	r.Header.Set("Authorization", "Bearer "+first.AccessToken)
+	w := httptest.NewRecorder()
+	flow.handler.UserInfo(w, r)
+
+	if w.Code != http.StatusUnauthorized || !strings.Contains(w.Header().Get("WWW-Authenticate"), errorInvalidToken) {
+		t.Fatalf("userinfo responded %d, challenge %q", w.Code, w.Header().Get("WWW-Authenticate"))
+	}
+}
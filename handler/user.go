@@ -6,18 +6,24 @@ import (
 	"strings"
 
 	repository "github.com/dev-xero/authentication-backend/repository/user"
+	"github.com/dev-xero/authentication-backend/service"
 	"github.com/dev-xero/authentication-backend/util"
 	"github.com/go-chi/chi/v5"
 )
 
 type User struct {
-	repo *repository.PostGreSQL
+	repo        *repository.PostGreSQL
+	mailService *service.MailProvider
 }
 
 func (user *User) New(repo *repository.PostGreSQL) {
 	user.repo = repo
 }
 
+func (user *User) WithMailService(service *service.MailProvider) {
+	user.mailService = service
+}
+
 func (user *User) Home(w http.ResponseWriter, r *http.Request) {
 	msg := "User route home"
 	util.JsonResponse(w, msg, http.StatusOK, nil)
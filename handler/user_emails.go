@@ -0,0 +1,243 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	shared "github.com/dev-xero/authentication-backend/handler/auth/shared"
+	"github.com/dev-xero/authentication-backend/middleware"
+	"github.com/dev-xero/authentication-backend/model"
+	repository "github.com/dev-xero/authentication-backend/repository/user"
+	"github.com/dev-xero/authentication-backend/util"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+// How long the emailed token can be used to verify a new address
+const emailVerificationLifetime = 24 * time.Hour
+
+/*
+Handles listing the addresses of the signed-in user
+
+Params:
+  - w: A http response writer
+  - r: A pointer to a http request object
+
+Returns:
+  - No return value
+*/
+func (user *User) ListEmails(w http.ResponseWriter, r *http.Request) {
+	userID, _ := middleware.UserIDFromContext(r.Context())
+
+	emails, err := user.repo.ListUserEmails(r.Context(), userID)
+	if err != nil {
+		log.Println(err)
+		msg := "Internal server error, failed to get emails"
+		util.JsonResponse(w, msg, http.StatusInternalServerError, nil)
+		return
+	}
+
+	util.JsonResponse(w, "Successfully fetched emails", http.StatusOK, emails)
+}
+
+/*
+Handles adding an address to the signed-in user
+
+Objectives:
+  - Decode, sanitize and validate the email
+  - Store the address as unverified with a hashed verification token
+  - Email the verification token to the new address
+
+Params:
+  - w: A http response writer
+  - r: A pointer to a http request object
+
+Returns:
+  - No return value
+*/
+func (user *User) AddEmail(w http.ResponseWriter, r *http.Request) {
+	userID, _ := middleware.UserIDFromContext(r.Context())
+
+	var body = util.EmailRequestBody{}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		msg := "Bad request, email not present"
+		util.JsonResponse(w, msg, http.StatusBadRequest, nil)
+		return
+	}
+
+	util.SanitizeUserInput(&body)
+
+	if !util.IsValidEmail(body.Email) {
+		util.JsonResponse(w, util.CapitalizeFirstLetter(util.ErrEmailInvalid.Error()), http.StatusBadRequest, nil)
+		return
+	}
+
+	token, err := util.GenerateRandomToken(32)
+	if err != nil {
+		log.Println(err)
+		util.JsonResponse(w, "Failed to add email", http.StatusInternalServerError, nil)
+		return
+	}
+
+	email := model.UserEmail{
+		ID:                    uuid.New(),
+		UserID:                userID,
+		Email:                 body.Email,
+		VerificationTokenHash: util.HashToken(token),
+		VerificationExpiresAt: time.Now().Add(emailVerificationLifetime),
+	}
+
+	event := shared.NewAuditEvent(r, userID, "email.added", body.Email)
+	if err := user.repo.AddUserEmail(r.Context(), email, event); err != nil {
+		if errors.Is(err, repository.ErrEmailTaken) {
+			util.JsonResponse(w, util.CapitalizeFirstLetter(err.Error()), http.StatusConflict, nil)
+			return
+		}
+		log.Println(err)
+		util.JsonResponse(w, "Failed to add email", http.StatusInternalServerError, nil)
+		return
+	}
+
+	emailBody := fmt.Sprintf(
+		"This address was added to your account.\n\n"+
+			"Verify it within %d hours with this token:\n\n%s\n\n"+
+			"If this wasn't you, ignore this email and the address will not be added.",
+		int(emailVerificationLifetime.Hours()), token,
+	)
+	if err := user.mailService.Mailer.Send(r.Context(), body.Email, "Verify your email", emailBody); err != nil {
+		log.Println(err)
+	}
+
+	util.JsonResponse(w, "Email added, a verification token was sent to it", http.StatusCreated, email)
+}
+
+/*
+Handles verifying an address of the signed-in user with its emailed token
+
+Params:
+  - w: A http response writer
+  - r: A pointer to a http request object
+
+Returns:
+  - No return value
+*/
+func (user *User) VerifyEmail(w http.ResponseWriter, r *http.Request) {
+	userID, _ := middleware.UserIDFromContext(r.Context())
+
+	var body = util.TokenRequestBody{}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Token == "" {
+		msg := "Bad request, token not present"
+		util.JsonResponse(w, msg, http.StatusBadRequest, nil)
+		return
+	}
+
+	util.SanitizeUserInput(&body)
+
+	event := shared.NewAuditEvent(r, userID, "email.verified", "")
+	email, err := user.repo.VerifyUserEmail(r.Context(), userID, util.HashToken(body.Token), event)
+	if err != nil {
+		if errors.Is(err, repository.ErrEmailNotFound) {
+			msg := "Invalid or expired verification token"
+			util.JsonResponse(w, msg, http.StatusBadRequest, nil)
+			return
+		}
+		log.Println(err)
+		util.JsonResponse(w, "Failed to verify email", http.StatusInternalServerError, nil)
+		return
+	}
+
+	util.JsonResponse(w, "Successfully verified email", http.StatusOK, email)
+}
+
+/*
+Handles making a verified address the primary address of the signed-in user
+
+Params:
+  - w: A http response writer
+  - r: A pointer to a http request object
+
+Returns:
+  - No return value
+*/
+func (user *User) SetPrimaryEmail(w http.ResponseWriter, r *http.Request) {
+	userID, _ := middleware.UserIDFromContext(r.Context())
+
+	emailID, ok := emailIDFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	event := shared.NewAuditEvent(r, userID, "email.primary_changed", "")
+	if err := user.repo.SetPrimaryEmail(r.Context(), userID, emailID, event); err != nil {
+		if errors.Is(err, repository.ErrEmailNotFound) {
+			msg := "No verified email with that id"
+			util.JsonResponse(w, msg, http.StatusNotFound, nil)
+			return
+		}
+		log.Println(err)
+		util.JsonResponse(w, "Failed to change primary email", http.StatusInternalServerError, nil)
+		return
+	}
+
+	util.JsonResponse(w, "Successfully changed primary email", http.StatusOK, nil)
+}
+
+/*
+Handles removing an address of the signed-in user
+
+Params:
+  - w: A http response writer
+  - r: A pointer to a http request object
+
+Returns:
+  - No return value
+*/
+func (user *User) RemoveEmail(w http.ResponseWriter, r *http.Request) {
+	userID, _ := middleware.UserIDFromContext(r.Context())
+
+	emailID, ok := emailIDFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	event := shared.NewAuditEvent(r, userID, "email.removed", "")
+	if err := user.repo.RemoveUserEmail(r.Context(), userID, emailID, event); err != nil {
+		switch {
+		case errors.Is(err, repository.ErrEmailNotFound):
+			util.JsonResponse(w, "No email with that id", http.StatusNotFound, nil)
+		case errors.Is(err, repository.ErrEmailPrimary):
+			util.JsonResponse(w, util.CapitalizeFirstLetter(err.Error()), http.StatusBadRequest, nil)
+		default:
+			log.Println(err)
+			util.JsonResponse(w, "Failed to remove email", http.StatusInternalServerError, nil)
+		}
+		return
+	}
+
+	util.JsonResponse(w, "Successfully removed email", http.StatusOK, nil)
+}
+
+/*
+Parses the email id URL parameter
+
+Params:
+  - w: A http response writer
+  - r: A pointer to a http request object
+
+Returns:
+  - The email id
+  - False if the id is invalid, the response is already written
+*/
+func emailIDFromURL(w http.ResponseWriter, r *http.Request) (uuid.UUID, bool) {
+	emailID, err := uuid.Parse(chi.URLParam(r, "emailID"))
+	if err != nil {
+		util.JsonResponse(w, "Bad request, invalid email id", http.StatusBadRequest, nil)
+		return uuid.Nil, false
+	}
+
+	return emailID, true
+}
@@ -0,0 +1,69 @@
+package handler
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	shared "github.com/dev-xero/authentication-backend/handler/auth/shared"
+	"github.com/dev-xero/authentication-backend/middleware"
+	repository "github.com/dev-xero/authentication-backend/repository/user"
+	"github.com/dev-xero/authentication-backend/util"
+	"github.com/go-chi/chi/v5"
+)
+
+/*
+Handles listing the OAuth clients the signed-in user granted access
+
+Params:
+  - w: A http response writer
+  - r: A pointer to a http request object
+
+Returns:
+  - No return value
+*/
+func (user *User) ListGrants(w http.ResponseWriter, r *http.Request) {
+	userID, _ := middleware.UserIDFromContext(r.Context())
+
+	grants, err := user.repo.ListOAuthGrants(r.Context(), userID)
+	if err != nil {
+		log.Println(err)
+		msg := "Internal server error, failed to get grants"
+		util.JsonResponse(w, msg, http.StatusInternalServerError, nil)
+		return
+	}
+
+	util.JsonResponse(w, "Successfully fetched grants", http.StatusOK, grants)
+}
+
+/*
+Handles revoking the access the signed-in user granted an OAuth client
+
+Objectives:
+  - Revoke the grant, with the refresh and access tokens the client holds for the user
+  - Record an audit event
+
+Params:
+  - w: A http response writer
+  - r: A pointer to a http request object
+
+Returns:
+  - No return value
+*/
+func (user *User) RevokeGrant(w http.ResponseWriter, r *http.Request) {
+	userID, _ := middleware.UserIDFromContext(r.Context())
+	clientID := chi.URLParam(r, "clientID")
+
+	event := shared.NewAuditEvent(r, userID, "grant.revoked", "")
+	if err := user.repo.RevokeOAuthGrant(r.Context(), userID, clientID, event); err != nil {
+		if errors.Is(err, repository.ErrOAuthGrantNotFound) {
+			util.JsonResponse(w, "No grant for that client", http.StatusNotFound, nil)
+			return
+		}
+		log.Println(err)
+		util.JsonResponse(w, "Failed to revoke grant", http.StatusInternalServerError, nil)
+		return
+	}
+
+	util.JsonResponse(w, "Successfully revoked grant", http.StatusOK, nil)
+}
@@ -0,0 +1,122 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	shared "github.com/dev-xero/authentication-backend/handler/auth/shared"
+	"github.com/dev-xero/authentication-backend/middleware"
+	repository "github.com/dev-xero/authentication-backend/repository/user"
+	"github.com/dev-xero/authentication-backend/util"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+/*
+Handles listing the external identities linked to the signed-in user
+
+Params:
+  - w: A http response writer
+  - r: A pointer to a http request object
+
+Returns:
+  - No return value
+*/
+func (user *User) ListIdentities(w http.ResponseWriter, r *http.Request) {
+	userID, _ := middleware.UserIDFromContext(r.Context())
+
+	identities, err := user.repo.ListUserIdentities(r.Context(), userID)
+	if err != nil {
+		log.Println(err)
+		msg := "Internal server error, failed to get identities"
+		util.JsonResponse(w, msg, http.StatusInternalServerError, nil)
+		return
+	}
+
+	util.JsonResponse(w, "Successfully fetched identities", http.StatusOK, identities)
+}
+
+/*
+Handles unlinking an external identity from the signed-in user
+
+Objectives:
+  - Parse the identity id
+  - Refuse to unlink the only way the user has left to sign-in
+  - Unlink the identity, recording an audit event
+
+Params:
+  - w: A http response writer
+  - r: A pointer to a http request object
+
+Returns:
+  - No return value
+*/
+func (user *User) UnlinkIdentity(w http.ResponseWriter, r *http.Request) {
+	userID, _ := middleware.UserIDFromContext(r.Context())
+
+	identityID, err := uuid.Parse(chi.URLParam(r, "identityID"))
+	if err != nil {
+		util.JsonResponse(w, "Bad request, invalid identity id", http.StatusBadRequest, nil)
+		return
+	}
+
+	event := shared.NewAuditEvent(r, userID, "identity.unlinked", "")
+	if err := user.repo.UnlinkIdentity(r.Context(), userID, identityID, event); err != nil {
+		switch {
+		case errors.Is(err, repository.ErrIdentityNotFound):
+			util.JsonResponse(w, "No identity with that id", http.StatusNotFound, nil)
+		case errors.Is(err, repository.ErrLastSignInMethod):
+			msg := "Add a password or link another identity before unlinking this one"
+			util.JsonResponse(w, msg, http.StatusBadRequest, nil)
+		default:
+			log.Println(err)
+			util.JsonResponse(w, "Failed to unlink identity", http.StatusInternalServerError, nil)
+		}
+		return
+	}
+
+	util.JsonResponse(w, "Successfully unlinked identity", http.StatusOK, nil)
+}
+
+/*
+Handles adding a password to a signed-in user that signed-up with an external identity
+
+Params:
+  - w: A http response writer
+  - r: A pointer to a http request object
+
+Returns:
+  - No return value
+*/
+func (user *User) AddPassword(w http.ResponseWriter, r *http.Request) {
+	userID, _ := middleware.UserIDFromContext(r.Context())
+
+	var body = util.PasswordRequestBody{}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		msg := "Bad request, password not present"
+		util.JsonResponse(w, msg, http.StatusBadRequest, nil)
+		return
+	}
+
+	util.SanitizeUserInput(&body)
+
+	if len(body.Password) < 8 {
+		util.JsonResponse(w, util.CapitalizeFirstLetter(util.ErrPasswordLength.Error()), http.StatusBadRequest, nil)
+		return
+	}
+
+	event := shared.NewAuditEvent(r, userID, "password.added", "")
+	if err := user.repo.AddUserPassword(r.Context(), userID, body.Password, event); err != nil {
+		if errors.Is(err, repository.ErrPasswordAlreadySet) {
+			util.JsonResponse(w, util.CapitalizeFirstLetter(err.Error()), http.StatusConflict, nil)
+			return
+		}
+		log.Println(err)
+		util.JsonResponse(w, "Failed to add password", http.StatusInternalServerError, nil)
+		return
+	}
+
+	util.JsonResponse(w, "Successfully added password", http.StatusOK, nil)
+}
@@ -0,0 +1,107 @@
+package testutil
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"testing"
+	"time"
+)
+
+/*
+Certificate struct, a certificate and its key, as cmd/generate-certs creates them
+
+Fields:
+  - Cert: The parsed certificate
+  - Key:  The P-256 key of the certificate
+*/
+type Certificate struct {
+	Cert *x509.Certificate
+	Key  *ecdsa.PrivateKey
+}
+
+/*
+Creates a certificate valid for the next hour, with a new P-256 key
+
+Params:
+  - t:        The test
+  - template: The certificate template, its serial number and validity are set
+  - parent:   The issuing certificate, nil for a self-signed certificate
+
+Returns:
+  - The certificate and its key
+*/
+func NewCertificate(t *testing.T, template *x509.Certificate, parent *Certificate) Certificate {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template.SerialNumber = big.NewInt(time.Now().UnixNano())
+	template.NotBefore = time.Now().Add(-time.Minute)
+	template.NotAfter = time.Now().Add(time.Hour)
+
+	issuer, issuerKey := template, key
+	if parent != nil {
+		issuer, issuerKey = parent.Cert, parent.Key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, issuer, &key.PublicKey, issuerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return Certificate{Cert: cert, Key: key}
+}
+
+/*
+Creates a self-signed CA certificate
+
+Params:
+  - t:    The test
+  - name: The common name of the CA
+
+Returns:
+  - The CA certificate and its key
+*/
+func NewCA(t *testing.T, name string) Certificate {
+	t.Helper()
+
+	return NewCertificate(t, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: name},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, nil)
+}
+
+/*
+Creates a certificate of the billing service, for the SAN DNS name billing.localhost
+
+Params:
+  - t:     The test
+  - ca:    The issuing CA, nil for a self-signed certificate
+  - usage: The extended key usage of the certificate
+
+Returns:
+  - The certificate and its key
+*/
+func NewClientCertificate(t *testing.T, ca *Certificate, usage x509.ExtKeyUsage) Certificate {
+	t.Helper()
+
+	return NewCertificate(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "billing", Organization: []string{"Local Development"}},
+		DNSNames:    []string{"billing.localhost"},
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{usage},
+	}, ca)
+}
@@ -0,0 +1,40 @@
+package testutil
+
+import (
+	"database/sql"
+	"os"
+	"testing"
+
+	repository "github.com/dev-xero/authentication-backend/repository/user"
+	_ "github.com/lib/pq"
+)
+
+/*
+Connects to the database named by TEST_DATABASE_URL
+
+Params:
+  - t: The test, skipped when TEST_DATABASE_URL isn't set
+
+Returns:
+  - The repository, closed when the test ends
+*/
+func Repository(t *testing.T) *repository.PostGreSQL {
+	t.Helper()
+
+	databaseURL := os.Getenv("TEST_DATABASE_URL")
+	if databaseURL == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+
+	db, err := sql.Open("postgres", databaseURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	if err := db.Ping(); err != nil {
+		t.Fatal(err)
+	}
+
+	return &repository.PostGreSQL{Database: db}
+}
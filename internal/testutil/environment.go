@@ -0,0 +1,20 @@
+package testutil
+
+import "testing"
+
+/*
+Sets the key session and access tokens are signed with for the test
+
+Params:
+  - t: The test
+
+Returns:
+  - No return value
+*/
+func SetSigningKey(t *testing.T) {
+	t.Helper()
+
+	// Production skips loading a .env file
+	t.Setenv("ENVIRONMENT", "production")
+	t.Setenv("JWT_SECRET_KEY", "test-secret-key")
+}
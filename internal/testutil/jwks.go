@@ -0,0 +1,66 @@
+package testutil
+
+import (
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+)
+
+/*
+Encodes the public part of an RSA key as a JSON Web Key, as identity providers publish their signing keys
+
+Params:
+  - keyID: The kid of the key
+  - key:   The RSA key
+
+Returns:
+  - The JSON Web Key
+*/
+func RSAJWK(keyID string, key *rsa.PrivateKey) map[string]string {
+	return map[string]string{
+		"kty": "RSA",
+		"kid": keyID,
+		"use": "sig",
+		"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+		"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+	}
+}
+
+/*
+Encodes the public part of a P-256 key as a JSON Web Key Set, as self_signed_tls_client_auth clients register it
+
+Params:
+  - key: The P-256 key
+
+Returns:
+  - The key set JSON
+*/
+func ECJWKS(key *ecdsa.PrivateKey) string {
+	encode := func(value *big.Int) string {
+		return base64.RawURLEncoding.EncodeToString(value.FillBytes(make([]byte, 32)))
+	}
+
+	data, _ := json.Marshal(map[string]interface{}{
+		"keys": []map[string]string{{"kty": "EC", "crv": "P-256", "use": "sig", "x": encode(key.X), "y": encode(key.Y)}},
+	})
+
+	return string(data)
+}
+
+/*
+Writes a JSON response, for fake provider endpoints
+
+Params:
+  - w:     A http response writer
+  - value: The response body
+
+Returns:
+  - No return value
+*/
+func WriteJSON(w http.ResponseWriter, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(value)
+}
@@ -0,0 +1,123 @@
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+/*
+Email struct, an email delivered by the fake mailer
+
+Fields:
+  - To:      string
+  - Subject: string
+  - Body:    string
+  - SentAt:  time.Time
+*/
+type Email struct {
+	To      string
+	Subject string
+	Body    string
+	SentAt  time.Time
+}
+
+/*
+Fake mailer for development and tests
+
+Objectives:
+  - Keep every email in an in-memory inbox
+  - Optionally append every email to an outbox file
+
+Fields:
+  - outboxPath: File emails are appended to, emails are only kept in memory if empty
+  - emails:     The in-memory inbox
+*/
+type FakeMailer struct {
+	outboxPath string
+
+	mutex  sync.Mutex
+	emails []Email
+}
+
+/*
+Initializes a new fake mailer
+
+Params:
+  - outboxPath: File to append emails to, optional
+
+Returns:
+  - A pointer to the fake mailer
+*/
+func NewFakeMailer(outboxPath string) *FakeMailer {
+	return &FakeMailer{outboxPath: outboxPath}
+}
+
+// Implement the mailer interface for the fake mailer
+func (mailer *FakeMailer) Send(ctx context.Context, to string, subject string, body string) error {
+	mailer.mutex.Lock()
+	defer mailer.mutex.Unlock()
+
+	email := Email{To: to, Subject: subject, Body: body, SentAt: time.Now()}
+	mailer.emails = append(mailer.emails, email)
+
+	if mailer.outboxPath == "" {
+		log.Printf("[LOG]: fake email %q sent to %s\n", subject, to)
+		return nil
+	}
+
+	file, err := os.OpenFile(mailer.outboxPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("[FAIL]: could not open mail outbox: %w", err)
+	}
+	defer file.Close()
+
+	_, err = fmt.Fprintf(file, "Date: %s\nTo: %s\nSubject: %s\n\n%s\n\n", email.SentAt.Format(time.RFC1123Z), email.To, email.Subject, email.Body)
+	if err != nil {
+		return fmt.Errorf("[FAIL]: could not write mail outbox: %w", err)
+	}
+
+	return nil
+}
+
+/*
+Returns every email delivered so far
+
+Params:
+  - No parameters
+
+Returns:
+  - A copy of the inbox
+*/
+func (mailer *FakeMailer) Emails() []Email {
+	mailer.mutex.Lock()
+	defer mailer.mutex.Unlock()
+
+	return append([]Email{}, mailer.emails...)
+}
+
+/*
+Returns the latest email delivered to an address
+
+Params:
+  - to: The email address
+
+Returns:
+  - The email
+  - False if no email was delivered to the address
+*/
+func (mailer *FakeMailer) LastEmailTo(to string) (Email, bool) {
+	mailer.mutex.Lock()
+	defer mailer.mutex.Unlock()
+
+	for i := len(mailer.emails) - 1; i >= 0; i-- {
+		if mailer.emails[i].To == to {
+			return mailer.emails[i], true
+		}
+	}
+
+	return Email{}, false
+}
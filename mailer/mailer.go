@@ -0,0 +1,61 @@
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/joho/godotenv"
+)
+
+/*
+Mailer interface, defines email providers that deliver messages
+
+Methods:
+  - Send: Delivers a plain text email
+*/
+type Mailer interface {
+	Send(ctx context.Context, to string, subject string, body string) error
+}
+
+/*
+Creates the mailer configured through environment variables
+
+Objectives:
+  - Load the mail provider name from the environment
+  - Construct the matching mailer
+
+Params:
+  - No parameters
+
+Returns:
+  - The mailer
+  - An error if the provider is unknown or misconfigured
+*/
+func NewFromEnvironment() (Mailer, error) {
+	// Load environment variables from .env file in development
+	if env := os.Getenv("ENVIRONMENT"); env != "production" {
+		err := godotenv.Load()
+		if err != nil {
+			return nil, fmt.Errorf("[FAIL]: could not load environment variables: %w", err)
+		}
+	}
+
+	switch provider := os.Getenv("MAIL_PROVIDER"); provider {
+	case "", "fake":
+		if os.Getenv("ENVIRONMENT") == "production" {
+			return nil, fmt.Errorf("[FAIL]: the fake mail provider can't be used in production")
+		}
+		return NewFakeMailer(os.Getenv("MAIL_FAKE_OUTBOX")), nil
+	case "smtp":
+		return NewSMTPMailer(
+			os.Getenv("MAIL_SMTP_HOST"),
+			os.Getenv("MAIL_SMTP_PORT"),
+			os.Getenv("MAIL_SMTP_USERNAME"),
+			os.Getenv("MAIL_SMTP_PASSWORD"),
+			os.Getenv("MAIL_FROM"),
+		)
+	default:
+		return nil, fmt.Errorf("[FAIL]: unknown mail provider %q", provider)
+	}
+}
@@ -0,0 +1,76 @@
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+/*
+SMTP mailer struct
+
+Fields:
+  - address: The SMTP server host and port
+  - auth:    SMTP plain authentication, nil when no username is configured
+  - from:    The sender address
+*/
+type SMTPMailer struct {
+	address string
+	auth    smtp.Auth
+	from    string
+}
+
+/*
+Initializes a new SMTP mailer
+
+Params:
+  - host:     The SMTP server host
+  - port:     The SMTP server port
+  - username: The SMTP username, optional
+  - password: The SMTP password
+  - from:     The sender address
+
+Returns:
+  - A pointer to the SMTP mailer
+  - An error if the host or sender is missing
+*/
+func NewSMTPMailer(host string, port string, username string, password string, from string) (*SMTPMailer, error) {
+	if host == "" || port == "" || from == "" {
+		return nil, fmt.Errorf("[FAIL]: MAIL_SMTP_HOST, MAIL_SMTP_PORT and MAIL_FROM are required")
+	}
+
+	mailer := &SMTPMailer{address: net.JoinHostPort(host, port), from: from}
+	if username != "" {
+		mailer.auth = smtp.PlainAuth("", username, password, host)
+	}
+
+	return mailer, nil
+}
+
+// Implement the mailer interface for the SMTP mailer
+func (mailer *SMTPMailer) Send(ctx context.Context, to string, subject string, body string) error {
+	// Refuse header injection through the recipient or subject
+	if strings.ContainsAny(to, "\r\n") || strings.ContainsAny(subject, "\r\n") {
+		return fmt.Errorf("[FAIL]: invalid email header")
+	}
+
+	message := strings.Join([]string{
+		"From: " + mailer.from,
+		"To: " + to,
+		"Subject: " + subject,
+		"Date: " + time.Now().Format(time.RFC1123Z),
+		"MIME-Version: 1.0",
+		"Content-Type: text/plain; charset=UTF-8",
+		"",
+		body,
+	}, "\r\n")
+
+	if err := smtp.SendMail(mailer.address, mailer.auth, mailer.from, []string{to}, []byte(message)); err != nil {
+		return fmt.Errorf("[FAIL]: could not send email: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,164 @@
+package mfa
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dev-xero/authentication-backend/model"
+	"github.com/dev-xero/authentication-backend/service"
+	"github.com/joho/godotenv"
+)
+
+/*
+MFA enforcement policy struct
+
+Fields:
+  - RequireAll:    Whether every user must enroll a second factor
+  - RequiredRoles: User roles that must enroll a second factor
+  - GracePeriod:   How long required users can keep using the API before enrolling
+*/
+type Policy struct {
+	RequireAll    bool
+	RequiredRoles []string
+	GracePeriod   time.Duration
+}
+
+/*
+Decision struct, the outcome of evaluating the policy for a user
+
+Fields:
+  - Required:    bool, whether the user must have a second factor
+  - Enrolled:    bool, whether the user has a verified second factor
+  - Reason:      string, why a second factor is required
+  - GraceEndsAt: *time.Time, when unenrolled required users get blocked
+  - Blocked:     bool, whether the grace period is over without enrollment
+*/
+type Decision struct {
+	Required    bool       `json:"required"`
+	Enrolled    bool       `json:"enrolled"`
+	Reason      string     `json:"reason,omitempty"`
+	GraceEndsAt *time.Time `json:"grace_ends_at,omitempty"`
+	Blocked     bool       `json:"blocked"`
+}
+
+/*
+Creates the MFA policy configured through environment variables
+
+Objectives:
+  - Load the global setting and required roles
+  - Load the grace period, 7 days unless configured
+
+Params:
+  - No parameters
+
+Returns:
+  - A pointer to the MFA policy
+  - An error if a setting is malformed
+*/
+func NewPolicyFromEnvironment() (*Policy, error) {
+	// Load environment variables from .env file in development
+	if env := os.Getenv("ENVIRONMENT"); env != "production" {
+		err := godotenv.Load()
+		if err != nil {
+			return nil, fmt.Errorf("[FAIL]: could not load environment variables: %w", err)
+		}
+	}
+
+	policy := &Policy{GracePeriod: 7 * 24 * time.Hour}
+
+	if value := os.Getenv("MFA_REQUIRED_FOR_ALL"); value != "" {
+		requireAll, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("[FAIL]: MFA_REQUIRED_FOR_ALL must be true or false: %w", err)
+		}
+		policy.RequireAll = requireAll
+	}
+
+	for _, role := range strings.Split(os.Getenv("MFA_REQUIRED_ROLES"), ",") {
+		if role = strings.TrimSpace(role); role != "" {
+			policy.RequiredRoles = append(policy.RequiredRoles, role)
+		}
+	}
+
+	if value := os.Getenv("MFA_GRACE_PERIOD_HOURS"); value != "" {
+		hours, err := strconv.Atoi(value)
+		if err != nil || hours < 0 {
+			return nil, fmt.Errorf("[FAIL]: MFA_GRACE_PERIOD_HOURS must be a positive number")
+		}
+		policy.GracePeriod = time.Duration(hours) * time.Hour
+	}
+
+	return policy, nil
+}
+
+/*
+Decides whether a user must have a second factor
+
+Objectives:
+  - Require MFA from the global setting, the user's role or their organizations
+  - Check whether the user has enrolled a second factor
+  - Start the grace period of required users that haven't enrolled
+
+Params:
+  - ctx:       Method context
+  - dbService: The database service provider
+  - user:      The user to evaluate the policy for
+
+Returns:
+  - The policy decision
+  - An error if any lookup fails
+*/
+func (policy *Policy) Evaluate(ctx context.Context, dbService *service.DatabaseProvider, user model.User) (Decision, error) {
+	var decision Decision
+
+	switch {
+	case policy.RequireAll:
+		decision.Required, decision.Reason = true, "required for all users"
+	case policy.requiresRole(user.Role):
+		decision.Required, decision.Reason = true, fmt.Sprintf("required for the %s role", user.Role)
+	default:
+		required, err := dbService.Repo.OrganizationRequiresMFA(ctx, user.ID)
+		if err != nil {
+			return Decision{}, err
+		}
+		if required {
+			decision.Required, decision.Reason = true, "required by your organization"
+		}
+	}
+
+	enrolled, err := dbService.Repo.HasVerifiedPhone(ctx, user.ID)
+	if err != nil {
+		return Decision{}, err
+	}
+	decision.Enrolled = enrolled
+
+	if !decision.Required || decision.Enrolled {
+		return decision, nil
+	}
+
+	startedAt, err := dbService.Repo.StartMFAGracePeriod(ctx, user.ID)
+	if err != nil {
+		return Decision{}, err
+	}
+
+	graceEndsAt := startedAt.Add(policy.GracePeriod)
+	decision.GraceEndsAt = &graceEndsAt
+	decision.Blocked = time.Now().After(graceEndsAt)
+
+	return decision, nil
+}
+
+// Checks whether a role is one of the roles required to enroll
+func (policy *Policy) requiresRole(role string) bool {
+	for _, required := range policy.RequiredRoles {
+		if required == role {
+			return true
+		}
+	}
+
+	return false
+}
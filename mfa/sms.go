@@ -0,0 +1,215 @@
+package mfa
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"time"
+
+	"github.com/dev-xero/authentication-backend/model"
+	repository "github.com/dev-xero/authentication-backend/repository/user"
+	"github.com/dev-xero/authentication-backend/service"
+	"github.com/dev-xero/authentication-backend/util"
+	"github.com/google/uuid"
+)
+
+// What an SMS code was sent for, codes can only be verified for the same purpose
+const (
+	PurposeEnroll = "enroll"
+	PurposeSignIn = "sign-in"
+)
+
+const (
+	codeLifetime = 5 * time.Minute
+	maxAttempts  = 5
+)
+
+// Stores all errors returned when verifying SMS codes
+var (
+	ErrCodeNotPending = errors.New("no verification code is pending, request a new one")
+	ErrCodeMismatch   = errors.New("the verification code is incorrect")
+	ErrCodeExhausted  = errors.New("too many incorrect attempts, request a new code")
+)
+
+/*
+Sends a one-time code to a phone number
+
+Objectives:
+  - Check the destination with the SMS guard
+  - Generate a random 6 digit code and store its hash, once the rate limits of the guard allow it
+  - Deliver the code through the SMS sender
+
+Params:
+  - ctx:        Method context
+  - dbService:  The database service provider
+  - smsService: The SMS service provider
+  - userID:     The user the code is sent for
+  - phone:      The E.164 formatted phone number
+  - purpose:    What the code is sent for
+
+Returns:
+  - An error if the send was refused or failed
+*/
+func SendSMSCode(ctx context.Context, dbService *service.DatabaseProvider, smsService *service.SMSProvider, userID uuid.UUID, phone string, purpose string) error {
+	if err := checkDestination(smsService, phone); err != nil {
+		return err
+	}
+
+	code, err := generateCode()
+	if err != nil {
+		return err
+	}
+
+	codeHash, err := util.GenerateHash(code, util.DefaultHashCost)
+	if err != nil {
+		return err
+	}
+
+	err = dbService.Repo.InsertSMSCodeWithinLimit(ctx, model.SMSCode{
+		ID:        uuid.New(),
+		UserID:    userID,
+		Phone:     phone,
+		Purpose:   purpose,
+		CodeHash:  codeHash,
+		ExpiresAt: time.Now().Add(codeLifetime),
+	}, time.Now().Add(-smsService.Guard.Window), checkRate(smsService, phone))
+	if err != nil {
+		return err
+	}
+
+	message := fmt.Sprintf("Your verification code is %s. It expires in %d minutes.", code, int(codeLifetime.Minutes()))
+	if err := smsService.Sender.Send(ctx, phone, message); err != nil {
+		return fmt.Errorf("[FAIL]: could not send sms: %w", err)
+	}
+
+	return nil
+}
+
+/*
+Sends a notification to a phone number, through the same guard as codes
+
+Objectives:
+  - Check the destination with the SMS guard
+  - Record the notification once the rate limits of the guard allow it, so it counts towards them
+  - Deliver the message through the SMS sender
+
+Params:
+  - ctx:        Method context
+  - dbService:  The database service provider
+  - smsService: The SMS service provider
+  - userID:     The user the notification is sent for
+  - phone:      The E.164 formatted phone number
+  - message:    The notification text
+
+Returns:
+  - An error if the send was refused or failed
+*/
+func SendSMSNotice(ctx context.Context, dbService *service.DatabaseProvider, smsService *service.SMSProvider, userID uuid.UUID, phone string, message string) error {
+	if err := checkDestination(smsService, phone); err != nil {
+		return err
+	}
+
+	err := dbService.Repo.InsertSMSNoticeWithinLimit(ctx, model.SMSNotice{
+		ID:     uuid.New(),
+		UserID: userID,
+		Phone:  phone,
+	}, time.Now().Add(-smsService.Guard.Window), checkRate(smsService, phone))
+	if err != nil {
+		return err
+	}
+
+	if err := smsService.Sender.Send(ctx, phone, message); err != nil {
+		return fmt.Errorf("[FAIL]: could not send sms: %w", err)
+	}
+
+	return nil
+}
+
+/*
+Verifies a one-time code sent to a user
+
+Objectives:
+  - Find the latest pending code for the user and purpose
+  - Count the attempt before comparing, refusing codes with no attempts left
+  - Compare the code with the stored hash and consume it on success
+
+Params:
+  - ctx:       Method context
+  - dbService: The database service provider
+  - userID:    The user the code was sent for
+  - purpose:   What the code was sent for
+  - code:      The code entered by the user
+
+Returns:
+  - The phone number the code was sent to
+  - An error if the code is not valid
+*/
+func VerifySMSCode(ctx context.Context, dbService *service.DatabaseProvider, userID uuid.UUID, purpose string, code string) (string, error) {
+	pending, err := dbService.Repo.GetPendingSMSCode(ctx, userID, purpose)
+	if err != nil {
+		log.Println(err)
+		return "", ErrCodeNotPending
+	}
+
+	if err := dbService.Repo.ClaimSMSCodeAttempt(ctx, pending.ID, maxAttempts); err != nil {
+		if errors.Is(err, repository.ErrSMSCodeExhausted) {
+			return "", ErrCodeExhausted
+		}
+		return "", err
+	}
+
+	if !util.CompareWithHash([]byte(pending.CodeHash), code) {
+		return "", ErrCodeMismatch
+	}
+
+	if err := dbService.Repo.ConsumeSMSCode(ctx, pending.ID); err != nil {
+		log.Println(err)
+		return "", ErrCodeNotPending
+	}
+
+	return pending.Phone, nil
+}
+
+// Checks a phone number against the blocked destinations of the SMS guard
+func checkDestination(smsService *service.SMSProvider, phone string) error {
+	if err := smsService.Guard.CheckDestination(phone); err != nil {
+		log.Printf("[FAIL]: sms destination %s blocked\n", phone)
+		return err
+	}
+
+	return nil
+}
+
+/*
+Returns the rate limit check of the SMS guard, run by the repository under its lock on the destination
+
+Params:
+  - smsService: The SMS service provider
+  - phone:      The E.164 formatted phone number, logged when a limit is reached
+
+Returns:
+  - The check of the recent messages sent to the phone number and for the user
+*/
+func checkRate(smsService *service.SMSProvider, phone string) func(perNumber int, perUser int, lastSent time.Time) error {
+	return func(perNumber int, perUser int, lastSent time.Time) error {
+		if err := smsService.Guard.CheckRate(perNumber, perUser, lastSent); err != nil {
+			log.Printf("[FAIL]: sms rate limit reached for %s\n", phone)
+			return err
+		}
+
+		return nil
+	}
+}
+
+// Generates a random 6 digit code
+func generateCode() (string, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(1000000))
+	if err != nil {
+		return "", fmt.Errorf("[FAIL]: could not generate code: %w", err)
+	}
+
+	return fmt.Sprintf("%06d", n.Int64()), nil
+}
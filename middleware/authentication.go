@@ -1,51 +1,430 @@
 package middleware
 
 import (
+	"context"
+	"fmt"
 	"log"
 	"net/http"
+	"strings"
 
 	"github.com/dev-xero/authentication-backend/authentication"
+	"github.com/dev-xero/authentication-backend/authserver"
+	"github.com/dev-xero/authentication-backend/service"
 	"github.com/dev-xero/authentication-backend/util"
+	"github.com/google/uuid"
+)
+
+type contextKey string
+
+// Context keys the authenticated user id and principal are stored under
+const (
+	userIDKey    contextKey = "userID"
+	principalKey contextKey = "principal"
 )
 
+/*
+Principal struct, who a request was authenticated as
+
+Fields:
+  - UserID:   uuid, the signed-in user or the user a bearer token acts for, uuid.Nil for machine clients
+  - ClientID: string, the client a bearer token was issued to, empty for cookie sessions
+  - Scopes:   []string, the scopes of the bearer token
+*/
+type Principal struct {
+	UserID   uuid.UUID
+	ClientID string
+	Scopes   []string
+}
+
+/*
+Checks whether the principal is a client acting for itself
+
+Returns:
+  - True if the request acts for no user
+*/
+func (principal Principal) IsMachine() bool {
+	return principal.UserID == uuid.Nil
+}
+
 /*
 Authentication middleware for restricting access to protected routes
 
 Objectives:
-  - Obtain the token cookie if present
+  - Authenticate clients by the bearer access token in the Authorization header
+  - Otherwise obtain the token cookie if present
   - Verify the token
   - Authenticate the user based on whether the token is valid
+  - Store the principal, and the user id for cookie sessions, in the request context
 
 Params:
-  - next: A http handler
+  - dbService: The database service provider, access tokens are checked against their revocation state and DPoP proofs recorded
+  - config:    The authorization server config, the public URL of the API is built from, nil when it is disabled
 
 Returns:
-  - A http handler
+  - A http middleware
 */
-func AuthenticateMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		log.Println("[LOG]: authentication requested on:", r.URL)
+func AuthenticateMiddleware(dbService *service.DatabaseProvider, config *authserver.Config) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			log.Println("[LOG]: authentication requested on:", r.URL)
+
+			if r.Header.Get("Authorization") != "" {
+				authenticateBearer(dbService, config, next, w, r)
+				return
+			}
+
+			// Obtain the token cookie
+			tokenString, err := r.Cookie("token")
+			if err != nil {
+				log.Println("[FAIL]: token not present in cookie")
+				msg := "Unauthorized request to a protected endpoint"
+				util.JsonResponse(w, msg, http.StatusUnauthorized, nil)
+				return
+			}
+
+			// Verify the token
+			token, err := authentication.VerifyToken(tokenString.Value)
+			if err != nil {
+				log.Printf("[FAIL]: token verification failed: %v", err)
+				msg := "Failed to verify token"
+				util.JsonResponse(w, msg, http.StatusInternalServerError, nil)
+				return
+			}
+
+			userID, err := authentication.SubjectFromToken(token)
+			if err != nil {
+				log.Println(err)
+				msg := "Failed to verify token"
+				util.JsonResponse(w, msg, http.StatusInternalServerError, nil)
+				return
+			}
+
+			log.Printf("[SUCCESS]: token successfully verified: %v", token.Claims)
+
+			ctx := context.WithValue(r.Context(), userIDKey, userID)
+			ctx = context.WithValue(ctx, principalKey, Principal{UserID: userID})
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+/*
+Authenticates a client by its bearer access token, acting for itself or for a user
+
+Objectives:
+  - Verify the access token was issued for this API, sent with the Bearer scheme, or the DPoP scheme for DPoP bound tokens
+  - Reject revoked tokens, and tokens of deleted clients
+  - Verify the DPoP proof of a bound token was created for this request with the key the token is bound to, and wasn't replayed
+  - Require the client certificate a certificate bound token is bound to, on the connection it was sent over
+  - Store the principal in the request context, without the user id, so routes for signed-in users still require a cookie session
+
+Params:
+  - dbService: The database service provider
+  - config:    The authorization server config, nil when it is disabled
+  - next:      A http handler
+  - w:         A http response writer
+  - r:         A pointer to a http request object
+
+Returns:
+  - No return value
+*/
+func authenticateBearer(dbService *service.DatabaseProvider, config *authserver.Config, next http.Handler, w http.ResponseWriter, r *http.Request) {
+	scheme, tokenString, _ := strings.Cut(r.Header.Get("Authorization"), " ")
+	tokenString = strings.TrimSpace(tokenString)
+	dpop := strings.EqualFold(scheme, "DPoP")
+	if (!strings.EqualFold(scheme, "Bearer") && !dpop) || tokenString == "" {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="api"`)
+		w.Header().Add("WWW-Authenticate", dpopChallenge(""))
+		msg := "Authorization header must hold a bearer or DPoP access token"
+		util.JsonResponse(w, msg, http.StatusUnauthorized, nil)
+		return
+	}
+
+	claims, err := authentication.VerifyAccessToken(tokenString, authentication.APIAudience)
+	if err != nil {
+		log.Println(err)
+		w.Header().Set("WWW-Authenticate", `Bearer realm="api", error="invalid_token"`)
+		msg := "Failed to verify access token"
+		util.JsonResponse(w, msg, http.StatusUnauthorized, nil)
+		return
+	}
 
-		// Obtain the token cookie
-		tokenString, err := r.Cookie("token")
-		if err != nil {
-			log.Println("[FAIL]: token not present in cookie")
-			msg := "Unauthorized request to a protected endpoint"
+	if thumbprint := claims.CertificateThumbprint(); thumbprint != "" {
+		cert, ok := authserver.ClientCertificate(r)
+		if !ok || authserver.CertificateThumbprint(cert) != thumbprint {
+			log.Printf("[FAIL]: certificate bound token of client %s presented without its certificate\n", claims.ClientID)
+			w.Header().Set("WWW-Authenticate", `Bearer realm="api", error="invalid_token"`)
+			msg := "Access token is bound to another client certificate"
 			util.JsonResponse(w, msg, http.StatusUnauthorized, nil)
 			return
 		}
+	}
 
-		// Verify the token
-		token, err := authentication.VerifyToken(tokenString.Value)
-		if err != nil {
-			log.Printf("[FAIL]: token verification failed: %v", err)
-			msg := "Failed to verify token"
-			util.JsonResponse(w, msg, http.StatusInternalServerError, nil)
+	if thumbprint := claims.DPoPKeyThumbprint(); thumbprint != "" || dpop {
+		if !dpop || thumbprint == "" {
+			log.Printf("[FAIL]: access token of client %s sent with the wrong scheme\n", claims.ClientID)
+			w.Header().Set("WWW-Authenticate", dpopChallenge("invalid_token"))
+			msg := "DPoP bound access tokens must be sent with the DPoP scheme, and only they"
+			util.JsonResponse(w, msg, http.StatusUnauthorized, nil)
+			return
+		}
+
+		if err := verifyDPoPProof(r, dbService, config, tokenString, thumbprint); err != nil {
+			log.Println(err)
+			w.Header().Set("WWW-Authenticate", dpopChallenge("invalid_dpop_proof"))
+			msg := "Failed to verify DPoP proof"
+			util.JsonResponse(w, msg, http.StatusUnauthorized, nil)
 			return
 		}
+	}
+
+	principal, err := bearerPrincipal(claims)
+	if err != nil {
+		log.Println(err)
+		w.Header().Set("WWW-Authenticate", `Bearer realm="api", error="invalid_token"`)
+		msg := "Failed to verify access token"
+		util.JsonResponse(w, msg, http.StatusUnauthorized, nil)
+		return
+	}
+
+	revoked, err := accessTokenRevoked(r, dbService, claims)
+	if err != nil {
+		log.Println(err)
+		msg := "Failed to check access token revocation"
+		util.JsonResponse(w, msg, http.StatusInternalServerError, nil)
+		return
+	}
+	if revoked {
+		log.Printf("[FAIL]: revoked access token of client %s presented\n", claims.ClientID)
+		w.Header().Set("WWW-Authenticate", `Bearer realm="api", error="invalid_token"`)
+		msg := "Access token was revoked"
+		util.JsonResponse(w, msg, http.StatusUnauthorized, nil)
+		return
+	}
+
+	log.Printf("[SUCCESS]: access token of client %s successfully verified\n", claims.ClientID)
+
+	ctx := context.WithValue(r.Context(), principalKey, principal)
+	next.ServeHTTP(w, r.WithContext(ctx))
+}
+
+/*
+Builds the principal of a verified access token
+
+Objectives:
+  - Treat a token whose subject is its client as the client acting for itself
+  - Otherwise read the subject as the id of the user the client acts for
+
+Params:
+  - claims: The verified access token claims
+
+Returns:
+  - The principal
+  - An error if the subject is neither the client nor a user id
+*/
+func bearerPrincipal(claims authentication.AccessTokenClaims) (Principal, error) {
+	principal := Principal{ClientID: claims.ClientID, Scopes: strings.Fields(claims.Scope)}
+	if claims.Subject == claims.ClientID {
+		return principal, nil
+	}
+
+	userID, err := uuid.Parse(claims.Subject)
+	if err != nil || userID == uuid.Nil {
+		return Principal{}, fmt.Errorf("[FAIL]: access token of client %s has a malformed subject", claims.ClientID)
+	}
+	principal.UserID = userID
+
+	return principal, nil
+}
+
+/*
+Checks whether an access token was revoked, on its own, with its grant, or by deleting its client
+
+Params:
+  - r:         A pointer to a http request object
+  - dbService: The database service provider
+  - claims:    The verified access token claims
+
+Returns:
+  - True if the token is revoked
+  - An error if the revocation state couldn't be read
+*/
+func accessTokenRevoked(r *http.Request, dbService *service.DatabaseProvider, claims authentication.AccessTokenClaims) (bool, error) {
+	familyID, userID, issuedAt, err := claims.RevocationScope()
+	if err != nil {
+		return true, nil
+	}
+
+	return dbService.Repo.AccessTokenRevoked(r.Context(), claims.ID, familyID, userID, claims.ClientID, issuedAt)
+}
+
+/*
+Verifies the DPoP proof sent with a bound access token
 
-		log.Printf("[SUCCESS]: token successfully verified: %v", token.Claims)
+Objectives:
+  - Check the proof was created for the method and URL of the request and covers the access token
+  - Check the proof was signed with the key the token is bound to
+  - Record the proof id in the same store as the token endpoint, so a captured proof can't be replayed against any instance
+
+Params:
+  - r:           A pointer to a http request object
+  - dbService:   The database service provider
+  - config:      The authorization server config, nil when it is disabled
+  - accessToken: The access token
+  - thumbprint:  The thumbprint of the key the token is bound to
+
+Returns:
+  - An error if the proof is missing, invalid or replayed
+*/
+func verifyDPoPProof(r *http.Request, dbService *service.DatabaseProvider, config *authserver.Config, accessToken string, thumbprint string) error {
+	proof, err := authserver.DPoPProofFromRequest(r)
+	if err != nil {
+		return err
+	}
+	if proof == "" {
+		return fmt.Errorf("[FAIL]: dpop proof is missing")
+	}
+
+	verified, err := authserver.VerifyDPoPProof(proof, r.Method, requestURL(r, config), accessToken)
+	if err != nil {
+		return err
+	}
+
+	if verified.KeyThumbprint != thumbprint {
+		return fmt.Errorf("[FAIL]: dpop proof was signed with another key than the token is bound to")
+	}
+
+	return dbService.Repo.ConsumeDPoPProof(r.Context(), verified.KeyThumbprint, verified.ID, verified.ExpiresAt())
+}
+
+/*
+Returns the URL a request was sent to, as the client sees it, for comparing with the htu claim of DPoP proofs
+
+Params:
+  - r:      A pointer to a http request object
+  - config: The authorization server config, nil when it is disabled
+
+Returns:
+  - The URL, below the same base URL the authorization server uses, or the request host when it is disabled
+*/
+func requestURL(r *http.Request, config *authserver.Config) string {
+	if config != nil {
+		return config.BaseURL(r) + r.URL.EscapedPath()
+	}
+
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+
+	return scheme + "://" + r.Host + r.URL.EscapedPath()
+}
+
+/*
+Builds the DPoP challenge of the WWW-Authenticate header, naming the algorithms proofs may be signed with
+
+Params:
+  - code: The error code, empty when the request carried no token
+
+Returns:
+  - The challenge
+*/
+func dpopChallenge(code string) string {
+	algorithms := strings.Join(authserver.DPoPAlgorithms(), " ")
+	if code == "" {
+		return fmt.Sprintf(`DPoP realm="api", algs=%q`, algorithms)
+	}
+
+	return fmt.Sprintf(`DPoP realm="api", error=%q, algs=%q`, code, algorithms)
+}
+
+/*
+Returns the id of the user authenticated by AuthenticateMiddleware
+
+Params:
+  - ctx: The request context
+
+Returns:
+  - The user id
+  - False if the request was not authenticated, or was authenticated by a bearer access token
+*/
+func UserIDFromContext(ctx context.Context) (uuid.UUID, bool) {
+	userID, ok := ctx.Value(userIDKey).(uuid.UUID)
+	return userID, ok
+}
+
+/*
+Returns the principal authenticated by AuthenticateMiddleware
+
+Params:
+  - ctx: The request context
+
+Returns:
+  - The principal
+  - False if the request was not authenticated
+*/
+func PrincipalFromContext(ctx context.Context) (Principal, bool) {
+	principal, ok := ctx.Value(principalKey).(Principal)
+	return principal, ok
+}
+
+/*
+Restricts a route to signed-in users, must run after AuthenticateMiddleware
+
+Params:
+  - next: A http handler
+
+Returns:
+  - A http handler
+*/
+func RequireUser(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := UserIDFromContext(r.Context()); !ok {
+			msg := "This endpoint is only available to signed-in users"
+			util.JsonResponse(w, msg, http.StatusForbidden, nil)
+			return
+		}
 
 		next.ServeHTTP(w, r)
 	})
 }
+
+/*
+Restricts a route to access tokens granted every scope, cookie sessions are let through, must run after AuthenticateMiddleware
+
+Params:
+  - scopes: The scopes the access token must hold
+
+Returns:
+  - A http middleware
+*/
+func RequireScope(scopes ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			principal, ok := PrincipalFromContext(r.Context())
+			if !ok {
+				msg := "Unauthorized request to a protected endpoint"
+				util.JsonResponse(w, msg, http.StatusUnauthorized, nil)
+				return
+			}
+
+			// Tokens acting for users are limited to the scopes the user granted as well
+			if principal.ClientID != "" {
+				granted := make(map[string]bool, len(principal.Scopes))
+				for _, scope := range principal.Scopes {
+					granted[scope] = true
+				}
+				for _, scope := range scopes {
+					if !granted[scope] {
+						w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="api", error="insufficient_scope", scope=%q`, strings.Join(scopes, " ")))
+						msg := "Access token lacks a required scope"
+						util.JsonResponse(w, msg, http.StatusForbidden, nil)
+						return
+					}
+				}
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
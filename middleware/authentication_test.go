@@ -0,0 +1,154 @@
+package middleware
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dev-xero/authentication-backend/authentication"
+	"github.com/dev-xero/authentication-backend/authserver"
+	"github.com/dev-xero/authentication-backend/internal/testutil"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+// Issues an API access token of a client acting for itself, bound to cert
+func newBoundAccessToken(t *testing.T, cert *x509.Certificate) string {
+	t.Helper()
+
+	// Access tokens are signed with JWT_SECRET_KEY
+	testutil.SetSigningKey(t)
+
+	claims := authentication.AccessTokenClaims{
+		ClientID:     "billing",
+		Confirmation: &authentication.Confirmation{CertificateThumbprint: authserver.CertificateThumbprint(cert)},
+	}
+	claims.Subject = "billing"
+	claims.Audience = jwt.ClaimStrings{authentication.APIAudience}
+
+	token, _, err := authentication.CreateAccessToken(claims, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return token
+}
+
+// Certificate bound tokens are rejected before the revocation check, so no database is needed
+func TestAuthenticateMiddlewareRejectsMismatchedCertificate(t *testing.T) {
+	cert := testutil.NewClientCertificate(t, nil, x509.ExtKeyUsageClientAuth).Cert
+	other := testutil.NewClientCertificate(t, nil, x509.ExtKeyUsageClientAuth).Cert
+	token := newBoundAccessToken(t, cert)
+
+	tests := []struct {
+		name string
+		tls  *tls.ConnectionState
+	}{
+		{name: "another certificate", tls: &tls.ConnectionState{PeerCertificates: []*x509.Certificate{other}}},
+		{name: "no certificate", tls: &tls.ConnectionState{}},
+		{name: "without tls"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				t.Fatal("request reached the protected handler")
+			})
+
+			r := httptest.NewRequest(http.MethodGet, "https://localhost:8443/api/v1/user/me", nil)
+			r.Header.Set("Authorization", "Bearer "+token)
+			r.TLS = test.tls
+			w := httptest.NewRecorder()
+
+			AuthenticateMiddleware(nil, nil)(next).ServeHTTP(w, r)
+
+			if w.Code != http.StatusUnauthorized || !strings.Contains(w.Header().Get("WWW-Authenticate"), `error="invalid_token"`) {
+				t.Fatalf("responded %d, challenge %q", w.Code, w.Header().Get("WWW-Authenticate"))
+			}
+			if !strings.Contains(w.Body.String(), "bound to another client certificate") {
+				t.Fatalf("unexpected response %s", w.Body)
+			}
+		})
+	}
+}
+
+func TestBearerPrincipal(t *testing.T) {
+	userID := uuid.New()
+
+	tests := []struct {
+		name    string
+		subject string
+		want    Principal
+		wantErr bool
+	}{
+		{name: "client acting for itself", subject: "billing", want: Principal{ClientID: "billing", Scopes: []string{"invoices:read"}}},
+		{name: "client acting for a user", subject: userID.String(), want: Principal{UserID: userID, ClientID: "billing", Scopes: []string{"invoices:read"}}},
+		{name: "malformed subject", subject: "someone", wantErr: true},
+		{name: "nil user", subject: uuid.Nil.String(), wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			claims := authentication.AccessTokenClaims{ClientID: "billing", Scope: "invoices:read"}
+			claims.Subject = test.subject
+
+			principal, err := bearerPrincipal(claims)
+			if test.wantErr {
+				if err == nil {
+					t.Fatalf("subject %q was accepted as %+v", test.subject, principal)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if principal.UserID != test.want.UserID || principal.ClientID != test.want.ClientID || strings.Join(principal.Scopes, " ") != strings.Join(test.want.Scopes, " ") {
+				t.Fatalf("principal = %+v, want %+v", principal, test.want)
+			}
+			if principal.IsMachine() != (test.want.UserID == uuid.Nil) {
+				t.Fatalf("IsMachine() = %v for subject %q", principal.IsMachine(), test.subject)
+			}
+		})
+	}
+}
+
+// Tokens acting for users are held to their scopes like machine tokens, cookie sessions aren't
+func TestRequireScope(t *testing.T) {
+	userID := uuid.New()
+
+	tests := []struct {
+		name      string
+		principal Principal
+		want      int
+	}{
+		{name: "cookie session", principal: Principal{UserID: userID}, want: http.StatusOK},
+		{name: "machine token with scope", principal: Principal{ClientID: "billing", Scopes: []string{"invoices:read"}}, want: http.StatusOK},
+		{name: "machine token without scope", principal: Principal{ClientID: "billing"}, want: http.StatusForbidden},
+		{name: "user token with scope", principal: Principal{UserID: userID, ClientID: "billing", Scopes: []string{"invoices:read"}}, want: http.StatusOK},
+		{name: "user token without scope", principal: Principal{UserID: userID, ClientID: "billing", Scopes: []string{"profile"}}, want: http.StatusForbidden},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			})
+
+			r := httptest.NewRequest(http.MethodGet, "https://localhost:8443/api/v1/invoices", nil)
+			r = r.WithContext(context.WithValue(r.Context(), principalKey, test.principal))
+			w := httptest.NewRecorder()
+
+			RequireScope("invoices:read")(next).ServeHTTP(w, r)
+
+			if w.Code != test.want {
+				t.Fatalf("responded %d, want %d", w.Code, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,62 @@
+package middleware
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/dev-xero/authentication-backend/mfa"
+	"github.com/dev-xero/authentication-backend/service"
+	"github.com/dev-xero/authentication-backend/util"
+)
+
+/*
+MFA enforcement middleware, must run after AuthenticateMiddleware
+
+Objectives:
+  - Evaluate the MFA policy for the authenticated user
+  - Block users whose enrollment grace period ended without enrolling
+
+Params:
+  - dbService: The database service provider
+  - policy:    The MFA enforcement policy
+
+Returns:
+  - A http middleware
+*/
+func RequireMFAEnrollment(dbService *service.DatabaseProvider, policy *mfa.Policy) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			userID, ok := UserIDFromContext(r.Context())
+			if !ok {
+				msg := "Unauthorized request to a protected endpoint"
+				util.JsonResponse(w, msg, http.StatusUnauthorized, nil)
+				return
+			}
+
+			user, err := dbService.Repo.GetUserByID(r.Context(), userID.String())
+			if err != nil {
+				log.Println(err)
+				msg := "Internal server error, failed to get user"
+				util.JsonResponse(w, msg, http.StatusInternalServerError, nil)
+				return
+			}
+
+			decision, err := policy.Evaluate(r.Context(), dbService, user)
+			if err != nil {
+				log.Println(err)
+				msg := "Internal server error, could not evaluate mfa policy"
+				util.JsonResponse(w, msg, http.StatusInternalServerError, nil)
+				return
+			}
+
+			if decision.Blocked {
+				log.Printf("[FAIL]: user %s blocked until a second factor is enrolled\n", userID)
+				msg := "A second factor must be enrolled to access this endpoint"
+				util.JsonResponse(w, msg, http.StatusForbidden, decision)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,29 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+/*
+Audit event model struct, a security relevant change to an account
+
+Fields:
+  - ID:        uuid
+  - UserID:    uuid
+  - Event:     string, e.g. "recovery.requested"
+  - Detail:    string, optional context for the event
+  - IPAddress: string, the address the request came from
+  - UserAgent: string
+  - CreatedAt: time.Time
+*/
+type AuditEvent struct {
+	ID        uuid.UUID `json:"id"`
+	UserID    uuid.UUID `json:"user_id"`
+	Event     string    `json:"event"`
+	Detail    string    `json:"detail"`
+	IPAddress string    `json:"ip_address"`
+	UserAgent string    `json:"user_agent"`
+	CreatedAt time.Time `json:"created_at"`
+}
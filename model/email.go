@@ -0,0 +1,40 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+/*
+User email model struct, one of the addresses of a user
+
+Fields:
+
+  - ID:         uuid
+
+  - UserID:     uuid
+
+  - Email:      string
+
+  - Primary:    bool, the address emails are sent to
+
+  - VerifiedAt: *time.Time, nil until the address is verified
+
+  - CreatedAt:  time.Time
+
+  - VerificationTokenHash: string, hash of the emailed verification token
+
+  - VerificationExpiresAt: time.Time, when the verification token expires
+*/
+type UserEmail struct {
+	ID         uuid.UUID  `json:"id"`
+	UserID     uuid.UUID  `json:"user_id"`
+	Email      string     `json:"email"`
+	Primary    bool       `json:"primary"`
+	VerifiedAt *time.Time `json:"verified_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+
+	VerificationTokenHash string    `json:"-"`
+	VerificationExpiresAt time.Time `json:"-"`
+}
@@ -0,0 +1,67 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+/*
+User identity model struct, an external account the user signs-in with
+
+Fields:
+  - ID:           uuid
+  - UserID:       uuid
+  - Provider:     string, e.g. "google"
+  - Subject:      string, the user id at the provider
+  - Email:        string, the email the provider reported when linking
+  - CreatedAt:    time.Time
+  - LastSignInAt: *time.Time
+*/
+type UserIdentity struct {
+	ID           uuid.UUID  `json:"id"`
+	UserID       uuid.UUID  `json:"user_id"`
+	Provider     string     `json:"provider"`
+	Subject      string     `json:"subject"`
+	Email        string     `json:"email"`
+	CreatedAt    time.Time  `json:"created_at"`
+	LastSignInAt *time.Time `json:"last_sign_in_at"`
+}
+
+/*
+External profile struct, the user an identity provider authenticated
+
+Fields:
+  - Subject:       string, the user id at the provider
+  - Name:          string
+  - Email:         string
+  - EmailVerified: bool, whether the provider verified the email
+  - PrivateEmail:  bool, whether the email is a relay address hiding the user's own, e.g. Apple's private relay
+*/
+type ExternalProfile struct {
+	Subject       string
+	Name          string
+	Email         string
+	EmailVerified bool
+	PrivateEmail  bool
+}
+
+/*
+Identity token model struct, the provider tokens stored for an identity
+
+Fields:
+  - IdentityID: uuid
+  - UserID:     uuid
+  - Provider:   string
+  - Subject:    string
+  - Token:      string, the encrypted provider token
+  - UpdatedAt:  time.Time
+*/
+type IdentityToken struct {
+	IdentityID uuid.UUID `json:"identity_id"`
+	UserID     uuid.UUID `json:"user_id"`
+	Provider   string    `json:"provider"`
+	Subject    string    `json:"subject"`
+	Token      string    `json:"-"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
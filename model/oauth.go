@@ -0,0 +1,260 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Token endpoint authentication methods of OAuth clients
+const (
+	ClientAuthSecretBasic = "client_secret_basic"
+	ClientAuthSecretPost  = "client_secret_post"
+	ClientAuthNone        = "none"
+	ClientAuthPrivateKey  = "private_key_jwt"
+	ClientAuthTLS         = "tls_client_auth"
+	ClientAuthSelfSigned  = "self_signed_tls_client_auth"
+)
+
+// Grant types OAuth clients may be allowed to use
+const (
+	GrantAuthorizationCode = "authorization_code"
+	GrantRefreshToken      = "refresh_token"
+	GrantClientCredentials = "client_credentials"
+	GrantDeviceCode        = "urn:ietf:params:oauth:grant-type:device_code"
+	GrantTokenExchange     = "urn:ietf:params:oauth:grant-type:token-exchange"
+)
+
+// States of a device authorization, approved ones become consumed once the device gets its tokens
+const (
+	DeviceStatusPending  = "pending"
+	DeviceStatusApproved = "approved"
+	DeviceStatusDenied   = "denied"
+	DeviceStatusConsumed = "consumed"
+)
+
+/*
+OAuth client model struct, an application users sign-in to with their account
+
+Fields:
+  - ID:                      string, the client_id
+  - SecretHash:              string, hash of the client secret, empty for public clients
+  - Name:                    string
+  - RedirectURIs:            []string, the exact URIs codes may be sent to
+  - Scopes:                  []string, the scopes the client may request
+  - GrantTypes:              []string, the grant types the client may use
+  - TokenEndpointAuthMethod: string, how the client authenticates at the token endpoint
+  - JWKS:                    string, the JSON Web Key Set client assertions are verified with, for private_key_jwt
+  - Audiences:               []string, the services the client may request tokens for with its own credentials
+  - FirstParty:              bool, whether the client is operated by us
+  - ClientURI:               string, the home page of the client, shown to users
+  - LogoURI:                 string, the logo of the client, shown to users
+  - PolicyURI:               string, the privacy policy of the client, shown to users
+  - TOSURI:                  string, the terms of service of the client, shown to users
+  - Contacts:                []string, email addresses of the people responsible for the client
+  - SubjectTokenClients:     []string, the clients whose tokens the client may exchange with token exchange
+  - DPoPBoundAccessTokens:   bool, whether every token request of the client must carry a DPoP proof
+  - TLSSubjectDN:            string, the subject DN of the certificate a tls_client_auth client authenticates with
+  - TLSSANDNS:               string, the DNS name of the certificate a tls_client_auth client authenticates with
+  - RegistrationTokenHash:   string, hash of the registration access token, empty for clients not registered dynamically
+  - CreatedAt:               time.Time
+*/
+type OAuthClient struct {
+	ID                      string    `json:"client_id"`
+	SecretHash              string    `json:"-"`
+	Name                    string    `json:"client_name"`
+	RedirectURIs            []string  `json:"redirect_uris"`
+	Scopes                  []string  `json:"scopes"`
+	GrantTypes              []string  `json:"grant_types"`
+	TokenEndpointAuthMethod string    `json:"token_endpoint_auth_method"`
+	JWKS                    string    `json:"jwks,omitempty"`
+	Audiences               []string  `json:"audiences,omitempty"`
+	FirstParty              bool      `json:"first_party"`
+	ClientURI               string    `json:"client_uri,omitempty"`
+	LogoURI                 string    `json:"logo_uri,omitempty"`
+	PolicyURI               string    `json:"policy_uri,omitempty"`
+	TOSURI                  string    `json:"tos_uri,omitempty"`
+	Contacts                []string  `json:"contacts,omitempty"`
+	SubjectTokenClients     []string  `json:"subject_token_clients,omitempty"`
+	DPoPBoundAccessTokens   bool      `json:"dpop_bound_access_tokens"`
+	TLSSubjectDN            string    `json:"tls_client_auth_subject_dn,omitempty"`
+	TLSSANDNS               string    `json:"tls_client_auth_san_dns,omitempty"`
+	RegistrationTokenHash   string    `json:"-"`
+	CreatedAt               time.Time `json:"created_at"`
+}
+
+/*
+Authorization code model struct, a code the authorize endpoint issued to a client
+
+Fields:
+  - CodeHash:      string, hash of the code
+  - ClientID:      string
+  - UserID:        uuid, the user that authorized the client
+  - RedirectURI:   string, the redirect URI the code was sent to
+  - Scope:         string, the granted scopes, space separated
+  - CodeChallenge: string, the S256 PKCE challenge
+  - Nonce:         string, the OpenID Connect nonce, echoed in the id token
+  - AuthTime:      time.Time, when the user signed-in
+  - ExpiresAt:     time.Time
+*/
+type AuthorizationCode struct {
+	CodeHash      string
+	ClientID      string
+	UserID        uuid.UUID
+	RedirectURI   string
+	Scope         string
+	CodeChallenge string
+	Nonce         string
+	AuthTime      time.Time
+	ExpiresAt     time.Time
+}
+
+/*
+Device authorization model struct, a device waiting for a user to approve it
+
+Fields:
+  - DeviceCodeHash: string, hash of the device code the device polls with
+  - UserCode:       string, the normalized code the user enters
+  - ClientID:       string
+  - Scope:          string, the requested scopes, space separated
+  - Status:         string, pending, approved, denied or consumed
+  - UserID:         uuid, the user that approved or denied the device, uuid.Nil while pending
+  - AuthTime:       time.Time, when that user signed-in
+  - PollInterval:   int, seconds the device must wait between polls
+  - ExpiresAt:      time.Time
+*/
+type DeviceAuthorization struct {
+	DeviceCodeHash string
+	UserCode       string
+	ClientID       string
+	Scope          string
+	Status         string
+	UserID         uuid.UUID
+	AuthTime       time.Time
+	PollInterval   int
+	ExpiresAt      time.Time
+}
+
+/*
+OAuth grant model struct, the scopes a user consented to share with a client
+
+Fields:
+  - UserID:     uuid
+  - ClientID:   string
+  - ClientName: string, the name of the client, shown when listing grants
+  - Scopes:     []string, the scopes the user consented to
+  - CreatedAt:  time.Time, when the user first consented
+  - UpdatedAt:  time.Time, when the user last consented to more scopes
+*/
+type OAuthGrant struct {
+	UserID     uuid.UUID `json:"-"`
+	ClientID   string    `json:"client_id"`
+	ClientName string    `json:"client_name"`
+	Scopes     []string  `json:"scopes"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+/*
+Refresh token model struct, a refresh token issued to a client, rotated on every use
+
+Fields:
+  - TokenHash:         string, hash of the token
+  - FamilyID:          uuid, shared by every token rotated from the same grant
+  - ClientID:          string
+  - UserID:            uuid
+  - Scope:             string, the granted scopes, space separated
+  - CodeHash:          string, hash of the authorization code the family was issued for
+  - AuthTime:          time.Time, when the user signed-in, zero if unknown
+  - DPoPKeyThumbprint: string, the DPoP key a public client must prove possession of to use the token, empty if unbound
+  - CertThumbprint:    string, the client certificate a public client must present to use the token, empty if unbound
+  - ExpiresAt:         time.Time
+*/
+type RefreshToken struct {
+	TokenHash         string
+	FamilyID          uuid.UUID
+	ClientID          string
+	UserID            uuid.UUID
+	Scope             string
+	CodeHash          string
+	AuthTime          time.Time
+	DPoPKeyThumbprint string
+	CertThumbprint    string
+	ExpiresAt         time.Time
+}
+
+/*
+Checks whether the client may use a grant type
+
+Params:
+  - grantType: The grant type
+
+Returns:
+  - True if the grant type is registered for the client
+*/
+func (client OAuthClient) AllowsGrant(grantType string) bool {
+	for _, registered := range client.GrantTypes {
+		if registered == grantType {
+			return true
+		}
+	}
+
+	return false
+}
+
+/*
+Checks whether the client may request tokens for an audience
+
+Params:
+  - audience: The audience
+
+Returns:
+  - True if the audience is registered for the client
+*/
+func (client OAuthClient) AllowsAudience(audience string) bool {
+	for _, registered := range client.Audiences {
+		if registered == audience {
+			return true
+		}
+	}
+
+	return false
+}
+
+/*
+Checks whether the client may exchange tokens issued to another client
+
+Params:
+  - clientID: The client the subject token was issued to
+
+Returns:
+  - True if that client is registered as a subject token client
+*/
+func (client OAuthClient) AllowsSubjectTokenClient(clientID string) bool {
+	for _, registered := range client.SubjectTokenClients {
+		if registered == clientID {
+			return true
+		}
+	}
+
+	return false
+}
+
+/*
+Checks whether a redirect URI is registered for the client, URIs are compared exactly
+
+Params:
+  - redirectURI: The redirect URI
+
+Returns:
+  - True if the redirect URI is registered
+*/
+func (client OAuthClient) AllowsRedirectURI(redirectURI string) bool {
+	for _, registered := range client.RedirectURIs {
+		if registered == redirectURI {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,59 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+/*
+User phone model struct, a phone number enrolled as an SMS second factor
+
+Fields:
+  - UserID:     uuid
+  - Phone:      string, E.164 formatted
+  - VerifiedAt: *time.Time, nil until the number is verified
+  - CreatedAt:  time.Time
+*/
+type UserPhone struct {
+	UserID     uuid.UUID  `json:"user_id"`
+	Phone      string     `json:"phone"`
+	VerifiedAt *time.Time `json:"verified_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+/*
+SMS code model struct, a one-time code sent to a phone number
+
+Fields:
+  - ID:        uuid
+  - UserID:    uuid
+  - Phone:     string, E.164 formatted
+  - Purpose:   string, what the code was sent for
+  - CodeHash:  string
+  - Attempts:  int, failed verification attempts
+  - ExpiresAt: time.Time
+*/
+type SMSCode struct {
+	ID        uuid.UUID
+	UserID    uuid.UUID
+	Phone     string
+	Purpose   string
+	CodeHash  string
+	Attempts  int
+	ExpiresAt time.Time
+}
+
+/*
+SMS notice model struct, a notification sent to a phone number, recorded so it counts towards the rate limits
+
+Fields:
+  - ID:     uuid
+  - UserID: uuid
+  - Phone:  string, E.164 formatted
+*/
+type SMSNotice struct {
+	ID     uuid.UUID
+	UserID uuid.UUID
+	Phone  string
+}
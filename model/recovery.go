@@ -0,0 +1,37 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Account recovery statuses
+const (
+	RecoveryPendingVerification = "pending_verification"
+	RecoveryWaiting             = "waiting"
+	RecoveryCancelled           = "cancelled"
+	RecoveryCompleted           = "completed"
+)
+
+/*
+Account recovery model struct, a request to remove every second factor of an account
+
+Fields:
+  - ID:         uuid
+  - UserID:     uuid
+  - TokenHash:  string, hash of the token emailed to the account
+  - Status:     string, one of the recovery statuses
+  - ExpiresAt:  time.Time, when the emailed token stops verifying the request
+  - EligibleAt: *time.Time, when the waiting period ends
+  - CreatedAt:  time.Time
+*/
+type AccountRecovery struct {
+	ID         uuid.UUID  `json:"id"`
+	UserID     uuid.UUID  `json:"user_id"`
+	TokenHash  string     `json:"-"`
+	Status     string     `json:"status"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	EligibleAt *time.Time `json:"eligible_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
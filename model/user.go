@@ -10,10 +10,12 @@ Fields:
   - Username: string
   - Email:    string
   - Password: string
+  - Role:     string, "user" unless promoted
 */
 type User struct {
 	ID       uuid.UUID `json:"id"`
 	Username string    `json:"username"`
 	Email    string    `json:"email"`
 	Password string    `json:"password"`
+	Role     string    `json:"role"`
 }
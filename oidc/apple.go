@@ -0,0 +1,156 @@
+package oidc
+
+import (
+	"crypto/ecdsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/oauth2"
+)
+
+// Apple's issuer, its endpoints are below it
+const AppleBaseURL = "https://appleid.apple.com"
+
+// How long a signed Apple client secret is used before signing a new one, Apple accepts up to 6 months
+const appleClientSecretLifetime = time.Hour
+
+/*
+Returns Apple's endpoints below a base URL, so a local mock can stand in for Apple
+
+Params:
+  - baseURL: The Apple base URL, also the id token issuer
+
+Returns:
+  - The Apple endpoints
+*/
+func AppleEndpoints(baseURL string) Endpoints {
+	baseURL = strings.TrimSuffix(baseURL, "/")
+	return Endpoints{
+		AuthURL:           baseURL + "/auth/authorize",
+		TokenURL:          baseURL + "/auth/token",
+		JWKSURL:           baseURL + "/auth/keys",
+		SigningAlgorithms: []string{"RS256"},
+		AuthStyle:         oauth2.AuthStyleInParams,
+	}
+}
+
+/*
+Apple client secret struct, signs the ES256 client secret Apple requires in place of a static one
+
+Fields:
+  - teamID:    The Apple developer team id, the secret issuer
+  - keyID:     The id of the Sign in with Apple private key
+  - clientID:  The services id, the secret subject
+  - audience:  The Apple base URL
+  - key:       The Sign in with Apple private key
+  - mu:        Guards the cached secret
+  - secret:    The cached secret
+  - expiresAt: When the cached secret expires
+*/
+type AppleClientSecret struct {
+	teamID   string
+	keyID    string
+	clientID string
+	audience string
+	key      *ecdsa.PrivateKey
+
+	mu        sync.Mutex
+	secret    string
+	expiresAt time.Time
+}
+
+/*
+Creates an Apple client secret signer
+
+Params:
+  - teamID:   The Apple developer team id
+  - keyID:    The id of the Sign in with Apple private key
+  - clientID: The services id
+  - audience: The Apple base URL
+  - key:      The Sign in with Apple private key
+
+Returns:
+  - A pointer to the signer
+*/
+func NewAppleClientSecret(teamID string, keyID string, clientID string, audience string, key *ecdsa.PrivateKey) *AppleClientSecret {
+	return &AppleClientSecret{
+		teamID:   teamID,
+		keyID:    keyID,
+		clientID: clientID,
+		audience: strings.TrimSuffix(audience, "/"),
+		key:      key,
+	}
+}
+
+/*
+Returns a signed client secret, reusing it until shortly before it expires
+
+Params:
+  - No parameters
+
+Returns:
+  - The signed client secret
+  - An error if signing failed
+*/
+func (clientSecret *AppleClientSecret) Secret() (string, error) {
+	clientSecret.mu.Lock()
+	defer clientSecret.mu.Unlock()
+
+	now := time.Now()
+	if clientSecret.secret != "" && now.Add(5*time.Minute).Before(clientSecret.expiresAt) {
+		return clientSecret.secret, nil
+	}
+
+	expiresAt := now.Add(appleClientSecretLifetime)
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, jwt.RegisteredClaims{
+		Issuer:    clientSecret.teamID,
+		Subject:   clientSecret.clientID,
+		Audience:  jwt.ClaimStrings{clientSecret.audience},
+		IssuedAt:  jwt.NewNumericDate(now),
+		ExpiresAt: jwt.NewNumericDate(expiresAt),
+	})
+	token.Header["kid"] = clientSecret.keyID
+
+	secret, err := token.SignedString(clientSecret.key)
+	if err != nil {
+		return "", fmt.Errorf("[FAIL]: could not sign apple client secret: %w", err)
+	}
+
+	clientSecret.secret = secret
+	clientSecret.expiresAt = expiresAt
+	return secret, nil
+}
+
+/*
+Parses the PEM encoded PKCS #8 private key Apple issues for Sign in with Apple
+
+Params:
+  - data: The contents of the .p8 key file
+
+Returns:
+  - The P-256 private key
+  - An error if the key isn't a PKCS #8 P-256 key
+*/
+func ParseApplePrivateKey(data []byte) (*ecdsa.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("[FAIL]: apple private key is not PEM encoded")
+	}
+
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("[FAIL]: could not parse apple private key: %w", err)
+	}
+
+	key, ok := parsed.(*ecdsa.PrivateKey)
+	if !ok || key.Curve.Params().Name != "P-256" {
+		return nil, fmt.Errorf("[FAIL]: apple private key is not a P-256 key")
+	}
+
+	return key, nil
+}
@@ -0,0 +1,156 @@
+package oidc
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/oauth2"
+)
+
+func newTestAppleKey(t *testing.T) *ecdsa.PrivateKey {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return key
+}
+
+// Verifies a client secret as Apple would, with the public part of the key
+func parseAppleClientSecret(t *testing.T, key *ecdsa.PrivateKey, secret string) (*jwt.Token, jwt.RegisteredClaims) {
+	t.Helper()
+
+	var claims jwt.RegisteredClaims
+	token, err := jwt.ParseWithClaims(secret, &claims, func(token *jwt.Token) (interface{}, error) {
+		return &key.PublicKey, nil
+	}, jwt.WithValidMethods([]string{"ES256"}), jwt.WithExpirationRequired())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return token, claims
+}
+
+func TestAppleClientSecretClaims(t *testing.T) {
+	key := newTestAppleKey(t)
+	signer := NewAppleClientSecret("TEAM123456", "KEY1234567", "com.example.web", AppleBaseURL+"/", key)
+
+	secret, err := signer.Secret()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token, claims := parseAppleClientSecret(t, key, secret)
+	if token.Header["kid"] != "KEY1234567" {
+		t.Errorf("kid = %v", token.Header["kid"])
+	}
+	if claims.Issuer != "TEAM123456" || claims.Subject != "com.example.web" {
+		t.Errorf("iss = %q, sub = %q", claims.Issuer, claims.Subject)
+	}
+	if len(claims.Audience) != 1 || claims.Audience[0] != AppleBaseURL {
+		t.Errorf("aud = %v, want %s", claims.Audience, AppleBaseURL)
+	}
+	if lifetime := claims.ExpiresAt.Sub(claims.IssuedAt.Time); lifetime != appleClientSecretLifetime {
+		t.Errorf("secret is valid for %v", lifetime)
+	}
+}
+
+func TestAppleClientSecretIsReusedUntilExpiry(t *testing.T) {
+	signer := NewAppleClientSecret("TEAM123456", "KEY1234567", "com.example.web", AppleBaseURL, newTestAppleKey(t))
+
+	first, err := signer.Secret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := signer.Secret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first != second {
+		t.Fatal("secret was signed again before it expires")
+	}
+
+	// Secrets are replaced shortly before they expire
+	signer.expiresAt = time.Now().Add(time.Minute)
+	third, err := signer.Secret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if third == first {
+		t.Fatal("expiring secret was reused")
+	}
+}
+
+func TestParseApplePrivateKey(t *testing.T) {
+	encode := func(key interface{}) []byte {
+		der, err := x509.MarshalPKCS8PrivateKey(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+	}
+
+	key := newTestAppleKey(t)
+	parsed, err := ParseApplePrivateKey(encode(key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !parsed.Equal(key) {
+		t.Fatal("parsed key differs")
+	}
+
+	p384, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, data := range map[string][]byte{"P-384 key": encode(p384), "RSA key": encode(rsaKey), "not PEM": []byte("key")} {
+		if _, err := ParseApplePrivateKey(data); err == nil {
+			t.Errorf("%s was accepted", name)
+		}
+	}
+}
+
+// Apple authenticates token requests with the signed secret, posted in the form
+func TestAppleEndpointsPostClientSecret(t *testing.T) {
+	issuer := newTestIssuer(t)
+	key := newTestAppleKey(t)
+
+	provider := NewProviderWithEndpoints(Config{
+		Name:             "apple",
+		Issuer:           issuer.server.URL,
+		ClientID:         "com.example.web",
+		RedirectURL:      "https://app.example.com/auth/oauth/apple/callback",
+		ClientSecretFunc: NewAppleClientSecret("TEAM123456", "KEY1234567", "com.example.web", issuer.server.URL, key).Secret,
+		HTTPClient:       issuer.server.Client(),
+	}, AppleEndpoints(issuer.server.URL+"/"))
+
+	verifier := oauth2.GenerateVerifier()
+	if _, err := provider.Exchange(context.Background(), "code-1", oauth2.VerifierOption(verifier)); err != nil {
+		t.Fatal(err)
+	}
+
+	form := issuer.tokenForm
+	if form.Get("client_id") != "com.example.web" || form.Get("code_verifier") != verifier {
+		t.Errorf("unexpected token request %v", form)
+	}
+
+	_, claims := parseAppleClientSecret(t, key, form.Get("client_secret"))
+	if claims.Subject != "com.example.web" || len(claims.Audience) != 1 || claims.Audience[0] != issuer.server.URL {
+		t.Errorf("unexpected client secret claims %+v", claims)
+	}
+}
@@ -0,0 +1,177 @@
+package oidc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/joho/godotenv"
+)
+
+// Returned when a provider name isn't configured
+var ErrUnknownProvider = errors.New("unknown identity provider")
+
+// The Google issuer, configured through the GOOGLE_* variables
+const googleIssuer = "https://accounts.google.com"
+
+/*
+Providers struct, the configured providers, discovered on first use
+
+Fields:
+  - configs: The provider configs by name
+  - loaded:  The discovered providers by name
+*/
+type Providers struct {
+	configs map[string]Config
+
+	mu     sync.Mutex
+	loaded map[string]*Provider
+}
+
+/*
+Creates the set of configured providers
+
+Params:
+  - configs: The provider configs, names must be unique
+
+Returns:
+  - A pointer to the providers
+*/
+func NewProviders(configs ...Config) *Providers {
+	providers := &Providers{
+		configs: make(map[string]Config, len(configs)),
+		loaded:  make(map[string]*Provider, len(configs)),
+	}
+
+	for _, config := range configs {
+		providers.configs[config.Name] = config
+	}
+
+	return providers
+}
+
+/*
+Creates the providers configured through environment variables
+
+Objectives:
+  - Configure Google when GOOGLE_CLIENT_ID is set
+  - Configure every provider named in OIDC_PROVIDERS from its OIDC_<NAME>_* variables
+
+Params:
+  - No parameters
+
+Returns:
+  - A pointer to the providers
+  - An error if a provider is misconfigured
+*/
+func NewProvidersFromEnvironment() (*Providers, error) {
+	// Load environment variables from .env file in development
+	if env := os.Getenv("ENVIRONMENT"); env != "production" {
+		err := godotenv.Load()
+		if err != nil {
+			return nil, fmt.Errorf("[FAIL]: could not load environment variables: %w", err)
+		}
+	}
+
+	var configs []Config
+
+	if clientID := os.Getenv("GOOGLE_CLIENT_ID"); clientID != "" {
+		configs = append(configs, Config{
+			Name:          "google",
+			Issuer:        googleIssuer,
+			ClientID:      clientID,
+			ClientSecret:  os.Getenv("GOOGLE_CLIENT_SECRET"),
+			RedirectURL:   os.Getenv("GOOGLE_OAUTH_REDIRECT_URL"),
+			Scopes:        googleScopes(),
+			OfflineAccess: os.Getenv("GOOGLE_OFFLINE_ACCESS") == "true",
+		})
+	}
+
+	for _, name := range splitList(os.Getenv("OIDC_PROVIDERS")) {
+		name = strings.ToLower(name)
+		prefix := "OIDC_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_")) + "_"
+
+		config := Config{
+			Name:               name,
+			Issuer:             os.Getenv(prefix + "ISSUER"),
+			ClientID:           os.Getenv(prefix + "CLIENT_ID"),
+			ClientSecret:       os.Getenv(prefix + "CLIENT_SECRET"),
+			RedirectURL:        os.Getenv(prefix + "REDIRECT_URL"),
+			Scopes:             splitList(os.Getenv(prefix + "SCOPES")),
+			EmailClaim:         os.Getenv(prefix + "EMAIL_CLAIM"),
+			NameClaim:          os.Getenv(prefix + "NAME_CLAIM"),
+			EmailVerifiedClaim: os.Getenv(prefix + "EMAIL_VERIFIED_CLAIM"),
+			TrustEmail:         os.Getenv(prefix+"TRUST_EMAIL") == "true",
+			OfflineAccess:      os.Getenv(prefix+"OFFLINE_ACCESS") == "true",
+		}
+
+		if config.Issuer == "" || config.ClientID == "" || config.RedirectURL == "" {
+			return nil, fmt.Errorf("[FAIL]: %sISSUER, %sCLIENT_ID and %sREDIRECT_URL must be set", prefix, prefix, prefix)
+		}
+
+		if len(config.Scopes) == 0 {
+			config.Scopes = []string{"email", "profile"}
+		}
+
+		configs = append(configs, config)
+	}
+
+	return NewProviders(configs...), nil
+}
+
+/*
+Returns a configured provider, loading its discovery document on first use
+
+Params:
+  - ctx:  Method context
+  - name: The provider name
+
+Returns:
+  - A pointer to the provider
+  - ErrUnknownProvider if no provider has the name, or an error if discovery fails
+*/
+func (providers *Providers) Get(ctx context.Context, name string) (*Provider, error) {
+	providers.mu.Lock()
+	defer providers.mu.Unlock()
+
+	if provider, ok := providers.loaded[name]; ok {
+		return provider, nil
+	}
+
+	config, ok := providers.configs[name]
+	if !ok {
+		return nil, ErrUnknownProvider
+	}
+
+	// Failed discoveries are retried by the next request
+	provider, err := NewProvider(ctx, config)
+	if err != nil {
+		return nil, err
+	}
+
+	providers.loaded[name] = provider
+	return provider, nil
+}
+
+// Returns the Google scopes, GOOGLE_SCOPES requests API access beyond the profile
+func googleScopes() []string {
+	if scopes := splitList(os.Getenv("GOOGLE_SCOPES")); len(scopes) > 0 {
+		return scopes
+	}
+	return []string{"email", "profile"}
+}
+
+// Splits a comma separated list, dropping empty entries
+func splitList(list string) []string {
+	var entries []string
+	for _, entry := range strings.Split(list, ",") {
+		if entry = strings.TrimSpace(entry); entry != "" {
+			entries = append(entries, entry)
+		}
+	}
+
+	return entries
+}
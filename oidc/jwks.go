@@ -0,0 +1,231 @@
+package oidc
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Unknown key ids refetch the key set at most this often, so forged ids can't flood the issuer
+const minRefreshInterval = time.Minute
+
+/*
+JSON web key struct, the fields of RSA and EC signing keys
+
+Fields:
+  - KeyType: string, "RSA" or "EC"
+  - KeyID:   string
+  - Use:     string, keys used for anything but signatures are skipped
+  - N, E:    string, the RSA modulus and exponent
+  - Curve:   string, the EC curve
+  - X, Y:    string, the EC point
+*/
+type jsonWebKey struct {
+	KeyType string `json:"kty"`
+	KeyID   string `json:"kid"`
+	Use     string `json:"use"`
+	N       string `json:"n"`
+	E       string `json:"e"`
+	Curve   string `json:"crv"`
+	X       string `json:"x"`
+	Y       string `json:"y"`
+}
+
+/*
+Key set struct, caches the signing keys published by an issuer
+
+Fields:
+  - uri:       The JWKS endpoint
+  - client:    The http client fetching the keys
+  - keys:      The public keys by key id
+  - fetchedAt: When the keys were last fetched
+*/
+type KeySet struct {
+	uri    string
+	client *http.Client
+
+	mu        sync.Mutex
+	keys      map[string]interface{}
+	fetchedAt time.Time
+}
+
+/*
+Creates a key set fetching its keys from a JWKS endpoint
+
+Params:
+  - uri:    The JWKS endpoint
+  - client: The http client fetching the keys
+
+Returns:
+  - A pointer to the key set, keys are fetched on first use
+*/
+func NewKeySet(uri string, client *http.Client) *KeySet {
+	return &KeySet{uri: uri, client: client}
+}
+
+/*
+Returns the public key with the key id, refetching the keys when it is unknown
+
+Params:
+  - ctx:   Method context
+  - keyID: The key id of the token header, tokens without one need a set with a single key
+
+Returns:
+  - The RSA or ECDSA public key
+  - An error if no key matches
+*/
+func (set *KeySet) Key(ctx context.Context, keyID string) (interface{}, error) {
+	set.mu.Lock()
+	defer set.mu.Unlock()
+
+	if key, ok := set.lookup(keyID); ok {
+		return key, nil
+	}
+
+	// Issuers rotate keys, so an unknown key id refreshes the set
+	if time.Since(set.fetchedAt) >= minRefreshInterval {
+		if err := set.refresh(ctx); err != nil {
+			return nil, err
+		}
+
+		if key, ok := set.lookup(keyID); ok {
+			return key, nil
+		}
+	}
+
+	return nil, fmt.Errorf("[FAIL]: no signing key with id %q", keyID)
+}
+
+// Looks up a cached key, the caller must hold the lock
+func (set *KeySet) lookup(keyID string) (interface{}, bool) {
+	if keyID == "" && len(set.keys) == 1 {
+		for _, key := range set.keys {
+			return key, true
+		}
+	}
+
+	key, ok := set.keys[keyID]
+	return key, ok
+}
+
+// Fetches the keys from the JWKS endpoint, the caller must hold the lock
+func (set *KeySet) refresh(ctx context.Context) error {
+	set.fetchedAt = time.Now()
+
+	var document struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := getJSON(ctx, set.client, set.uri, &document); err != nil {
+		return fmt.Errorf("[FAIL]: could not fetch signing keys: %w", err)
+	}
+
+	keys := make(map[string]interface{}, len(document.Keys))
+	for _, jwk := range document.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+
+		key, err := jwk.publicKey()
+		if err != nil {
+			// Keys of unsupported types don't prevent using the others
+			continue
+		}
+		keys[jwk.KeyID] = key
+	}
+
+	set.keys = keys
+	return nil
+}
+
+/*
+Decodes the public key of a JSON web key
+
+Returns:
+  - The RSA or ECDSA public key
+  - An error if the key type or curve is unsupported or the key is malformed
+*/
+func (jwk jsonWebKey) publicKey() (interface{}, error) {
+	switch jwk.KeyType {
+	case "RSA":
+		n, err := decodeBigInt(jwk.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(jwk.E)
+		if err != nil {
+			return nil, err
+		}
+		if !e.IsInt64() || e.Int64() < 3 {
+			return nil, fmt.Errorf("[FAIL]: invalid RSA exponent")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+
+	case "EC":
+		var curve elliptic.Curve
+		switch jwk.Curve {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("[FAIL]: unsupported curve %q", jwk.Curve)
+		}
+
+		x, err := decodeBigInt(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(jwk.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, fmt.Errorf("[FAIL]: EC point is not on the curve")
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+
+	default:
+		return nil, fmt.Errorf("[FAIL]: unsupported key type %q", jwk.KeyType)
+	}
+}
+
+// Decodes a base64url encoded big-endian integer
+func decodeBigInt(value string) (*big.Int, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil || len(raw) == 0 {
+		return nil, fmt.Errorf("[FAIL]: malformed key parameter")
+	}
+
+	return new(big.Int).SetBytes(raw), nil
+}
+
+// Fetches a JSON document and decodes it into target
+func getJSON(ctx context.Context, client *http.Client, url string, target interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s responded with status %d", url, res.StatusCode)
+	}
+
+	return json.NewDecoder(res.Body).Decode(target)
+}
@@ -0,0 +1,450 @@
+package oidc
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/dev-xero/authentication-backend/model"
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/oauth2"
+)
+
+// Stores errors callers need to tell apart when authenticating
+var (
+	ErrNoIDToken     = errors.New("token response has no id_token")
+	ErrNonceMismatch = errors.New("id_token nonce doesn't match the sign-in request")
+	ErrMissingClaims = errors.New("id_token is missing the subject or email")
+)
+
+// Signing algorithms accepted for id tokens, symmetric and "none" algorithms are never accepted
+var supportedAlgorithms = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512"}
+
+// Clock skew tolerated between the issuer and this server
+const clockLeeway = time.Minute
+
+/*
+OpenID Connect provider config struct
+
+Fields:
+  - Name:               string, the provider name used in routes and identities
+  - Issuer:             string, the issuer URL the discovery document is loaded from
+  - ClientID:           string
+  - ClientSecret:       string
+  - RedirectURL:        string
+  - Scopes:             []string, "openid" is always requested
+  - EmailClaim:         string, the claim holding the email, "email" by default
+  - NameClaim:          string, the claim holding the name, "name" by default
+  - EmailVerifiedClaim: string, the claim telling whether the email is verified, "email_verified" by default
+  - TrustEmail:         bool, treat the email as verified for issuers that don't send the claim
+  - OfflineAccess:      bool, request a refresh token with access_type=offline, for Google
+  - ClientSecretFunc:   func, signs a fresh client secret for every token request when set, for Apple
+  - HTTPClient:         *http.Client, http.DefaultClient unless set
+*/
+type Config struct {
+	Name               string
+	Issuer             string
+	ClientID           string
+	ClientSecret       string
+	RedirectURL        string
+	Scopes             []string
+	EmailClaim         string
+	NameClaim          string
+	EmailVerifiedClaim string
+	TrustEmail         bool
+	OfflineAccess      bool
+	ClientSecretFunc   func() (string, error)
+	HTTPClient         *http.Client
+}
+
+/*
+Endpoints struct, the issuer metadata a provider needs
+
+Fields:
+  - AuthURL:           string, the authorization endpoint
+  - TokenURL:          string, the token endpoint
+  - JWKSURL:           string, the signing keys endpoint
+  - SigningAlgorithms: []string, the id token algorithms the issuer uses, RS256 when empty
+  - AuthStyle:         oauth2.AuthStyle, how the client authenticates to the token endpoint, detected when zero
+*/
+type Endpoints struct {
+	AuthURL           string
+	TokenURL          string
+	JWKSURL           string
+	SigningAlgorithms []string
+	AuthStyle         oauth2.AuthStyle
+}
+
+/*
+Discovery document struct, the parts of the issuer metadata the provider uses
+
+Fields:
+  - Issuer:                string
+  - AuthorizationEndpoint: string
+  - TokenEndpoint:         string
+  - JWKSURI:               string
+  - SigningAlgorithms:     []string
+*/
+type discoveryDocument struct {
+	Issuer                string   `json:"issuer"`
+	AuthorizationEndpoint string   `json:"authorization_endpoint"`
+	TokenEndpoint         string   `json:"token_endpoint"`
+	JWKSURI               string   `json:"jwks_uri"`
+	SigningAlgorithms     []string `json:"id_token_signing_alg_values_supported"`
+}
+
+/*
+OpenID Connect provider struct
+
+Fields:
+  - config:     The provider config
+  - oauth2:     The authorization-code flow config built from the discovery document
+  - keys:       The issuer signing keys
+  - algorithms: The signing algorithms accepted for id tokens
+*/
+type Provider struct {
+	config     Config
+	oauth2     oauth2.Config
+	keys       *KeySet
+	algorithms []string
+}
+
+/*
+Creates a provider from its issuer discovery document
+
+Objectives:
+  - Load the discovery document of the issuer
+  - Check it describes the configured issuer
+  - Setup the authorization-code flow and the signing key set
+
+Params:
+  - ctx:    Method context
+  - config: The provider config
+
+Returns:
+  - A pointer to the provider
+  - An error if the discovery document can't be loaded or doesn't match the issuer
+*/
+func NewProvider(ctx context.Context, config Config) (*Provider, error) {
+	if config.HTTPClient == nil {
+		config.HTTPClient = http.DefaultClient
+	}
+
+	discoveryURL := strings.TrimSuffix(config.Issuer, "/") + "/.well-known/openid-configuration"
+
+	var discovery discoveryDocument
+	if err := getJSON(ctx, config.HTTPClient, discoveryURL, &discovery); err != nil {
+		return nil, fmt.Errorf("[FAIL]: could not load %s discovery document: %w", config.Name, err)
+	}
+
+	if discovery.Issuer != config.Issuer {
+		return nil, fmt.Errorf("[FAIL]: %s discovery document is for issuer %q, expected %q", config.Name, discovery.Issuer, config.Issuer)
+	}
+
+	if discovery.AuthorizationEndpoint == "" || discovery.TokenEndpoint == "" || discovery.JWKSURI == "" {
+		return nil, fmt.Errorf("[FAIL]: %s discovery document is missing endpoints", config.Name)
+	}
+
+	return NewProviderWithEndpoints(config, Endpoints{
+		AuthURL:           discovery.AuthorizationEndpoint,
+		TokenURL:          discovery.TokenEndpoint,
+		JWKSURL:           discovery.JWKSURI,
+		SigningAlgorithms: discovery.SigningAlgorithms,
+	}), nil
+}
+
+/*
+Creates a provider from known endpoints, for issuers without a usable discovery document
+
+Params:
+  - config:    The provider config
+  - endpoints: The issuer endpoints
+
+Returns:
+  - A pointer to the provider
+*/
+func NewProviderWithEndpoints(config Config, endpoints Endpoints) *Provider {
+	if config.HTTPClient == nil {
+		config.HTTPClient = http.DefaultClient
+	}
+	if config.EmailClaim == "" {
+		config.EmailClaim = "email"
+	}
+	if config.NameClaim == "" {
+		config.NameClaim = "name"
+	}
+	if config.EmailVerifiedClaim == "" {
+		config.EmailVerifiedClaim = "email_verified"
+	}
+
+	scopes := []string{"openid"}
+	for _, scope := range config.Scopes {
+		if scope != "openid" {
+			scopes = append(scopes, scope)
+		}
+	}
+
+	return &Provider{
+		config: config,
+		oauth2: oauth2.Config{
+			ClientID:     config.ClientID,
+			ClientSecret: config.ClientSecret,
+			RedirectURL:  config.RedirectURL,
+			Scopes:       scopes,
+			Endpoint: oauth2.Endpoint{
+				AuthURL:   endpoints.AuthURL,
+				TokenURL:  endpoints.TokenURL,
+				AuthStyle: endpoints.AuthStyle,
+			},
+		},
+		keys:       NewKeySet(endpoints.JWKSURL, config.HTTPClient),
+		algorithms: acceptedAlgorithms(endpoints.SigningAlgorithms),
+	}
+}
+
+// Returns the provider name
+func (provider *Provider) Name() string {
+	return provider.config.Name
+}
+
+/*
+Returns the URL to redirect the user to for authentication
+
+Params:
+  - state: The OAuth state the callback must echo
+  - nonce: The nonce the id token must contain
+  - opts:  Extra authorization request options
+
+Returns:
+  - The authorization URL
+*/
+func (provider *Provider) AuthCodeURL(state string, nonce string, opts ...oauth2.AuthCodeOption) string {
+	opts = append(opts, oauth2.SetAuthURLParam("nonce", nonce))
+	if provider.config.OfflineAccess {
+		opts = append(opts, oauth2.AccessTypeOffline)
+	}
+	return provider.oauth2.AuthCodeURL(state, opts...)
+}
+
+/*
+Exchanges an authorization code for the provider's tokens
+
+Params:
+  - ctx:  Method context
+  - code: The authorization code
+  - opts: Extra token request options
+
+Returns:
+  - The provider token, holding the id token
+  - An error if the exchange fails
+*/
+func (provider *Provider) Exchange(ctx context.Context, code string, opts ...oauth2.AuthCodeOption) (*oauth2.Token, error) {
+	ctx = context.WithValue(ctx, oauth2.HTTPClient, provider.config.HTTPClient)
+
+	config, err := provider.tokenConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := config.Exchange(ctx, code, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("[FAIL]: could not exchange code: %w", err)
+	}
+
+	return token, nil
+}
+
+/*
+Returns the profile of the user a token was issued for
+
+Objectives:
+  - Verify the id token the token response holds
+  - Map its claims to an external profile
+
+Params:
+  - ctx:   Method context
+  - token: The provider token
+  - nonce: The nonce sent with the authorization request
+
+Returns:
+  - The external profile of the user
+  - An error if the id token is missing or invalid
+*/
+func (provider *Provider) TokenProfile(ctx context.Context, token *oauth2.Token, nonce string) (model.ExternalProfile, error) {
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok || rawIDToken == "" {
+		return model.ExternalProfile{}, ErrNoIDToken
+	}
+
+	claims, err := provider.VerifyIDToken(ctx, rawIDToken, nonce)
+	if err != nil {
+		return model.ExternalProfile{}, err
+	}
+
+	return provider.Profile(claims)
+}
+
+/*
+Returns a token source refreshing a provider token once it expires
+
+Params:
+  - ctx:   Method context, used for refreshes
+  - token: The provider token
+
+Returns:
+  - The token source
+*/
+func (provider *Provider) TokenSource(ctx context.Context, token *oauth2.Token) oauth2.TokenSource {
+	ctx = context.WithValue(ctx, oauth2.HTTPClient, provider.config.HTTPClient)
+
+	config, err := provider.tokenConfig()
+	if err != nil {
+		return errorTokenSource{err}
+	}
+
+	return config.TokenSource(ctx, token)
+}
+
+// Returns the flow config for a token request, with a freshly signed client secret when configured
+func (provider *Provider) tokenConfig() (oauth2.Config, error) {
+	config := provider.oauth2
+	if provider.config.ClientSecretFunc == nil {
+		return config, nil
+	}
+
+	secret, err := provider.config.ClientSecretFunc()
+	if err != nil {
+		return oauth2.Config{}, fmt.Errorf("[FAIL]: could not sign %s client secret: %w", provider.config.Name, err)
+	}
+	config.ClientSecret = secret
+
+	return config, nil
+}
+
+// Token source failing every request, when a token source can't be created
+type errorTokenSource struct {
+	err error
+}
+
+func (source errorTokenSource) Token() (*oauth2.Token, error) {
+	return nil, source.err
+}
+
+/*
+Verifies an id token issued to this client
+
+Objectives:
+  - Check the signature against the issuer keys, with an accepted algorithm
+  - Check the issuer, audience, expiry and issue time
+  - Check the authorized party when the token has several audiences
+  - Check the nonce matches the authorization request
+
+Params:
+  - ctx:   Method context
+  - raw:   The encoded id token
+  - nonce: The nonce sent with the authorization request
+
+Returns:
+  - The id token claims
+  - An error if any check fails
+*/
+func (provider *Provider) VerifyIDToken(ctx context.Context, raw string, nonce string) (jwt.MapClaims, error) {
+	parser := jwt.NewParser(
+		jwt.WithValidMethods(provider.algorithms),
+		jwt.WithIssuer(provider.config.Issuer),
+		jwt.WithAudience(provider.config.ClientID),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithLeeway(clockLeeway),
+	)
+
+	claims := jwt.MapClaims{}
+	_, err := parser.ParseWithClaims(raw, claims, func(token *jwt.Token) (interface{}, error) {
+		keyID, _ := token.Header["kid"].(string)
+		return provider.keys.Key(ctx, keyID)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("[FAIL]: invalid id_token: %w", err)
+	}
+
+	audiences, _ := claims.GetAudience()
+	if len(audiences) > 1 {
+		if party, _ := claims["azp"].(string); party != provider.config.ClientID {
+			return nil, fmt.Errorf("[FAIL]: invalid id_token: authorized party %q is not this client", party)
+		}
+	}
+
+	tokenNonce, _ := claims["nonce"].(string)
+	if nonce == "" || subtle.ConstantTimeCompare([]byte(tokenNonce), []byte(nonce)) != 1 {
+		return nil, ErrNonceMismatch
+	}
+
+	return claims, nil
+}
+
+/*
+Maps id token claims to an external profile using the configured claim names
+
+Params:
+  - claims: The verified id token claims
+
+Returns:
+  - The external profile
+  - ErrMissingClaims if the subject or email is missing
+*/
+func (provider *Provider) Profile(claims jwt.MapClaims) (model.ExternalProfile, error) {
+	subject, _ := claims.GetSubject()
+	email, _ := claims[provider.config.EmailClaim].(string)
+	name, _ := claims[provider.config.NameClaim].(string)
+
+	if subject == "" || email == "" {
+		return model.ExternalProfile{}, ErrMissingClaims
+	}
+
+	// Some issuers send the claim as a string
+	verified := provider.config.TrustEmail
+	switch value := claims[provider.config.EmailVerifiedClaim].(type) {
+	case bool:
+		verified = verified || value
+	case string:
+		verified = verified || value == "true"
+	}
+
+	return model.ExternalProfile{
+		Subject:       subject,
+		Name:          name,
+		Email:         email,
+		EmailVerified: verified,
+	}, nil
+}
+
+/*
+Returns the supported algorithms among those the issuer advertises
+
+Params:
+  - advertised: The id_token_signing_alg_values_supported of the discovery document
+
+Returns:
+  - The accepted algorithms, RS256 when the issuer advertises none we support
+*/
+func acceptedAlgorithms(advertised []string) []string {
+	var accepted []string
+	for _, algorithm := range advertised {
+		for _, supported := range supportedAlgorithms {
+			if algorithm == supported {
+				accepted = append(accepted, algorithm)
+			}
+		}
+	}
+
+	// RS256 is mandatory for every OpenID Connect issuer
+	if len(accepted) == 0 {
+		accepted = []string{"RS256"}
+	}
+
+	return accepted
+}
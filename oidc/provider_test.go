@@ -0,0 +1,474 @@
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dev-xero/authentication-backend/internal/testutil"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const testClientID = "client-1"
+
+// An issuer serving discovery, its signing keys and a token endpoint, counting the requests it answers
+type testIssuer struct {
+	server *httptest.Server
+
+	mu        sync.Mutex
+	keys      map[string]*rsa.PrivateKey
+	requests  map[string]int
+	idToken   string
+	tokenForm url.Values
+}
+
+func newTestIssuer(t *testing.T) *testIssuer {
+	t.Helper()
+
+	issuer := &testIssuer{keys: map[string]*rsa.PrivateKey{}, requests: map[string]int{}}
+	issuer.rotate(t, "key-1")
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		issuer.count(r)
+		testutil.WriteJSON(w, map[string]interface{}{
+			"issuer":                                issuer.server.URL,
+			"authorization_endpoint":                issuer.server.URL + "/auth/authorize",
+			"token_endpoint":                        issuer.server.URL + "/auth/token",
+			"jwks_uri":                              issuer.server.URL + "/auth/keys",
+			"id_token_signing_alg_values_supported": []string{"RS256", "HS256", "none"},
+		})
+	})
+	mux.HandleFunc("/auth/keys", func(w http.ResponseWriter, r *http.Request) {
+		issuer.count(r)
+		issuer.mu.Lock()
+		defer issuer.mu.Unlock()
+
+		var keys []map[string]string
+		for keyID, key := range issuer.keys {
+			keys = append(keys, testutil.RSAJWK(keyID, key))
+		}
+		testutil.WriteJSON(w, map[string]interface{}{"keys": keys})
+	})
+	mux.HandleFunc("/auth/token", func(w http.ResponseWriter, r *http.Request) {
+		issuer.count(r)
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		issuer.mu.Lock()
+		defer issuer.mu.Unlock()
+		issuer.tokenForm = r.PostForm
+		testutil.WriteJSON(w, map[string]interface{}{
+			"access_token": "access-token",
+			"token_type":   "Bearer",
+			"expires_in":   3600,
+			"id_token":     issuer.idToken,
+		})
+	})
+
+	issuer.server = httptest.NewServer(mux)
+	t.Cleanup(issuer.server.Close)
+
+	return issuer
+}
+
+func (issuer *testIssuer) count(r *http.Request) {
+	issuer.mu.Lock()
+	defer issuer.mu.Unlock()
+	issuer.requests[r.URL.Path]++
+}
+
+// Returns how often a path was requested
+func (issuer *testIssuer) requested(path string) int {
+	issuer.mu.Lock()
+	defer issuer.mu.Unlock()
+	return issuer.requests[path]
+}
+
+// Replaces the published keys with a new key
+func (issuer *testIssuer) rotate(t *testing.T, keyID string) {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	issuer.mu.Lock()
+	defer issuer.mu.Unlock()
+	issuer.keys = map[string]*rsa.PrivateKey{keyID: key}
+}
+
+// Sets the id token the token endpoint responds with
+func (issuer *testIssuer) respondWith(idToken string) {
+	issuer.mu.Lock()
+	defer issuer.mu.Unlock()
+	issuer.idToken = idToken
+}
+
+// Claims of a valid id token for the test client, tests change them to make it invalid
+func (issuer *testIssuer) claims(nonce string) jwt.MapClaims {
+	now := time.Now()
+	return jwt.MapClaims{
+		"iss":            issuer.server.URL,
+		"sub":            "user-1",
+		"aud":            testClientID,
+		"iat":            now.Unix(),
+		"exp":            now.Add(time.Hour).Unix(),
+		"nonce":          nonce,
+		"email":          "jane@example.com",
+		"email_verified": true,
+		"name":           "Jane Doe",
+	}
+}
+
+// Signs claims with a published key
+func (issuer *testIssuer) sign(t *testing.T, keyID string, claims jwt.MapClaims) string {
+	t.Helper()
+
+	issuer.mu.Lock()
+	key, ok := issuer.keys[keyID]
+	issuer.mu.Unlock()
+	if !ok {
+		t.Fatalf("key %s is not published", keyID)
+	}
+
+	return signTestToken(t, key, keyID, claims)
+}
+
+func signTestToken(t *testing.T, key *rsa.PrivateKey, keyID string, claims jwt.MapClaims) string {
+	t.Helper()
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = keyID
+
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return signed
+}
+
+func newTestProvider(t *testing.T, issuer *testIssuer) *Provider {
+	t.Helper()
+
+	provider, err := NewProvider(context.Background(), Config{
+		Name:        "test",
+		Issuer:      issuer.server.URL,
+		ClientID:    testClientID,
+		RedirectURL: "https://app.example.com/auth/oidc/test/callback",
+		Scopes:      []string{"email", "profile"},
+		HTTPClient:  issuer.server.Client(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return provider
+}
+
+func TestNewProviderUsesDiscoveredEndpoints(t *testing.T) {
+	issuer := newTestIssuer(t)
+	provider := newTestProvider(t, issuer)
+
+	authURL, err := url.Parse(provider.AuthCodeURL("state-1", "nonce-1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(authURL.String(), issuer.server.URL+"/auth/authorize?") {
+		t.Errorf("auth url %s is not the discovered endpoint", authURL)
+	}
+	query := authURL.Query()
+	if query.Get("nonce") != "nonce-1" || query.Get("state") != "state-1" || query.Get("scope") != "openid email profile" {
+		t.Errorf("unexpected authorization request %v", query)
+	}
+
+	// Symmetric and unsigned algorithms are dropped from those the issuer advertises
+	if len(provider.algorithms) != 1 || provider.algorithms[0] != "RS256" {
+		t.Errorf("accepted algorithms %v", provider.algorithms)
+	}
+}
+
+func TestNewProviderRejectsAnotherIssuer(t *testing.T) {
+	issuer := newTestIssuer(t)
+
+	_, err := NewProvider(context.Background(), Config{Name: "test", Issuer: issuer.server.URL + "/tenant", HTTPClient: issuer.server.Client()})
+	if err == nil {
+		t.Fatal("discovery document of another issuer was accepted")
+	}
+}
+
+func TestProvidersCacheDiscovery(t *testing.T) {
+	issuer := newTestIssuer(t)
+	providers := NewProviders(Config{Name: "test", Issuer: issuer.server.URL, ClientID: testClientID, HTTPClient: issuer.server.Client()})
+
+	first, err := providers.Get(context.Background(), "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := providers.Get(context.Background(), "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if first != second || issuer.requested("/.well-known/openid-configuration") != 1 {
+		t.Fatalf("discovery loaded %d times", issuer.requested("/.well-known/openid-configuration"))
+	}
+
+	if _, err := providers.Get(context.Background(), "other"); !errors.Is(err, ErrUnknownProvider) {
+		t.Fatalf("expected ErrUnknownProvider, got %v", err)
+	}
+}
+
+func TestProvidersRetryFailedDiscovery(t *testing.T) {
+	issuer := newTestIssuer(t)
+	providers := NewProviders(Config{Name: "test", Issuer: issuer.server.URL, ClientID: testClientID, HTTPClient: issuer.server.Client()})
+
+	issuer.server.Close()
+	if _, err := providers.Get(context.Background(), "test"); err == nil {
+		t.Fatal("discovery of an unreachable issuer succeeded")
+	}
+
+	issuer.server = httptest.NewServer(issuer.server.Config.Handler)
+	defer issuer.server.Close()
+	providers.configs["test"] = Config{Name: "test", Issuer: issuer.server.URL, ClientID: testClientID, HTTPClient: issuer.server.Client()}
+
+	if _, err := providers.Get(context.Background(), "test"); err != nil {
+		t.Fatalf("failed discovery wasn't retried: %v", err)
+	}
+}
+
+func TestVerifyIDTokenCachesKeys(t *testing.T) {
+	issuer := newTestIssuer(t)
+	provider := newTestProvider(t, issuer)
+
+	for i := 0; i < 3; i++ {
+		if _, err := provider.VerifyIDToken(context.Background(), issuer.sign(t, "key-1", issuer.claims("nonce-1")), "nonce-1"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if fetched := issuer.requested("/auth/keys"); fetched != 1 {
+		t.Fatalf("keys fetched %d times", fetched)
+	}
+}
+
+// Tokens signed with a rotated-in key refetch the keys, at most once a minute
+func TestVerifyIDTokenFollowsKeyRotation(t *testing.T) {
+	issuer := newTestIssuer(t)
+	provider := newTestProvider(t, issuer)
+	ctx := context.Background()
+
+	if _, err := provider.VerifyIDToken(ctx, issuer.sign(t, "key-1", issuer.claims("nonce-1")), "nonce-1"); err != nil {
+		t.Fatal(err)
+	}
+
+	issuer.rotate(t, "key-2")
+	rotated := issuer.sign(t, "key-2", issuer.claims("nonce-1"))
+
+	if _, err := provider.VerifyIDToken(ctx, rotated, "nonce-1"); err == nil {
+		t.Fatal("unknown key refetched the keys within the refresh interval")
+	}
+	if fetched := issuer.requested("/auth/keys"); fetched != 1 {
+		t.Fatalf("keys fetched %d times within the refresh interval", fetched)
+	}
+
+	provider.keys.fetchedAt = time.Now().Add(-minRefreshInterval)
+
+	if _, err := provider.VerifyIDToken(ctx, rotated, "nonce-1"); err != nil {
+		t.Fatalf("token of the rotated key was rejected: %v", err)
+	}
+	if fetched := issuer.requested("/auth/keys"); fetched != 2 {
+		t.Fatalf("keys fetched %d times, want 2", fetched)
+	}
+
+	// The rotated-out key isn't published anymore
+	if _, ok := provider.keys.lookup("key-1"); ok {
+		t.Fatal("rotated-out key is still cached")
+	}
+}
+
+func TestVerifyIDTokenRejectsInvalidTokens(t *testing.T) {
+	issuer := newTestIssuer(t)
+	provider := newTestProvider(t, issuer)
+
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		token func() string
+		nonce string
+	}{
+		{
+			name: "another issuer",
+			token: func() string {
+				claims := issuer.claims("nonce-1")
+				claims["iss"] = "https://evil.example.com"
+				return issuer.sign(t, "key-1", claims)
+			},
+		},
+		{
+			name: "another audience",
+			token: func() string {
+				claims := issuer.claims("nonce-1")
+				claims["aud"] = "client-2"
+				return issuer.sign(t, "key-1", claims)
+			},
+		},
+		{
+			name: "several audiences issued to another party",
+			token: func() string {
+				claims := issuer.claims("nonce-1")
+				claims["aud"] = []string{testClientID, "client-2"}
+				claims["azp"] = "client-2"
+				return issuer.sign(t, "key-1", claims)
+			},
+		},
+		{
+			name: "expired",
+			token: func() string {
+				claims := issuer.claims("nonce-1")
+				claims["exp"] = time.Now().Add(-2 * clockLeeway).Unix()
+				return issuer.sign(t, "key-1", claims)
+			},
+		},
+		{
+			name: "no expiry",
+			token: func() string {
+				claims := issuer.claims("nonce-1")
+				delete(claims, "exp")
+				return issuer.sign(t, "key-1", claims)
+			},
+		},
+		{
+			name: "another nonce",
+			token: func() string {
+				return issuer.sign(t, "key-1", issuer.claims("nonce-2"))
+			},
+		},
+		{
+			name: "signed with an unpublished key",
+			token: func() string {
+				return signTestToken(t, otherKey, "key-1", issuer.claims("nonce-1"))
+			},
+		},
+		{
+			name: "symmetric algorithm",
+			token: func() string {
+				token := jwt.NewWithClaims(jwt.SigningMethodHS256, issuer.claims("nonce-1"))
+				token.Header["kid"] = "key-1"
+				signed, err := token.SignedString([]byte("secret"))
+				if err != nil {
+					t.Fatal(err)
+				}
+				return signed
+			},
+		},
+		{
+			name: "unsigned",
+			token: func() string {
+				token := jwt.NewWithClaims(jwt.SigningMethodNone, issuer.claims("nonce-1"))
+				signed, err := token.SignedString(jwt.UnsafeAllowNoneSignatureType)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return signed
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := provider.VerifyIDToken(context.Background(), test.token(), "nonce-1"); err == nil {
+				t.Fatal("id token was accepted")
+			}
+		})
+	}
+}
+
+func TestExchangeReturnsTokenProfile(t *testing.T) {
+	issuer := newTestIssuer(t)
+	provider := newTestProvider(t, issuer)
+	ctx := context.Background()
+
+	issuer.respondWith(issuer.sign(t, "key-1", issuer.claims("nonce-1")))
+
+	token, err := provider.Exchange(ctx, "code-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if issuer.tokenForm.Get("code") != "code-1" || issuer.tokenForm.Get("grant_type") != "authorization_code" {
+		t.Errorf("unexpected token request %v", issuer.tokenForm)
+	}
+
+	profile, err := provider.TokenProfile(ctx, token, "nonce-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if profile.Subject != "user-1" || profile.Email != "jane@example.com" || profile.Name != "Jane Doe" || !profile.EmailVerified {
+		t.Errorf("unexpected profile %+v", profile)
+	}
+
+	if _, err := provider.TokenProfile(ctx, token, "nonce-2"); !errors.Is(err, ErrNonceMismatch) {
+		t.Errorf("expected ErrNonceMismatch, got %v", err)
+	}
+
+	issuer.respondWith("")
+	token, err = provider.Exchange(ctx, "code-2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := provider.TokenProfile(ctx, token, "nonce-1"); !errors.Is(err, ErrNoIDToken) {
+		t.Errorf("expected ErrNoIDToken, got %v", err)
+	}
+}
+
+func TestProfileReadsVerifiedClaim(t *testing.T) {
+	provider := &Provider{config: Config{Name: "test", ClientID: testClientID, EmailClaim: "email", NameClaim: "name", EmailVerifiedClaim: "email_verified"}}
+
+	tests := []struct {
+		name     string
+		verified interface{}
+		want     bool
+	}{
+		{name: "boolean", verified: true, want: true},
+		{name: "string", verified: "true", want: true},
+		{name: "false string", verified: "false"},
+		{name: "missing"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			claims := jwt.MapClaims{"sub": "user-1", "email": "jane@example.com"}
+			if test.verified != nil {
+				claims["email_verified"] = test.verified
+			}
+
+			profile, err := provider.Profile(claims)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if profile.EmailVerified != test.want {
+				t.Fatalf("email verified = %v, want %v", profile.EmailVerified, test.want)
+			}
+		})
+	}
+
+	if _, err := provider.Profile(jwt.MapClaims{"sub": "user-1"}); !errors.Is(err, ErrMissingClaims) {
+		t.Errorf("expected ErrMissingClaims, got %v", err)
+	}
+}
@@ -0,0 +1,315 @@
+package providertoken
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/dev-xero/authentication-backend/encryption"
+	repository "github.com/dev-xero/authentication-backend/repository/user"
+	"github.com/dev-xero/authentication-backend/service"
+	"github.com/google/uuid"
+	"github.com/joho/godotenv"
+	"golang.org/x/oauth2"
+)
+
+// Returned when the provider rejected the stored refresh token, the user must sign-in with the provider again
+var ErrReauthorizationRequired = errors.New("the provider token was revoked, sign-in with the provider again")
+
+// Number of locks refreshes are serialized with, identities share them so their number stays fixed
+const lockStripes = 64
+
+/*
+Refresher interface, implemented by providers whose tokens can be refreshed
+
+Methods:
+  - TokenSource: Returns a token source refreshing the token at the provider's token endpoint once it expires
+*/
+type Refresher interface {
+	TokenSource(ctx context.Context, token *oauth2.Token) oauth2.TokenSource
+}
+
+// Finds the refresher of a provider by name
+type Resolver func(ctx context.Context, provider string) (Refresher, error)
+
+/*
+Provider token store struct, keeps provider tokens encrypted at rest per linked identity
+
+Fields:
+  - dbService: The database service provider
+  - cipher:    The envelope encryption service
+  - resolve:   Finds the refresher of a provider
+  - providers: The providers tokens are stored for
+  - locks:     Locks striped by identity id, so a refresh token is only used once at a time
+*/
+type Store struct {
+	dbService *service.DatabaseProvider
+	cipher    *encryption.Service
+	resolve   Resolver
+	providers map[string]bool
+	locks     [lockStripes]sync.Mutex
+}
+
+/*
+Creates a provider token store
+
+Params:
+  - dbService: The database service provider
+  - cipher:    The envelope encryption service
+  - resolve:   Finds the refresher of a provider
+  - providers: The providers tokens are stored for
+
+Returns:
+  - A pointer to the store
+*/
+func NewStore(dbService *service.DatabaseProvider, cipher *encryption.Service, resolve Resolver, providers ...string) *Store {
+	store := &Store{
+		dbService: dbService,
+		cipher:    cipher,
+		resolve:   resolve,
+		providers: make(map[string]bool, len(providers)),
+	}
+
+	for _, provider := range providers {
+		store.providers[provider] = true
+	}
+
+	return store
+}
+
+/*
+Creates the provider token store configured through environment variables
+
+Objectives:
+  - Load the providers named in PROVIDER_TOKEN_STORAGE
+  - Load the encryption service only when tokens are stored
+
+Params:
+  - dbService: The database service provider
+  - resolve:   Finds the refresher of a provider
+
+Returns:
+  - A pointer to the store, nil when PROVIDER_TOKEN_STORAGE isn't set
+  - An error if the environment or the encryption keys can't be loaded
+*/
+func NewStoreFromEnvironment(dbService *service.DatabaseProvider, resolve Resolver) (*Store, error) {
+	// Load environment variables from .env file in development
+	if env := os.Getenv("ENVIRONMENT"); env != "production" {
+		err := godotenv.Load()
+		if err != nil {
+			return nil, fmt.Errorf("[FAIL]: could not load environment variables: %w", err)
+		}
+	}
+
+	var providers []string
+	for _, provider := range strings.Split(os.Getenv("PROVIDER_TOKEN_STORAGE"), ",") {
+		if provider = strings.ToLower(strings.TrimSpace(provider)); provider != "" {
+			providers = append(providers, provider)
+		}
+	}
+
+	if len(providers) == 0 {
+		return nil, nil
+	}
+
+	cipher, err := encryption.NewFromEnvironment()
+	if err != nil {
+		return nil, err
+	}
+
+	return NewStore(dbService, cipher, resolve, providers...), nil
+}
+
+/*
+Checks whether tokens are stored for a provider
+
+Params:
+  - provider: The provider name
+
+Returns:
+  - True if tokens are stored for the provider, false for a nil store
+*/
+func (store *Store) Enabled(provider string) bool {
+	return store != nil && store.providers[provider]
+}
+
+/*
+Stores the token a provider issued when the user signed-in or linked an identity
+
+Objectives:
+  - Keep the stored refresh token when the provider didn't issue a new one
+  - Encrypt the token bound to its identity
+
+Params:
+  - ctx:      Method context
+  - provider: The provider name
+  - subject:  The user id at the provider
+  - token:    The provider token
+
+Returns:
+  - An error if any step fails, nothing is stored when tokens aren't stored for the provider
+*/
+func (store *Store) Save(ctx context.Context, provider string, subject string, token *oauth2.Token) error {
+	if !store.Enabled(provider) || token == nil {
+		return nil
+	}
+
+	// Providers usually only issue a refresh token on the first consent
+	if token.RefreshToken == "" {
+		if stored, err := store.load(ctx, provider, subject); err == nil {
+			token.RefreshToken = stored.RefreshToken
+		}
+	}
+
+	return store.save(ctx, provider, subject, token)
+}
+
+/*
+Returns a valid provider token for one of a user's identities
+
+Objectives:
+  - Load and decrypt the token stored for the identity
+  - Refresh it through the provider's token source once it expired, storing the refreshed token
+  - Forget tokens the provider revoked
+
+Params:
+  - ctx:        Method context
+  - userID:     The user id
+  - identityID: The identity id, as listed by ListUserIdentities
+
+Returns:
+  - A valid provider token
+  - repository.ErrIdentityTokenNotFound if no token is stored, ErrReauthorizationRequired if it was revoked
+*/
+func (store *Store) Token(ctx context.Context, userID uuid.UUID, identityID uuid.UUID) (*oauth2.Token, error) {
+	if store == nil {
+		return nil, repository.ErrIdentityTokenNotFound
+	}
+
+	// Version 4 ids end with a random byte, spreading identities over the stripes
+	lock := &store.locks[int(identityID[len(identityID)-1])%lockStripes]
+	lock.Lock()
+	defer lock.Unlock()
+
+	stored, err := store.dbService.Repo.GetUserIdentityToken(ctx, userID, identityID)
+	if err != nil {
+		return nil, err
+	}
+
+	provider := stored.Provider
+	if !store.Enabled(provider) {
+		return nil, repository.ErrIdentityTokenNotFound
+	}
+
+	token, err := store.decrypt(ctx, stored.Provider, stored.Subject, stored.Token)
+	if err != nil {
+		return nil, err
+	}
+
+	if token.Valid() {
+		return token, nil
+	}
+
+	if token.RefreshToken == "" {
+		return nil, ErrReauthorizationRequired
+	}
+
+	refresher, err := store.resolve(ctx, provider)
+	if err != nil {
+		return nil, err
+	}
+
+	refreshed, err := refresher.TokenSource(ctx, token).Token()
+	if err != nil {
+		var retrieveErr *oauth2.RetrieveError
+		if errors.As(err, &retrieveErr) && retrieveErr.ErrorCode == "invalid_grant" {
+			if err := store.dbService.Repo.DeleteIdentityToken(ctx, stored.IdentityID); err != nil {
+				return nil, err
+			}
+			return nil, ErrReauthorizationRequired
+		}
+		return nil, fmt.Errorf("[FAIL]: could not refresh %s token: %w", provider, err)
+	}
+
+	if err := store.save(ctx, stored.Provider, stored.Subject, refreshed); err != nil {
+		return nil, err
+	}
+
+	return refreshed, nil
+}
+
+/*
+Returns a token source for calling a provider's APIs on a user's behalf
+
+Params:
+  - ctx:        Method context, used for refreshes
+  - userID:     The user id
+  - identityID: The identity id, as listed by ListUserIdentities
+
+Returns:
+  - A token source returning valid tokens, reusing a token until it expires
+*/
+func (store *Store) TokenSource(ctx context.Context, userID uuid.UUID, identityID uuid.UUID) oauth2.TokenSource {
+	return oauth2.ReuseTokenSource(nil, &identityTokenSource{ctx: ctx, store: store, userID: userID, identityID: identityID})
+}
+
+// Token source backed by the store
+type identityTokenSource struct {
+	ctx        context.Context
+	store      *Store
+	userID     uuid.UUID
+	identityID uuid.UUID
+}
+
+func (source *identityTokenSource) Token() (*oauth2.Token, error) {
+	return source.store.Token(source.ctx, source.userID, source.identityID)
+}
+
+// Loads and decrypts the token stored for an identity
+func (store *Store) load(ctx context.Context, provider string, subject string) (*oauth2.Token, error) {
+	stored, err := store.dbService.Repo.GetIdentityToken(ctx, provider, subject)
+	if err != nil {
+		return nil, err
+	}
+
+	return store.decrypt(ctx, provider, subject, stored.Token)
+}
+
+// Encrypts and stores the token of an identity
+func (store *Store) save(ctx context.Context, provider string, subject string, token *oauth2.Token) error {
+	plaintext, err := json.Marshal(token)
+	if err != nil {
+		return fmt.Errorf("[FAIL]: could not encode %s token: %w", provider, err)
+	}
+
+	ciphertext, err := store.cipher.Encrypt(ctx, plaintext, associatedData(provider, subject))
+	if err != nil {
+		return err
+	}
+
+	return store.dbService.Repo.SaveIdentityToken(ctx, provider, subject, ciphertext)
+}
+
+// Decrypts the stored token of an identity
+func (store *Store) decrypt(ctx context.Context, provider string, subject string, ciphertext string) (*oauth2.Token, error) {
+	plaintext, err := store.cipher.Decrypt(ctx, ciphertext, associatedData(provider, subject))
+	if err != nil {
+		return nil, err
+	}
+
+	var token oauth2.Token
+	if err := json.Unmarshal(plaintext, &token); err != nil {
+		return nil, fmt.Errorf("[FAIL]: could not decode %s token: %w", provider, err)
+	}
+
+	return &token, nil
+}
+
+// Binds a ciphertext to the identity it belongs to, so it can't be copied to another row
+func associatedData(provider string, subject string) string {
+	return fmt.Sprintf("user_identity_tokens.token:%s:%s", provider, subject)
+}
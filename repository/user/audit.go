@@ -0,0 +1,57 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+
+	"github.com/dev-xero/authentication-backend/model"
+	"github.com/google/uuid"
+)
+
+/*
+Records a security relevant change to an account
+
+Params:
+  - ctx:   Method context
+  - event: The audit event model
+
+Returns:
+  - An error if the insertion failed
+*/
+func (repo *PostGreSQL) RecordAuditEvent(ctx context.Context, event model.AuditEvent) error {
+	return repo.withTransaction(ctx, []string{"users", "audit_events"}, func(tx *sql.Tx) error {
+		return repo.insertAuditEvent(ctx, tx, event)
+	})
+}
+
+/*
+Inserts an audit event as part of a transaction, so changes and their records commit together
+
+Params:
+  - ctx:   Method context
+  - tx:    A pointer to the transaction object
+  - event: The audit event model
+
+Returns:
+  - An error if the insertion failed
+*/
+func (repo *PostGreSQL) insertAuditEvent(ctx context.Context, tx *sql.Tx, event model.AuditEvent) error {
+	if event.ID == uuid.Nil {
+		event.ID = uuid.New()
+	}
+
+	var insertQuery = `
+		INSERT INTO audit_events (id, user_id, event, detail, ip_address, user_agent)
+		VALUES ($1, $2, $3, $4, $5, $6)
+	`
+
+	_, err := tx.ExecContext(ctx, insertQuery, event.ID, event.UserID, event.Event, event.Detail, event.IPAddress, event.UserAgent)
+	if err != nil {
+		log.Println(err)
+		return fmt.Errorf("[FAIL]: could not record audit event %s", event.Event)
+	}
+
+	return nil
+}
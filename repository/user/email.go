@@ -0,0 +1,269 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/dev-xero/authentication-backend/model"
+	"github.com/google/uuid"
+)
+
+// Stores errors callers need to tell apart when managing email addresses
+var (
+	ErrEmailTaken    = errors.New("that email is already used by an account")
+	ErrEmailNotFound = errors.New("email not found")
+	ErrEmailPrimary  = errors.New("the primary email can't be removed")
+)
+
+// Tables touched when managing email addresses, in creation order
+var emailTables = []string{"users", "user_emails", "audit_events"}
+
+/*
+Returns every address of a user, the primary address first
+
+Params:
+  - ctx:    Method context
+  - userID: The user id
+
+Returns:
+  - The user email models
+  - An error if the query failed
+*/
+func (repo *PostGreSQL) ListUserEmails(ctx context.Context, userID uuid.UUID) ([]model.UserEmail, error) {
+	var emails []model.UserEmail
+
+	err := repo.withTransaction(ctx, emailTables, func(tx *sql.Tx) error {
+		var listQuery = `
+			SELECT id, user_id, email, is_primary, verified_at, created_at
+			FROM user_emails
+			WHERE user_id = $1
+			ORDER BY is_primary DESC, created_at
+		`
+
+		rows, err := tx.QueryContext(ctx, listQuery, userID)
+		if err != nil {
+			return fmt.Errorf("[FAIL]: could not execute query: %w", err)
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var email model.UserEmail
+			if err := rows.Scan(&email.ID, &email.UserID, &email.Email, &email.Primary, &email.VerifiedAt, &email.CreatedAt); err != nil {
+				return fmt.Errorf("[FAIL]: could not scan user email: %w", err)
+			}
+			emails = append(emails, email)
+		}
+
+		return rows.Err()
+	})
+
+	return emails, err
+}
+
+/*
+Adds an unverified address to a user
+
+Objectives:
+  - Release the address if another account let its verification expire
+  - Insert the address with its verification token hash
+  - Record the audit event in the same transaction
+
+Params:
+  - ctx:   Method context
+  - email: The user email model
+  - event: The audit event recording the addition
+
+Returns:
+  - ErrEmailTaken if any account already uses the address
+  - An error if any other step fails
+*/
+func (repo *PostGreSQL) AddUserEmail(ctx context.Context, email model.UserEmail, event model.AuditEvent) error {
+	return repo.withTransaction(ctx, emailTables, func(tx *sql.Tx) error {
+		if err := repo.releaseExpiredEmail(ctx, tx, email.Email); err != nil {
+			return err
+		}
+
+		var insertQuery = `
+			INSERT INTO user_emails (id, user_id, email, verification_token_hash, verification_expires_at)
+			VALUES ($1, $2, $3, $4, $5)
+		`
+
+		_, err := tx.ExecContext(ctx, insertQuery, email.ID, email.UserID, email.Email, email.VerificationTokenHash, email.VerificationExpiresAt)
+		if err != nil {
+			log.Println(err)
+			if isUniqueViolation(err) {
+				return ErrEmailTaken
+			}
+			return fmt.Errorf("[FAIL]: could not execute insert query")
+		}
+
+		return repo.insertAuditEvent(ctx, tx, event)
+	})
+}
+
+/*
+Verifies an address of a user with its emailed token
+
+Params:
+  - ctx:       Method context
+  - userID:    The user id
+  - tokenHash: The hash of the emailed token
+  - event:     The audit event recording the verification
+
+Returns:
+  - The verified user email
+  - ErrEmailNotFound if no unexpired address of the user matches the token
+*/
+func (repo *PostGreSQL) VerifyUserEmail(ctx context.Context, userID uuid.UUID, tokenHash string, event model.AuditEvent) (model.UserEmail, error) {
+	var email model.UserEmail
+
+	err := repo.withTransaction(ctx, emailTables, func(tx *sql.Tx) error {
+		var verifyQuery = `
+			UPDATE user_emails
+			SET verified_at = NOW(), verification_token_hash = NULL, verification_expires_at = NULL
+			WHERE user_id = $1 AND verification_token_hash = $2 AND verification_expires_at > NOW()
+			RETURNING id, user_id, email, is_primary, verified_at, created_at
+		`
+
+		err := tx.QueryRowContext(ctx, verifyQuery, userID, tokenHash).Scan(
+			&email.ID, &email.UserID, &email.Email, &email.Primary, &email.VerifiedAt, &email.CreatedAt,
+		)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				return ErrEmailNotFound
+			}
+			return fmt.Errorf("[FAIL]: could not verify email: %w", err)
+		}
+
+		event.Detail = email.Email
+		return repo.insertAuditEvent(ctx, tx, event)
+	})
+
+	return email, err
+}
+
+/*
+Makes a verified address the primary address of a user
+
+Objectives:
+  - Unset the current primary address
+  - Set the new primary address and mirror it on the users table
+  - Record the audit event in the same transaction
+
+Params:
+  - ctx:     Method context
+  - userID:  The user id
+  - emailID: The id of the address to promote
+  - event:   The audit event recording the change
+
+Returns:
+  - ErrEmailNotFound if the user has no verified address with that id
+  - An error if any other step fails
+*/
+func (repo *PostGreSQL) SetPrimaryEmail(ctx context.Context, userID uuid.UUID, emailID uuid.UUID, event model.AuditEvent) error {
+	return repo.withTransaction(ctx, emailTables, func(tx *sql.Tx) error {
+		var address string
+		var findQuery = `
+			SELECT email FROM user_emails WHERE id = $1 AND user_id = $2 AND verified_at IS NOT NULL
+		`
+
+		if err := tx.QueryRowContext(ctx, findQuery, emailID, userID).Scan(&address); err != nil {
+			if err == sql.ErrNoRows {
+				return ErrEmailNotFound
+			}
+			return fmt.Errorf("[FAIL]: could not execute query: %w", err)
+		}
+
+		var queries = []struct {
+			query string
+			args  []interface{}
+		}{
+			{`UPDATE user_emails SET is_primary = FALSE WHERE user_id = $1 AND is_primary`, []interface{}{userID}},
+			{`UPDATE user_emails SET is_primary = TRUE WHERE id = $1`, []interface{}{emailID}},
+			{`UPDATE users SET email = $2 WHERE id = $1`, []interface{}{userID, address}},
+		}
+
+		for _, q := range queries {
+			if _, err := tx.ExecContext(ctx, q.query, q.args...); err != nil {
+				log.Println(err)
+				return fmt.Errorf("[FAIL]: could not change primary email")
+			}
+		}
+
+		event.Detail = address
+		return repo.insertAuditEvent(ctx, tx, event)
+	})
+}
+
+/*
+Removes an address that isn't the primary address of a user
+
+Params:
+  - ctx:     Method context
+  - userID:  The user id
+  - emailID: The id of the address to remove
+  - event:   The audit event recording the removal
+
+Returns:
+  - ErrEmailNotFound or ErrEmailPrimary if the address can't be removed
+  - An error if any other step fails
+*/
+func (repo *PostGreSQL) RemoveUserEmail(ctx context.Context, userID uuid.UUID, emailID uuid.UUID, event model.AuditEvent) error {
+	return repo.withTransaction(ctx, emailTables, func(tx *sql.Tx) error {
+		var (
+			address   string
+			isPrimary bool
+		)
+
+		var findQuery = `
+			SELECT email, is_primary FROM user_emails WHERE id = $1 AND user_id = $2
+		`
+
+		if err := tx.QueryRowContext(ctx, findQuery, emailID, userID).Scan(&address, &isPrimary); err != nil {
+			if err == sql.ErrNoRows {
+				return ErrEmailNotFound
+			}
+			return fmt.Errorf("[FAIL]: could not execute query: %w", err)
+		}
+
+		if isPrimary {
+			return ErrEmailPrimary
+		}
+
+		if _, err := tx.ExecContext(ctx, `DELETE FROM user_emails WHERE id = $1`, emailID); err != nil {
+			log.Println(err)
+			return fmt.Errorf("[FAIL]: could not remove email")
+		}
+
+		event.Detail = address
+		return repo.insertAuditEvent(ctx, tx, event)
+	})
+}
+
+/*
+Removes an unverified address whose verification expired as part of a transaction, so another account can use it
+
+Params:
+  - ctx:   Method context
+  - tx:    A pointer to the transaction object
+  - email: The address
+
+Returns:
+  - An error if the deletion failed
+*/
+func (repo *PostGreSQL) releaseExpiredEmail(ctx context.Context, tx *sql.Tx, email string) error {
+	var releaseQuery = `
+		DELETE FROM user_emails
+		WHERE LOWER(email) = LOWER($1) AND verified_at IS NULL AND verification_expires_at <= NOW()
+	`
+
+	if _, err := tx.ExecContext(ctx, releaseQuery, email); err != nil {
+		log.Println(err)
+		return fmt.Errorf("[FAIL]: could not release expired email")
+	}
+
+	return nil
+}
@@ -0,0 +1,326 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/dev-xero/authentication-backend/model"
+	"github.com/dev-xero/authentication-backend/util"
+	"github.com/google/uuid"
+)
+
+// Stores errors callers need to tell apart when managing external identities
+var (
+	ErrIdentityNotFound   = errors.New("identity not found")
+	ErrIdentityLinked     = errors.New("that identity is already linked to an account")
+	ErrLastSignInMethod   = errors.New("the account would have no way left to sign-in")
+	ErrPasswordAlreadySet = errors.New("the account already has a password")
+)
+
+// Tables touched by external identities, in creation order
+var identityTables = []string{"users", "user_emails", "audit_events", "user_identities"}
+
+/*
+Returns the user linked to an external identity and records the sign-in
+
+Params:
+  - ctx:      Method context
+  - provider: The identity provider, e.g. "google"
+  - subject:  The user id at the provider
+
+Returns:
+  - The linked user data model
+  - ErrIdentityNotFound if no user is linked to the identity
+*/
+func (repo *PostGreSQL) GetUserByIdentity(ctx context.Context, provider string, subject string) (model.User, error) {
+	var user model.User
+
+	err := repo.withTransaction(ctx, identityTables, func(tx *sql.Tx) error {
+		var signInQuery = `
+			UPDATE user_identities SET last_sign_in_at = NOW()
+			WHERE provider = $1 AND subject = $2
+			RETURNING user_id
+		`
+
+		if err := tx.QueryRowContext(ctx, signInQuery, provider, subject).Scan(&user.ID); err != nil {
+			if err == sql.ErrNoRows {
+				return ErrIdentityNotFound
+			}
+			return fmt.Errorf("[FAIL]: could not execute query: %w", err)
+		}
+
+		var getUserQuery = `
+			SELECT id, username, email, password, role FROM users WHERE id = $1
+		`
+
+		err := tx.QueryRowContext(ctx, getUserQuery, user.ID).Scan(&user.ID, &user.Username, &user.Email, &user.Password, &user.Role)
+		if err != nil {
+			return fmt.Errorf("[FAIL]: could not execute query: %w", err)
+		}
+
+		return nil
+	})
+
+	return user, err
+}
+
+/*
+Creates a user signing-up with an external identity
+
+Objectives:
+  - Store the user without a password and their primary address
+  - Link the identity to the new user
+  - Record the audit event in the same transaction
+
+Params:
+  - ctx:      Method context
+  - user:     User model, its password must be empty
+  - identity: The external identity to link
+  - event:    The audit event recording the link
+
+Returns:
+  - ErrEmailTaken if another account already uses the email
+  - An error if any other step fails
+*/
+func (repo *PostGreSQL) CreateUserWithIdentity(ctx context.Context, user model.User, identity model.UserIdentity, event model.AuditEvent) error {
+	return repo.withTransaction(ctx, identityTables, func(tx *sql.Tx) error {
+		if err := repo.insertUser(ctx, tx, user); err != nil {
+			return err
+		}
+
+		if err := repo.insertIdentity(ctx, tx, identity); err != nil {
+			return err
+		}
+
+		return repo.insertAuditEvent(ctx, tx, event)
+	})
+}
+
+/*
+Links the external identity of an account created before identities were stored
+
+Accounts created through Google sign-in used to store the hashed Google id as their password,
+the identity replaces it so the id can no longer be used to sign-in with a password
+
+Params:
+  - ctx:      Method context
+  - identity: The external identity to link
+  - event:    The audit event recording the link
+
+Returns:
+  - An error if any step fails
+*/
+func (repo *PostGreSQL) ClaimLegacyIdentity(ctx context.Context, identity model.UserIdentity, event model.AuditEvent) error {
+	return repo.withTransaction(ctx, identityTables, func(tx *sql.Tx) error {
+		if err := repo.insertIdentity(ctx, tx, identity); err != nil {
+			return err
+		}
+
+		if _, err := tx.ExecContext(ctx, `UPDATE users SET password = '' WHERE id = $1`, identity.UserID); err != nil {
+			log.Println(err)
+			return fmt.Errorf("[FAIL]: could not clear legacy password")
+		}
+
+		return repo.insertAuditEvent(ctx, tx, event)
+	})
+}
+
+/*
+Returns the external identities linked to a user
+
+Params:
+  - ctx:    Method context
+  - userID: The user id
+
+Returns:
+  - The user identity models
+  - An error if the query failed
+*/
+func (repo *PostGreSQL) ListUserIdentities(ctx context.Context, userID uuid.UUID) ([]model.UserIdentity, error) {
+	var identities []model.UserIdentity
+
+	err := repo.withTransaction(ctx, identityTables, func(tx *sql.Tx) error {
+		var listQuery = `
+			SELECT id, user_id, provider, subject, email, created_at, last_sign_in_at
+			FROM user_identities
+			WHERE user_id = $1
+			ORDER BY created_at
+		`
+
+		rows, err := tx.QueryContext(ctx, listQuery, userID)
+		if err != nil {
+			return fmt.Errorf("[FAIL]: could not execute query: %w", err)
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var identity model.UserIdentity
+			err := rows.Scan(
+				&identity.ID, &identity.UserID, &identity.Provider, &identity.Subject,
+				&identity.Email, &identity.CreatedAt, &identity.LastSignInAt,
+			)
+			if err != nil {
+				return fmt.Errorf("[FAIL]: could not scan user identity: %w", err)
+			}
+			identities = append(identities, identity)
+		}
+
+		return rows.Err()
+	})
+
+	return identities, err
+}
+
+/*
+Links an external identity to an existing user
+
+Params:
+  - ctx:      Method context
+  - identity: The external identity to link
+  - event:    The audit event recording the link
+
+Returns:
+  - ErrIdentityLinked if the identity is already linked to an account
+  - An error if any other step fails
+*/
+func (repo *PostGreSQL) LinkIdentity(ctx context.Context, identity model.UserIdentity, event model.AuditEvent) error {
+	return repo.withTransaction(ctx, identityTables, func(tx *sql.Tx) error {
+		if err := repo.insertIdentity(ctx, tx, identity); err != nil {
+			return err
+		}
+
+		return repo.insertAuditEvent(ctx, tx, event)
+	})
+}
+
+/*
+Unlinks an external identity from a user
+
+Objectives:
+  - Lock the user so concurrent unlinks can't remove every sign-in method
+  - Refuse to unlink the last identity of a user without a password
+  - Delete the identity, its stored provider tokens and record the audit event in the same transaction
+
+Params:
+  - ctx:        Method context
+  - userID:     The user id
+  - identityID: The id of the identity to unlink
+  - event:      The audit event recording the unlink
+
+Returns:
+  - ErrIdentityNotFound or ErrLastSignInMethod if the identity can't be unlinked
+  - An error if any other step fails
+*/
+func (repo *PostGreSQL) UnlinkIdentity(ctx context.Context, userID uuid.UUID, identityID uuid.UUID, event model.AuditEvent) error {
+	return repo.withTransaction(ctx, identityTokenTables, func(tx *sql.Tx) error {
+		var password string
+		if err := tx.QueryRowContext(ctx, `SELECT password FROM users WHERE id = $1 FOR UPDATE`, userID).Scan(&password); err != nil {
+			return fmt.Errorf("[FAIL]: could not execute query: %w", err)
+		}
+
+		var provider string
+		var findQuery = `
+			SELECT provider FROM user_identities WHERE id = $1 AND user_id = $2
+		`
+
+		if err := tx.QueryRowContext(ctx, findQuery, identityID, userID).Scan(&provider); err != nil {
+			if err == sql.ErrNoRows {
+				return ErrIdentityNotFound
+			}
+			return fmt.Errorf("[FAIL]: could not execute query: %w", err)
+		}
+
+		var others int
+		var countQuery = `
+			SELECT COUNT(*) FROM user_identities WHERE user_id = $1 AND id <> $2
+		`
+
+		if err := tx.QueryRowContext(ctx, countQuery, userID, identityID).Scan(&others); err != nil {
+			return fmt.Errorf("[FAIL]: could not execute query: %w", err)
+		}
+
+		if password == "" && others == 0 {
+			return ErrLastSignInMethod
+		}
+
+		if _, err := tx.ExecContext(ctx, `DELETE FROM user_identity_tokens WHERE identity_id = $1`, identityID); err != nil {
+			log.Println(err)
+			return fmt.Errorf("[FAIL]: could not delete provider token")
+		}
+
+		if _, err := tx.ExecContext(ctx, `DELETE FROM user_identities WHERE id = $1`, identityID); err != nil {
+			log.Println(err)
+			return fmt.Errorf("[FAIL]: could not unlink identity")
+		}
+
+		event.Detail = provider
+		return repo.insertAuditEvent(ctx, tx, event)
+	})
+}
+
+/*
+Sets the password of a user that signed-up with an external identity
+
+Params:
+  - ctx:      Method context
+  - userID:   The user id
+  - password: The new password, hashed before storing
+  - event:    The audit event recording the change
+
+Returns:
+  - ErrPasswordAlreadySet if the user already has a password
+  - An error if any other step fails
+*/
+func (repo *PostGreSQL) AddUserPassword(ctx context.Context, userID uuid.UUID, password string, event model.AuditEvent) error {
+	hash, err := util.GenerateHash(password, util.DefaultHashCost)
+	if err != nil {
+		return err
+	}
+
+	return repo.withTransaction(ctx, identityTables, func(tx *sql.Tx) error {
+		result, err := tx.ExecContext(ctx, `UPDATE users SET password = $2 WHERE id = $1 AND password = ''`, userID, hash)
+		if err != nil {
+			log.Println(err)
+			return fmt.Errorf("[FAIL]: could not set password")
+		}
+
+		if affected, _ := result.RowsAffected(); affected == 0 {
+			return ErrPasswordAlreadySet
+		}
+
+		return repo.insertAuditEvent(ctx, tx, event)
+	})
+}
+
+/*
+Inserts an external identity as part of a transaction
+
+Params:
+  - ctx:      Method context
+  - tx:       A pointer to the transaction object
+  - identity: The external identity to link
+
+Returns:
+  - ErrIdentityLinked if the identity is already linked to an account
+  - An error if the insertion failed
+*/
+func (repo *PostGreSQL) insertIdentity(ctx context.Context, tx *sql.Tx, identity model.UserIdentity) error {
+	var insertQuery = `
+		INSERT INTO user_identities (id, user_id, provider, subject, email, last_sign_in_at)
+		VALUES ($1, $2, $3, $4, $5, NOW())
+	`
+
+	_, err := tx.ExecContext(ctx, insertQuery, identity.ID, identity.UserID, identity.Provider, identity.Subject, identity.Email)
+	if err != nil {
+		log.Println(err)
+		if isUniqueViolation(err) {
+			return ErrIdentityLinked
+		}
+		return fmt.Errorf("[FAIL]: could not execute insert query")
+	}
+
+	return nil
+}
@@ -0,0 +1,155 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/dev-xero/authentication-backend/encryption"
+	"github.com/dev-xero/authentication-backend/model"
+	"github.com/google/uuid"
+)
+
+// Returned when no provider token is stored for an identity
+var ErrIdentityTokenNotFound = errors.New("no provider token stored for that identity")
+
+// Tables touched by stored provider tokens, in creation order
+var identityTokenTables = []string{"users", "user_emails", "audit_events", "user_identities", "user_identity_tokens"}
+
+// Stored provider tokens are re-wrapped by master key rotation
+func init() {
+	encryption.RegisterColumn(encryption.Column{Table: "user_identity_tokens", KeyColumn: "identity_id", Column: "token"})
+}
+
+/*
+Stores the encrypted provider token of an identity, replacing any stored one
+
+Params:
+  - ctx:      Method context
+  - provider: The identity provider
+  - subject:  The user id at the provider
+  - token:    The encrypted provider token
+
+Returns:
+  - ErrIdentityNotFound if the identity isn't linked to an account
+  - An error if any other step fails
+*/
+func (repo *PostGreSQL) SaveIdentityToken(ctx context.Context, provider string, subject string, token string) error {
+	return repo.withTransaction(ctx, identityTokenTables, func(tx *sql.Tx) error {
+		var upsertQuery = `
+			INSERT INTO user_identity_tokens (identity_id, token)
+			SELECT id, $3 FROM user_identities WHERE provider = $1 AND subject = $2
+			ON CONFLICT (identity_id) DO UPDATE
+			SET token = EXCLUDED.token, updated_at = NOW()
+		`
+
+		result, err := tx.ExecContext(ctx, upsertQuery, provider, subject, token)
+		if err != nil {
+			log.Println(err)
+			return fmt.Errorf("[FAIL]: could not store provider token")
+		}
+
+		if affected, _ := result.RowsAffected(); affected == 0 {
+			return ErrIdentityNotFound
+		}
+
+		return nil
+	})
+}
+
+/*
+Returns the provider token stored for an identity
+
+Params:
+  - ctx:      Method context
+  - provider: The identity provider
+  - subject:  The user id at the provider
+
+Returns:
+  - The identity token model
+  - ErrIdentityTokenNotFound if no token is stored for the identity
+*/
+func (repo *PostGreSQL) GetIdentityToken(ctx context.Context, provider string, subject string) (model.IdentityToken, error) {
+	var getTokenQuery = `
+		SELECT t.identity_id, i.user_id, i.provider, i.subject, t.token, t.updated_at
+		FROM user_identity_tokens t JOIN user_identities i ON i.id = t.identity_id
+		WHERE i.provider = $1 AND i.subject = $2
+	`
+
+	return repo.getIdentityToken(ctx, getTokenQuery, provider, subject)
+}
+
+/*
+Returns the provider token stored for one of a user's identities
+
+Params:
+  - ctx:        Method context
+  - userID:     The user id
+  - identityID: The identity id, as listed by ListUserIdentities
+
+Returns:
+  - The identity token model
+  - ErrIdentityTokenNotFound if no token is stored for the identity or it belongs to another user
+*/
+func (repo *PostGreSQL) GetUserIdentityToken(ctx context.Context, userID uuid.UUID, identityID uuid.UUID) (model.IdentityToken, error) {
+	var getTokenQuery = `
+		SELECT t.identity_id, i.user_id, i.provider, i.subject, t.token, t.updated_at
+		FROM user_identity_tokens t JOIN user_identities i ON i.id = t.identity_id
+		WHERE i.user_id = $1 AND i.id = $2
+	`
+
+	return repo.getIdentityToken(ctx, getTokenQuery, userID, identityID)
+}
+
+/*
+Deletes the provider token stored for an identity
+
+Params:
+  - ctx:        Method context
+  - identityID: The identity id
+
+Returns:
+  - An error if the query failed
+*/
+func (repo *PostGreSQL) DeleteIdentityToken(ctx context.Context, identityID uuid.UUID) error {
+	return repo.withTransaction(ctx, identityTokenTables, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, `DELETE FROM user_identity_tokens WHERE identity_id = $1`, identityID); err != nil {
+			log.Println(err)
+			return fmt.Errorf("[FAIL]: could not delete provider token")
+		}
+
+		return nil
+	})
+}
+
+/*
+Runs a query returning a single stored provider token
+
+Params:
+  - ctx:   Method context
+  - query: The query selecting the token columns
+  - args:  The query arguments
+
+Returns:
+  - The identity token model
+  - ErrIdentityTokenNotFound if the query returned no row
+*/
+func (repo *PostGreSQL) getIdentityToken(ctx context.Context, query string, args ...interface{}) (model.IdentityToken, error) {
+	var token model.IdentityToken
+
+	err := repo.withTransaction(ctx, identityTokenTables, func(tx *sql.Tx) error {
+		err := tx.QueryRowContext(ctx, query, args...).Scan(&token.IdentityID, &token.UserID, &token.Provider, &token.Subject, &token.Token, &token.UpdatedAt)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				return ErrIdentityTokenNotFound
+			}
+			return fmt.Errorf("[FAIL]: could not execute query: %w", err)
+		}
+
+		return nil
+	})
+
+	return token, err
+}
@@ -0,0 +1,255 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/dev-xero/authentication-backend/model"
+	"github.com/lib/pq"
+)
+
+// Returned when no OAuth client has the client id, or a client assertion or DPoP proof is replayed
+var (
+	ErrOAuthClientNotFound = errors.New("oauth client not found")
+	ErrClientAssertionUsed = errors.New("client assertion was already used")
+	ErrDPoPProofUsed       = errors.New("dpop proof was already used")
+)
+
+// Tables touched by the authorization server, in creation order
+var oauthTables = []string{"users", "oauth_clients", "oauth_authorization_codes", "oauth_refresh_tokens", "oauth_client_assertions", "oauth_revoked_access_tokens", "oauth_device_codes", "oauth_grants", "oauth_dpop_proofs"}
+
+/*
+Registers an OAuth client
+
+Params:
+  - ctx:    Method context
+  - client: The OAuth client model, its secret already hashed
+
+Returns:
+  - An error if the client id is taken or the query failed
+*/
+func (repo *PostGreSQL) CreateOAuthClient(ctx context.Context, client model.OAuthClient) error {
+	return repo.withTransaction(ctx, oauthTables, func(tx *sql.Tx) error {
+		var insertQuery = `
+			INSERT INTO oauth_clients (id, secret_hash, name, redirect_uris, scopes, grant_types, token_endpoint_auth_method, jwks, audiences, first_party,
+				client_uri, logo_uri, policy_uri, tos_uri, contacts, registration_token_hash, subject_token_clients,
+				dpop_bound_access_tokens, tls_subject_dn, tls_san_dns)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20)
+		`
+
+		_, err := tx.ExecContext(ctx, insertQuery, client.ID, client.SecretHash, client.Name, textArray(client.RedirectURIs),
+			textArray(client.Scopes), textArray(client.GrantTypes), client.TokenEndpointAuthMethod, client.JWKS,
+			textArray(client.Audiences), client.FirstParty, client.ClientURI, client.LogoURI, client.PolicyURI, client.TOSURI,
+			textArray(client.Contacts), client.RegistrationTokenHash, textArray(client.SubjectTokenClients), client.DPoPBoundAccessTokens,
+			client.TLSSubjectDN, client.TLSSANDNS)
+		if err != nil {
+			log.Println(err)
+			if isUniqueViolation(err) {
+				return fmt.Errorf("[FAIL]: client id %q is taken", client.ID)
+			}
+			return fmt.Errorf("[FAIL]: could not execute insert query")
+		}
+
+		return nil
+	})
+}
+
+/*
+Returns a registered OAuth client
+
+Params:
+  - ctx:      Method context
+  - clientID: The client id
+
+Returns:
+  - The OAuth client model
+  - ErrOAuthClientNotFound if no client has the id
+*/
+func (repo *PostGreSQL) GetOAuthClient(ctx context.Context, clientID string) (model.OAuthClient, error) {
+	var client model.OAuthClient
+
+	err := repo.withTransaction(ctx, oauthTables, func(tx *sql.Tx) error {
+		var getClientQuery = `
+			SELECT id, secret_hash, name, redirect_uris, scopes, grant_types, token_endpoint_auth_method, jwks, audiences, first_party,
+				client_uri, logo_uri, policy_uri, tos_uri, contacts, registration_token_hash, subject_token_clients, dpop_bound_access_tokens,
+				tls_subject_dn, tls_san_dns, created_at
+			FROM oauth_clients WHERE id = $1
+		`
+
+		err := tx.QueryRowContext(ctx, getClientQuery, clientID).Scan(&client.ID, &client.SecretHash, &client.Name,
+			pq.Array(&client.RedirectURIs), pq.Array(&client.Scopes), pq.Array(&client.GrantTypes),
+			&client.TokenEndpointAuthMethod, &client.JWKS, pq.Array(&client.Audiences), &client.FirstParty,
+			&client.ClientURI, &client.LogoURI, &client.PolicyURI, &client.TOSURI, pq.Array(&client.Contacts),
+			&client.RegistrationTokenHash, pq.Array(&client.SubjectTokenClients), &client.DPoPBoundAccessTokens,
+			&client.TLSSubjectDN, &client.TLSSANDNS, &client.CreatedAt)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				return ErrOAuthClientNotFound
+			}
+			return fmt.Errorf("[FAIL]: could not execute query: %w", err)
+		}
+
+		return nil
+	})
+
+	return client, err
+}
+
+/*
+Replaces the metadata of a registered OAuth client, its id, first party flag, subject token clients and registration access token stay as they are
+
+Params:
+  - ctx:    Method context
+  - client: The OAuth client model, its secret already hashed
+
+Returns:
+  - ErrOAuthClientNotFound if no client has the id
+  - An error if the query failed
+*/
+func (repo *PostGreSQL) UpdateOAuthClient(ctx context.Context, client model.OAuthClient) error {
+	return repo.withTransaction(ctx, oauthTables, func(tx *sql.Tx) error {
+		var updateQuery = `
+			UPDATE oauth_clients SET secret_hash = $2, name = $3, redirect_uris = $4, scopes = $5, grant_types = $6,
+				token_endpoint_auth_method = $7, jwks = $8, audiences = $9, client_uri = $10, logo_uri = $11, policy_uri = $12,
+				tos_uri = $13, contacts = $14, dpop_bound_access_tokens = $15, tls_subject_dn = $16, tls_san_dns = $17
+			WHERE id = $1
+		`
+
+		result, err := tx.ExecContext(ctx, updateQuery, client.ID, client.SecretHash, client.Name, textArray(client.RedirectURIs),
+			textArray(client.Scopes), textArray(client.GrantTypes), client.TokenEndpointAuthMethod, client.JWKS,
+			textArray(client.Audiences), client.ClientURI, client.LogoURI, client.PolicyURI, client.TOSURI, textArray(client.Contacts),
+			client.DPoPBoundAccessTokens, client.TLSSubjectDN, client.TLSSANDNS)
+		if err != nil {
+			log.Println(err)
+			return fmt.Errorf("[FAIL]: could not execute update query")
+		}
+
+		if rows, err := result.RowsAffected(); err != nil || rows == 0 {
+			return ErrOAuthClientNotFound
+		}
+
+		return nil
+	})
+}
+
+/*
+Deletes an OAuth client, with every code, token and grant issued to it
+
+Params:
+  - ctx:      Method context
+  - clientID: The client id
+
+Returns:
+  - ErrOAuthClientNotFound if no client has the id
+  - An error if the query failed
+*/
+func (repo *PostGreSQL) DeleteOAuthClient(ctx context.Context, clientID string) error {
+	return repo.withTransaction(ctx, oauthTables, func(tx *sql.Tx) error {
+		result, err := tx.ExecContext(ctx, `DELETE FROM oauth_clients WHERE id = $1`, clientID)
+		if err != nil {
+			log.Println(err)
+			return fmt.Errorf("[FAIL]: could not execute delete query")
+		}
+
+		if rows, err := result.RowsAffected(); err != nil || rows == 0 {
+			return ErrOAuthClientNotFound
+		}
+
+		return nil
+	})
+}
+
+/*
+Wraps a string slice as a TEXT[] query argument
+
+Params:
+  - values: The strings, a nil slice is stored as an empty array instead of NULL
+
+Returns:
+  - The query argument
+*/
+func textArray(values []string) interface{} {
+	if values == nil {
+		values = []string{}
+	}
+
+	return pq.Array(values)
+}
+
+/*
+Marks a client assertion as used, so a captured assertion can't be replayed
+
+Objectives:
+  - Remove records of assertions that expired, they are rejected by their expiry anyway
+  - Record the assertion id for its client, failing if another request already recorded it
+
+Params:
+  - ctx:       Method context
+  - clientID:  The client that signed the assertion
+  - jti:       The assertion id
+  - expiresAt: When the assertion expires
+
+Returns:
+  - ErrClientAssertionUsed if the assertion was already used
+  - An error if any other step fails
+*/
+func (repo *PostGreSQL) ConsumeClientAssertion(ctx context.Context, clientID string, jti string, expiresAt time.Time) error {
+	return repo.withTransaction(ctx, oauthTables, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, `DELETE FROM oauth_client_assertions WHERE expires_at < NOW()`); err != nil {
+			log.Println(err)
+			return fmt.Errorf("[FAIL]: could not remove expired client assertions")
+		}
+
+		insertQuery := `INSERT INTO oauth_client_assertions (client_id, jti, expires_at) VALUES ($1, $2, $3)`
+		if _, err := tx.ExecContext(ctx, insertQuery, clientID, jti, expiresAt); err != nil {
+			log.Println(err)
+			if isUniqueViolation(err) {
+				return ErrClientAssertionUsed
+			}
+			return fmt.Errorf("[FAIL]: could not execute insert query")
+		}
+
+		return nil
+	})
+}
+
+/*
+Marks a DPoP proof as used, so a captured proof can't be replayed at the authorization server
+
+Objectives:
+  - Remove records of proofs that expired, they are rejected by their age anyway
+  - Record the proof id for the key that signed it, failing if another request already recorded it
+
+Params:
+  - ctx:        Method context
+  - thumbprint: The thumbprint of the key that signed the proof
+  - jti:        The proof id
+  - expiresAt:  When the proof stops being accepted
+
+Returns:
+  - ErrDPoPProofUsed if the proof was already used
+  - An error if any other step fails
+*/
+func (repo *PostGreSQL) ConsumeDPoPProof(ctx context.Context, thumbprint string, jti string, expiresAt time.Time) error {
+	return repo.withTransaction(ctx, oauthTables, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, `DELETE FROM oauth_dpop_proofs WHERE expires_at < NOW()`); err != nil {
+			log.Println(err)
+			return fmt.Errorf("[FAIL]: could not remove expired dpop proofs")
+		}
+
+		insertQuery := `INSERT INTO oauth_dpop_proofs (jkt, jti, expires_at) VALUES ($1, $2, $3)`
+		if _, err := tx.ExecContext(ctx, insertQuery, thumbprint, jti, expiresAt); err != nil {
+			log.Println(err)
+			if isUniqueViolation(err) {
+				return ErrDPoPProofUsed
+			}
+			return fmt.Errorf("[FAIL]: could not execute insert query")
+		}
+
+		return nil
+	})
+}
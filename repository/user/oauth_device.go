@@ -0,0 +1,252 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/dev-xero/authentication-backend/model"
+	"github.com/google/uuid"
+)
+
+// Stores errors of the device authorization grant, the token endpoint responds to each with its RFC 8628 error
+var (
+	ErrUserCodeTaken              = errors.New("user code is taken")
+	ErrDeviceCodeInvalid          = errors.New("device code is invalid or was already used")
+	ErrDeviceCodeExpired          = errors.New("device code expired")
+	ErrDeviceAuthorizationPending = errors.New("device authorization is pending")
+	ErrDeviceSlowDown             = errors.New("device polls too often")
+	ErrDeviceAccessDenied         = errors.New("device authorization was denied")
+)
+
+// Seconds added to the poll interval of a device that polls too often
+const slowDownIncrement = 5
+
+/*
+Stores a device authorization issued by the device authorization endpoint
+
+Objectives:
+  - Remove device codes that expired a day ago, the grace period reports expired_token to late polls
+  - Insert the device authorization
+
+Params:
+  - ctx:           Method context
+  - authorization: The device authorization model
+
+Returns:
+  - ErrUserCodeTaken if another device holds the user code
+  - An error if any other step fails
+*/
+func (repo *PostGreSQL) InsertDeviceAuthorization(ctx context.Context, authorization model.DeviceAuthorization) error {
+	return repo.withTransaction(ctx, oauthTables, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, `DELETE FROM oauth_device_codes WHERE expires_at < NOW() - INTERVAL '1 day'`); err != nil {
+			log.Println(err)
+			return fmt.Errorf("[FAIL]: could not remove expired device codes")
+		}
+
+		var insertQuery = `
+			INSERT INTO oauth_device_codes (device_code_hash, user_code, client_id, scope, status, poll_interval, expires_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7)
+		`
+
+		_, err := tx.ExecContext(ctx, insertQuery, authorization.DeviceCodeHash, authorization.UserCode, authorization.ClientID,
+			authorization.Scope, model.DeviceStatusPending, authorization.PollInterval, authorization.ExpiresAt)
+		if err != nil {
+			log.Println(err)
+			if isUniqueViolation(err) {
+				return ErrUserCodeTaken
+			}
+			return fmt.Errorf("[FAIL]: could not execute insert query")
+		}
+
+		return nil
+	})
+}
+
+/*
+Returns the pending device authorization a user code was issued for
+
+Params:
+  - ctx:      Method context
+  - userCode: The normalized user code
+
+Returns:
+  - The device authorization model
+  - ErrDeviceCodeInvalid if no pending, unexpired authorization has the code
+*/
+func (repo *PostGreSQL) GetPendingDeviceAuthorization(ctx context.Context, userCode string) (model.DeviceAuthorization, error) {
+	var authorization model.DeviceAuthorization
+
+	err := repo.withTransaction(ctx, oauthTables, func(tx *sql.Tx) error {
+		var getQuery = `
+			SELECT device_code_hash, user_code, client_id, scope, status, poll_interval, expires_at
+			FROM oauth_device_codes WHERE user_code = $1 AND status = $2 AND expires_at > NOW()
+		`
+
+		err := tx.QueryRowContext(ctx, getQuery, userCode, model.DeviceStatusPending).Scan(&authorization.DeviceCodeHash,
+			&authorization.UserCode, &authorization.ClientID, &authorization.Scope, &authorization.Status,
+			&authorization.PollInterval, &authorization.ExpiresAt)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				return ErrDeviceCodeInvalid
+			}
+			return fmt.Errorf("[FAIL]: could not execute query: %w", err)
+		}
+
+		return nil
+	})
+
+	return authorization, err
+}
+
+/*
+Records a user's decision on a pending device authorization
+
+Objectives:
+  - Mark the device approved or denied by the user
+  - Record the requested scopes as granted to the client when the user approved it
+
+Params:
+  - ctx:      Method context
+  - userCode: The normalized user code
+  - userID:   The signed-in user deciding
+  - authTime: When the user signed-in
+  - approve:  Whether the user approved the device
+
+Returns:
+  - ErrDeviceCodeInvalid if no pending, unexpired authorization has the code
+  - An error if any other step fails
+*/
+func (repo *PostGreSQL) DecideDeviceAuthorization(ctx context.Context, userCode string, userID uuid.UUID, authTime time.Time, approve bool) error {
+	status := model.DeviceStatusDenied
+	if approve {
+		status = model.DeviceStatusApproved
+	}
+
+	return repo.withTransaction(ctx, oauthTables, func(tx *sql.Tx) error {
+		var decideQuery = `
+			UPDATE oauth_device_codes SET status = $1, user_id = $2, auth_time = $3
+			WHERE user_code = $4 AND status = $5 AND expires_at > NOW()
+			RETURNING client_id, scope
+		`
+
+		var clientID, scope string
+		err := tx.QueryRowContext(ctx, decideQuery, status, userID, nullTime(authTime), userCode, model.DeviceStatusPending).Scan(&clientID, &scope)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				return ErrDeviceCodeInvalid
+			}
+			log.Println(err)
+			return fmt.Errorf("[FAIL]: could not execute update query")
+		}
+
+		if !approve {
+			return nil
+		}
+
+		return repo.saveOAuthGrant(ctx, tx, userID, clientID, strings.Fields(scope))
+	})
+}
+
+/*
+Handles a device polling the token endpoint
+
+Objectives:
+  - Lock the device authorization, so concurrent polls can't both get tokens
+  - Slow down devices polling faster than their interval, raising the interval by 5 seconds
+  - Report pending, denied and expired authorizations, recording the poll
+  - Consume an approved authorization, it is exchanged for tokens once
+
+Params:
+  - ctx:            Method context
+  - deviceCodeHash: Hash of the device code
+  - clientID:       The authenticated client polling
+
+Returns:
+  - The approved device authorization model
+  - The RFC 8628 error of the poll, ErrDeviceCodeInvalid if the code is unknown, consumed or another client's
+*/
+func (repo *PostGreSQL) PollDeviceAuthorization(ctx context.Context, deviceCodeHash string, clientID string) (model.DeviceAuthorization, error) {
+	var (
+		authorization model.DeviceAuthorization
+		result        error
+	)
+
+	err := repo.withTransaction(ctx, oauthTables, func(tx *sql.Tx) error {
+		var (
+			userID       uuid.NullUUID
+			authTime     sql.NullTime
+			lastPolledAt sql.NullTime
+		)
+
+		var getQuery = `
+			SELECT device_code_hash, user_code, client_id, scope, status, user_id, auth_time, poll_interval, last_polled_at, expires_at
+			FROM oauth_device_codes WHERE device_code_hash = $1
+			FOR UPDATE
+		`
+
+		err := tx.QueryRowContext(ctx, getQuery, deviceCodeHash).Scan(&authorization.DeviceCodeHash, &authorization.UserCode,
+			&authorization.ClientID, &authorization.Scope, &authorization.Status, &userID, &authTime,
+			&authorization.PollInterval, &lastPolledAt, &authorization.ExpiresAt)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				result = ErrDeviceCodeInvalid
+				return nil
+			}
+			return fmt.Errorf("[FAIL]: could not execute query: %w", err)
+		}
+		authorization.UserID = userID.UUID
+		authorization.AuthTime = authTime.Time
+
+		if authorization.ClientID != clientID || authorization.Status == model.DeviceStatusConsumed {
+			result = ErrDeviceCodeInvalid
+			return nil
+		}
+
+		if authorization.ExpiresAt.Before(time.Now()) {
+			result = ErrDeviceCodeExpired
+			return nil
+		}
+
+		// The poll is recorded even though it fails, the next one is timed from it
+		if lastPolledAt.Valid && time.Since(lastPolledAt.Time) < time.Duration(authorization.PollInterval)*time.Second {
+			var slowDownQuery = `UPDATE oauth_device_codes SET poll_interval = poll_interval + $1, last_polled_at = NOW() WHERE device_code_hash = $2`
+			if _, err := tx.ExecContext(ctx, slowDownQuery, slowDownIncrement, deviceCodeHash); err != nil {
+				log.Println(err)
+				return fmt.Errorf("[FAIL]: could not execute update query")
+			}
+			result = ErrDeviceSlowDown
+			return nil
+		}
+
+		nextStatus := authorization.Status
+		switch authorization.Status {
+		case model.DeviceStatusPending:
+			result = ErrDeviceAuthorizationPending
+		case model.DeviceStatusDenied:
+			result = ErrDeviceAccessDenied
+		case model.DeviceStatusApproved:
+			nextStatus = model.DeviceStatusConsumed
+		}
+
+		var pollQuery = `UPDATE oauth_device_codes SET status = $1, last_polled_at = NOW() WHERE device_code_hash = $2`
+		if _, err := tx.ExecContext(ctx, pollQuery, nextStatus, deviceCodeHash); err != nil {
+			log.Println(err)
+			return fmt.Errorf("[FAIL]: could not execute update query")
+		}
+
+		return nil
+	})
+	if err != nil {
+		return model.DeviceAuthorization{}, err
+	}
+	if result != nil {
+		return model.DeviceAuthorization{}, result
+	}
+
+	return authorization, nil
+}
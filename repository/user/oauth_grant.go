@@ -0,0 +1,202 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/dev-xero/authentication-backend/model"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// Returned when a user hasn't granted a client access, or revoked it
+var ErrOAuthGrantNotFound = errors.New("oauth grant not found")
+
+// Tables touched when a user revokes a grant, the revocation is audited
+var grantTables = []string{"users", "oauth_clients", "oauth_authorization_codes", "oauth_refresh_tokens", "oauth_client_assertions", "oauth_revoked_access_tokens", "oauth_device_codes", "oauth_grants", "audit_events"}
+
+/*
+Returns the scopes a user granted a client
+
+Params:
+  - ctx:      Method context
+  - userID:   The user id
+  - clientID: The client id
+
+Returns:
+  - The OAuth grant model
+  - ErrOAuthGrantNotFound if the user hasn't granted the client access
+*/
+func (repo *PostGreSQL) GetOAuthGrant(ctx context.Context, userID uuid.UUID, clientID string) (model.OAuthGrant, error) {
+	var grant model.OAuthGrant
+
+	err := repo.withTransaction(ctx, oauthTables, func(tx *sql.Tx) error {
+		var getQuery = `
+			SELECT g.user_id, g.client_id, c.name, g.scopes, g.created_at, g.updated_at
+			FROM oauth_grants g JOIN oauth_clients c ON c.id = g.client_id
+			WHERE g.user_id = $1 AND g.client_id = $2 AND g.active
+		`
+
+		err := tx.QueryRowContext(ctx, getQuery, userID, clientID).Scan(&grant.UserID, &grant.ClientID, &grant.ClientName,
+			pq.Array(&grant.Scopes), &grant.CreatedAt, &grant.UpdatedAt)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				return ErrOAuthGrantNotFound
+			}
+			return fmt.Errorf("[FAIL]: could not execute query: %w", err)
+		}
+
+		return nil
+	})
+
+	return grant, err
+}
+
+/*
+Returns the clients a user granted access, most recently granted first
+
+Params:
+  - ctx:    Method context
+  - userID: The user id
+
+Returns:
+  - The OAuth grant models
+  - An error if the query failed
+*/
+func (repo *PostGreSQL) ListOAuthGrants(ctx context.Context, userID uuid.UUID) ([]model.OAuthGrant, error) {
+	var grants []model.OAuthGrant
+
+	err := repo.withTransaction(ctx, oauthTables, func(tx *sql.Tx) error {
+		var listQuery = `
+			SELECT g.user_id, g.client_id, c.name, g.scopes, g.created_at, g.updated_at
+			FROM oauth_grants g JOIN oauth_clients c ON c.id = g.client_id
+			WHERE g.user_id = $1 AND g.active
+			ORDER BY g.updated_at DESC
+		`
+
+		rows, err := tx.QueryContext(ctx, listQuery, userID)
+		if err != nil {
+			return fmt.Errorf("[FAIL]: could not execute query: %w", err)
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var grant model.OAuthGrant
+			err := rows.Scan(&grant.UserID, &grant.ClientID, &grant.ClientName, pq.Array(&grant.Scopes), &grant.CreatedAt, &grant.UpdatedAt)
+			if err != nil {
+				return fmt.Errorf("[FAIL]: could not scan oauth grant: %w", err)
+			}
+			grants = append(grants, grant)
+		}
+
+		return rows.Err()
+	})
+
+	return grants, err
+}
+
+/*
+Records that a user granted a client scopes, adding them to the scopes already granted
+
+Params:
+  - ctx:      Method context
+  - userID:   The user id
+  - clientID: The client id
+  - scopes:   The scopes the user consented to
+
+Returns:
+  - An error if the query failed
+*/
+func (repo *PostGreSQL) SaveOAuthGrant(ctx context.Context, userID uuid.UUID, clientID string, scopes []string) error {
+	return repo.withTransaction(ctx, oauthTables, func(tx *sql.Tx) error {
+		return repo.saveOAuthGrant(ctx, tx, userID, clientID, scopes)
+	})
+}
+
+/*
+Revokes the access a user granted a client
+
+Objectives:
+  - Lock the grant and mark it revoked, access tokens issued before are rejected from now on
+  - Revoke the refresh tokens of the client for the user
+  - Consume authorization codes not yet exchanged, and deny devices approved but not yet polled
+  - Record the audit event in the same transaction
+
+Params:
+  - ctx:      Method context
+  - userID:   The user id
+  - clientID: The client id
+  - event:    The audit event recording the revocation
+
+Returns:
+  - ErrOAuthGrantNotFound if the user hasn't granted the client access
+  - An error if any other step fails
+*/
+func (repo *PostGreSQL) RevokeOAuthGrant(ctx context.Context, userID uuid.UUID, clientID string, event model.AuditEvent) error {
+	return repo.withTransaction(ctx, grantTables, func(tx *sql.Tx) error {
+		var active bool
+		err := tx.QueryRowContext(ctx, `SELECT active FROM oauth_grants WHERE user_id = $1 AND client_id = $2 FOR UPDATE`, userID, clientID).Scan(&active)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				return ErrOAuthGrantNotFound
+			}
+			return fmt.Errorf("[FAIL]: could not execute query: %w", err)
+		}
+		if !active {
+			return ErrOAuthGrantNotFound
+		}
+
+		var revokeQueries = []string{
+			`UPDATE oauth_grants SET active = FALSE, scopes = '{}', revoked_at = NOW(), updated_at = NOW() WHERE user_id = $1 AND client_id = $2`,
+			`UPDATE oauth_refresh_tokens SET revoked_at = NOW() WHERE user_id = $1 AND client_id = $2 AND revoked_at IS NULL`,
+			`UPDATE oauth_authorization_codes SET consumed_at = NOW() WHERE user_id = $1 AND client_id = $2 AND consumed_at IS NULL`,
+			`UPDATE oauth_device_codes SET status = 'denied' WHERE user_id = $1 AND client_id = $2 AND status = 'approved'`,
+		}
+
+		for _, query := range revokeQueries {
+			if _, err := tx.ExecContext(ctx, query, userID, clientID); err != nil {
+				log.Println(err)
+				return fmt.Errorf("[FAIL]: could not revoke grant")
+			}
+		}
+
+		event.Detail = clientID
+		return repo.insertAuditEvent(ctx, tx, event)
+	})
+}
+
+/*
+Upserts a grant as part of a transaction, a revoked grant starts over with the new scopes
+
+Params:
+  - ctx:      Method context
+  - tx:       A pointer to the transaction object
+  - userID:   The user id
+  - clientID: The client id
+  - scopes:   The scopes the user consented to
+
+Returns:
+  - An error if the query failed
+*/
+func (repo *PostGreSQL) saveOAuthGrant(ctx context.Context, tx *sql.Tx, userID uuid.UUID, clientID string, scopes []string) error {
+	var upsertQuery = `
+		INSERT INTO oauth_grants (user_id, client_id, scopes) VALUES ($1, $2, $3)
+		ON CONFLICT (user_id, client_id) DO UPDATE SET
+			scopes = CASE WHEN oauth_grants.active
+				THEN ARRAY(SELECT DISTINCT scope FROM unnest(oauth_grants.scopes || EXCLUDED.scopes) AS scope ORDER BY scope)
+				ELSE EXCLUDED.scopes END,
+			created_at = CASE WHEN oauth_grants.active THEN oauth_grants.created_at ELSE NOW() END,
+			active = TRUE,
+			updated_at = NOW()
+	`
+
+	if _, err := tx.ExecContext(ctx, upsertQuery, userID, clientID, textArray(scopes)); err != nil {
+		log.Println(err)
+		return fmt.Errorf("[FAIL]: could not save grant")
+	}
+
+	return nil
+}
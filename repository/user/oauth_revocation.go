@@ -0,0 +1,184 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/dev-xero/authentication-backend/model"
+	"github.com/google/uuid"
+)
+
+/*
+Revokes an access token until it expires
+
+Objectives:
+  - Remove records of revoked tokens that expired, they are rejected by their expiry anyway
+  - Record the token id, revoking a token twice is a no-op
+
+Params:
+  - ctx:       Method context
+  - jti:       The access token id
+  - clientID:  The client the token was issued to
+  - expiresAt: When the token expires
+
+Returns:
+  - An error if any step fails
+*/
+func (repo *PostGreSQL) RevokeAccessToken(ctx context.Context, jti string, clientID string, expiresAt time.Time) error {
+	return repo.withTransaction(ctx, oauthTables, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, `DELETE FROM oauth_revoked_access_tokens WHERE expires_at < NOW()`); err != nil {
+			log.Println(err)
+			return fmt.Errorf("[FAIL]: could not remove expired access token revocations")
+		}
+
+		var insertQuery = `
+			INSERT INTO oauth_revoked_access_tokens (jti, client_id, expires_at) VALUES ($1, $2, $3)
+			ON CONFLICT (jti) DO NOTHING
+		`
+
+		if _, err := tx.ExecContext(ctx, insertQuery, jti, clientID, expiresAt); err != nil {
+			log.Println(err)
+			return fmt.Errorf("[FAIL]: could not execute insert query")
+		}
+
+		return nil
+	})
+}
+
+/*
+Checks whether an access token was revoked, on its own, with the refresh token family of its grant, with the grant itself, or by deleting its client
+
+Params:
+  - ctx:      Method context
+  - jti:      The access token id
+  - familyID: The refresh token family of the token, uuid.Nil if it has none
+  - userID:   The user the token acts for, uuid.Nil for tokens of clients acting for themselves
+  - clientID: The client the token was issued to
+  - issuedAt: When the token was issued, revoking the grant revokes the tokens issued before
+
+Returns:
+  - True if the token is revoked
+  - An error if the query failed
+*/
+func (repo *PostGreSQL) AccessTokenRevoked(ctx context.Context, jti string, familyID uuid.UUID, userID uuid.UUID, clientID string, issuedAt time.Time) (bool, error) {
+	var revoked bool
+
+	err := repo.withTransaction(ctx, oauthTables, func(tx *sql.Tx) error {
+		var revokedQuery = `
+			SELECT EXISTS (SELECT 1 FROM oauth_revoked_access_tokens WHERE jti = $1)
+				OR NOT EXISTS (SELECT 1 FROM oauth_clients WHERE id = $2)
+		`
+		if err := tx.QueryRowContext(ctx, revokedQuery, jti, clientID).Scan(&revoked); err != nil {
+			return fmt.Errorf("[FAIL]: could not execute query: %w", err)
+		}
+
+		if !revoked && familyID != uuid.Nil {
+			var familyQuery = `SELECT EXISTS (SELECT 1 FROM oauth_refresh_tokens WHERE family_id = $1 AND revoked_at IS NOT NULL)`
+			if err := tx.QueryRowContext(ctx, familyQuery, familyID).Scan(&revoked); err != nil {
+				return fmt.Errorf("[FAIL]: could not execute query: %w", err)
+			}
+		}
+
+		// Token times are in whole seconds, a token issued in the second of the revocation counts as issued before it
+		if !revoked && userID != uuid.Nil {
+			var grantQuery = `SELECT EXISTS (SELECT 1 FROM oauth_grants WHERE user_id = $1 AND client_id = $2 AND revoked_at >= $3)`
+			if err := tx.QueryRowContext(ctx, grantQuery, userID, clientID, issuedAt).Scan(&revoked); err != nil {
+				return fmt.Errorf("[FAIL]: could not execute query: %w", err)
+			}
+		}
+
+		return nil
+	})
+
+	return revoked, err
+}
+
+/*
+Returns a refresh token and whether it can still be used
+
+Params:
+  - ctx:       Method context
+  - tokenHash: Hash of the refresh token
+
+Returns:
+  - The refresh token model
+  - True if the token isn't rotated, revoked or expired
+  - ErrRefreshTokenInvalid if no token has the hash
+*/
+func (repo *PostGreSQL) GetRefreshToken(ctx context.Context, tokenHash string) (model.RefreshToken, bool, error) {
+	var (
+		token  model.RefreshToken
+		active bool
+	)
+
+	err := repo.withTransaction(ctx, oauthTables, func(tx *sql.Tx) error {
+		var (
+			authTime  sql.NullTime
+			rotatedAt sql.NullTime
+			revokedAt sql.NullTime
+		)
+
+		var getTokenQuery = `
+			SELECT token_hash, family_id, client_id, user_id, scope, code_hash, auth_time, expires_at, rotated_at, revoked_at
+			FROM oauth_refresh_tokens WHERE token_hash = $1
+		`
+
+		err := tx.QueryRowContext(ctx, getTokenQuery, tokenHash).Scan(&token.TokenHash, &token.FamilyID, &token.ClientID,
+			&token.UserID, &token.Scope, &token.CodeHash, &authTime, &token.ExpiresAt, &rotatedAt, &revokedAt)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				return ErrRefreshTokenInvalid
+			}
+			return fmt.Errorf("[FAIL]: could not execute query: %w", err)
+		}
+
+		token.AuthTime = authTime.Time
+		active = !rotatedAt.Valid && !revokedAt.Valid && token.ExpiresAt.After(time.Now())
+		return nil
+	})
+	if err != nil {
+		return model.RefreshToken{}, false, err
+	}
+
+	return token, active, nil
+}
+
+/*
+Revokes the refresh token family a token belongs to, with the access tokens issued from it
+
+Params:
+  - ctx:       Method context
+  - tokenHash: Hash of any refresh token of the family
+  - clientID:  The client revoking the token, tokens of other clients are left alone
+
+Returns:
+  - True if a family of the client was found
+  - An error if the query failed
+*/
+func (repo *PostGreSQL) RevokeRefreshTokenFamily(ctx context.Context, tokenHash string, clientID string) (bool, error) {
+	var found bool
+
+	err := repo.withTransaction(ctx, oauthTables, func(tx *sql.Tx) error {
+		var familyID uuid.UUID
+		err := tx.QueryRowContext(ctx, `SELECT family_id FROM oauth_refresh_tokens WHERE token_hash = $1 AND client_id = $2`, tokenHash, clientID).Scan(&familyID)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				return nil
+			}
+			return fmt.Errorf("[FAIL]: could not execute query: %w", err)
+		}
+
+		if _, err := tx.ExecContext(ctx, `UPDATE oauth_refresh_tokens SET revoked_at = NOW() WHERE family_id = $1 AND revoked_at IS NULL`, familyID); err != nil {
+			log.Println(err)
+			return fmt.Errorf("[FAIL]: could not revoke refresh token family")
+		}
+
+		found = true
+		return nil
+	})
+
+	return found, err
+}
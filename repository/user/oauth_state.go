@@ -0,0 +1,49 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+// Returned when an OAuth state was already used to complete a flow
+var ErrOAuthStateUsed = errors.New("oauth state was already used")
+
+/*
+Marks an OAuth state as used, so its callback can only be completed once
+
+Objectives:
+  - Remove records of states that expired, they can't be replayed anymore
+  - Record the state id, failing if another callback already recorded it
+
+Params:
+  - ctx:       Method context
+  - id:        The state id
+  - expiresAt: When the state expires
+
+Returns:
+  - ErrOAuthStateUsed if the state was already used
+  - An error if any other step fails
+*/
+func (repo *PostGreSQL) ConsumeOAuthState(ctx context.Context, id string, expiresAt time.Time) error {
+	return repo.withTransaction(ctx, []string{"oauth_states"}, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, `DELETE FROM oauth_states WHERE expires_at < NOW()`); err != nil {
+			log.Println(err)
+			return fmt.Errorf("[FAIL]: could not remove expired oauth states")
+		}
+
+		_, err := tx.ExecContext(ctx, `INSERT INTO oauth_states (id, expires_at) VALUES ($1, $2)`, id, expiresAt)
+		if err != nil {
+			log.Println(err)
+			if isUniqueViolation(err) {
+				return ErrOAuthStateUsed
+			}
+			return fmt.Errorf("[FAIL]: could not execute insert query")
+		}
+
+		return nil
+	})
+}
@@ -0,0 +1,275 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/dev-xero/authentication-backend/model"
+)
+
+// Stores errors the token endpoint responds to with invalid_grant
+var (
+	ErrAuthorizationCodeInvalid = errors.New("authorization code is invalid or expired")
+	ErrAuthorizationCodeUsed    = errors.New("authorization code was already used")
+	ErrRefreshTokenInvalid      = errors.New("refresh token is invalid, expired or revoked")
+	ErrRefreshTokenReused       = errors.New("refresh token was already used")
+)
+
+/*
+Stores an authorization code issued by the authorize endpoint
+
+Objectives:
+  - Remove codes that expired, they can't be exchanged anymore
+  - Insert the code
+
+Params:
+  - ctx:  Method context
+  - code: The authorization code model
+
+Returns:
+  - An error if any step fails
+*/
+func (repo *PostGreSQL) InsertAuthorizationCode(ctx context.Context, code model.AuthorizationCode) error {
+	return repo.withTransaction(ctx, oauthTables, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, `DELETE FROM oauth_authorization_codes WHERE expires_at < NOW() - INTERVAL '1 day'`); err != nil {
+			log.Println(err)
+			return fmt.Errorf("[FAIL]: could not remove expired authorization codes")
+		}
+
+		var insertQuery = `
+			INSERT INTO oauth_authorization_codes (code_hash, client_id, user_id, redirect_uri, scope, code_challenge, nonce, auth_time, expires_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		`
+
+		_, err := tx.ExecContext(ctx, insertQuery, code.CodeHash, code.ClientID, code.UserID, code.RedirectURI, code.Scope,
+			code.CodeChallenge, code.Nonce, nullTime(code.AuthTime), code.ExpiresAt)
+		if err != nil {
+			log.Println(err)
+			return fmt.Errorf("[FAIL]: could not execute insert query")
+		}
+
+		return nil
+	})
+}
+
+/*
+Marks an authorization code as used and returns it
+
+Objectives:
+  - Consume the code, so it can only be exchanged once
+  - Revoke the refresh tokens issued for a code that is exchanged again, it may have been stolen
+
+Params:
+  - ctx:      Method context
+  - codeHash: Hash of the authorization code
+
+Returns:
+  - The authorization code model
+  - ErrAuthorizationCodeUsed if it was already exchanged, ErrAuthorizationCodeInvalid if it is unknown or expired
+*/
+func (repo *PostGreSQL) ConsumeAuthorizationCode(ctx context.Context, codeHash string) (model.AuthorizationCode, error) {
+	var (
+		code     model.AuthorizationCode
+		authTime sql.NullTime
+		result   error
+	)
+
+	err := repo.withTransaction(ctx, oauthTables, func(tx *sql.Tx) error {
+		var consumeQuery = `
+			UPDATE oauth_authorization_codes SET consumed_at = NOW()
+			WHERE code_hash = $1 AND consumed_at IS NULL
+			RETURNING code_hash, client_id, user_id, redirect_uri, scope, code_challenge, nonce, auth_time, expires_at
+		`
+
+		err := tx.QueryRowContext(ctx, consumeQuery, codeHash).Scan(&code.CodeHash, &code.ClientID, &code.UserID,
+			&code.RedirectURI, &code.Scope, &code.CodeChallenge, &code.Nonce, &authTime, &code.ExpiresAt)
+		if err == nil {
+			code.AuthTime = authTime.Time
+			if code.ExpiresAt.Before(time.Now()) {
+				result = ErrAuthorizationCodeInvalid
+			}
+			return nil
+		}
+		if err != sql.ErrNoRows {
+			return fmt.Errorf("[FAIL]: could not execute query: %w", err)
+		}
+
+		var exists bool
+		if err := tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM oauth_authorization_codes WHERE code_hash = $1)`, codeHash).Scan(&exists); err != nil {
+			return fmt.Errorf("[FAIL]: could not execute query: %w", err)
+		}
+		if !exists {
+			result = ErrAuthorizationCodeInvalid
+			return nil
+		}
+
+		// The revocation is committed even though the exchange fails
+		var revokeQuery = `
+			UPDATE oauth_refresh_tokens SET revoked_at = NOW()
+			WHERE revoked_at IS NULL AND family_id IN (SELECT family_id FROM oauth_refresh_tokens WHERE code_hash = $1)
+		`
+
+		if _, err := tx.ExecContext(ctx, revokeQuery, codeHash); err != nil {
+			log.Println(err)
+			return fmt.Errorf("[FAIL]: could not revoke refresh tokens")
+		}
+
+		result = ErrAuthorizationCodeUsed
+		return nil
+	})
+	if err != nil {
+		return model.AuthorizationCode{}, err
+	}
+	if result != nil {
+		return model.AuthorizationCode{}, result
+	}
+
+	return code, nil
+}
+
+/*
+Stores a refresh token issued by the token endpoint
+
+Params:
+  - ctx:   Method context
+  - token: The refresh token model
+
+Returns:
+  - An error if the query failed
+*/
+func (repo *PostGreSQL) InsertRefreshToken(ctx context.Context, token model.RefreshToken) error {
+	return repo.withTransaction(ctx, oauthTables, func(tx *sql.Tx) error {
+		return repo.insertRefreshToken(ctx, tx, token)
+	})
+}
+
+/*
+Rotates a refresh token, replacing it with the next token of its family
+
+Objectives:
+  - Lock the presented token and check it is active
+  - Revoke the whole family when a token that was already rotated is presented again
+  - Mark the token as rotated and insert the next token
+
+Params:
+  - ctx:       Method context
+  - tokenHash: Hash of the presented refresh token
+  - next:      Builds the next token from the presented one, returns an error to abort the rotation
+
+Returns:
+  - The presented refresh token model
+  - ErrRefreshTokenReused if it was already rotated, ErrRefreshTokenInvalid if it is unknown, expired or revoked
+*/
+func (repo *PostGreSQL) RotateRefreshToken(ctx context.Context, tokenHash string, next func(current model.RefreshToken) (model.RefreshToken, error)) (model.RefreshToken, error) {
+	var (
+		current model.RefreshToken
+		result  error
+	)
+
+	err := repo.withTransaction(ctx, oauthTables, func(tx *sql.Tx) error {
+		var (
+			authTime  sql.NullTime
+			rotatedAt sql.NullTime
+			revokedAt sql.NullTime
+		)
+
+		var getTokenQuery = `
+			SELECT token_hash, family_id, client_id, user_id, scope, code_hash, auth_time, dpop_jkt, cert_thumbprint, expires_at,
+				rotated_at, revoked_at
+			FROM oauth_refresh_tokens WHERE token_hash = $1
+			FOR UPDATE
+		`
+
+		err := tx.QueryRowContext(ctx, getTokenQuery, tokenHash).Scan(&current.TokenHash, &current.FamilyID, &current.ClientID,
+			&current.UserID, &current.Scope, &current.CodeHash, &authTime, &current.DPoPKeyThumbprint, &current.CertThumbprint,
+			&current.ExpiresAt, &rotatedAt, &revokedAt)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				result = ErrRefreshTokenInvalid
+				return nil
+			}
+			return fmt.Errorf("[FAIL]: could not execute query: %w", err)
+		}
+		current.AuthTime = authTime.Time
+
+		if revokedAt.Valid || current.ExpiresAt.Before(time.Now()) {
+			result = ErrRefreshTokenInvalid
+			return nil
+		}
+
+		// A rotated token presented again means two parties hold the family, the revocation is committed even though the refresh fails
+		if rotatedAt.Valid {
+			if _, err := tx.ExecContext(ctx, `UPDATE oauth_refresh_tokens SET revoked_at = NOW() WHERE family_id = $1 AND revoked_at IS NULL`, current.FamilyID); err != nil {
+				log.Println(err)
+				return fmt.Errorf("[FAIL]: could not revoke refresh token family")
+			}
+			result = ErrRefreshTokenReused
+			return nil
+		}
+
+		replacement, err := next(current)
+		if err != nil {
+			return err
+		}
+
+		if _, err := tx.ExecContext(ctx, `UPDATE oauth_refresh_tokens SET rotated_at = NOW() WHERE token_hash = $1`, tokenHash); err != nil {
+			log.Println(err)
+			return fmt.Errorf("[FAIL]: could not rotate refresh token")
+		}
+
+		replacement.FamilyID = current.FamilyID
+		return repo.insertRefreshToken(ctx, tx, replacement)
+	})
+	if err != nil {
+		return model.RefreshToken{}, err
+	}
+	if result != nil {
+		return model.RefreshToken{}, result
+	}
+
+	return current, nil
+}
+
+/*
+Inserts a refresh token as part of a transaction
+
+Params:
+  - ctx:   Method context
+  - tx:    A pointer to the transaction object
+  - token: The refresh token model
+
+Returns:
+  - An error if the query failed
+*/
+func (repo *PostGreSQL) insertRefreshToken(ctx context.Context, tx *sql.Tx, token model.RefreshToken) error {
+	var insertQuery = `
+		INSERT INTO oauth_refresh_tokens (token_hash, family_id, client_id, user_id, scope, code_hash, auth_time, dpop_jkt, cert_thumbprint, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+	`
+
+	_, err := tx.ExecContext(ctx, insertQuery, token.TokenHash, token.FamilyID, token.ClientID, token.UserID, token.Scope,
+		token.CodeHash, nullTime(token.AuthTime), token.DPoPKeyThumbprint, token.CertThumbprint, token.ExpiresAt)
+	if err != nil {
+		log.Println(err)
+		return fmt.Errorf("[FAIL]: could not execute insert query")
+	}
+
+	return nil
+}
+
+/*
+Wraps a time as a nullable query argument
+
+Params:
+  - t: The time, the zero time is stored as NULL
+
+Returns:
+  - The query argument
+*/
+func nullTime(t time.Time) sql.NullTime {
+	return sql.NullTime{Time: t, Valid: !t.IsZero()}
+}
@@ -0,0 +1,78 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+/*
+Checks whether any organization the user belongs to requires MFA
+
+Params:
+  - ctx:    Method context
+  - userID: The user id
+
+Returns:
+  - True if at least one of the user's organizations requires MFA
+  - An error if the query failed
+*/
+func (repo *PostGreSQL) OrganizationRequiresMFA(ctx context.Context, userID uuid.UUID) (bool, error) {
+	var required bool
+
+	err := repo.withTransaction(ctx, []string{"users", "organizations", "organization_members"}, func(tx *sql.Tx) error {
+		var requiredQuery = `
+			SELECT EXISTS (
+				SELECT 1 FROM organization_members m
+				JOIN organizations o ON o.id = m.organization_id
+				WHERE m.user_id = $1 AND o.require_mfa
+			)
+		`
+
+		if err := tx.QueryRowContext(ctx, requiredQuery, userID).Scan(&required); err != nil {
+			return fmt.Errorf("[FAIL]: could not check organization mfa policy: %w", err)
+		}
+
+		return nil
+	})
+
+	return required, err
+}
+
+/*
+Starts the MFA enrollment grace period of a user, if it hasn't started yet
+
+Params:
+  - ctx:    Method context
+  - userID: The user id
+
+Returns:
+  - When the user's grace period started
+  - An error if the update failed
+*/
+func (repo *PostGreSQL) StartMFAGracePeriod(ctx context.Context, userID uuid.UUID) (time.Time, error) {
+	var startedAt time.Time
+
+	err := repo.withTransaction(ctx, []string{"users"}, func(tx *sql.Tx) error {
+		var startQuery = `
+			UPDATE users SET mfa_grace_started_at = COALESCE(mfa_grace_started_at, NOW())
+			WHERE id = $1
+			RETURNING mfa_grace_started_at
+		`
+
+		err := tx.QueryRowContext(ctx, startQuery, userID).Scan(&startedAt)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				return fmt.Errorf("[FAIL]: user with ID %s not found", userID)
+			}
+			return fmt.Errorf("[FAIL]: could not start mfa grace period: %w", err)
+		}
+
+		return nil
+	})
+
+	return startedAt, err
+}
@@ -0,0 +1,368 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/dev-xero/authentication-backend/model"
+	"github.com/google/uuid"
+)
+
+// Returned when an SMS code was verified too many times
+var ErrSMSCodeExhausted = errors.New("sms code has no attempts left")
+
+// Tables counted by the SMS rate limits, in creation order
+var smsTables = []string{"users", "sms_codes", "sms_notices"}
+
+/*
+Stores an unverified phone number for a user, replacing any unverified one
+
+Params:
+  - ctx:    Method context
+  - userID: The user id
+  - phone:  The E.164 formatted phone number
+
+Returns:
+  - An error if any step fails
+*/
+func (repo *PostGreSQL) UpsertUserPhone(ctx context.Context, userID uuid.UUID, phone string) error {
+	return repo.withTransaction(ctx, []string{"users", "user_phones"}, func(tx *sql.Tx) error {
+		// Verified numbers are never replaced here
+		var upsertQuery = `
+			INSERT INTO user_phones (user_id, phone)
+			VALUES ($1, $2)
+			ON CONFLICT (user_id) DO UPDATE
+			SET phone = EXCLUDED.phone, created_at = NOW()
+			WHERE user_phones.verified_at IS NULL
+		`
+
+		_, err := tx.ExecContext(ctx, upsertQuery, userID, phone)
+		if err != nil {
+			log.Println(err)
+			return fmt.Errorf("[FAIL]: could not store user phone")
+		}
+
+		return nil
+	})
+}
+
+/*
+Returns the phone number enrolled by a user
+
+Params:
+  - ctx:    Method context
+  - userID: The user id
+
+Returns:
+  - A user phone model
+  - An error if the user has no phone number or the query failed
+*/
+func (repo *PostGreSQL) GetUserPhone(ctx context.Context, userID uuid.UUID) (model.UserPhone, error) {
+	var phone model.UserPhone
+
+	err := repo.withTransaction(ctx, []string{"users", "user_phones"}, func(tx *sql.Tx) error {
+		var getUserPhoneQuery = `
+			SELECT user_id, phone, verified_at, created_at FROM user_phones WHERE user_id = $1
+		`
+
+		err := tx.QueryRowContext(ctx, getUserPhoneQuery, userID).Scan(&phone.UserID, &phone.Phone, &phone.VerifiedAt, &phone.CreatedAt)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				return fmt.Errorf("[FAIL]: phone for user %s not found", userID)
+			}
+			return fmt.Errorf("[FAIL]: could not execute query: %w", err)
+		}
+
+		return nil
+	})
+
+	return phone, err
+}
+
+/*
+Marks a user's phone number as verified
+
+Params:
+  - ctx:    Method context
+  - userID: The user id
+  - phone:  The phone number the verification code was sent to
+
+Returns:
+  - An error if the number changed since the code was sent or the query failed
+*/
+func (repo *PostGreSQL) MarkUserPhoneVerified(ctx context.Context, userID uuid.UUID, phone string) error {
+	return repo.withTransaction(ctx, []string{"users", "user_phones"}, func(tx *sql.Tx) error {
+		var verifyQuery = `
+			UPDATE user_phones SET verified_at = NOW() WHERE user_id = $1 AND phone = $2
+		`
+
+		result, err := tx.ExecContext(ctx, verifyQuery, userID, phone)
+		if err != nil {
+			log.Println(err)
+			return fmt.Errorf("[FAIL]: could not verify user phone")
+		}
+
+		if affected, _ := result.RowsAffected(); affected == 0 {
+			return fmt.Errorf("[FAIL]: phone for user %s not found", userID)
+		}
+
+		return nil
+	})
+}
+
+/*
+Checks whether a user has a verified SMS second factor
+
+Params:
+  - ctx:    Method context
+  - userID: The user id
+
+Returns:
+  - True if the user has a verified phone number
+  - An error if the query failed
+*/
+func (repo *PostGreSQL) HasVerifiedPhone(ctx context.Context, userID uuid.UUID) (bool, error) {
+	var exists bool
+
+	err := repo.withTransaction(ctx, []string{"users", "user_phones"}, func(tx *sql.Tx) error {
+		var existsQuery = `
+			SELECT EXISTS (SELECT 1 FROM user_phones WHERE user_id = $1 AND verified_at IS NOT NULL)
+		`
+
+		if err := tx.QueryRowContext(ctx, existsQuery, userID).Scan(&exists); err != nil {
+			return fmt.Errorf("[FAIL]: could not check user phone: %w", err)
+		}
+
+		return nil
+	})
+
+	return exists, err
+}
+
+/*
+Stores a one-time SMS code once the rate limits allow sending it
+
+Objectives:
+  - Check the rate limits under a lock on the phone number and the user
+  - Store the code in the same transaction, before the lock is released
+
+Params:
+  - ctx:       Method context
+  - code:      The SMS code model, with the code already hashed
+  - since:     The start of the rate limit window
+  - checkRate: Checks the messages sent to the phone number and for the user, and when the last one was sent to the number
+
+Returns:
+  - The error of checkRate if a rate limit is reached
+  - An error if any other step fails
+*/
+func (repo *PostGreSQL) InsertSMSCodeWithinLimit(ctx context.Context, code model.SMSCode, since time.Time, checkRate func(perNumber int, perUser int, lastSent time.Time) error) error {
+	return repo.withTransaction(ctx, smsTables, func(tx *sql.Tx) error {
+		if err := checkSMSRate(ctx, tx, code.Phone, code.UserID, since, checkRate); err != nil {
+			return err
+		}
+
+		var insertQuery = `
+			INSERT INTO sms_codes (id, user_id, phone, purpose, code_hash, expires_at)
+			VALUES ($1, $2, $3, $4, $5, $6)
+		`
+
+		_, err := tx.ExecContext(ctx, insertQuery, code.ID, code.UserID, code.Phone, code.Purpose, code.CodeHash, code.ExpiresAt)
+		if err != nil {
+			log.Println(err)
+			return fmt.Errorf("[FAIL]: could not execute insert query")
+		}
+
+		return nil
+	})
+}
+
+/*
+Records an SMS notification once the rate limits allow sending it
+
+Objectives:
+  - Check the rate limits under a lock on the phone number and the user
+  - Record the notification in the same transaction, so it counts towards the limits
+
+Params:
+  - ctx:       Method context
+  - notice:    The SMS notice model
+  - since:     The start of the rate limit window
+  - checkRate: Checks the messages sent to the phone number and for the user, and when the last one was sent to the number
+
+Returns:
+  - The error of checkRate if a rate limit is reached
+  - An error if any other step fails
+*/
+func (repo *PostGreSQL) InsertSMSNoticeWithinLimit(ctx context.Context, notice model.SMSNotice, since time.Time, checkRate func(perNumber int, perUser int, lastSent time.Time) error) error {
+	return repo.withTransaction(ctx, smsTables, func(tx *sql.Tx) error {
+		if err := checkSMSRate(ctx, tx, notice.Phone, notice.UserID, since, checkRate); err != nil {
+			return err
+		}
+
+		_, err := tx.ExecContext(ctx, `INSERT INTO sms_notices (id, user_id, phone) VALUES ($1, $2, $3)`, notice.ID, notice.UserID, notice.Phone)
+		if err != nil {
+			log.Println(err)
+			return fmt.Errorf("[FAIL]: could not execute insert query")
+		}
+
+		return nil
+	})
+}
+
+/*
+Checks the SMS rate limits as part of a transaction
+
+Objectives:
+  - Take transaction level advisory locks on the phone number and the user, so concurrent sends are counted one after the other
+  - Always lock the number before the user, so two sends can't wait on each other
+  - Count the codes and notices sent recently and check them with checkRate
+
+Params:
+  - ctx:       Method context
+  - tx:        A pointer to the transaction object
+  - phone:     The destination phone number
+  - userID:    The user the message is sent for
+  - since:     The start of the rate limit window
+  - checkRate: Checks the messages sent to the phone number and for the user, and when the last one was sent to the number
+
+Returns:
+  - The error of checkRate if a rate limit is reached
+  - An error if a lock couldn't be taken or the count failed
+*/
+func checkSMSRate(ctx context.Context, tx *sql.Tx, phone string, userID uuid.UUID, since time.Time, checkRate func(perNumber int, perUser int, lastSent time.Time) error) error {
+	for _, key := range []string{"sms:phone:" + phone, "sms:user:" + userID.String()} {
+		if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock(hashtext($1))`, key); err != nil {
+			return fmt.Errorf("[FAIL]: could not lock sms destination: %w", err)
+		}
+	}
+
+	var (
+		perNumber int
+		perUser   int
+		lastSent  sql.NullTime
+	)
+
+	var countQuery = `
+		SELECT
+			COUNT(*) FILTER (WHERE phone = $1),
+			COUNT(*) FILTER (WHERE user_id = $2),
+			MAX(created_at) FILTER (WHERE phone = $1)
+		FROM (
+			SELECT user_id, phone, created_at FROM sms_codes
+			WHERE (phone = $1 OR user_id = $2) AND created_at >= $3
+			UNION ALL
+			SELECT user_id, phone, created_at FROM sms_notices
+			WHERE (phone = $1 OR user_id = $2) AND created_at >= $3
+		) AS sent
+	`
+
+	if err := tx.QueryRowContext(ctx, countQuery, phone, userID, since).Scan(&perNumber, &perUser, &lastSent); err != nil {
+		return fmt.Errorf("[FAIL]: could not count sent sms: %w", err)
+	}
+
+	return checkRate(perNumber, perUser, lastSent.Time)
+}
+
+/*
+Returns the latest unconsumed and unexpired SMS code sent for a user and purpose
+
+Params:
+  - ctx:     Method context
+  - userID:  The user id
+  - purpose: What the code was sent for
+
+Returns:
+  - The SMS code model
+  - An error if no code is pending or the query failed
+*/
+func (repo *PostGreSQL) GetPendingSMSCode(ctx context.Context, userID uuid.UUID, purpose string) (model.SMSCode, error) {
+	var code model.SMSCode
+
+	err := repo.withTransaction(ctx, []string{"users", "sms_codes"}, func(tx *sql.Tx) error {
+		var pendingQuery = `
+			SELECT id, user_id, phone, purpose, code_hash, attempts, expires_at
+			FROM sms_codes
+			WHERE user_id = $1 AND purpose = $2 AND consumed_at IS NULL AND expires_at > NOW()
+			ORDER BY created_at DESC
+			LIMIT 1
+		`
+
+		err := tx.QueryRowContext(ctx, pendingQuery, userID, purpose).Scan(
+			&code.ID, &code.UserID, &code.Phone, &code.Purpose, &code.CodeHash, &code.Attempts, &code.ExpiresAt,
+		)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				return fmt.Errorf("[FAIL]: pending sms code for user %s not found", userID)
+			}
+			return fmt.Errorf("[FAIL]: could not execute query: %w", err)
+		}
+
+		return nil
+	})
+
+	return code, err
+}
+
+/*
+Records a verification attempt for an SMS code, before the code is compared
+
+Objectives:
+  - Count the attempt and check the limit in a single statement, so parallel guesses can't exceed it
+
+Params:
+  - ctx:         Method context
+  - id:          The SMS code id
+  - maxAttempts: How many attempts a code allows
+
+Returns:
+  - ErrSMSCodeExhausted if the code has no attempts left
+  - An error if the update failed
+*/
+func (repo *PostGreSQL) ClaimSMSCodeAttempt(ctx context.Context, id uuid.UUID, maxAttempts int) error {
+	return repo.withTransaction(ctx, []string{"users", "sms_codes"}, func(tx *sql.Tx) error {
+		var attempts int
+		err := tx.QueryRowContext(ctx, `
+			UPDATE sms_codes SET attempts = attempts + 1
+			WHERE id = $1 AND attempts < $2
+			RETURNING attempts
+		`, id, maxAttempts).Scan(&attempts)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				return ErrSMSCodeExhausted
+			}
+			return fmt.Errorf("[FAIL]: could not update sms code: %w", err)
+		}
+
+		return nil
+	})
+}
+
+/*
+Marks an SMS code as used so it can't be verified again
+
+Params:
+  - ctx: Method context
+  - id:  The SMS code id
+
+Returns:
+  - An error if the code was already consumed or the update failed
+*/
+func (repo *PostGreSQL) ConsumeSMSCode(ctx context.Context, id uuid.UUID) error {
+	return repo.withTransaction(ctx, []string{"users", "sms_codes"}, func(tx *sql.Tx) error {
+		result, err := tx.ExecContext(ctx, `UPDATE sms_codes SET consumed_at = NOW() WHERE id = $1 AND consumed_at IS NULL`, id)
+		if err != nil {
+			return fmt.Errorf("[FAIL]: could not update sms code: %w", err)
+		}
+
+		if affected, _ := result.RowsAffected(); affected == 0 {
+			return fmt.Errorf("[FAIL]: sms code already used")
+		}
+
+		return nil
+	})
+}
@@ -3,63 +3,72 @@ package repository
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"log"
-	"strings"
+	"sync"
 
 	"github.com/dev-xero/authentication-backend/model"
 	"github.com/dev-xero/authentication-backend/util"
-	_ "github.com/lib/pq"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
 )
 
 type PostGreSQL struct {
 	Database *sql.DB
 }
 
+// Tables created by this process, shared by every repository since they all use the application database
+var createdTables sync.Map
+
 /*
 Handles inserting users into the database
 
 Objectives:
-  - Create the users table if absent
-  - Hash the user password before storing
-  - Construct a query to store the user in the database
-  - Commit the transaction
+  - Create the users and user emails tables if absent
+  - Store the user and their primary address in one transaction
 
 Params:
   - ctx:  Method context
   - user: User model to construct the query from
 
 Returns:
-  - An error if any stage fails
+  - ErrEmailTaken if another account already uses the email
+  - An error if any other stage fails
 */
 func (repo *PostGreSQL) InsertUser(ctx context.Context, user model.User) error {
-	// Begin a new database transaction
-	tx, err := repo.Database.BeginTx(ctx, nil)
-	if err != nil {
-		_, err = util.Fail(err, "[FAIL]: could not begin database transaction")
-		return err
-	}
+	return repo.withTransaction(ctx, []string{"users", "user_emails"}, func(tx *sql.Tx) error {
+		return repo.insertUser(ctx, tx, user)
+	})
+}
 
-	// Rollback transaction incase of failure (deferred)
-	defer func() {
-		if rErr := tx.Rollback(); rErr != nil && err == nil {
-			err = fmt.Errorf("[FAIL]: rollback failed: %w", rErr)
-		}
-	}()
+/*
+Inserts a user and their primary address as part of a transaction
 
-	// Create the table if it doesn't yet exist
-	if err := repo.createTableIfNonExistent(ctx, tx, "users"); err != nil {
-		return err
-	}
+Objectives:
+  - Hash the user password before storing, users without a password keep an empty one no password matches
+  - Construct a query to store the user in the database
+  - Store the user email as the primary address
 
+Params:
+  - ctx:  Method context
+  - tx:   A pointer to the transaction object
+  - user: User model to construct the query from
+
+Returns:
+  - ErrEmailTaken if another account already uses the email
+  - An error if any other stage fails
+*/
+func (repo *PostGreSQL) insertUser(ctx context.Context, tx *sql.Tx, user model.User) error {
 	// Hash the user password
-	user.Password, err = util.GenerateHash(user.Password, util.DefaultHashCost)
-	if err != nil {
-		return err
+	if user.Password != "" {
+		hash, err := util.GenerateHash(user.Password, util.DefaultHashCost)
+		if err != nil {
+			return err
+		}
+		user.Password = hash
 	}
 
-	log.Println("Hashed user password:", user.Password)
-
 	// Construct a query to insert the user from the model data
 	var insertQuery = `
 		INSERT INTO users (id, username, email, password)
@@ -67,16 +76,30 @@ func (repo *PostGreSQL) InsertUser(ctx context.Context, user model.User) error {
 	`
 
 	// Execute the insertion query
-	_, err = tx.ExecContext(ctx, insertQuery, user.ID, user.Username, user.Email, user.Password)
+	_, err := tx.ExecContext(ctx, insertQuery, user.ID, user.Username, user.Email, user.Password)
 	if err != nil {
 		log.Println(err)
 		return fmt.Errorf("[FAIL]: could not execute insert query")
 	}
 
-	// Commit the transaction
-	err = tx.Commit()
+	// An address another account let expire unverified is free to sign-up with
+	if err := repo.releaseExpiredEmail(ctx, tx, user.Email); err != nil {
+		return err
+	}
+
+	// The sign-up address is trusted for sign-in, as it always has been
+	var insertEmailQuery = `
+		INSERT INTO user_emails (id, user_id, email, is_primary, verified_at)
+		VALUES ($1, $2, $3, TRUE, NOW())
+	`
+
+	_, err = tx.ExecContext(ctx, insertEmailQuery, uuid.New(), user.ID, user.Email)
 	if err != nil {
-		return fmt.Errorf("[FAIL]: could not commit transaction")
+		log.Println(err)
+		if isUniqueViolation(err) {
+			return ErrEmailTaken
+		}
+		return fmt.Errorf("[FAIL]: could not execute insert query")
 	}
 
 	return nil
@@ -86,39 +109,45 @@ func (repo *PostGreSQL) InsertUser(ctx context.Context, user model.User) error {
 Checks whether the user with the provided email exists
 
 Objectives:
-  - Check if the user exists in the table
+  - Check if the username is taken
+  - Check if the email is taken by any verified or pending address of any user
 
 Params:
-  - ctx:   Method context
-  - email: Provided user email
+  - ctx:      Method context
+  - email:    Provided user email
+  - username: Provided username, ignored if empty
 
 Returns:
   - A boolean indicating whether the user exists
   - An error, in case the query failed
 */
 func (repo *PostGreSQL) UserExists(ctx context.Context, email string, username string) (bool, error) {
-	// Construct a query to check if a column with the email exists
-	var checkUserExistsQuery = `
-		SELECT EXISTS (SELECT 1 FROM users WHERE email = $1 OR username = $2)
-	`
-
 	// Exists is set to false by default
 	var exists = false
 
-	// Check if the user is already stored in the database
-	err := repo.Database.QueryRowContext(ctx, checkUserExistsQuery, email, username).Scan(&exists)
-	if err != nil {
-		log.Println(err)
-
-		// Return false if the table doesn't exist
-		if strings.Contains(err.Error(), "does not exist") {
-			return false, nil
+	err := repo.withTransaction(ctx, []string{"users", "user_emails"}, func(tx *sql.Tx) error {
+		// Construct a query to check if the username or any address with the email exists
+		var checkUserExistsQuery = `
+			SELECT
+				EXISTS (SELECT 1 FROM users WHERE username = $2 AND $2 <> '')
+				OR EXISTS (
+					SELECT 1 FROM user_emails
+					WHERE LOWER(email) = LOWER($1)
+					AND (verified_at IS NOT NULL OR verification_expires_at > NOW())
+				)
+		`
+
+		// Check if the user is already stored in the database
+		err := tx.QueryRowContext(ctx, checkUserExistsQuery, email, username).Scan(&exists)
+		if err != nil {
+			log.Println(err)
+			return fmt.Errorf("[FAIL]: could not check if user already exists")
 		}
 
-		return false, fmt.Errorf("[FAIL]: could not check if user already exists")
-	}
+		return nil
+	})
 
-	return exists, nil
+	return exists, err
 }
 
 /*
@@ -138,80 +167,79 @@ Returns:
   - An error if any
 */
 func (repo *PostGreSQL) GetUserByID(ctx context.Context, id string) (model.User, error) {
-	// Begin the database transaction
-	tx, err := repo.Database.BeginTx(ctx, nil)
-	if err != nil {
-		_, err = util.Fail(err, "[FAIL]: could not begin database transaction")
-		return model.User{}, err
-	}
-
-	// Create the users table if it doesn't already exist
-	repo.createTableIfNonExistent(ctx, tx, "users")
-
-	// Construct a query to return the user details from the provided id
-	var getUserByIDQuery = `
-		SELECT id, username, email, password FROM users WHERE id = $1
-	`
-
 	// Allocate memory for the user model data
 	var user model.User
 
-	// Execute the query, returns the row with the details
-	err = tx.QueryRowContext(ctx, getUserByIDQuery, id).Scan(&user.ID, &user.Username, &user.Email, &user.Password)
-	if err != nil {
-		log.Println(err)
-		if err == sql.ErrNoRows {
-			return model.User{}, fmt.Errorf("[FAIL]: user with ID %s not found", id)
+	// Run the query in a transaction, creating the users table if it doesn't already exist
+	err := repo.withTransaction(ctx, []string{"users"}, func(tx *sql.Tx) error {
+		// Construct a query to return the user details from the provided id
+		var getUserByIDQuery = `
+			SELECT id, username, email, password, role FROM users WHERE id = $1
+		`
+
+		// Execute the query, returns the row with the details
+		err := tx.QueryRowContext(ctx, getUserByIDQuery, id).Scan(&user.ID, &user.Username, &user.Email, &user.Password, &user.Role)
+		if err != nil {
+			log.Println(err)
+			if err == sql.ErrNoRows {
+				return fmt.Errorf("[FAIL]: user with ID %s not found", id)
+			}
+			return fmt.Errorf("[FAIL]: could not execute query: %w", err)
 		}
-		return model.User{}, fmt.Errorf("[FAIL]: could not execute query: %w", err)
+
+		return nil
+	})
+	if err != nil {
+		return model.User{}, err
 	}
 
 	return user, nil
 }
 
 /*
-Returns a user with the corresponding email
+Returns the user owning a verified address with the corresponding email
 
 Objectives:
-  - Create the user table if it doesn't already exist
-  - Construct a query to return the user details from the email
+  - Create the user tables if they don't already exist
+  - Construct a query to return the user details from any of their verified emails
   - Execute the query and return the user data model
 
 Params:
-  - ctx: Method context
-  - id:  The user id
+  - ctx:   Method context
+  - email: Any verified email of the user
 
 Returns:
   - A user data model
   - An error if any
 */
 func (repo *PostGreSQL) GetUserByEmail(ctx context.Context, email string) (model.User, error) {
-	// Begin the transaction
-	tx, err := repo.Database.BeginTx(ctx, nil)
-	if err != nil {
-		_, err = util.Fail(err, "[FAIL]: could not begin database transaction")
-		return model.User{}, err
-	}
-
-	// Create the users table if it doesn't already exist
-	repo.createTableIfNonExistent(ctx, tx, "users")
-
-	// construct a query to return the data model using the email provided
-	var getUserByIDQuery = `
-		SELECT id, username, email, password FROM users WHERE email = $1
-	`
-
 	// Allocate memory for the user data
 	var user model.User
 
-	// Execute the query
-	err = tx.QueryRowContext(ctx, getUserByIDQuery, email).Scan(&user.ID, &user.Username, &user.Email, &user.Password)
-	if err != nil {
-		log.Println(err)
-		if err == sql.ErrNoRows {
-			return model.User{}, fmt.Errorf("[FAIL]: user with email %s not found", email)
+	// Run the query in a transaction, creating the users table if it doesn't already exist
+	err := repo.withTransaction(ctx, []string{"users", "user_emails"}, func(tx *sql.Tx) error {
+		// construct a query to return the data model using the email provided
+		var getUserByEmailQuery = `
+			SELECT u.id, u.username, u.email, u.password, u.role
+			FROM user_emails e
+			JOIN users u ON u.id = e.user_id
+			WHERE LOWER(e.email) = LOWER($1) AND e.verified_at IS NOT NULL
+		`
+
+		// Execute the query
+		err := tx.QueryRowContext(ctx, getUserByEmailQuery, email).Scan(&user.ID, &user.Username, &user.Email, &user.Password, &user.Role)
+		if err != nil {
+			log.Println(err)
+			if err == sql.ErrNoRows {
+				return fmt.Errorf("[FAIL]: user with email %s not found", email)
+			}
+			return fmt.Errorf("[FAIL]: could not execute query: %w", err)
 		}
-		return model.User{}, fmt.Errorf("[FAIL]: could not execute query: %w", err)
+
+		return nil
+	})
+	if err != nil {
+		return model.User{}, err
 	}
 
 	return user, nil
@@ -232,21 +260,87 @@ Returns:
   - An error if any step fails
 */
 func (repo *PostGreSQL) createTableIfNonExistent(ctx context.Context, tx *sql.Tx, table string) error {
-	// Construct a query to create the table if it doesn't yet exist
-	var createTableQuery = fmt.Sprintf(`
-		CREATE TABLE IF NOT EXISTS %s (
-			id UUID PRIMARY KEY,
-			username VARCHAR(255) NOT NULL UNIQUE,
-			email VARCHAR(255) NOT NULL UNIQUE,
-			password VARCHAR(255) NOT NULL
-		);
-	`, table)
+	// Skip tables already created by this process, schema changes take exclusive locks
+	if _, ok := createdTables.Load(table); ok {
+		return nil
+	}
+
+	// Look up the query to create the table if it doesn't yet exist
+	createTableQuery, ok := tableSchemas[table]
+	if !ok {
+		return fmt.Errorf("[FAIL]: no schema defined for table %s", table)
+	}
 
 	// Execute the query with the context
 	_, err := tx.ExecContext(ctx, createTableQuery)
 	if err != nil {
-		return fmt.Errorf("[FAIL]: could not create users table: %w", err)
+		return fmt.Errorf("[FAIL]: could not create %s table: %w", table, err)
+	}
+
+	return nil
+}
+
+/*
+Runs a function inside a database transaction
+
+Objectives:
+  - Begin a new database transaction
+  - Create the tables the function uses if they don't exist
+  - Run the function and commit the transaction if it succeeds
+
+Params:
+  - ctx:    Method context
+  - tables: The tables used by the function, in creation order
+  - fn:     The function to run with the transaction
+
+Returns:
+  - An error if any step fails
+*/
+func (repo *PostGreSQL) withTransaction(ctx context.Context, tables []string, fn func(tx *sql.Tx) error) error {
+	// Begin a new database transaction
+	tx, err := repo.Database.BeginTx(ctx, nil)
+	if err != nil {
+		_, err = util.Fail(err, "[FAIL]: could not begin database transaction")
+		return err
+	}
+
+	// Rollback transaction incase of failure (deferred), a no-op after commit
+	defer tx.Rollback()
+
+	// Create the tables if they don't yet exist
+	for _, table := range tables {
+		if err := repo.createTableIfNonExistent(ctx, tx, table); err != nil {
+			return err
+		}
+	}
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+
+	// Commit the transaction
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("[FAIL]: could not commit transaction")
+	}
+
+	// The tables are known to exist once the transaction is committed
+	for _, table := range tables {
+		createdTables.Store(table, true)
 	}
 
 	return nil
 }
+
+/*
+Checks whether a query failed because of a unique constraint
+
+Params:
+  - err: The query error
+
+Returns:
+  - True if the error is a PostGreSQL unique violation
+*/
+func isUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505"
+}
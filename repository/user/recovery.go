@@ -0,0 +1,278 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/dev-xero/authentication-backend/model"
+	"github.com/google/uuid"
+)
+
+// Tables touched by account recoveries, in creation order
+var recoveryTables = []string{"users", "user_phones", "sms_codes", "audit_events", "account_recoveries"}
+
+/*
+Creates an account recovery request
+
+Objectives:
+  - Cancel recovery requests of the user still waiting for email verification
+  - Insert the new recovery request
+  - Record the audit event in the same transaction
+
+Params:
+  - ctx:      Method context
+  - recovery: The account recovery model
+  - event:    The audit event recording the request
+
+Returns:
+  - An error if any step fails
+*/
+func (repo *PostGreSQL) CreateRecovery(ctx context.Context, recovery model.AccountRecovery, event model.AuditEvent) error {
+	return repo.withTransaction(ctx, recoveryTables, func(tx *sql.Tx) error {
+		var cancelQuery = `
+			UPDATE account_recoveries SET status = $2, cancelled_at = NOW()
+			WHERE user_id = $1 AND status = $3
+		`
+
+		_, err := tx.ExecContext(ctx, cancelQuery, recovery.UserID, model.RecoveryCancelled, model.RecoveryPendingVerification)
+		if err != nil {
+			log.Println(err)
+			return fmt.Errorf("[FAIL]: could not cancel previous recovery requests")
+		}
+
+		var insertQuery = `
+			INSERT INTO account_recoveries (id, user_id, token_hash, status, expires_at)
+			VALUES ($1, $2, $3, $4, $5)
+		`
+
+		_, err = tx.ExecContext(ctx, insertQuery, recovery.ID, recovery.UserID, recovery.TokenHash, model.RecoveryPendingVerification, recovery.ExpiresAt)
+		if err != nil {
+			log.Println(err)
+			return fmt.Errorf("[FAIL]: could not execute insert query")
+		}
+
+		return repo.insertAuditEvent(ctx, tx, event)
+	})
+}
+
+/*
+Returns the recovery request of a user that is waiting for verification or for its waiting period
+
+Params:
+  - ctx:    Method context
+  - userID: The user id
+
+Returns:
+  - The account recovery model
+  - An error if the user has no active recovery request
+*/
+func (repo *PostGreSQL) GetActiveRecovery(ctx context.Context, userID uuid.UUID) (model.AccountRecovery, error) {
+	var activeQuery = `
+		SELECT id, user_id, token_hash, status, expires_at, eligible_at, created_at
+		FROM account_recoveries
+		WHERE user_id = $1 AND status IN ($2, $3)
+		ORDER BY created_at DESC
+		LIMIT 1
+	`
+
+	return repo.getRecovery(ctx, activeQuery, userID, model.RecoveryPendingVerification, model.RecoveryWaiting)
+}
+
+/*
+Returns the recovery request matching an emailed token
+
+Params:
+  - ctx:       Method context
+  - tokenHash: The hash of the emailed token
+
+Returns:
+  - The account recovery model
+  - An error if no request matches the token
+*/
+func (repo *PostGreSQL) GetRecoveryByTokenHash(ctx context.Context, tokenHash string) (model.AccountRecovery, error) {
+	var tokenQuery = `
+		SELECT id, user_id, token_hash, status, expires_at, eligible_at, created_at
+		FROM account_recoveries
+		WHERE token_hash = $1
+	`
+
+	return repo.getRecovery(ctx, tokenQuery, tokenHash)
+}
+
+/*
+Starts the waiting period of a recovery request once the email is verified
+
+Params:
+  - ctx:        Method context
+  - id:         The recovery request id
+  - eligibleAt: When the waiting period ends
+  - event:      The audit event recording the verification
+
+Returns:
+  - An error if the request is no longer pending verification or the update failed
+*/
+func (repo *PostGreSQL) StartRecoveryWaitingPeriod(ctx context.Context, id uuid.UUID, eligibleAt time.Time, event model.AuditEvent) error {
+	var startQuery = `
+		UPDATE account_recoveries SET status = $2, verified_at = NOW(), eligible_at = $3
+		WHERE id = $1 AND status = $4 AND expires_at > NOW()
+	`
+
+	return repo.transitionRecovery(ctx, event, startQuery, id, model.RecoveryWaiting, eligibleAt, model.RecoveryPendingVerification)
+}
+
+/*
+Cancels a recovery request
+
+Params:
+  - ctx:   Method context
+  - id:    The recovery request id
+  - event: The audit event recording the cancellation
+
+Returns:
+  - An error if the request is no longer active or the update failed
+*/
+func (repo *PostGreSQL) CancelRecovery(ctx context.Context, id uuid.UUID, event model.AuditEvent) error {
+	var cancelQuery = `
+		UPDATE account_recoveries SET status = $2, cancelled_at = NOW()
+		WHERE id = $1 AND status IN ($3, $4)
+	`
+
+	return repo.transitionRecovery(ctx, event, cancelQuery, id, model.RecoveryCancelled, model.RecoveryPendingVerification, model.RecoveryWaiting)
+}
+
+/*
+Completes a recovery request whose waiting period is over
+
+Objectives:
+  - Mark the request as completed
+  - Remove every second factor of the user and restart their enrollment grace period
+  - Record the audit event in the same transaction
+
+Params:
+  - ctx:   Method context
+  - id:    The recovery request id
+  - event: The audit event recording the completion
+
+Returns:
+  - An error if the request is not eligible for completion or any step failed
+*/
+func (repo *PostGreSQL) CompleteRecovery(ctx context.Context, id uuid.UUID, event model.AuditEvent) error {
+	return repo.withTransaction(ctx, recoveryTables, func(tx *sql.Tx) error {
+		var completeQuery = `
+			UPDATE account_recoveries SET status = $2, completed_at = NOW()
+			WHERE id = $1 AND status = $3 AND eligible_at <= NOW()
+			RETURNING user_id
+		`
+
+		var userID uuid.UUID
+		err := tx.QueryRowContext(ctx, completeQuery, id, model.RecoveryCompleted, model.RecoveryWaiting).Scan(&userID)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				return fmt.Errorf("[FAIL]: recovery %s is not eligible for completion", id)
+			}
+			return fmt.Errorf("[FAIL]: could not complete recovery: %w", err)
+		}
+
+		if err := repo.deleteMFAFactors(ctx, tx, userID); err != nil {
+			return err
+		}
+
+		return repo.insertAuditEvent(ctx, tx, event)
+	})
+}
+
+/*
+Removes every second factor of a user as part of a transaction
+
+Objectives:
+  - Delete the enrolled phone and restart the enrollment grace period
+  - Consume the pending SMS codes, keeping the send history the rate limits count
+
+Params:
+  - ctx:    Method context
+  - tx:     A pointer to the transaction object
+  - userID: The user id
+
+Returns:
+  - An error if any update failed
+*/
+func (repo *PostGreSQL) deleteMFAFactors(ctx context.Context, tx *sql.Tx, userID uuid.UUID) error {
+	var queries = []string{
+		`UPDATE sms_codes SET consumed_at = NOW() WHERE user_id = $1 AND consumed_at IS NULL`,
+		`DELETE FROM user_phones WHERE user_id = $1`,
+		`UPDATE users SET mfa_grace_started_at = NULL WHERE id = $1`,
+	}
+
+	for _, query := range queries {
+		if _, err := tx.ExecContext(ctx, query, userID); err != nil {
+			log.Println(err)
+			return fmt.Errorf("[FAIL]: could not remove second factors")
+		}
+	}
+
+	return nil
+}
+
+/*
+Runs a recovery status update and records its audit event in one transaction
+
+Params:
+  - ctx:   Method context
+  - event: The audit event recording the update
+  - query: The update query, its first argument must be the recovery id
+  - args:  The query arguments
+
+Returns:
+  - An error if no request was updated or the update failed
+*/
+func (repo *PostGreSQL) transitionRecovery(ctx context.Context, event model.AuditEvent, query string, args ...interface{}) error {
+	return repo.withTransaction(ctx, recoveryTables, func(tx *sql.Tx) error {
+		result, err := tx.ExecContext(ctx, query, args...)
+		if err != nil {
+			log.Println(err)
+			return fmt.Errorf("[FAIL]: could not update recovery")
+		}
+
+		if affected, _ := result.RowsAffected(); affected == 0 {
+			return fmt.Errorf("[FAIL]: recovery %v can't be updated in its current state", args[0])
+		}
+
+		return repo.insertAuditEvent(ctx, tx, event)
+	})
+}
+
+/*
+Returns a single recovery request
+
+Params:
+  - ctx:   Method context
+  - query: The select query
+  - args:  The query arguments
+
+Returns:
+  - The account recovery model
+  - An error if no request was found or the query failed
+*/
+func (repo *PostGreSQL) getRecovery(ctx context.Context, query string, args ...interface{}) (model.AccountRecovery, error) {
+	var recovery model.AccountRecovery
+
+	err := repo.withTransaction(ctx, recoveryTables, func(tx *sql.Tx) error {
+		err := tx.QueryRowContext(ctx, query, args...).Scan(
+			&recovery.ID, &recovery.UserID, &recovery.TokenHash, &recovery.Status,
+			&recovery.ExpiresAt, &recovery.EligibleAt, &recovery.CreatedAt,
+		)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				return fmt.Errorf("[FAIL]: recovery not found")
+			}
+			return fmt.Errorf("[FAIL]: could not execute query: %w", err)
+		}
+
+		return nil
+	})
+
+	return recovery, err
+}
@@ -0,0 +1,50 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+// Returned when a SAML assertion was already used to sign-in
+var ErrSAMLAssertionUsed = errors.New("saml assertion was already used")
+
+/*
+Marks a SAML assertion as used, so a captured response can't be replayed
+
+Objectives:
+  - Remove records of assertions that expired, they are rejected by their conditions anyway
+  - Record the assertion id for its connection, failing if another response already recorded it
+
+Params:
+  - ctx:        Method context
+  - connection: The connection the assertion was issued for
+  - id:         The assertion id
+  - expiresAt:  When the assertion expires
+
+Returns:
+  - ErrSAMLAssertionUsed if the assertion was already used
+  - An error if any other step fails
+*/
+func (repo *PostGreSQL) ConsumeSAMLAssertion(ctx context.Context, connection string, id string, expiresAt time.Time) error {
+	return repo.withTransaction(ctx, []string{"saml_assertions"}, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, `DELETE FROM saml_assertions WHERE expires_at < NOW()`); err != nil {
+			log.Println(err)
+			return fmt.Errorf("[FAIL]: could not remove expired saml assertions")
+		}
+
+		insertQuery := `INSERT INTO saml_assertions (connection, id, expires_at) VALUES ($1, $2, $3)`
+		if _, err := tx.ExecContext(ctx, insertQuery, connection, id, expiresAt); err != nil {
+			log.Println(err)
+			if isUniqueViolation(err) {
+				return ErrSAMLAssertionUsed
+			}
+			return fmt.Errorf("[FAIL]: could not execute insert query")
+		}
+
+		return nil
+	})
+}
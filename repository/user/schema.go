@@ -0,0 +1,263 @@
+package repository
+
+// Queries creating each table if it doesn't exist, tables referencing users must be created after it
+var tableSchemas = map[string]string{
+	"users": `
+		CREATE TABLE IF NOT EXISTS users (
+			id UUID PRIMARY KEY,
+			username VARCHAR(255) NOT NULL UNIQUE,
+			email VARCHAR(255) NOT NULL UNIQUE,
+			password VARCHAR(255) NOT NULL
+		);
+		ALTER TABLE users ADD COLUMN IF NOT EXISTS role VARCHAR(32) NOT NULL DEFAULT 'user';
+		ALTER TABLE users ADD COLUMN IF NOT EXISTS mfa_grace_started_at TIMESTAMPTZ;
+	`,
+	"user_emails": `
+		CREATE TABLE IF NOT EXISTS user_emails (
+			id UUID PRIMARY KEY,
+			user_id UUID NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+			email VARCHAR(255) NOT NULL,
+			is_primary BOOLEAN NOT NULL DEFAULT FALSE,
+			verified_at TIMESTAMPTZ,
+			verification_token_hash VARCHAR(64),
+			verification_expires_at TIMESTAMPTZ,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+		);
+		CREATE UNIQUE INDEX IF NOT EXISTS user_emails_email_idx ON user_emails (LOWER(email));
+		CREATE UNIQUE INDEX IF NOT EXISTS user_emails_primary_idx ON user_emails (user_id) WHERE is_primary;
+		INSERT INTO user_emails (id, user_id, email, is_primary, verified_at)
+		SELECT gen_random_uuid(), u.id, u.email, TRUE, NOW() FROM users u
+		WHERE NOT EXISTS (SELECT 1 FROM user_emails e WHERE e.user_id = u.id)
+		ON CONFLICT DO NOTHING;
+	`,
+	"user_identities": `
+		CREATE TABLE IF NOT EXISTS user_identities (
+			id UUID PRIMARY KEY,
+			user_id UUID NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+			provider VARCHAR(32) NOT NULL,
+			subject VARCHAR(255) NOT NULL,
+			email VARCHAR(255) NOT NULL DEFAULT '',
+			created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+			last_sign_in_at TIMESTAMPTZ,
+			UNIQUE (provider, subject)
+		);
+		CREATE INDEX IF NOT EXISTS user_identities_user_idx ON user_identities (user_id);
+	`,
+	"organizations": `
+		CREATE TABLE IF NOT EXISTS organizations (
+			id UUID PRIMARY KEY,
+			name VARCHAR(255) NOT NULL,
+			require_mfa BOOLEAN NOT NULL DEFAULT FALSE,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+		);
+	`,
+	"organization_members": `
+		CREATE TABLE IF NOT EXISTS organization_members (
+			organization_id UUID NOT NULL REFERENCES organizations (id) ON DELETE CASCADE,
+			user_id UUID NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+			PRIMARY KEY (organization_id, user_id)
+		);
+	`,
+	"user_phones": `
+		CREATE TABLE IF NOT EXISTS user_phones (
+			user_id UUID PRIMARY KEY REFERENCES users (id) ON DELETE CASCADE,
+			phone VARCHAR(16) NOT NULL,
+			verified_at TIMESTAMPTZ,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+		);
+	`,
+	"sms_codes": `
+		CREATE TABLE IF NOT EXISTS sms_codes (
+			id UUID PRIMARY KEY,
+			user_id UUID NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+			phone VARCHAR(16) NOT NULL,
+			purpose VARCHAR(32) NOT NULL,
+			code_hash VARCHAR(255) NOT NULL,
+			attempts INTEGER NOT NULL DEFAULT 0,
+			expires_at TIMESTAMPTZ NOT NULL,
+			consumed_at TIMESTAMPTZ,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+		);
+		CREATE INDEX IF NOT EXISTS sms_codes_phone_created_at_idx ON sms_codes (phone, created_at);
+		CREATE INDEX IF NOT EXISTS sms_codes_user_id_created_at_idx ON sms_codes (user_id, created_at);
+	`,
+	"sms_notices": `
+		CREATE TABLE IF NOT EXISTS sms_notices (
+			id UUID PRIMARY KEY,
+			user_id UUID NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+			phone VARCHAR(16) NOT NULL,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+		);
+		CREATE INDEX IF NOT EXISTS sms_notices_phone_created_at_idx ON sms_notices (phone, created_at);
+		CREATE INDEX IF NOT EXISTS sms_notices_user_id_created_at_idx ON sms_notices (user_id, created_at);
+	`,
+	"audit_events": `
+		CREATE TABLE IF NOT EXISTS audit_events (
+			id UUID PRIMARY KEY,
+			user_id UUID NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+			event VARCHAR(64) NOT NULL,
+			detail TEXT NOT NULL DEFAULT '',
+			ip_address VARCHAR(64) NOT NULL DEFAULT '',
+			user_agent TEXT NOT NULL DEFAULT '',
+			created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+		);
+		CREATE INDEX IF NOT EXISTS audit_events_user_id_created_at_idx ON audit_events (user_id, created_at);
+	`,
+	"account_recoveries": `
+		CREATE TABLE IF NOT EXISTS account_recoveries (
+			id UUID PRIMARY KEY,
+			user_id UUID NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+			token_hash VARCHAR(64) NOT NULL UNIQUE,
+			status VARCHAR(32) NOT NULL,
+			expires_at TIMESTAMPTZ NOT NULL,
+			eligible_at TIMESTAMPTZ,
+			verified_at TIMESTAMPTZ,
+			cancelled_at TIMESTAMPTZ,
+			completed_at TIMESTAMPTZ,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+		);
+		CREATE INDEX IF NOT EXISTS account_recoveries_user_id_idx ON account_recoveries (user_id);
+	`,
+	"oauth_states": `
+		CREATE TABLE IF NOT EXISTS oauth_states (
+			id VARCHAR(64) PRIMARY KEY,
+			consumed_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+			expires_at TIMESTAMPTZ NOT NULL
+		);
+		CREATE INDEX IF NOT EXISTS oauth_states_expires_idx ON oauth_states (expires_at);
+	`,
+	"user_identity_tokens": `
+		CREATE TABLE IF NOT EXISTS user_identity_tokens (
+			identity_id UUID PRIMARY KEY REFERENCES user_identities (id) ON DELETE CASCADE,
+			token TEXT NOT NULL,
+			updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+		);
+	`,
+	"saml_assertions": `
+		CREATE TABLE IF NOT EXISTS saml_assertions (
+			connection VARCHAR(32) NOT NULL,
+			id VARCHAR(255) NOT NULL,
+			consumed_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+			expires_at TIMESTAMPTZ NOT NULL,
+			PRIMARY KEY (connection, id)
+		);
+		CREATE INDEX IF NOT EXISTS saml_assertions_expires_idx ON saml_assertions (expires_at);
+	`,
+	"oauth_clients": `
+		CREATE TABLE IF NOT EXISTS oauth_clients (
+			id VARCHAR(64) PRIMARY KEY,
+			secret_hash VARCHAR(64) NOT NULL DEFAULT '',
+			name VARCHAR(255) NOT NULL,
+			redirect_uris TEXT[] NOT NULL DEFAULT '{}',
+			scopes TEXT[] NOT NULL DEFAULT '{}',
+			grant_types TEXT[] NOT NULL DEFAULT '{}',
+			token_endpoint_auth_method VARCHAR(32) NOT NULL,
+			first_party BOOLEAN NOT NULL DEFAULT FALSE,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+		);
+		ALTER TABLE oauth_clients ADD COLUMN IF NOT EXISTS jwks TEXT NOT NULL DEFAULT '';
+		ALTER TABLE oauth_clients ADD COLUMN IF NOT EXISTS audiences TEXT[] NOT NULL DEFAULT '{}';
+		ALTER TABLE oauth_clients ADD COLUMN IF NOT EXISTS client_uri TEXT NOT NULL DEFAULT '';
+		ALTER TABLE oauth_clients ADD COLUMN IF NOT EXISTS logo_uri TEXT NOT NULL DEFAULT '';
+		ALTER TABLE oauth_clients ADD COLUMN IF NOT EXISTS policy_uri TEXT NOT NULL DEFAULT '';
+		ALTER TABLE oauth_clients ADD COLUMN IF NOT EXISTS tos_uri TEXT NOT NULL DEFAULT '';
+		ALTER TABLE oauth_clients ADD COLUMN IF NOT EXISTS contacts TEXT[] NOT NULL DEFAULT '{}';
+		ALTER TABLE oauth_clients ADD COLUMN IF NOT EXISTS registration_token_hash VARCHAR(64) NOT NULL DEFAULT '';
+		ALTER TABLE oauth_clients ADD COLUMN IF NOT EXISTS subject_token_clients TEXT[] NOT NULL DEFAULT '{}';
+		ALTER TABLE oauth_clients ADD COLUMN IF NOT EXISTS dpop_bound_access_tokens BOOLEAN NOT NULL DEFAULT FALSE;
+		ALTER TABLE oauth_clients ADD COLUMN IF NOT EXISTS tls_subject_dn TEXT NOT NULL DEFAULT '';
+		ALTER TABLE oauth_clients ADD COLUMN IF NOT EXISTS tls_san_dns TEXT NOT NULL DEFAULT '';
+	`,
+	"oauth_device_codes": `
+		CREATE TABLE IF NOT EXISTS oauth_device_codes (
+			device_code_hash VARCHAR(64) PRIMARY KEY,
+			user_code VARCHAR(16) NOT NULL UNIQUE,
+			client_id VARCHAR(64) NOT NULL REFERENCES oauth_clients (id) ON DELETE CASCADE,
+			scope TEXT NOT NULL DEFAULT '',
+			status VARCHAR(16) NOT NULL DEFAULT 'pending',
+			user_id UUID REFERENCES users (id) ON DELETE CASCADE,
+			auth_time TIMESTAMPTZ,
+			poll_interval INTEGER NOT NULL,
+			last_polled_at TIMESTAMPTZ,
+			expires_at TIMESTAMPTZ NOT NULL,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+		);
+		CREATE INDEX IF NOT EXISTS oauth_device_codes_expires_idx ON oauth_device_codes (expires_at);
+	`,
+	"oauth_grants": `
+		CREATE TABLE IF NOT EXISTS oauth_grants (
+			user_id UUID NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+			client_id VARCHAR(64) NOT NULL REFERENCES oauth_clients (id) ON DELETE CASCADE,
+			scopes TEXT[] NOT NULL DEFAULT '{}',
+			active BOOLEAN NOT NULL DEFAULT TRUE,
+			revoked_at TIMESTAMPTZ,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+			updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+			PRIMARY KEY (user_id, client_id)
+		);
+	`,
+	"oauth_revoked_access_tokens": `
+		CREATE TABLE IF NOT EXISTS oauth_revoked_access_tokens (
+			jti VARCHAR(64) PRIMARY KEY,
+			client_id VARCHAR(64) NOT NULL REFERENCES oauth_clients (id) ON DELETE CASCADE,
+			expires_at TIMESTAMPTZ NOT NULL,
+			revoked_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+		);
+		CREATE INDEX IF NOT EXISTS oauth_revoked_access_tokens_expires_idx ON oauth_revoked_access_tokens (expires_at);
+	`,
+	"oauth_client_assertions": `
+		CREATE TABLE IF NOT EXISTS oauth_client_assertions (
+			client_id VARCHAR(64) NOT NULL REFERENCES oauth_clients (id) ON DELETE CASCADE,
+			jti VARCHAR(255) NOT NULL,
+			expires_at TIMESTAMPTZ NOT NULL,
+			PRIMARY KEY (client_id, jti)
+		);
+		CREATE INDEX IF NOT EXISTS oauth_client_assertions_expires_idx ON oauth_client_assertions (expires_at);
+	`,
+	"oauth_dpop_proofs": `
+		CREATE TABLE IF NOT EXISTS oauth_dpop_proofs (
+			jkt VARCHAR(64) NOT NULL,
+			jti VARCHAR(255) NOT NULL,
+			expires_at TIMESTAMPTZ NOT NULL,
+			PRIMARY KEY (jkt, jti)
+		);
+		CREATE INDEX IF NOT EXISTS oauth_dpop_proofs_expires_idx ON oauth_dpop_proofs (expires_at);
+	`,
+	"oauth_authorization_codes": `
+		CREATE TABLE IF NOT EXISTS oauth_authorization_codes (
+			code_hash VARCHAR(64) PRIMARY KEY,
+			client_id VARCHAR(64) NOT NULL REFERENCES oauth_clients (id) ON DELETE CASCADE,
+			user_id UUID NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+			redirect_uri TEXT NOT NULL,
+			scope TEXT NOT NULL DEFAULT '',
+			code_challenge VARCHAR(128) NOT NULL,
+			expires_at TIMESTAMPTZ NOT NULL,
+			consumed_at TIMESTAMPTZ,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+		);
+		CREATE INDEX IF NOT EXISTS oauth_authorization_codes_expires_idx ON oauth_authorization_codes (expires_at);
+		ALTER TABLE oauth_authorization_codes ADD COLUMN IF NOT EXISTS nonce VARCHAR(255) NOT NULL DEFAULT '';
+		ALTER TABLE oauth_authorization_codes ADD COLUMN IF NOT EXISTS auth_time TIMESTAMPTZ;
+	`,
+	"oauth_refresh_tokens": `
+		CREATE TABLE IF NOT EXISTS oauth_refresh_tokens (
+			token_hash VARCHAR(64) PRIMARY KEY,
+			family_id UUID NOT NULL,
+			client_id VARCHAR(64) NOT NULL REFERENCES oauth_clients (id) ON DELETE CASCADE,
+			user_id UUID NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+			scope TEXT NOT NULL DEFAULT '',
+			code_hash VARCHAR(64) NOT NULL DEFAULT '',
+			expires_at TIMESTAMPTZ NOT NULL,
+			rotated_at TIMESTAMPTZ,
+			revoked_at TIMESTAMPTZ,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+		);
+		CREATE INDEX IF NOT EXISTS oauth_refresh_tokens_family_idx ON oauth_refresh_tokens (family_id);
+		CREATE INDEX IF NOT EXISTS oauth_refresh_tokens_user_client_idx ON oauth_refresh_tokens (user_id, client_id);
+		CREATE INDEX IF NOT EXISTS oauth_refresh_tokens_code_idx ON oauth_refresh_tokens (code_hash) WHERE code_hash <> '';
+		ALTER TABLE oauth_refresh_tokens ADD COLUMN IF NOT EXISTS auth_time TIMESTAMPTZ;
+		ALTER TABLE oauth_refresh_tokens ADD COLUMN IF NOT EXISTS dpop_jkt VARCHAR(64) NOT NULL DEFAULT '';
+		ALTER TABLE oauth_refresh_tokens ADD COLUMN IF NOT EXISTS cert_thumbprint VARCHAR(64) NOT NULL DEFAULT '';
+	`,
+}
@@ -2,10 +2,19 @@ package route
 
 import (
 	"database/sql"
+	"log"
 
+	"github.com/dev-xero/authentication-backend/authserver"
 	handler "github.com/dev-xero/authentication-backend/handler/auth"
+	oauth "github.com/dev-xero/authentication-backend/handler/auth/oauth"
+	"github.com/dev-xero/authentication-backend/mailer"
+	"github.com/dev-xero/authentication-backend/mfa"
+	"github.com/dev-xero/authentication-backend/middleware"
+	"github.com/dev-xero/authentication-backend/providertoken"
 	repository "github.com/dev-xero/authentication-backend/repository/user"
+	"github.com/dev-xero/authentication-backend/saml"
 	"github.com/dev-xero/authentication-backend/service"
+	"github.com/dev-xero/authentication-backend/sms"
 	"github.com/go-chi/chi/v5"
 )
 
@@ -15,27 +24,97 @@ Loads auth routes
 Objectives:
   - Setup an auth sub-router
   - Setup a database repository
+  - Setup the SMS sender, the mailer, the MFA policy, the identity providers, provider token storage and SAML connections
   - Handle requests made to auth routes
 
 Params:
   - router: A chi router
   - db:     A pointer to the application database
+  - config: The authorization server config, nil when it is disabled
 
 Returns:
   - No return value
 */
-func LoadAuthRoutes(router chi.Router, db *sql.DB) {
+func LoadAuthRoutes(router chi.Router, db *sql.DB, config *authserver.Config) {
 	authDBService := &service.DatabaseProvider{}
 	authDBService.New(&repository.PostGreSQL{Database: db})
 
+	smsSender, err := sms.NewFromEnvironment()
+	if err != nil {
+		log.Fatal("[FATAL]: failed to setup sms sender: ", err)
+	}
+
+	smsGuard, err := sms.NewGuardFromEnvironment()
+	if err != nil {
+		log.Fatal("[FATAL]: failed to setup sms guard: ", err)
+	}
+
+	authSMSService := &service.SMSProvider{}
+	authSMSService.New(smsSender, smsGuard)
+
+	mailSender, err := mailer.NewFromEnvironment()
+	if err != nil {
+		log.Fatal("[FATAL]: failed to setup mailer: ", err)
+	}
+
+	authMailService := &service.MailProvider{}
+	authMailService.New(mailSender)
+
+	mfaPolicy, err := mfa.NewPolicyFromEnvironment()
+	if err != nil {
+		log.Fatal("[FATAL]: failed to load mfa policy: ", err)
+	}
+
+	oauthProviders, err := oauth.NewRegistryFromEnvironment()
+	if err != nil {
+		log.Fatal("[FATAL]: failed to load identity providers: ", err)
+	}
+
+	returnToAllowlist, err := oauth.NewReturnToAllowlistFromEnvironment()
+	if err != nil {
+		log.Fatal("[FATAL]: failed to load return_to allowlist: ", err)
+	}
+
+	// Stored tokens are refreshed by the provider that issued them
+	providerTokens, err := providertoken.NewStoreFromEnvironment(authDBService, oauthProviders.Refresher)
+	if err != nil {
+		log.Fatal("[FATAL]: failed to load provider token storage: ", err)
+	}
+
+	samlConnections, err := saml.NewConnectionsFromEnvironment()
+	if err != nil {
+		log.Fatal("[FATAL]: failed to load saml connections: ", err)
+	}
+
 	authHandler := &handler.AuthHandler{}
 	authHandler.WithService(authDBService)
+	authHandler.WithSMSService(authSMSService)
+	authHandler.WithMailService(authMailService)
+	authHandler.WithMFAPolicy(mfaPolicy)
+	authHandler.WithOAuthProviders(oauthProviders)
+	authHandler.WithReturnToAllowlist(returnToAllowlist)
+	authHandler.WithProviderTokens(providerTokens)
+	authHandler.WithSAMLConnections(samlConnections)
 
 	router.Get("/", authHandler.Home)
 	router.Post("/sign-up", authHandler.SignUp)
 	router.Post("/sign-in", authHandler.SignIn)
 	router.Post("/sign-out", authHandler.SignOut)
-	router.Get("/oauth/google", authHandler.GoogleSignIn)
-	router.Get("/oauth/google/callback", authHandler.GoogleSignInCallback)
-	router.Get("/oauth/{x}/failure", authHandler.OAuthFailure)
+	router.Post("/mfa/sms/send", authHandler.ResendSMSChallenge)
+	router.Post("/mfa/sms/verify", authHandler.VerifySMSChallenge)
+	router.Post("/recovery", authHandler.RequestRecovery)
+	router.Post("/recovery/verify", authHandler.VerifyRecovery)
+	router.Post("/recovery/cancel", authHandler.CancelRecovery)
+	router.Post("/recovery/complete", authHandler.CompleteRecovery)
+	router.With(middleware.AuthenticateMiddleware(authDBService, config), middleware.RequireUser).Post("/mfa/sms/enroll", authHandler.EnrollSMS)
+	router.With(middleware.AuthenticateMiddleware(authDBService, config), middleware.RequireUser).Post("/mfa/sms/enroll/verify", authHandler.VerifySMSEnrollment)
+	router.Get("/oauth/{provider}", authHandler.OAuthSignIn)
+	router.Get("/oauth/{provider}/callback", authHandler.OAuthCallback)
+	router.Post("/oauth/{provider}/callback", authHandler.OAuthCallback)
+	router.Get("/oauth/{provider}/failure", authHandler.OAuthFailure)
+	router.With(middleware.AuthenticateMiddleware(authDBService, config), middleware.RequireUser).Get("/oauth/{provider}/link", authHandler.OAuthLink)
+	router.Get("/saml/{connection}/metadata", authHandler.SAMLMetadata)
+	router.Get("/saml/{connection}/login", authHandler.SAMLSignIn)
+	router.Post("/saml/{connection}/acs", authHandler.SAMLAssertionConsumer)
+	router.Get("/saml/{connection}/failure", authHandler.SAMLFailure)
 }
@@ -0,0 +1,106 @@
+package route
+
+import (
+	"database/sql"
+	"log"
+
+	"github.com/dev-xero/authentication-backend/authserver"
+	handler "github.com/dev-xero/authentication-backend/handler/authorization"
+	"github.com/dev-xero/authentication-backend/mfa"
+	repository "github.com/dev-xero/authentication-backend/repository/user"
+	"github.com/dev-xero/authentication-backend/service"
+	"github.com/go-chi/chi/v5"
+)
+
+/*
+Loads authorization server routes
+
+Objectives:
+  - Setup the authorization server, no routes are served when it is disabled
+  - Handle requests made to authorization server routes
+
+Params:
+  - router: A chi router
+  - db:     A pointer to the application database
+  - config: The authorization server config, nil when it is disabled
+
+Returns:
+  - No return value
+*/
+func LoadOAuthRoutes(router chi.Router, db *sql.DB, config *authserver.Config) {
+	authorizationHandler := newAuthorizationHandler(db, config)
+	if authorizationHandler == nil {
+		log.Println("[LOG]: authorization server disabled, OAUTH_ISSUER is not set")
+		return
+	}
+
+	router.Get("/authorize", authorizationHandler.Authorize)
+	router.Post("/authorize", authorizationHandler.Consent)
+	router.Post("/token", authorizationHandler.Token)
+	router.Post("/device_authorization", authorizationHandler.DeviceAuthorization)
+	router.Get("/device", authorizationHandler.DeviceVerification)
+	router.Post("/device", authorizationHandler.DeviceDecision)
+	router.Post("/introspect", authorizationHandler.Introspect)
+	router.Post("/revoke", authorizationHandler.Revoke)
+	router.Get("/userinfo", authorizationHandler.UserInfo)
+	router.Post("/userinfo", authorizationHandler.UserInfo)
+	router.Get("/jwks", authorizationHandler.JWKS)
+	router.Post("/register", authorizationHandler.Register)
+	router.Get("/register/{clientID}", authorizationHandler.GetRegistration)
+	router.Put("/register/{clientID}", authorizationHandler.UpdateRegistration)
+	router.Delete("/register/{clientID}", authorizationHandler.DeleteRegistration)
+}
+
+/*
+Loads the discovery routes, served below /.well-known of the issuer
+
+Params:
+  - router: A chi router
+  - db:     A pointer to the application database
+  - config: The authorization server config, nil when it is disabled
+
+Returns:
+  - No return value
+*/
+func LoadDiscoveryRoutes(router chi.Router, db *sql.DB, config *authserver.Config) {
+	authorizationHandler := newAuthorizationHandler(db, config)
+	if authorizationHandler == nil {
+		return
+	}
+
+	router.Get("/openid-configuration", authorizationHandler.Discovery)
+	router.Get("/oauth-authorization-server", authorizationHandler.Discovery)
+}
+
+/*
+Sets up the authorization server handler
+
+Objectives:
+  - Setup a database repository and the MFA policy
+
+Params:
+  - db:     A pointer to the application database
+  - config: The authorization server config, nil when it is disabled
+
+Returns:
+  - A pointer to the handler, nil when the authorization server is disabled
+*/
+func newAuthorizationHandler(db *sql.DB, config *authserver.Config) *handler.AuthorizationHandler {
+	if config == nil {
+		return nil
+	}
+
+	oauthDBService := &service.DatabaseProvider{}
+	oauthDBService.New(&repository.PostGreSQL{Database: db})
+
+	mfaPolicy, err := mfa.NewPolicyFromEnvironment()
+	if err != nil {
+		log.Fatal("[FATAL]: failed to load mfa policy: ", err)
+	}
+
+	authorizationHandler := &handler.AuthorizationHandler{}
+	authorizationHandler.WithService(oauthDBService)
+	authorizationHandler.WithMFAPolicy(mfaPolicy)
+	authorizationHandler.WithConfig(config)
+	return authorizationHandler
+}
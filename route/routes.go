@@ -2,9 +2,12 @@ package route
 
 import (
 	"database/sql"
+	"log"
 	"net/http"
 
+	"github.com/dev-xero/authentication-backend/authserver"
 	auth "github.com/dev-xero/authentication-backend/route/auth"
+	oauth "github.com/dev-xero/authentication-backend/route/oauth"
 	user "github.com/dev-xero/authentication-backend/route/user"
 	"github.com/dev-xero/authentication-backend/util"
 	"github.com/go-chi/chi/v5"
@@ -17,6 +20,7 @@ Loads the application routes
 
 Objectives:
   - Create the application base router
+  - Load the authorization server config shared by the sub-routers
   - Setup CORS
   - Setup a request handler to the base route
   - Setup other routes and sub-routers
@@ -29,6 +33,12 @@ Returns:
   - A chi multiplexer
 */
 func LoadRoutes(db *sql.DB) *chi.Mux {
+	// Loaded once, so the authorization server and the API agree on their public URLs
+	config, err := authserver.NewConfigFromEnvironment()
+	if err != nil {
+		log.Fatal("[FATAL]: failed to load authorization server config: ", err)
+	}
+
 	router := chi.NewRouter()
 	router.Use(middleware.Logger)
 
@@ -36,7 +46,7 @@ func LoadRoutes(db *sql.DB) *chi.Mux {
 	router.Use(cors.Handler(cors.Options{
 		AllowedOrigins:   []string{"https://*", "http://*"},
 		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token"},
+		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "DPoP", "X-CSRF-Token"},
 		ExposedHeaders:   []string{"Link"},
 		AllowCredentials: true,
 	}))
@@ -49,12 +59,22 @@ func LoadRoutes(db *sql.DB) *chi.Mux {
 
 	// Setup auth route handlers
 	router.Route("/auth", func(router chi.Router) {
-		auth.LoadAuthRoutes(router, db)
+		auth.LoadAuthRoutes(router, db, config)
 	})
 
 	// Setup user route handlers
 	router.Route("/user", func(router chi.Router) {
-		user.LoadUserRoutes(router, db)
+		user.LoadUserRoutes(router, db, config)
+	})
+
+	// Setup authorization server route handlers
+	router.Route("/oauth", func(router chi.Router) {
+		oauth.LoadOAuthRoutes(router, db, config)
+	})
+
+	// Setup authorization server discovery handlers
+	router.Route("/.well-known", func(router chi.Router) {
+		oauth.LoadDiscoveryRoutes(router, db, config)
 	})
 
 	// Handle requests to undefined endpoints
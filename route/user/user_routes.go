@@ -2,10 +2,15 @@ package route
 
 import (
 	"database/sql"
+	"log"
 
+	"github.com/dev-xero/authentication-backend/authserver"
 	"github.com/dev-xero/authentication-backend/handler"
+	"github.com/dev-xero/authentication-backend/mailer"
+	"github.com/dev-xero/authentication-backend/mfa"
 	"github.com/dev-xero/authentication-backend/middleware"
 	repository "github.com/dev-xero/authentication-backend/repository/user"
+	"github.com/dev-xero/authentication-backend/service"
 	"github.com/go-chi/chi/v5"
 )
 
@@ -15,19 +20,56 @@ Loads user routes
 Objectives:
   - Setup a user sub-router
   - Setup a database repository
-  - Handle requests made to user routes
+  - Setup the mailer and the MFA policy
+  - Handle requests made to user routes, protected routes require a signed-in user, and MFA enrollment when the policy does
 
 Params:
   - router: A chi router
   - db:     A pointer to the application database
+  - config: The authorization server config, nil when it is disabled
 
 Returns:
   - No return value
 */
-func LoadUserRoutes(router chi.Router, db *sql.DB) {
+func LoadUserRoutes(router chi.Router, db *sql.DB, config *authserver.Config) {
+	repo := &repository.PostGreSQL{Database: db}
+
 	user := &handler.User{}
-	user.New(&repository.PostGreSQL{Database: db})
+	user.New(repo)
+
+	userDBService := &service.DatabaseProvider{}
+	userDBService.New(repo)
+
+	mailSender, err := mailer.NewFromEnvironment()
+	if err != nil {
+		log.Fatal("[FATAL]: failed to setup mailer: ", err)
+	}
+
+	userMailService := &service.MailProvider{}
+	userMailService.New(mailSender)
+	user.WithMailService(userMailService)
+
+	mfaPolicy, err := mfa.NewPolicyFromEnvironment()
+	if err != nil {
+		log.Fatal("[FATAL]: failed to load mfa policy: ", err)
+	}
+
+	protected := router.With(
+		middleware.AuthenticateMiddleware(userDBService, config),
+		middleware.RequireUser,
+		middleware.RequireMFAEnrollment(userDBService, mfaPolicy),
+	)
 
 	router.Get("/", user.Home)
-	router.With(middleware.AuthenticateMiddleware).Get("/{id}", user.GetUserByID)
+	protected.Get("/me/emails", user.ListEmails)
+	protected.Post("/me/emails", user.AddEmail)
+	protected.Post("/me/emails/verify", user.VerifyEmail)
+	protected.Post("/me/emails/{emailID}/primary", user.SetPrimaryEmail)
+	protected.Delete("/me/emails/{emailID}", user.RemoveEmail)
+	protected.Get("/me/identities", user.ListIdentities)
+	protected.Delete("/me/identities/{identityID}", user.UnlinkIdentity)
+	protected.Post("/me/password", user.AddPassword)
+	protected.Get("/me/grants", user.ListGrants)
+	protected.Delete("/me/grants/{clientID}", user.RevokeGrant)
+	protected.Get("/{id}", user.GetUserByID)
 }
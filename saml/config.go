@@ -0,0 +1,322 @@
+package saml
+
+import (
+	"context"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/joho/godotenv"
+)
+
+// Returned when a connection name isn't configured
+var ErrUnknownConnection = errors.New("unknown saml connection")
+
+// Connection names end up in stored identities as "saml:<name>", which are limited to 32 characters
+var connectionNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,26}$`)
+
+// How long fetched IdP metadata is used before it is fetched again, so rotated IdP certificates are picked up
+const metadataRefreshInterval = 24 * time.Hour
+
+/*
+Connection config struct, an enterprise IdP users sign-in with
+
+Fields:
+  - Name:            The connection name used in routes, stored identities use "saml:<name>"
+  - BaseURL:         The URL the SAML routes are served below, e.g. "https://api.example.com/auth/saml"
+  - EntityID:        The SP entity id, defaults to the metadata URL
+  - IDPMetadata:     The IdP metadata XML, imported from a file
+  - IDPMetadataURL:  The IdP metadata URL, fetched when IDPMetadata isn't set
+  - NameIDFormat:    The NameID format requested from the IdP, defaults to persistent
+  - EmailAttributes: The attributes holding the email, the first one present is used
+  - NameAttributes:  The attributes holding the display name, the first one present is used
+  - EmailDomains:    The email domains the IdP is authoritative for, only those emails count as verified
+*/
+type Config struct {
+	Name            string
+	BaseURL         string
+	EntityID        string
+	IDPMetadata     []byte
+	IDPMetadataURL  string
+	NameIDFormat    string
+	EmailAttributes []string
+	NameAttributes  []string
+	EmailDomains    []string
+}
+
+/*
+Credentials struct, the key and certificate the SP signs AuthnRequests and decrypts assertions with
+
+Fields:
+  - Key:         The RSA private key
+  - Certificate: The certificate of the key, published in the SP metadata
+*/
+type Credentials struct {
+	Key         *rsa.PrivateKey
+	Certificate *x509.Certificate
+}
+
+/*
+Connections struct, the configured connections, their IdP metadata is loaded on first use
+
+Fields:
+  - configs:     The connection configs by name
+  - credentials: The SP key and certificate shared by every connection
+  - client:      The http client fetching IdP metadata
+  - loaded:      The loaded connections by name
+*/
+type Connections struct {
+	configs     map[string]Config
+	credentials Credentials
+	client      *http.Client
+
+	mu     sync.Mutex
+	loaded map[string]*Connection
+}
+
+/*
+Creates the set of configured connections
+
+Params:
+  - credentials: The SP key and certificate
+  - client:      The http client fetching IdP metadata, http.DefaultClient when nil
+  - configs:     The connection configs, names must be unique
+
+Returns:
+  - A pointer to the connections
+*/
+func NewConnections(credentials Credentials, client *http.Client, configs ...Config) *Connections {
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	connections := &Connections{
+		configs:     make(map[string]Config, len(configs)),
+		credentials: credentials,
+		client:      client,
+		loaded:      make(map[string]*Connection, len(configs)),
+	}
+
+	for _, config := range configs {
+		connections.configs[config.Name] = config
+	}
+
+	return connections
+}
+
+/*
+Creates the connections configured through environment variables
+
+Objectives:
+  - Configure every connection named in SAML_CONNECTIONS from its SAML_<NAME>_* variables
+  - Import IdP metadata files up front, so a bad file fails at startup
+  - Load the SP key and certificate only when connections are configured
+
+Params:
+  - No parameters
+
+Returns:
+  - A pointer to the connections, nil when SAML_CONNECTIONS isn't set
+  - An error if a connection or the SP credentials are misconfigured
+*/
+func NewConnectionsFromEnvironment() (*Connections, error) {
+	// Load environment variables from .env file in development
+	if env := os.Getenv("ENVIRONMENT"); env != "production" {
+		err := godotenv.Load()
+		if err != nil {
+			return nil, fmt.Errorf("[FAIL]: could not load environment variables: %w", err)
+		}
+	}
+
+	names := splitList(os.Getenv("SAML_CONNECTIONS"))
+	if len(names) == 0 {
+		return nil, nil
+	}
+
+	baseURL := strings.TrimSuffix(os.Getenv("SAML_SP_BASE_URL"), "/")
+	if baseURL == "" {
+		return nil, fmt.Errorf("[FAIL]: SAML_SP_BASE_URL must be set")
+	}
+
+	credentials, err := loadCredentials(os.Getenv("SAML_SP_KEY_FILE"), os.Getenv("SAML_SP_CERT_FILE"))
+	if err != nil {
+		return nil, err
+	}
+
+	var configs []Config
+	for _, name := range names {
+		name = strings.ToLower(name)
+		if !connectionNamePattern.MatchString(name) {
+			return nil, fmt.Errorf("[FAIL]: invalid saml connection name %q", name)
+		}
+		prefix := "SAML_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_")) + "_"
+
+		config := Config{
+			Name:            name,
+			BaseURL:         baseURL,
+			EntityID:        os.Getenv(prefix + "ENTITY_ID"),
+			IDPMetadataURL:  os.Getenv(prefix + "IDP_METADATA_URL"),
+			NameIDFormat:    os.Getenv(prefix + "NAME_ID_FORMAT"),
+			EmailAttributes: splitList(os.Getenv(prefix + "EMAIL_ATTRIBUTE")),
+			NameAttributes:  splitList(os.Getenv(prefix + "NAME_ATTRIBUTE")),
+			EmailDomains:    splitList(strings.ToLower(os.Getenv(prefix + "EMAIL_DOMAINS"))),
+		}
+
+		if file := os.Getenv(prefix + "IDP_METADATA_FILE"); file != "" {
+			config.IDPMetadata, err = os.ReadFile(file)
+			if err != nil {
+				return nil, fmt.Errorf("[FAIL]: could not read %sIDP_METADATA_FILE: %w", prefix, err)
+			}
+			if _, err := parseIDPMetadata(config.IDPMetadata); err != nil {
+				return nil, fmt.Errorf("[FAIL]: invalid %sIDP_METADATA_FILE: %w", prefix, err)
+			}
+		}
+
+		if config.IDPMetadata == nil && config.IDPMetadataURL == "" {
+			return nil, fmt.Errorf("[FAIL]: %sIDP_METADATA_FILE or %sIDP_METADATA_URL must be set", prefix, prefix)
+		}
+
+		configs = append(configs, config)
+	}
+
+	return NewConnections(credentials, &http.Client{Timeout: 10 * time.Second}, configs...), nil
+}
+
+/*
+Returns a configured connection, loading its IdP metadata on first use
+
+Objectives:
+  - Fetch metadata from the IdP metadata URL again once it is older than a day
+  - Keep using the loaded metadata when fetching it again fails
+
+Params:
+  - ctx:  Method context
+  - name: The connection name
+
+Returns:
+  - A pointer to the connection
+  - ErrUnknownConnection if no connection has the name, or an error if the metadata can't be loaded
+*/
+func (connections *Connections) Get(ctx context.Context, name string) (*Connection, error) {
+	if connections == nil {
+		return nil, ErrUnknownConnection
+	}
+
+	connections.mu.Lock()
+	defer connections.mu.Unlock()
+
+	connection, loaded := connections.loaded[name]
+	if loaded && (connection.config.IDPMetadata != nil || time.Since(connection.loadedAt) < metadataRefreshInterval) {
+		return connection, nil
+	}
+
+	config, ok := connections.configs[name]
+	if !ok {
+		return nil, ErrUnknownConnection
+	}
+
+	// Failed fetches are retried by the next request
+	fresh, err := NewConnection(ctx, config, connections.credentials, connections.client)
+	if err != nil {
+		if loaded {
+			return connection, nil
+		}
+		return nil, err
+	}
+
+	connections.loaded[name] = fresh
+	return fresh, nil
+}
+
+/*
+Loads the SP key and certificate from PEM files
+
+Params:
+  - keyFile:  The path of the PKCS #1 or PKCS #8 RSA private key
+  - certFile: The path of the certificate
+
+Returns:
+  - The SP credentials
+  - An error if a file can't be read or doesn't hold an RSA key and its certificate
+*/
+func loadCredentials(keyFile string, certFile string) (Credentials, error) {
+	if keyFile == "" || certFile == "" {
+		return Credentials{}, fmt.Errorf("[FAIL]: SAML_SP_KEY_FILE and SAML_SP_CERT_FILE must be set")
+	}
+
+	keyData, err := os.ReadFile(keyFile)
+	if err != nil {
+		return Credentials{}, fmt.Errorf("[FAIL]: could not read saml sp key: %w", err)
+	}
+
+	certData, err := os.ReadFile(certFile)
+	if err != nil {
+		return Credentials{}, fmt.Errorf("[FAIL]: could not read saml sp certificate: %w", err)
+	}
+
+	return ParseCredentials(keyData, certData)
+}
+
+/*
+Parses the SP key and certificate
+
+Params:
+  - keyData:  The PEM encoded PKCS #1 or PKCS #8 RSA private key
+  - certData: The PEM encoded certificate
+
+Returns:
+  - The SP credentials
+  - An error if the key isn't an RSA key or the certificate isn't its certificate
+*/
+func ParseCredentials(keyData []byte, certData []byte) (Credentials, error) {
+	keyBlock, _ := pem.Decode(keyData)
+	if keyBlock == nil {
+		return Credentials{}, fmt.Errorf("[FAIL]: saml sp key is not PEM encoded")
+	}
+
+	var key *rsa.PrivateKey
+	if parsed, err := x509.ParsePKCS1PrivateKey(keyBlock.Bytes); err == nil {
+		key = parsed
+	} else if parsed, err := x509.ParsePKCS8PrivateKey(keyBlock.Bytes); err == nil {
+		key, _ = parsed.(*rsa.PrivateKey)
+	}
+	if key == nil {
+		return Credentials{}, fmt.Errorf("[FAIL]: saml sp key is not an RSA private key")
+	}
+
+	certBlock, _ := pem.Decode(certData)
+	if certBlock == nil {
+		return Credentials{}, fmt.Errorf("[FAIL]: saml sp certificate is not PEM encoded")
+	}
+
+	certificate, err := x509.ParseCertificate(certBlock.Bytes)
+	if err != nil {
+		return Credentials{}, fmt.Errorf("[FAIL]: could not parse saml sp certificate: %w", err)
+	}
+
+	if public, ok := certificate.PublicKey.(*rsa.PublicKey); !ok || !public.Equal(&key.PublicKey) {
+		return Credentials{}, fmt.Errorf("[FAIL]: saml sp certificate does not match the key")
+	}
+
+	return Credentials{Key: key, Certificate: certificate}, nil
+}
+
+// Splits a comma separated list, dropping empty entries
+func splitList(list string) []string {
+	var entries []string
+	for _, entry := range strings.Split(list, ",") {
+		if entry = strings.TrimSpace(entry); entry != "" {
+			entries = append(entries, entry)
+		}
+	}
+
+	return entries
+}
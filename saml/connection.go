@@ -0,0 +1,431 @@
+package saml
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	gosaml "github.com/crewjam/saml"
+	"github.com/crewjam/saml/samlsp"
+	"github.com/dev-xero/authentication-backend/model"
+	dsig "github.com/russellhaering/goxmldsig"
+)
+
+// Returned when the IdP responded with a non-success status, e.g. the user cancelled
+var ErrIdPDenied = errors.New("the identity provider did not authenticate the user")
+
+// Attributes tried in order when a connection doesn't configure its own, covering the common IdP defaults
+var (
+	defaultEmailAttributes = []string{
+		"email",
+		"mail",
+		"http://schemas.xmlsoap.org/ws/2005/05/identity/claims/emailaddress",
+		"urn:oid:0.9.2342.19200300.100.1.3",
+	}
+	defaultNameAttributes = []string{
+		"name",
+		"displayName",
+		"http://schemas.xmlsoap.org/ws/2005/05/identity/claims/name",
+		"urn:oid:2.16.840.1.113730.3.1.241",
+		"urn:oid:2.5.4.3",
+	}
+)
+
+/*
+Connection struct, a service provider bound to one IdP
+
+Fields:
+  - config:   The connection config
+  - sp:       The service provider, holding the imported IdP metadata
+  - loadedAt: When the IdP metadata was loaded
+*/
+type Connection struct {
+	config   Config
+	sp       *gosaml.ServiceProvider
+	loadedAt time.Time
+}
+
+/*
+AuthnRequest struct, a signed request sending the user to the IdP
+
+Fields:
+  - ID:          The request id, the response must be in response to it
+  - RedirectURL: The URL to redirect the user to, for IdPs supporting the HTTP-Redirect binding
+  - PostForm:    A self-submitting HTML form, for IdPs only supporting the HTTP-POST binding
+*/
+type AuthnRequest struct {
+	ID          string
+	RedirectURL string
+	PostForm    []byte
+}
+
+/*
+Assertion struct, the verified assertion of a response
+
+Fields:
+  - ID:        The assertion id, used once
+  - ExpiresAt: When the assertion can't be accepted anymore, replays must be rejected until then
+  - Profile:   The user the IdP authenticated, mapped from the NameID and attributes
+*/
+type Assertion struct {
+	ID        string
+	ExpiresAt time.Time
+	Profile   model.ExternalProfile
+}
+
+/*
+Creates a connection, importing the IdP metadata
+
+Params:
+  - ctx:         Method context, used to fetch the IdP metadata
+  - config:      The connection config
+  - credentials: The SP key and certificate
+  - client:      The http client fetching IdP metadata
+
+Returns:
+  - A pointer to the connection
+  - An error if the IdP metadata can't be loaded or has no signing certificate
+*/
+func NewConnection(ctx context.Context, config Config, credentials Credentials, client *http.Client) (*Connection, error) {
+	var (
+		metadata *gosaml.EntityDescriptor
+		err      error
+	)
+
+	if config.IDPMetadata != nil {
+		metadata, err = parseIDPMetadata(config.IDPMetadata)
+	} else {
+		metadataURL, parseErr := url.Parse(config.IDPMetadataURL)
+		if parseErr != nil {
+			return nil, fmt.Errorf("[FAIL]: invalid %s idp metadata url: %w", config.Name, parseErr)
+		}
+		metadata, err = samlsp.FetchMetadata(ctx, client, *metadataURL)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("[FAIL]: could not load %s idp metadata: %w", config.Name, err)
+	}
+
+	if len(metadata.IDPSSODescriptors) == 0 {
+		return nil, fmt.Errorf("[FAIL]: %s idp metadata has no IDPSSODescriptor", config.Name)
+	}
+
+	base := strings.TrimSuffix(config.BaseURL, "/") + "/" + config.Name
+	metadataURL, err := url.Parse(base + "/metadata")
+	if err != nil {
+		return nil, fmt.Errorf("[FAIL]: invalid saml base url: %w", err)
+	}
+	acsURL, _ := url.Parse(base + "/acs")
+
+	nameIDFormat := gosaml.PersistentNameIDFormat
+	if config.NameIDFormat != "" {
+		nameIDFormat = gosaml.NameIDFormat(config.NameIDFormat)
+	}
+
+	sp := &gosaml.ServiceProvider{
+		EntityID:          config.EntityID,
+		Key:               credentials.Key,
+		Certificate:       credentials.Certificate,
+		HTTPClient:        client,
+		MetadataURL:       *metadataURL,
+		AcsURL:            *acsURL,
+		IDPMetadata:       metadata,
+		AuthnNameIDFormat: nameIDFormat,
+		SignatureMethod:   dsig.RSASHA256SignatureMethod,
+	}
+
+	if sp.GetSSOBindingLocation(gosaml.HTTPRedirectBinding) == "" && sp.GetSSOBindingLocation(gosaml.HTTPPostBinding) == "" {
+		return nil, fmt.Errorf("[FAIL]: %s idp metadata has no redirect or post single sign-on service", config.Name)
+	}
+
+	// Responses are only trusted when signed by a certificate from the metadata
+	if !hasSigningCertificate(metadata) {
+		return nil, fmt.Errorf("[FAIL]: %s idp metadata has no signing certificate", config.Name)
+	}
+
+	return &Connection{config: config, sp: sp, loadedAt: time.Now()}, nil
+}
+
+/*
+Returns the connection name
+
+Params:
+  - No parameters
+
+Returns:
+  - The connection name
+*/
+func (connection *Connection) Name() string {
+	return connection.config.Name
+}
+
+/*
+Returns the provider name identities of the connection are stored with
+
+Params:
+  - No parameters
+
+Returns:
+  - The provider name, "saml:<name>"
+*/
+func (connection *Connection) Provider() string {
+	return "saml:" + connection.config.Name
+}
+
+/*
+Returns the SP metadata to import into the IdP
+
+Objectives:
+  - Publish the signing and encryption certificate, the ACS URL and the requested NameID format
+  - Only advertise the HTTP-POST binding, artifacts aren't resolved
+
+Params:
+  - No parameters
+
+Returns:
+  - The SP metadata XML
+  - An error if encoding failed
+*/
+func (connection *Connection) Metadata() ([]byte, error) {
+	metadata := connection.sp.Metadata()
+	for i := range metadata.SPSSODescriptors {
+		descriptor := &metadata.SPSSODescriptors[i]
+		descriptor.AssertionConsumerServices = descriptor.AssertionConsumerServices[:1]
+	}
+
+	encoded, err := xml.MarshalIndent(metadata, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("[FAIL]: could not encode %s sp metadata: %w", connection.config.Name, err)
+	}
+
+	return append([]byte(xml.Header), encoded...), nil
+}
+
+/*
+Creates a signed AuthnRequest
+
+Objectives:
+  - Use the HTTP-Redirect binding when the IdP supports it, signing the query
+  - Otherwise use the HTTP-POST binding, signing the request XML
+
+Params:
+  - relayState: The relay state the IdP posts back, must be URL safe
+
+Returns:
+  - The AuthnRequest
+  - An error if signing failed
+*/
+func (connection *Connection) AuthnRequest(relayState string) (AuthnRequest, error) {
+	if location := connection.sp.GetSSOBindingLocation(gosaml.HTTPRedirectBinding); location != "" {
+		request, err := connection.sp.MakeAuthenticationRequest(location, gosaml.HTTPRedirectBinding, gosaml.HTTPPostBinding)
+		if err != nil {
+			return AuthnRequest{}, fmt.Errorf("[FAIL]: could not create %s authn request: %w", connection.config.Name, err)
+		}
+
+		redirectURL, err := request.Redirect(relayState, connection.sp)
+		if err != nil {
+			return AuthnRequest{}, fmt.Errorf("[FAIL]: could not sign %s authn request: %w", connection.config.Name, err)
+		}
+
+		return AuthnRequest{ID: request.ID, RedirectURL: redirectURL.String()}, nil
+	}
+
+	location := connection.sp.GetSSOBindingLocation(gosaml.HTTPPostBinding)
+	request, err := connection.sp.MakeAuthenticationRequest(location, gosaml.HTTPPostBinding, gosaml.HTTPPostBinding)
+	if err != nil {
+		return AuthnRequest{}, fmt.Errorf("[FAIL]: could not create %s authn request: %w", connection.config.Name, err)
+	}
+
+	return AuthnRequest{ID: request.ID, PostForm: request.Post(relayState)}, nil
+}
+
+/*
+Verifies the response the IdP posted to the ACS URL
+
+Objectives:
+  - Verify the response or assertion signature against the IdP metadata certificates
+  - Verify the issuer, destination, recipient, time conditions and that it answers the request
+  - Require an audience restriction naming this SP
+  - Map the NameID and attributes to a profile
+
+Params:
+  - r:         A pointer to a http request object, its form holds the SAMLResponse
+  - requestID: The id of the AuthnRequest the response must answer
+
+Returns:
+  - The verified assertion
+  - ErrIdPDenied if the IdP responded with an error status, or an error if any check fails
+*/
+func (connection *Connection) ParseResponse(r *http.Request, requestID string) (Assertion, error) {
+	encoded := r.PostFormValue("SAMLResponse")
+	if encoded == "" {
+		return Assertion{}, fmt.Errorf("[FAIL]: %s response has no SAMLResponse", connection.config.Name)
+	}
+
+	decoded, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return Assertion{}, fmt.Errorf("[FAIL]: %s response is not base64 encoded", connection.config.Name)
+	}
+
+	assertion, err := connection.sp.ParseXMLResponse(decoded, []string{requestID})
+	if err != nil {
+		var invalid *gosaml.InvalidResponseError
+		if errors.As(err, &invalid) {
+			err = invalid.PrivateErr
+		}
+		if errors.As(err, &gosaml.ErrBadStatus{}) {
+			return Assertion{}, ErrIdPDenied
+		}
+		return Assertion{}, fmt.Errorf("[FAIL]: invalid %s response: %w", connection.config.Name, err)
+	}
+
+	// The library accepts assertions without audience restriction, those could be meant for any SP of the IdP
+	if assertion.Conditions == nil || len(assertion.Conditions.AudienceRestrictions) == 0 {
+		return Assertion{}, fmt.Errorf("[FAIL]: %s assertion has no audience restriction", connection.config.Name)
+	}
+
+	if assertion.ID == "" {
+		return Assertion{}, fmt.Errorf("[FAIL]: %s assertion has no id", connection.config.Name)
+	}
+
+	profile, err := connection.profile(assertion)
+	if err != nil {
+		return Assertion{}, err
+	}
+
+	expiresAt := assertion.IssueInstant.Add(gosaml.MaxIssueDelay)
+	if !assertion.Conditions.NotOnOrAfter.IsZero() {
+		expiresAt = assertion.Conditions.NotOnOrAfter
+	}
+
+	return Assertion{ID: assertion.ID, ExpiresAt: expiresAt.Add(gosaml.MaxClockSkew), Profile: profile}, nil
+}
+
+/*
+Maps an assertion to a profile
+
+Objectives:
+  - Use the NameID as the subject, transient NameIDs change every sign-in and are rejected
+  - Read the email and name from the configured attributes, falling back to an email NameID
+  - Only mark emails in the connection's domains as verified
+
+Params:
+  - assertion: The verified assertion
+
+Returns:
+  - The profile
+  - An error if the assertion has no usable NameID or email
+*/
+func (connection *Connection) profile(assertion *gosaml.Assertion) (model.ExternalProfile, error) {
+	if assertion.Subject == nil || assertion.Subject.NameID == nil || strings.TrimSpace(assertion.Subject.NameID.Value) == "" {
+		return model.ExternalProfile{}, fmt.Errorf("[FAIL]: %s assertion has no NameID", connection.config.Name)
+	}
+
+	nameID := assertion.Subject.NameID
+	if nameID.Format == string(gosaml.TransientNameIDFormat) {
+		return model.ExternalProfile{}, fmt.Errorf("[FAIL]: %s assertion has a transient NameID", connection.config.Name)
+	}
+
+	subject := strings.TrimSpace(nameID.Value)
+	if len(subject) > 255 {
+		return model.ExternalProfile{}, fmt.Errorf("[FAIL]: %s assertion NameID is too long", connection.config.Name)
+	}
+
+	emailAttributes := connection.config.EmailAttributes
+	if len(emailAttributes) == 0 {
+		emailAttributes = defaultEmailAttributes
+	}
+	nameAttributes := connection.config.NameAttributes
+	if len(nameAttributes) == 0 {
+		nameAttributes = defaultNameAttributes
+	}
+
+	email := attributeValue(assertion, emailAttributes)
+	if email == "" && nameID.Format == string(gosaml.EmailAddressNameIDFormat) {
+		email = subject
+	}
+	if email == "" {
+		return model.ExternalProfile{}, fmt.Errorf("[FAIL]: %s assertion has no email attribute", connection.config.Name)
+	}
+
+	name := attributeValue(assertion, nameAttributes)
+	if name == "" {
+		name, _, _ = strings.Cut(email, "@")
+	}
+
+	return model.ExternalProfile{
+		Subject:       subject,
+		Name:          name,
+		Email:         email,
+		EmailVerified: connection.authoritativeFor(email),
+	}, nil
+}
+
+/*
+Checks whether the connection's IdP is authoritative for an email's domain
+
+Params:
+  - email: The asserted email
+
+Returns:
+  - True if the email domain is one of the connection's email domains
+*/
+func (connection *Connection) authoritativeFor(email string) bool {
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return false
+	}
+
+	domain := strings.ToLower(email[at+1:])
+	for _, allowed := range connection.config.EmailDomains {
+		if domain == allowed {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Returns the first value of the first attribute present, matching names and friendly names
+func attributeValue(assertion *gosaml.Assertion, names []string) string {
+	for _, name := range names {
+		for _, statement := range assertion.AttributeStatements {
+			for _, attribute := range statement.Attributes {
+				if attribute.Name != name && attribute.FriendlyName != name {
+					continue
+				}
+				for _, value := range attribute.Values {
+					if value := strings.TrimSpace(value.Value); value != "" {
+						return value
+					}
+				}
+			}
+		}
+	}
+
+	return ""
+}
+
+// Parses IdP metadata, which may be wrapped in an EntitiesDescriptor
+func parseIDPMetadata(data []byte) (*gosaml.EntityDescriptor, error) {
+	return samlsp.ParseMetadata(bytes.TrimSpace(data))
+}
+
+// Checks whether IdP metadata publishes a certificate responses can be verified with
+func hasSigningCertificate(metadata *gosaml.EntityDescriptor) bool {
+	for _, descriptor := range metadata.IDPSSODescriptors {
+		for _, key := range descriptor.KeyDescriptors {
+			if (key.Use == "" || key.Use == "signing") && len(key.KeyInfo.X509Data.X509Certificates) > 0 {
+				return true
+			}
+		}
+	}
+
+	return false
+}
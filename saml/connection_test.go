@@ -0,0 +1,349 @@
+package saml
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	gosaml "github.com/crewjam/saml"
+	"github.com/dev-xero/authentication-backend/internal/testutil"
+	repository "github.com/dev-xero/authentication-backend/repository/user"
+	dsig "github.com/russellhaering/goxmldsig"
+)
+
+const (
+	testIdPEntityID = "https://idp.example.com/metadata"
+	testRequestID   = "id-authn-request"
+)
+
+// An in-process IdP, signing responses with a self-signed certificate published in its metadata
+type testIdP struct {
+	provider *gosaml.IdentityProvider
+	metadata []byte
+}
+
+// How a test response deviates from a valid one
+type responseOptions struct {
+	plaintext  bool
+	issuedAt   time.Time
+	assertion  func(assertion *gosaml.Assertion)
+	acsURL     string
+	signedWith *testIdP
+	nameFormat gosaml.NameIDFormat
+}
+
+func newTestCredentials(t *testing.T, commonName string) Credentials {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certificate, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return Credentials{Key: key, Certificate: certificate}
+}
+
+func newTestIdP(t *testing.T) *testIdP {
+	t.Helper()
+
+	credentials := newTestCredentials(t, "idp.example.com")
+	metadataURL, _ := url.Parse(testIdPEntityID)
+	ssoURL, _ := url.Parse("https://idp.example.com/sso")
+
+	provider := &gosaml.IdentityProvider{
+		Key:             credentials.Key,
+		Certificate:     credentials.Certificate,
+		MetadataURL:     *metadataURL,
+		SSOURL:          *ssoURL,
+		SignatureMethod: dsig.RSASHA256SignatureMethod,
+	}
+
+	metadata, err := xml.Marshal(provider.Metadata())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &testIdP{provider: provider, metadata: metadata}
+}
+
+func newTestConnection(t *testing.T, idp *testIdP) *Connection {
+	t.Helper()
+
+	connection, err := NewConnection(context.Background(), Config{
+		Name:         "acme",
+		BaseURL:      "https://sp.example.com/auth/saml",
+		IDPMetadata:  idp.metadata,
+		EmailDomains: []string{"acme.example"},
+	}, newTestCredentials(t, "sp.example.com"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return connection
+}
+
+// Creates the SAMLResponse the IdP posts to the connection's ACS URL, in response to testRequestID
+func (idp *testIdP) response(t *testing.T, connection *Connection, options responseOptions) string {
+	t.Helper()
+
+	now := time.Now()
+	if !options.issuedAt.IsZero() {
+		now = options.issuedAt
+	}
+
+	metadata := connection.sp.Metadata()
+	descriptor := metadata.SPSSODescriptors[0]
+	if options.plaintext {
+		var signing []gosaml.KeyDescriptor
+		for _, key := range descriptor.KeyDescriptors {
+			if key.Use == "signing" {
+				signing = append(signing, key)
+			}
+		}
+		descriptor.KeyDescriptors = signing
+	}
+
+	signer := idp
+	if options.signedWith != nil {
+		signer = options.signedWith
+	}
+
+	request := &gosaml.IdpAuthnRequest{
+		IDP:                     signer.provider,
+		HTTPRequest:             httptest.NewRequest(http.MethodPost, "https://idp.example.com/sso", nil),
+		Request:                 gosaml.AuthnRequest{ID: testRequestID, IssueInstant: now},
+		ServiceProviderMetadata: metadata,
+		SPSSODescriptor:         &descriptor,
+		ACSEndpoint:             &descriptor.AssertionConsumerServices[0],
+		Now:                     now,
+	}
+
+	nameFormat := gosaml.PersistentNameIDFormat
+	if options.nameFormat != "" {
+		nameFormat = options.nameFormat
+	}
+
+	err := gosaml.DefaultAssertionMaker{}.MakeAssertion(request, &gosaml.Session{
+		CreateTime:     now,
+		NameID:         "employee-1",
+		NameIDFormat:   string(nameFormat),
+		UserCommonName: "Jane Doe",
+		CustomAttributes: []gosaml.Attribute{
+			{Name: "email", Values: []gosaml.AttributeValue{{Type: "xs:string", Value: "jane@acme.example"}}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	request.Assertion.IssueInstant = now
+	if options.assertion != nil {
+		options.assertion(request.Assertion)
+	}
+
+	if options.acsURL != "" {
+		request.ACSEndpoint = &gosaml.IndexedEndpoint{Binding: gosaml.HTTPPostBinding, Location: options.acsURL}
+	}
+
+	form, err := request.PostBinding()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return form.SAMLResponse
+}
+
+// Posts a SAMLResponse to the connection, as the browser would
+func parse(connection *Connection, samlResponse string, requestID string) (Assertion, error) {
+	body := url.Values{"SAMLResponse": {samlResponse}}.Encode()
+	r := httptest.NewRequest(http.MethodPost, "https://sp.example.com/auth/saml/acme/acs", strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	return connection.ParseResponse(r, requestID)
+}
+
+func TestParseResponseAcceptsSignedResponse(t *testing.T) {
+	idp := newTestIdP(t)
+	connection := newTestConnection(t, idp)
+
+	for _, plaintext := range []bool{false, true} {
+		assertion, err := parse(connection, idp.response(t, connection, responseOptions{plaintext: plaintext}), testRequestID)
+		if err != nil {
+			t.Fatalf("plaintext=%v: %v", plaintext, err)
+		}
+
+		if assertion.ID == "" || !assertion.ExpiresAt.After(time.Now()) {
+			t.Errorf("plaintext=%v: assertion id %q expires at %v", plaintext, assertion.ID, assertion.ExpiresAt)
+		}
+		profile := assertion.Profile
+		if profile.Subject != "employee-1" || profile.Email != "jane@acme.example" || profile.Name != "Jane Doe" || !profile.EmailVerified {
+			t.Errorf("plaintext=%v: unexpected profile %+v", plaintext, profile)
+		}
+	}
+}
+
+func TestParseResponseRejectsInvalidResponses(t *testing.T) {
+	idp := newTestIdP(t)
+	connection := newTestConnection(t, idp)
+	otherIdP := newTestIdP(t)
+
+	tests := []struct {
+		name      string
+		options   responseOptions
+		requestID string
+	}{
+		{
+			name:    "signed by another key",
+			options: responseOptions{signedWith: otherIdP},
+		},
+		{
+			name: "audience of another sp",
+			options: responseOptions{assertion: func(assertion *gosaml.Assertion) {
+				assertion.Conditions.AudienceRestrictions[0].Audience.Value = "https://other.example.com/metadata"
+			}},
+		},
+		{
+			name: "no audience restriction",
+			options: responseOptions{assertion: func(assertion *gosaml.Assertion) {
+				assertion.Conditions.AudienceRestrictions = nil
+			}},
+		},
+		{
+			name: "recipient of another acs",
+			options: responseOptions{assertion: func(assertion *gosaml.Assertion) {
+				assertion.Subject.SubjectConfirmations[0].SubjectConfirmationData.Recipient = "https://other.example.com/acs"
+			}},
+		},
+		{
+			name:    "destination of another acs",
+			options: responseOptions{acsURL: "https://other.example.com/acs"},
+		},
+		{
+			name:    "issued too long ago",
+			options: responseOptions{issuedAt: time.Now().Add(-time.Hour)},
+		},
+		{
+			name: "expired assertion",
+			options: responseOptions{assertion: func(assertion *gosaml.Assertion) {
+				assertion.Conditions.NotOnOrAfter = time.Now().Add(-10 * time.Minute)
+			}},
+		},
+		{
+			name: "not yet valid",
+			options: responseOptions{assertion: func(assertion *gosaml.Assertion) {
+				assertion.Conditions.NotBefore = time.Now().Add(time.Hour)
+			}},
+		},
+		{
+			name:    "transient name id",
+			options: responseOptions{nameFormat: gosaml.TransientNameIDFormat},
+		},
+		{
+			name:      "answering another request",
+			requestID: "id-other-request",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			requestID := testRequestID
+			if test.requestID != "" {
+				requestID = test.requestID
+			}
+
+			if _, err := parse(connection, idp.response(t, connection, test.options), requestID); err == nil {
+				t.Fatal("response was accepted")
+			}
+		})
+	}
+}
+
+func TestParseResponseRejectsTamperedResponse(t *testing.T) {
+	idp := newTestIdP(t)
+	connection := newTestConnection(t, idp)
+
+	decoded, err := base64.StdEncoding.DecodeString(idp.response(t, connection, responseOptions{plaintext: true}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tampered := strings.Replace(string(decoded), "employee-1", "employee-2", 1)
+	if tampered == string(decoded) {
+		t.Fatal("name id not found in plaintext response")
+	}
+
+	if _, err := parse(connection, base64.StdEncoding.EncodeToString([]byte(tampered)), testRequestID); err == nil {
+		t.Fatal("tampered response was accepted")
+	}
+}
+
+func TestParseResponseReportsDeniedStatus(t *testing.T) {
+	idp := newTestIdP(t)
+	connection := newTestConnection(t, idp)
+
+	decoded, err := base64.StdEncoding.DecodeString(idp.response(t, connection, responseOptions{plaintext: true}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The status is checked before any signature, a denial doesn't need to be signed
+	denied := strings.Replace(string(decoded), gosaml.StatusSuccess, gosaml.StatusRequester, 1)
+	if _, err := parse(connection, base64.StdEncoding.EncodeToString([]byte(denied)), testRequestID); !errors.Is(err, ErrIdPDenied) {
+		t.Fatalf("expected ErrIdPDenied, got %v", err)
+	}
+}
+
+// The assertion id identifies a response across parses, the ACS handler records it so a captured response is only accepted once
+func TestParseResponseRejectsReplayedAssertion(t *testing.T) {
+	idp := newTestIdP(t)
+	connection := newTestConnection(t, idp)
+	response := idp.response(t, connection, responseOptions{})
+
+	first, err := parse(connection, response, testRequestID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	replayed, err := parse(connection, response, testRequestID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.ID != replayed.ID || !first.ExpiresAt.Equal(replayed.ExpiresAt) {
+		t.Fatalf("replayed response parsed to assertion %s, want %s", replayed.ID, first.ID)
+	}
+
+	repo := testutil.Repository(t)
+	ctx := context.Background()
+	if err := repo.ConsumeSAMLAssertion(ctx, connection.Name(), first.ID, first.ExpiresAt); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.ConsumeSAMLAssertion(ctx, connection.Name(), replayed.ID, replayed.ExpiresAt); !errors.Is(err, repository.ErrSAMLAssertionUsed) {
+		t.Fatalf("expected ErrSAMLAssertionUsed, got %v", err)
+	}
+}
@@ -0,0 +1,29 @@
+package service
+
+import "github.com/dev-xero/authentication-backend/mailer"
+
+/*
+MailProvider handler struct
+
+Objectives:
+  - Provides the mailer to handlers
+
+Fields:
+  - Mailer: The mailer
+*/
+type MailProvider struct {
+	Mailer mailer.Mailer
+}
+
+/*
+Initializes a new mail service
+
+Params:
+  - mailer: The mailer
+
+Returns:
+  - No return value
+*/
+func (provider *MailProvider) New(mailer mailer.Mailer) {
+	provider.Mailer = mailer
+}
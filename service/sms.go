@@ -0,0 +1,33 @@
+package service
+
+import "github.com/dev-xero/authentication-backend/sms"
+
+/*
+SMSProvider handler struct
+
+Objectives:
+  - Provides the SMS sender and its guard to handlers
+
+Fields:
+  - Sender: The SMS sender
+  - Guard:  Rate limits and fraud checks applied before sending
+*/
+type SMSProvider struct {
+	Sender sms.SMSSender
+	Guard  *sms.Guard
+}
+
+/*
+Initializes a new SMS service
+
+Params:
+  - sender: The SMS sender
+  - guard:  The SMS guard
+
+Returns:
+  - No return value
+*/
+func (provider *SMSProvider) New(sender sms.SMSSender, guard *sms.Guard) {
+	provider.Sender = sender
+	provider.Guard = guard
+}
@@ -0,0 +1,121 @@
+package sms
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+/*
+Message struct, a message delivered by the fake sender
+
+Fields:
+  - To:     string
+  - Body:   string
+  - SentAt: time.Time
+*/
+type Message struct {
+	To     string
+	Body   string
+	SentAt time.Time
+}
+
+/*
+Fake SMS sender for development and tests
+
+Objectives:
+  - Keep every message in an in-memory inbox
+  - Optionally append every message to an outbox file
+
+Fields:
+  - outboxPath: File messages are appended to, messages are only kept in memory if empty
+  - messages:   The in-memory inbox
+*/
+type FakeSender struct {
+	outboxPath string
+
+	mutex    sync.Mutex
+	messages []Message
+}
+
+/*
+Initializes a new fake sender
+
+Params:
+  - outboxPath: File to append messages to, optional
+
+Returns:
+  - A pointer to the fake sender
+*/
+func NewFakeSender(outboxPath string) *FakeSender {
+	return &FakeSender{outboxPath: outboxPath}
+}
+
+// Implement the SMS sender interface for the fake sender
+func (sender *FakeSender) Send(ctx context.Context, to string, message string) error {
+	sender.mutex.Lock()
+	defer sender.mutex.Unlock()
+
+	msg := Message{To: to, Body: message, SentAt: time.Now()}
+	sender.messages = append(sender.messages, msg)
+
+	if sender.outboxPath == "" {
+		log.Printf("[LOG]: fake sms sent to %s\n", to)
+		return nil
+	}
+
+	file, err := os.OpenFile(sender.outboxPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("[FAIL]: could not open sms outbox: %w", err)
+	}
+	defer file.Close()
+
+	_, err = fmt.Fprintf(file, "%s\t%s\t%s\n", msg.SentAt.Format(time.RFC3339), msg.To, msg.Body)
+	if err != nil {
+		return fmt.Errorf("[FAIL]: could not write sms outbox: %w", err)
+	}
+
+	return nil
+}
+
+/*
+Returns every message delivered so far
+
+Params:
+  - No parameters
+
+Returns:
+  - A copy of the inbox
+*/
+func (sender *FakeSender) Messages() []Message {
+	sender.mutex.Lock()
+	defer sender.mutex.Unlock()
+
+	return append([]Message{}, sender.messages...)
+}
+
+/*
+Returns the latest message delivered to a phone number
+
+Params:
+  - to: The E.164 formatted phone number
+
+Returns:
+  - The message
+  - False if no message was delivered to the number
+*/
+func (sender *FakeSender) LastMessageTo(to string) (Message, bool) {
+	sender.mutex.Lock()
+	defer sender.mutex.Unlock()
+
+	for i := len(sender.messages) - 1; i >= 0; i-- {
+		if sender.messages[i].To == to {
+			return sender.messages[i], true
+		}
+	}
+
+	return Message{}, false
+}
@@ -0,0 +1,167 @@
+package sms
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/joho/godotenv"
+)
+
+// Stores all errors returned by the SMS guard
+var (
+	ErrDestinationBlocked = errors.New("sms messages can't be sent to that phone number")
+	ErrRateLimited        = errors.New("too many sms messages requested, try again later")
+)
+
+/*
+Default destination prefixes that are never sent to. These are shared-cost
+international networks and satellite services that are common targets of
+SMS pumping fraud.
+*/
+var defaultBlockedPrefixes = []string{"+870", "+881", "+882", "+883", "+979"}
+
+/*
+SMS guard struct, rate limits and fraud checks applied before sending
+
+Fields:
+  - AllowedCountryCodes: Country calling codes that may be sent to, all if empty
+  - BlockedPrefixes:     Number prefixes that are never sent to
+  - Window:              The rate limiting window
+  - MaxPerNumber:        Messages allowed per phone number in the window
+  - MaxPerUser:          Messages allowed per user in the window
+  - Cooldown:            Minimum time between two messages to the same number
+*/
+type Guard struct {
+	AllowedCountryCodes []string
+	BlockedPrefixes     []string
+	Window              time.Duration
+	MaxPerNumber        int
+	MaxPerUser          int
+	Cooldown            time.Duration
+}
+
+/*
+Creates the SMS guard configured through environment variables
+
+Objectives:
+  - Load the allowed country codes and blocked prefixes
+  - Load rate limits, falling back to defaults when unset
+
+Params:
+  - No parameters
+
+Returns:
+  - A pointer to the SMS guard
+  - An error if a limit is not a valid number
+*/
+func NewGuardFromEnvironment() (*Guard, error) {
+	// Load environment variables from .env file in development
+	if env := os.Getenv("ENVIRONMENT"); env != "production" {
+		err := godotenv.Load()
+		if err != nil {
+			return nil, fmt.Errorf("[FAIL]: could not load environment variables: %w", err)
+		}
+	}
+
+	guard := &Guard{
+		AllowedCountryCodes: splitList(os.Getenv("SMS_ALLOWED_COUNTRY_CODES")),
+		BlockedPrefixes:     append(splitList(os.Getenv("SMS_BLOCKED_PREFIXES")), defaultBlockedPrefixes...),
+		Window:              time.Hour,
+		MaxPerNumber:        5,
+		MaxPerUser:          10,
+		Cooldown:            30 * time.Second,
+	}
+
+	var err error
+	if guard.MaxPerNumber, err = intFromEnvironment("SMS_MAX_PER_NUMBER_PER_HOUR", guard.MaxPerNumber); err != nil {
+		return nil, err
+	}
+	if guard.MaxPerUser, err = intFromEnvironment("SMS_MAX_PER_USER_PER_HOUR", guard.MaxPerUser); err != nil {
+		return nil, err
+	}
+
+	return guard, nil
+}
+
+/*
+Checks that messages may be sent to a phone number
+
+Params:
+  - phone: The E.164 formatted phone number
+
+Returns:
+  - ErrDestinationBlocked if the destination is blocked or not allowed
+*/
+func (guard *Guard) CheckDestination(phone string) error {
+	for _, prefix := range guard.BlockedPrefixes {
+		if strings.HasPrefix(phone, prefix) {
+			return ErrDestinationBlocked
+		}
+	}
+
+	if len(guard.AllowedCountryCodes) == 0 {
+		return nil
+	}
+
+	for _, code := range guard.AllowedCountryCodes {
+		if strings.HasPrefix(phone, "+"+strings.TrimPrefix(code, "+")) {
+			return nil
+		}
+	}
+
+	return ErrDestinationBlocked
+}
+
+/*
+Checks recent sending activity against the rate limits
+
+Params:
+  - sentToNumber: Messages sent to the phone number in the window
+  - sentForUser:  Messages sent for the user in the window
+  - lastSent:     When the last message was sent to the number, zero if never
+
+Returns:
+  - ErrRateLimited if any limit is exceeded
+*/
+func (guard *Guard) CheckRate(sentToNumber int, sentForUser int, lastSent time.Time) error {
+	if sentToNumber >= guard.MaxPerNumber || sentForUser >= guard.MaxPerUser {
+		return ErrRateLimited
+	}
+
+	if !lastSent.IsZero() && time.Since(lastSent) < guard.Cooldown {
+		return ErrRateLimited
+	}
+
+	return nil
+}
+
+// Splits a comma separated list, ignoring empty entries
+func splitList(list string) []string {
+	var entries []string
+	for _, entry := range strings.Split(list, ",") {
+		if entry = strings.TrimSpace(entry); entry != "" {
+			entries = append(entries, entry)
+		}
+	}
+
+	return entries
+}
+
+// Reads an integer environment variable, returning the fallback if unset
+func intFromEnvironment(name string, fallback int) (int, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback, nil
+	}
+
+	parsed, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("[FAIL]: %s must be a number: %w", name, err)
+	}
+
+	return parsed, nil
+}
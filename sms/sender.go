@@ -0,0 +1,53 @@
+package sms
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/joho/godotenv"
+)
+
+/*
+SMSSender interface, defines SMS providers that deliver text messages
+
+Methods:
+  - Send: Delivers a message to an E.164 formatted phone number
+*/
+type SMSSender interface {
+	Send(ctx context.Context, to string, message string) error
+}
+
+/*
+Creates the SMS sender configured through environment variables
+
+Objectives:
+  - Load the SMS provider name from the environment
+  - Construct the matching sender
+
+Params:
+  - No parameters
+
+Returns:
+  - The SMS sender
+  - An error if the provider is unknown or misconfigured
+*/
+func NewFromEnvironment() (SMSSender, error) {
+	// Load environment variables from .env file in development
+	if env := os.Getenv("ENVIRONMENT"); env != "production" {
+		err := godotenv.Load()
+		if err != nil {
+			return nil, fmt.Errorf("[FAIL]: could not load environment variables: %w", err)
+		}
+	}
+
+	switch provider := os.Getenv("SMS_PROVIDER"); provider {
+	case "", "fake":
+		if os.Getenv("ENVIRONMENT") == "production" {
+			return nil, fmt.Errorf("[FAIL]: the fake SMS provider can't be used in production")
+		}
+		return NewFakeSender(os.Getenv("SMS_FAKE_OUTBOX")), nil
+	default:
+		return nil, fmt.Errorf("[FAIL]: unknown SMS provider %q", provider)
+	}
+}
@@ -29,6 +29,31 @@ func CreateTokenCookie(token string) http.Cookie {
 	return cookie
 }
 
+/*
+Creates a cookie with the mfa token, with a max age of 5 minutes
+
+Objectives:
+  - Create a cookie with the value set to the mfa token to expire in 5 minutes
+
+Params:
+  - token: A JSON Web Token from authentication.CreateMFAToken
+
+Returns:
+  - A http cookie with the token and configurations
+*/
+func CreateMFACookie(token string) http.Cookie {
+	cookie := http.Cookie{
+		Name:     "mfa_token",
+		Value:    token,
+		Path:     "/",
+		Secure:   true,
+		SameSite: http.SameSiteStrictMode,
+		HttpOnly: true,
+		MaxAge:   300, // Lives for 5 minutes
+	}
+	return cookie
+}
+
 /*
 Expires any cookies saved in the client
 
@@ -54,3 +79,56 @@ func ExpireCookie(w http.ResponseWriter, name string) {
 	}
 	http.SetCookie(w, deletedCookie)
 }
+
+/*
+Creates a cookie with the signed oauth state, with a max age of 10 minutes
+
+Params:
+  - token:    A signed token from authentication.CreateOAuthStateToken
+  - formPost: Whether the provider posts the callback cross-site, browsers only send the cookie with it when SameSite is None
+
+Returns:
+  - A http cookie with the token and configurations
+*/
+func CreateOAuthStateCookie(token string, formPost bool) http.Cookie {
+	sameSite := http.SameSiteLaxMode
+	if formPost {
+		sameSite = http.SameSiteNoneMode
+	}
+
+	cookie := http.Cookie{
+		Name:     "oauthstate",
+		Value:    token,
+		Path:     "/",
+		Secure:   true,
+		SameSite: sameSite,
+		HttpOnly: true,
+		MaxAge:   600, // Lives for 10 minutes
+	}
+	return cookie
+}
+
+/*
+Creates a cookie with the signed SAML request, with a max age of 10 minutes
+
+Objectives:
+  - Use SameSite None, IdPs post the response to the ACS cross-site
+
+Params:
+  - token: A signed token from authentication.CreateSAMLRequestToken
+
+Returns:
+  - A http cookie with the token and configurations
+*/
+func CreateSAMLRequestCookie(token string) http.Cookie {
+	cookie := http.Cookie{
+		Name:     "samlrequest",
+		Value:    token,
+		Path:     "/",
+		Secure:   true,
+		SameSite: http.SameSiteNoneMode,
+		HttpOnly: true,
+		MaxAge:   600, // Lives for 10 minutes
+	}
+	return cookie
+}
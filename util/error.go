@@ -12,6 +12,8 @@ var (
 	ErrEmptyFields    ValidationError = errors.New("one or more fields are empty")
 	ErrEmailInvalid   ValidationError = errors.New("invalid email provided")
 	ErrPasswordLength ValidationError = errors.New("password length must be at least 8 characters long")
+	ErrPhoneInvalid   ValidationError = errors.New("phone number must be in international format, e.g. +14155550123")
+	ErrCodeInvalid    ValidationError = errors.New("verification code must be 6 digits")
 )
 
 /*
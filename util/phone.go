@@ -0,0 +1,44 @@
+package util
+
+import (
+	"regexp"
+	"strings"
+)
+
+// E.164 numbers are a + followed by up to 15 digits, the country code never starts with 0
+var e164Pattern = regexp.MustCompile(`^\+[1-9][0-9]{6,14}$`)
+
+/*
+Normalizes a phone number to the E.164 format
+
+Objectives:
+  - Strip common separators such as spaces, dashes, dots and parentheses
+  - Accept the international 00 prefix in place of +
+  - Verify the result is a valid E.164 number
+
+Params:
+  - phone: The phone number to normalize, must include the country code
+
+Returns:
+  - The E.164 formatted phone number
+  - An error if the number is not a valid international number
+*/
+func NormalizePhoneNumber(phone string) (string, error) {
+	normalized := strings.Map(func(r rune) rune {
+		switch r {
+		case ' ', '-', '.', '(', ')':
+			return -1
+		}
+		return r
+	}, strings.TrimSpace(phone))
+
+	if strings.HasPrefix(normalized, "00") {
+		normalized = "+" + normalized[2:]
+	}
+
+	if !e164Pattern.MatchString(normalized) {
+		return "", ErrPhoneInvalid
+	}
+
+	return normalized, nil
+}
@@ -50,6 +50,81 @@ func (sanitizable *SignInRequestBody) Sanitize() {
 	sanitizable.Password = sanitize.AlphaNumeric(sanitizable.Password, false)
 }
 
+/*
+Phone number request body
+
+Fields:
+  - Phone: string
+*/
+type PhoneRequestBody struct {
+	Phone string `json:"phone"`
+}
+
+// Implement the sanitize function for the phone number request body
+func (sanitizable *PhoneRequestBody) Sanitize() {
+	sanitizable.Phone = sanitize.Custom(sanitizable.Phone, `[^0-9+()\s.-]`)
+}
+
+/*
+Verification code request body
+
+Fields:
+  - Code: string
+*/
+type CodeRequestBody struct {
+	Code string `json:"code"`
+}
+
+// Implement the sanitize function for the verification code request body
+func (sanitizable *CodeRequestBody) Sanitize() {
+	sanitizable.Code = sanitize.Numeric(sanitizable.Code)
+}
+
+/*
+Email request body
+
+Fields:
+  - Email: string
+*/
+type EmailRequestBody struct {
+	Email string `json:"email"`
+}
+
+// Implement the sanitize function for the email request body
+func (sanitizable *EmailRequestBody) Sanitize() {
+	sanitizable.Email = sanitize.Email(sanitizable.Email, false)
+}
+
+/*
+Emailed token request body
+
+Fields:
+  - Token: string
+*/
+type TokenRequestBody struct {
+	Token string `json:"token"`
+}
+
+// Implement the sanitize function for the emailed token request body, tokens are base64 URL encoded
+func (sanitizable *TokenRequestBody) Sanitize() {
+	sanitizable.Token = sanitize.Custom(sanitizable.Token, `[^a-zA-Z0-9_-]`)
+}
+
+/*
+Password request body
+
+Fields:
+  - Password: string
+*/
+type PasswordRequestBody struct {
+	Password string `json:"password"`
+}
+
+// Implement the sanitize function for the password request body
+func (sanitizable *PasswordRequestBody) Sanitize() {
+	sanitizable.Password = sanitize.AlphaNumeric(sanitizable.Password, false)
+}
+
 /*
 Sanitize user input from request body
 
@@ -0,0 +1,42 @@
+package util
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+)
+
+/*
+Generates a random URL-safe token
+
+Params:
+  - size: The number of random bytes in the token
+
+Returns:
+  - The base64 URL encoded token
+  - An error if no randomness is available
+*/
+func GenerateRandomToken(size int) (string, error) {
+	b := make([]byte, size)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("[FAIL]: could not generate random token: %w", err)
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+/*
+Hashes a high entropy token for storage, tokens are looked up by their hash
+
+Params:
+  - token: The token to hash
+
+Returns:
+  - The hex encoded SHA-256 hash of the token
+*/
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}